@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func SingleQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func AliasExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) == 0 {
+		names := make([]string, 0, len(shellCtx.Aliases))
+		for name := range shellCtx.Aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			shellCtx.Sout += fmt.Sprintf("alias %s=%s\n", name, SingleQuote(shellCtx.Aliases[name]))
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !hasValue {
+			aliasValue, found := shellCtx.Aliases[name]
+			if !found {
+				shellCtx.Serr += fmt.Sprintf("alias: %s: not found\n", name)
+				shellCtx.Status = 1
+				continue
+			}
+			shellCtx.Sout += fmt.Sprintf("alias %s=%s\n", name, SingleQuote(aliasValue))
+			continue
+		}
+		if len(name) == 0 || strings.ContainsAny(name, " \t\n/$`'\"\\|<>") {
+			shellCtx.Serr += fmt.Sprintf("alias: `%s': invalid alias name\n", name)
+			shellCtx.Status = 1
+			continue
+		}
+		shellCtx.Aliases[name] = value
+	}
+	return nil
+}
+
+func UnaliasExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unalias command takes at least 1 argument of type string")
+	}
+	if args[0] == "-a" {
+		clear(shellCtx.Aliases)
+		return nil
+	}
+	for _, name := range args {
+		if _, found := shellCtx.Aliases[name]; !found {
+			shellCtx.Serr += fmt.Sprintf("unalias: %s: not found\n", name)
+			shellCtx.Status = 1
+			continue
+		}
+		delete(shellCtx.Aliases, name)
+	}
+	return nil
+}
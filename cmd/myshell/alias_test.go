@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestAliasBuiltins(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`alias a=echo b="echo x"; alias a; alias`, "alias a='echo'\nalias a='echo'\nalias b='echo x'\n"},
+		{`alias q="it's"; alias q`, `alias q='it'\''s'` + "\n"},
+		{"alias a=echo; unalias a; alias a; echo $?", "1\n"},
+		{"alias a=x b=y; unalias -a; alias", ""},
+		{"alias x=y; unalias x y; echo $?", "1\n"},
+		{"alias 'bad name=1'; echo $?", "1\n"},
+	})
+}
+
+func TestAliasesApplyToLaterLines(t *testing.T) {
+	tests := []struct{ define, line, want string }{
+		{`alias e="echo hi"`, "e there", "hi there\n"},
+		{`alias e="echo hi" l="e "`, "l e", "hi echo hi\n"},
+		{`alias e="echo hi"`, "echo e", "e\n"},
+		{`alias e="echo hi"`, `\e x; echo $?`, "127\n"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		runShell(t, shellCtx, test.define)
+		if got, _ := runShell(t, shellCtx, test.line); got != test.want {
+			t.Errorf("%s; %s: got %q, want %q", test.define, test.line, got, test.want)
+		}
+	}
+}
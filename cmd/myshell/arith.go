@@ -0,0 +1,480 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EvalArithmetic evaluates a shell arithmetic expression, as found in
+// for ((...)) and $((...)). Parameters and command substitutions in it are
+// expanded first; names left afterwards are variables whose values are
+// themselves evaluated, unset or empty ones being 0.
+func (ctx *ShellCtx) EvalArithmetic(expr string) (int64, error) {
+	expanded, err := ctx.ExpandString(expr)
+	if err != nil {
+		return 0, err
+	}
+	return ctx.evalArithmetic(expanded, 0)
+}
+
+// LetExecutor implements `let expression ...`. Each argument is evaluated
+// in turn and the status tells whether the last one was non-zero.
+func LetExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) == 0 {
+		shellCtx.Serr = "let: expression expected\n"
+		shellCtx.Status = 1
+		return nil
+	}
+	value := int64(0)
+	for _, expr := range args {
+		var err error
+		// The arguments have been expanded already.
+		if value, err = shellCtx.evalArithmetic(expr, 0); err != nil {
+			shellCtx.Serr = fmt.Sprintf("let: %s\n", err.Error())
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+	if value == 0 {
+		shellCtx.Status = 1
+	}
+	return nil
+}
+
+// maxArithDepth bounds how deep variables may refer to other expressions.
+const maxArithDepth = 64
+
+func (ctx *ShellCtx) evalArithmetic(expr string, depth int) (int64, error) {
+	if depth > maxArithDepth {
+		return 0, fmt.Errorf("%s: expression recursion level exceeded", expr)
+	}
+	a := &arith{ctx: ctx, expr: expr, depth: depth}
+	a.skipSpaces()
+	if a.pos == len(expr) {
+		return 0, nil
+	}
+	value := a.comma()
+	if a.err == nil && a.pos < len(expr) {
+		a.fail("syntax error in expression")
+	}
+	return value, a.err
+}
+
+// arith is a recursive descent evaluator over the C operators bash supports,
+// one method per precedence level. While noeval is positive, as on the side
+// of && or ?: that is not taken, operands are parsed but nothing is
+// assigned and division by zero is not an error.
+type arith struct {
+	ctx    *ShellCtx
+	expr   string
+	pos    int
+	depth  int
+	noeval int
+	err    error
+}
+
+func (a *arith) fail(message string) {
+	if a.err == nil && a.pos < len(a.expr) {
+		a.err = fmt.Errorf("%s: %s (error token is \"%s\")", a.expr, message, a.expr[a.pos:])
+	} else if a.err == nil {
+		a.err = fmt.Errorf("%s: %s", a.expr, message)
+	}
+	a.pos = len(a.expr)
+}
+
+func (a *arith) skipSpaces() {
+	for a.pos < len(a.expr) && strings.IndexByte(" \t\n", a.expr[a.pos]) != -1 {
+		a.pos++
+	}
+}
+
+// accept consumes op if it comes next, but not when it is only the start of
+// a longer operator, so that & doesn't match &&.
+func (a *arith) accept(op string, longer ...string) bool {
+	a.skipSpaces()
+	if !strings.HasPrefix(a.expr[a.pos:], op) {
+		return false
+	}
+	for _, other := range longer {
+		if strings.HasPrefix(a.expr[a.pos:], other) {
+			return false
+		}
+	}
+	a.pos += len(op)
+	return true
+}
+
+func (a *arith) comma() int64 {
+	value := a.assignment()
+	for a.err == nil && a.accept(",") {
+		value = a.assignment()
+	}
+	return value
+}
+
+var arithAssignOps = []string{"<<=", ">>=", "**=", "*=", "/=", "%=", "+=", "-=", "&=", "^=", "|=", "="}
+
+func (a *arith) assignment() int64 {
+	a.skipSpaces()
+	start := a.pos
+	name := a.name()
+	if name != "" {
+		a.skipSpaces()
+		for _, op := range arithAssignOps {
+			if !strings.HasPrefix(a.expr[a.pos:], op) || strings.HasPrefix(a.expr[a.pos:], "==") {
+				continue
+			}
+			a.pos += len(op)
+			value := a.assignment()
+			if op != "=" {
+				value = a.apply(op[:len(op)-1], a.variable(name), value)
+			}
+			a.assign(name, value)
+			return value
+		}
+	}
+	a.pos = start
+	return a.ternary()
+}
+
+func (a *arith) ternary() int64 {
+	condition := a.binary(0)
+	if !a.accept("?") {
+		return condition
+	}
+	if condition == 0 {
+		a.noeval++
+	}
+	yes := a.assignment()
+	if condition == 0 {
+		a.noeval--
+	}
+	if !a.accept(":") {
+		a.fail("`:' expected for conditional expression")
+		return 0
+	}
+	if condition != 0 {
+		a.noeval++
+	}
+	no := a.ternary()
+	if condition != 0 {
+		a.noeval--
+		return yes
+	}
+	return no
+}
+
+// arithLevels lists the binary operators from the loosest binding to the
+// tightest, each with the longer operators it must not be mistaken for.
+var arithLevels = [][]struct {
+	op     string
+	longer []string
+}{
+	{{"||", nil}},
+	{{"&&", nil}},
+	{{"|", []string{"||", "|="}}},
+	{{"^", []string{"^="}}},
+	{{"&", []string{"&&", "&="}}},
+	{{"==", nil}, {"!=", nil}},
+	{{"<=", nil}, {">=", nil}, {"<", []string{"<<"}}, {">", []string{">>"}}},
+	{{"<<", []string{"<<="}}, {">>", []string{">>="}}},
+	{{"+", []string{"++", "+="}}, {"-", []string{"--", "-="}}},
+	{{"*", []string{"**", "*="}}, {"/", []string{"/="}}, {"%", []string{"%="}}},
+}
+
+func (a *arith) binary(level int) int64 {
+	if level == len(arithLevels) {
+		return a.power()
+	}
+	left := a.binary(level + 1)
+	for a.err == nil {
+		matched := ""
+		for _, candidate := range arithLevels[level] {
+			if a.accept(candidate.op, candidate.longer...) {
+				matched = candidate.op
+				break
+			}
+		}
+		if matched == "" {
+			return left
+		}
+		// The right side of a decided && or || is not evaluated.
+		skip := (matched == "&&" && left == 0) || (matched == "||" && left != 0)
+		if skip {
+			a.noeval++
+		}
+		right := a.binary(level + 1)
+		if skip {
+			a.noeval--
+		}
+		left = a.apply(matched, left, right)
+	}
+	return left
+}
+
+func (a *arith) power() int64 {
+	base := a.unary()
+	if a.accept("**", "**=") {
+		// ** is right associative.
+		return a.apply("**", base, a.power())
+	}
+	return base
+}
+
+func (a *arith) unary() int64 {
+	a.skipSpaces()
+	for _, op := range []string{"++", "--"} {
+		if !strings.HasPrefix(a.expr[a.pos:], op) {
+			continue
+		}
+		a.pos += 2
+		a.skipSpaces()
+		name := a.name()
+		if name == "" {
+			a.fail("syntax error: operand expected")
+			return 0
+		}
+		value := a.variable(name) + 1
+		if op == "--" {
+			value -= 2
+		}
+		a.assign(name, value)
+		return value
+	}
+	switch {
+	case a.accept("!"):
+		return boolToInt(a.unary() == 0)
+	case a.accept("~"):
+		return ^a.unary()
+	case a.accept("-"):
+		return -a.unary()
+	case a.accept("+"):
+		return a.unary()
+	}
+	return a.postfix()
+}
+
+func (a *arith) postfix() int64 {
+	a.skipSpaces()
+	name := a.name()
+	if name == "" {
+		return a.primary()
+	}
+	value := a.variable(name)
+	switch {
+	case a.accept("++"):
+		a.assign(name, value+1)
+	case a.accept("--"):
+		a.assign(name, value-1)
+	}
+	return value
+}
+
+func (a *arith) primary() int64 {
+	a.skipSpaces()
+	if a.accept("(") {
+		value := a.comma()
+		if !a.accept(")") {
+			a.fail("missing `)'")
+		}
+		return value
+	}
+	start := a.pos
+	for a.pos < len(a.expr) && (isNameByte(a.expr[a.pos]) || a.expr[a.pos] == '#') {
+		a.pos++
+	}
+	if start == a.pos {
+		a.fail("syntax error: operand expected")
+		return 0
+	}
+	value, err := parseArithNumber(a.expr[start:a.pos])
+	if err != nil {
+		a.pos = start
+		a.fail(err.Error())
+	}
+	return value
+}
+
+// name consumes a variable name, possibly an array element, if one comes
+// next.
+func (a *arith) name() string {
+	start := a.pos
+	if a.pos < len(a.expr) && (a.expr[a.pos] == '_' || isLetter(a.expr[a.pos])) {
+		for a.pos < len(a.expr) && isNameByte(a.expr[a.pos]) {
+			a.pos++
+		}
+		if a.pos < len(a.expr) && a.expr[a.pos] == '[' {
+			depth := 0
+			for end := a.pos; end < len(a.expr); end++ {
+				if a.expr[end] == '[' {
+					depth++
+				} else if a.expr[end] == ']' {
+					if depth--; depth == 0 {
+						a.pos = end + 1
+						break
+					}
+				}
+			}
+		}
+	}
+	return a.expr[start:a.pos]
+}
+
+func (a *arith) variable(name string) int64 {
+	if a.noeval > 0 {
+		return 0
+	}
+	value, found, err := a.ctx.lookupSubscripted(name)
+	if err == nil && !found && a.ctx.Options["nounset"] {
+		err = a.ctx.unboundVariable(name)
+	}
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	if strings.TrimSpace(value) == "" {
+		return 0
+	}
+	result, err := a.ctx.evalArithmetic(value, a.depth+1)
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	return result
+}
+
+func (a *arith) assign(name string, value int64) {
+	if a.noeval > 0 || a.err != nil {
+		return
+	}
+	if err := a.ctx.assignSubscripted(name, value); err != nil {
+		a.err = err
+	}
+}
+
+func (a *arith) apply(op string, left, right int64) int64 {
+	switch op {
+	case "||":
+		return boolToInt(left != 0 || right != 0)
+	case "&&":
+		return boolToInt(left != 0 && right != 0)
+	case "|":
+		return left | right
+	case "^":
+		return left ^ right
+	case "&":
+		return left & right
+	case "==":
+		return boolToInt(left == right)
+	case "!=":
+		return boolToInt(left != right)
+	case "<":
+		return boolToInt(left < right)
+	case "<=":
+		return boolToInt(left <= right)
+	case ">":
+		return boolToInt(left > right)
+	case ">=":
+		return boolToInt(left >= right)
+	case "<<":
+		return left << uint64(right&63)
+	case ">>":
+		return left >> uint64(right&63)
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "/", "%":
+		if right == 0 {
+			if a.noeval == 0 {
+				a.fail("division by 0")
+			}
+			return 0
+		}
+		if op == "/" {
+			return left / right
+		}
+		return left % right
+	case "**":
+		if right < 0 {
+			if a.noeval == 0 {
+				a.fail("exponent less than 0")
+			}
+			return 0
+		}
+		// By squaring, wrapping around like repeated multiplication would.
+		result := int64(1)
+		for ; right > 0; right >>= 1 {
+			if right&1 == 1 {
+				result *= left
+			}
+			left *= left
+		}
+		return result
+	}
+	return 0
+}
+
+// parseArithNumber reads an integer constant: decimal, octal with a leading
+// 0, hexadecimal with 0x, or base#digits for any base from 2 to 64.
+func parseArithNumber(text string) (int64, error) {
+	base := 10
+	digits := text
+	if prefix, rest, found := strings.Cut(text, "#"); found {
+		var err error
+		if base, err = strconv.Atoi(prefix); err != nil || base < 2 || base > 64 {
+			return 0, fmt.Errorf("invalid arithmetic base")
+		}
+		digits = rest
+	} else if len(text) > 1 && text[0] == '0' {
+		base, digits = 8, text[1:]
+		if digits[0] == 'x' || digits[0] == 'X' {
+			base, digits = 16, digits[1:]
+		}
+	}
+	if digits == "" {
+		return 0, fmt.Errorf("invalid number")
+	}
+	value := int64(0)
+	for i := 0; i < len(digits); i++ {
+		digit := arithDigit(digits[i], base)
+		if digit < 0 || digit >= base {
+			return 0, fmt.Errorf("value too great for base")
+		}
+		value = value*int64(base) + int64(digit)
+	}
+	return value, nil
+}
+
+// arithDigit maps a digit the way bash does: 0-9, then a-z, A-Z, @ and _,
+// with letters case-insensitive for bases up to 36.
+func arithDigit(c byte, base int) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		if base <= 36 {
+			return int(c-'A') + 10
+		}
+		return int(c-'A') + 36
+	case c == '@':
+		return 62
+	case c == '_':
+		return 63
+	}
+	return -1
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func boolToInt(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
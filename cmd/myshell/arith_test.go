@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArithmeticPower(t *testing.T) {
+	shellCtx := NewShellCtx()
+	tests := []struct {
+		expr string
+		want int64
+	}{
+		{"2**10", 1024},
+		{"(-3)**3", -27},
+		{"7**0", 1},
+		{"2**63", -9223372036854775808},
+		{"2**64", 0},
+		{"3**41", -420491770248316829},
+		{"1**9999999999", 1},
+		{"(-1)**9999999999", -1},
+	}
+	start := time.Now()
+	for _, test := range tests {
+		got, err := shellCtx.EvalArithmetic(test.expr)
+		if err != nil || got != test.want {
+			t.Errorf("$((%s)) = %d, %v, want %d", test.expr, got, err, test.want)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s", elapsed)
+	}
+}
+
+func TestEvalArithmetic(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.SetVar("v", "3")
+	tests := []struct {
+		expr string
+		want int64
+		ok   bool
+	}{
+		{"(1 + 2) * 3", 9, true},
+		{"7 / 2", 3, true},
+		{"-7 % 3", -1, true},
+		{"1 << 4 | 1", 17, true},
+		{"2 > 1 && 0 || 3", 1, true},
+		{"0x1f + 010 + 2#101", 44, true},
+		{"~0", -1, true},
+		{"!5", 0, true},
+		{"3 == 3 ? 10 : 20", 10, true},
+		{"v * v", 9, true},
+		{"unset + 1", 1, true},
+		{"1 / 0", 0, false},
+		{"1 +", 0, false},
+	}
+	for _, test := range tests {
+		got, err := shellCtx.EvalArithmetic(test.expr)
+		if (err == nil) != test.ok || (test.ok && got != test.want) {
+			t.Errorf("$((%s)) = %d, %v, want %d", test.expr, got, err, test.want)
+		}
+	}
+}
+
+func TestLetAndArithmeticCommand(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"let x=2+3 y=x*2; echo $? $x $y", "0 5 10\n"},
+		{`let "z = 7 % 3"; echo $z`, "1\n"},
+		{"let 0; echo $?", "1\n"},
+		{"(( n = 5, n += 2 )); echo $? $n", "0 7\n"},
+		{"(( 0 )); echo $?", "1\n"},
+		{"(( i++ )); echo $? $i", "1 1\n"},
+		{"(( ++j )); echo $? $j", "0 1\n"},
+		{"(( 1 / 0 )); echo $?", "1\n"},
+		{"echo $(( a = 4 )) $a", "4 4\n"},
+		{"v=3; echo $(( $v + 1 ))", "4\n"},
+		{"for ((k = 0; k < 3; k++)); do echo $k; done", "0\n1\n2\n"},
+		{"c=0; while (( c < 3 )); do (( c++ )); done; echo $c", "3\n"},
+	})
+}
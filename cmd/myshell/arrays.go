@@ -0,0 +1,710 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArrayElement is an element of a compound assignment, either a plain value
+// or [key]=value.
+type ArrayElement struct {
+	Key    string
+	HasKey bool
+	Value  string
+}
+
+// splitSubscript splits name[subscript] into its parts.
+func splitSubscript(word string) (string, string, bool) {
+	open := strings.IndexByte(word, '[')
+	if open <= 0 || !strings.HasSuffix(word, "]") || !IsValidName(word[:open]) {
+		return word, "", false
+	}
+	return word[:open], word[open+1 : len(word)-1], true
+}
+
+// isCompoundValue tells whether the raw value of an assignment is a list in
+// parentheses. The tokenizer only keeps an unquoted ( in a word after =.
+func isCompoundValue(raw string) bool {
+	return strings.HasPrefix(raw, "(") && strings.HasSuffix(raw, ")")
+}
+
+func (variable *Variable) indices() []int {
+	indices := make([]int, 0, len(variable.Elements))
+	for index := range variable.Elements {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+func (variable *Variable) keys() []string {
+	keys := make([]string, 0, len(variable.Assoc))
+	for key := range variable.Assoc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ArrayValues returns the elements of an array in order. A scalar is an
+// array of one element, and an unset variable one of none.
+func (ctx *ShellCtx) ArrayValues(name string) []string {
+	variable, found := ctx.Vars[name]
+	if found && variable.Assoc != nil {
+		values := make([]string, 0, len(variable.Assoc))
+		for _, key := range variable.keys() {
+			values = append(values, variable.Assoc[key])
+		}
+		return values
+	}
+	if !found || variable.Elements == nil || ctx.DynamicVars[name] {
+		if value, found := ctx.GetVar(name); found {
+			return []string{value}
+		}
+		return nil
+	}
+	values := make([]string, 0, len(variable.Elements))
+	for _, index := range variable.indices() {
+		values = append(values, variable.Elements[index])
+	}
+	return values
+}
+
+// ArrayKeys returns the keys of an associative array, or the indices of an
+// indexed one, in the order ArrayValues lists the elements.
+func (ctx *ShellCtx) ArrayKeys(name string) []string {
+	variable, found := ctx.Vars[name]
+	switch {
+	case found && variable.Assoc != nil:
+		return variable.keys()
+	case found && variable.Elements != nil && !ctx.DynamicVars[name]:
+		keys := []string{}
+		for _, index := range variable.indices() {
+			keys = append(keys, strconv.Itoa(index))
+		}
+		return keys
+	}
+	if _, found := ctx.GetVar(name); found {
+		return []string{"0"}
+	}
+	return nil
+}
+
+// assocKey expands the subscript of an associative array. Unlike an index
+// it isn't arithmetic, so map[a+1] is the key "a+1".
+func (ctx *ShellCtx) assocKey(name, subscript string) (string, error) {
+	key, err := ctx.ExpandString(subscript)
+	if err == nil && key == "" {
+		err = fmt.Errorf("%s[%s]: bad array subscript", name, subscript)
+	}
+	return key, err
+}
+
+// arrayIndex evaluates a subscript. Negative ones count back from the end
+// of the array.
+func (ctx *ShellCtx) arrayIndex(variable *Variable, name, subscript string) (int, error) {
+	index, err := ctx.EvalArithmetic(subscript)
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 && variable != nil && len(variable.Elements) > 0 {
+		indices := variable.indices()
+		index += int64(indices[len(indices)-1]) + 1
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("%s[%s]: bad array subscript", name, subscript)
+	}
+	return int(index), nil
+}
+
+// GetElement looks up name[subscript]. Element 0 of a scalar is its value.
+func (ctx *ShellCtx) GetElement(name, subscript string) (string, bool, error) {
+	variable := ctx.Vars[name]
+	if variable != nil && variable.Assoc != nil {
+		key, err := ctx.assocKey(name, subscript)
+		if err != nil {
+			return "", false, err
+		}
+		value, found := variable.Assoc[key]
+		return value, found, nil
+	}
+	index, err := ctx.arrayIndex(variable, name, subscript)
+	if err != nil || variable == nil || variable.Elements == nil {
+		if err == nil && index == 0 {
+			value, found := ctx.GetVar(name)
+			return value, found, nil
+		}
+		return "", false, err
+	}
+	value, found := variable.Elements[index]
+	return value, found, nil
+}
+
+// toArray makes name an indexed array, keeping the value of a scalar as
+// element 0, and returns it.
+func (ctx *ShellCtx) toArray(name string) (*Variable, error) {
+	variable, found := ctx.Vars[name]
+	if !found {
+		variable = &Variable{}
+		ctx.Vars[name] = variable
+	}
+	if variable.ReadOnly {
+		return nil, fmt.Errorf("%s: readonly variable", name)
+	}
+	if variable.Assoc != nil {
+		return nil, fmt.Errorf("%s: cannot convert associative to indexed array", name)
+	}
+	if variable.Elements == nil {
+		variable.Elements = map[int]string{}
+		if found {
+			variable.Elements[0] = variable.Value
+		}
+		variable.Value = ""
+	}
+	return variable, nil
+}
+
+// toAssoc makes name an associative array, keeping the value of a scalar
+// under the key "0".
+func (ctx *ShellCtx) toAssoc(name string) error {
+	variable, found := ctx.Vars[name]
+	if !found {
+		ctx.Vars[name] = &Variable{Assoc: map[string]string{}}
+		return nil
+	}
+	if variable.Elements != nil {
+		return fmt.Errorf("%s: cannot convert indexed to associative array", name)
+	}
+	if variable.Assoc == nil {
+		variable.Assoc = map[string]string{"0": variable.Value}
+		variable.Value = ""
+	}
+	return nil
+}
+
+func (ctx *ShellCtx) SetElement(name, subscript, value string) error {
+	if variable, found := ctx.Vars[name]; found && variable.Assoc != nil {
+		if variable.ReadOnly {
+			return fmt.Errorf("%s: readonly variable", name)
+		}
+		key, err := ctx.assocKey(name, subscript)
+		if err != nil {
+			return err
+		}
+		if variable.Assoc[key], err = ctx.convertValue(variable, value); err != nil {
+			return err
+		}
+		ctx.varChanged(name)
+		return nil
+	}
+	variable, err := ctx.toArray(name)
+	if err != nil {
+		return err
+	}
+	index, err := ctx.arrayIndex(variable, name, subscript)
+	if err != nil {
+		return err
+	}
+	if value, err = ctx.convertValue(variable, value); err != nil {
+		return err
+	}
+	variable.Elements[index] = value
+	ctx.varChanged(name)
+	return nil
+}
+
+// SetArray replaces the value of name with the given elements.
+func (ctx *ShellCtx) SetArray(name string, values []string) error {
+	variable, err := ctx.toArray(name)
+	if err != nil {
+		return err
+	}
+	variable.Elements = make(map[int]string, len(values))
+	for i, value := range values {
+		if variable.Elements[i], err = ctx.convertValue(variable, value); err != nil {
+			return err
+		}
+	}
+	ctx.varChanged(name)
+	return nil
+}
+
+func (ctx *ShellCtx) UnsetElement(name, subscript string) error {
+	variable, found := ctx.Vars[name]
+	if !found {
+		return nil
+	}
+	if variable.ReadOnly {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+	if variable.Assoc != nil {
+		key, err := ctx.assocKey(name, subscript)
+		if err != nil {
+			return err
+		}
+		delete(variable.Assoc, key)
+		ctx.varChanged(name)
+		return nil
+	}
+	index, err := ctx.arrayIndex(variable, name, subscript)
+	if err != nil {
+		return err
+	}
+	if variable.Elements == nil {
+		if index == 0 {
+			return ctx.UnsetVar(name)
+		}
+		return nil
+	}
+	delete(variable.Elements, index)
+	ctx.varChanged(name)
+	return nil
+}
+
+// expandCompound expands the elements of (...). Plain elements are split
+// and globbed like command arguments; the key and value of [key]=value are
+// expanded as single words.
+func (ctx *ShellCtx) expandCompound(raw string) ([]ArrayElement, error) {
+	tokens, err := Tokenize(raw[1 : len(raw)-1])
+	if err != nil {
+		return nil, err
+	}
+	elements := []ArrayElement{}
+	for _, token := range tokens {
+		if token.Kind == TokenNewline {
+			continue
+		}
+		if token.Kind != TokenWord {
+			return nil, fmt.Errorf("syntax error near unexpected token `%s'", token.Value)
+		}
+		if key, value, found := strings.Cut(token.Value, "]="); found && strings.HasPrefix(key, "[") {
+			element := ArrayElement{HasKey: true}
+			if element.Key, err = ctx.ExpandString(key[1:]); err != nil {
+				return nil, err
+			}
+			if element.Value, err = ctx.ExpandAssignmentValue(value); err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+			continue
+		}
+		fields, err := ctx.ExpandWord(token.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			elements = append(elements, ArrayElement{Value: field})
+		}
+	}
+	return elements, nil
+}
+
+// ExpandAssignment expands the value of an assignment word in place.
+func (ctx *ShellCtx) ExpandAssignment(assignment *Assignment) error {
+	var err error
+	if isCompoundValue(assignment.Value) {
+		assignment.Compound, err = ctx.expandCompound(assignment.Value)
+		return err
+	}
+	assignment.Value, err = ctx.ExpandAssignmentValue(assignment.Value)
+	return err
+}
+
+// Assign carries out an expanded assignment.
+func (ctx *ShellCtx) Assign(assignment Assignment) error {
+	name := assignment.Name
+	if assignment.Compound != nil {
+		if assignment.Indexed {
+			return fmt.Errorf("%s[%s]: cannot assign list to array member", name, assignment.Subscript)
+		}
+		return ctx.assignCompound(name, assignment.Compound, assignment.Append)
+	}
+
+	value := assignment.Value
+	if assignment.Append {
+		var old string
+		if assignment.Indexed {
+			old, _, _ = ctx.GetElement(name, assignment.Subscript)
+		} else {
+			old, _ = ctx.GetVar(name)
+		}
+		value = ctx.appendValue(name, old, value)
+	}
+	if assignment.Indexed {
+		return ctx.SetElement(name, assignment.Subscript, value)
+	}
+	return ctx.SetVar(name, value)
+}
+
+// appendValue is the value += leaves: a sum for integer variables, and the
+// concatenation otherwise.
+func (ctx *ShellCtx) appendValue(name, old, value string) string {
+	if variable, found := ctx.Vars[name]; found && variable.Integer {
+		if old == "" {
+			old = "0"
+		}
+		return old + "+(" + value + ")"
+	}
+	return old + value
+}
+
+func (ctx *ShellCtx) assignCompound(name string, elements []ArrayElement, appending bool) error {
+	if variable, found := ctx.Vars[name]; found && variable.Assoc != nil {
+		return ctx.assignAssoc(name, variable, elements, appending)
+	}
+	variable, err := ctx.toArray(name)
+	if err != nil {
+		return err
+	}
+	next := 0
+	if appending {
+		if indices := variable.indices(); len(indices) > 0 {
+			next = indices[len(indices)-1] + 1
+		}
+	} else {
+		variable.Elements = map[int]string{}
+	}
+	for _, element := range elements {
+		if element.HasKey {
+			if next, err = ctx.arrayIndex(variable, name, element.Key); err != nil {
+				return err
+			}
+		}
+		if variable.Elements[next], err = ctx.convertValue(variable, element.Value); err != nil {
+			return err
+		}
+		next++
+	}
+	ctx.varChanged(name)
+	return nil
+}
+
+// assignAssoc assigns (...) to an associative array, where every element
+// needs a key.
+func (ctx *ShellCtx) assignAssoc(name string, variable *Variable, elements []ArrayElement, appending bool) error {
+	if variable.ReadOnly {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if !appending {
+		variable.Assoc = map[string]string{}
+	}
+	var err error
+	for _, element := range elements {
+		if !element.HasKey {
+			return fmt.Errorf("%s: %s: must use subscript when assigning associative array", name, element.Value)
+		}
+		if element.Key == "" {
+			return fmt.Errorf("%s[]: bad array subscript", name)
+		}
+		if variable.Assoc[element.Key], err = ctx.convertValue(variable, element.Value); err != nil {
+			return err
+		}
+	}
+	ctx.varChanged(name)
+	return nil
+}
+
+// isDeclarationCommand tells whether a command takes assignments as its
+// arguments, which are then expanded without field splitting.
+func isDeclarationCommand(word string) bool {
+	switch word {
+	case "declare", "typeset", "local", "readonly":
+		return true
+	}
+	return false
+}
+
+// expandDeclarationArg expands an assignment given to a declaration
+// command into a single argument. The elements of a compound value come out
+// single-quoted, so that the command can parse them again unchanged.
+func (ctx *ShellCtx) expandDeclarationArg(raw string, assignment Assignment) (string, error) {
+	prefix := raw[:len(raw)-len(assignment.Value)]
+	if !isCompoundValue(assignment.Value) {
+		value, err := ctx.ExpandAssignmentValue(assignment.Value)
+		return prefix + value, err
+	}
+	elements, err := ctx.expandCompound(assignment.Value)
+	if err != nil {
+		return "", err
+	}
+	words := make([]string, len(elements))
+	for i, element := range elements {
+		words[i] = SingleQuote(element.Value)
+		if element.HasKey {
+			words[i] = "[" + SingleQuote(element.Key) + "]=" + words[i]
+		}
+	}
+	return prefix + "(" + strings.Join(words, " ") + ")", nil
+}
+
+// formatValue renders the value of a variable for declare -p, arrays as
+// ([0]="a" [1]="b").
+func formatValue(variable *Variable) string {
+	if variable.Assoc != nil {
+		elements := ""
+		for _, key := range variable.keys() {
+			if ShellQuote(key) != key {
+				key = QuoteValue(key)
+			}
+			elements += fmt.Sprintf("[%s]=%s ", key, QuoteValue(variable.Assoc[key]))
+		}
+		return "(" + elements + ")"
+	}
+	if variable.Elements == nil {
+		return QuoteValue(variable.Value)
+	}
+	elements := []string{}
+	for _, index := range variable.indices() {
+		elements = append(elements, fmt.Sprintf("[%d]=%s", index, QuoteValue(variable.Elements[index])))
+	}
+	return "(" + strings.Join(elements, " ") + ")"
+}
+
+func declareFlags(variable *Variable) string {
+	flags := ""
+	if variable.Elements != nil {
+		flags += "a"
+	}
+	if variable.Assoc != nil {
+		flags += "A"
+	}
+	if variable.Integer {
+		flags += "i"
+	}
+	if variable.ReadOnly {
+		flags += "r"
+	}
+	if variable.Exported {
+		flags += "x"
+	}
+	if flags == "" {
+		return "--"
+	}
+	return "-" + flags
+}
+
+type declareOptions struct {
+	print, functions, functionNames, global bool
+	// set and unset hold the attribute letters turned on with - and off
+	// with +.
+	set, unset string
+}
+
+// DeclareExecutor implements `declare [-aAifFgprx] [name[=value] ...]`, also
+// known as typeset. Inside a function the names become local unless -g is
+// given. Without names it lists the variables that have the requested
+// attributes, or all of them.
+func DeclareExecutor(shellCtx *ShellCtx, args []string) error {
+	return declareVariables(shellCtx, "declare", args)
+}
+
+func declareVariables(shellCtx *ShellCtx, command string, args []string) error {
+	options := declareOptions{}
+	for len(args) > 0 && len(args[0]) > 1 && (args[0][0] == '-' || args[0][0] == '+') {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch {
+			case flag == 'p':
+				options.print = true
+			case flag == 'f':
+				options.functions = true
+			case flag == 'F':
+				options.functionNames = true
+			case flag == 'g' && command == "declare":
+				options.global = true
+			case strings.ContainsRune("aAirx", flag) && option[0] == '-':
+				options.set += string(flag)
+			case strings.ContainsRune("ix", flag):
+				options.unset += string(flag)
+			case flag == 'r' || flag == 'a' || flag == 'A':
+				shellCtx.Serr = fmt.Sprintf("%s: +%c: cannot remove the attribute\n", command, flag)
+				shellCtx.Status = 1
+				return nil
+			default:
+				return fmt.Errorf("%s command got invalid option %c%c", command, option[0], flag)
+			}
+		}
+	}
+
+	if options.functions || options.functionNames {
+		return declareFunctions(shellCtx, args, options.functionNames)
+	}
+	if len(args) == 0 || options.print {
+		return declarePrint(shellCtx, command, args, options)
+	}
+	local := command == "local" || (len(shellCtx.localScopes) > 0 && !options.global)
+	for _, arg := range args {
+		if err := shellCtx.declare(arg, options, local); err != nil {
+			shellCtx.Serr += fmt.Sprintf("%s: %s\n", command, err.Error())
+			shellCtx.Status = 1
+		}
+	}
+	return nil
+}
+
+func (ctx *ShellCtx) declare(arg string, options declareOptions, local bool) error {
+	assignment, hasValue := ParseAssignmentWord(arg)
+	if !hasValue {
+		assignment.Name = arg
+		if !IsValidName(arg) {
+			return fmt.Errorf("`%s': not a valid identifier", arg)
+		}
+	}
+	name := assignment.Name
+	if local {
+		if err := ctx.makeLocal(name); err != nil {
+			return err
+		}
+	}
+	variable, found := ctx.Vars[name]
+	if !found && (hasValue || options.set != "") {
+		variable = &Variable{}
+		if strings.Contains(options.set, "A") {
+			variable.Assoc = map[string]string{}
+		} else if strings.Contains(options.set, "a") {
+			variable.Elements = map[int]string{}
+		}
+		ctx.Vars[name] = variable
+	}
+	if variable == nil {
+		return nil
+	}
+	if variable.ReadOnly && (hasValue || strings.ContainsAny(options.set+options.unset, "aAix")) {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if strings.Contains(options.set, "A") {
+		if err := ctx.toAssoc(name); err != nil {
+			return err
+		}
+	} else if strings.Contains(options.set, "a") || (hasValue && isCompoundValue(assignment.Value) && variable.Assoc == nil) {
+		if _, err := ctx.toArray(name); err != nil {
+			return err
+		}
+	}
+	if strings.Contains(options.set, "i") {
+		variable.Integer = true
+	}
+	if strings.Contains(options.unset, "i") {
+		variable.Integer = false
+	}
+	if strings.Contains(options.set, "x") {
+		variable.Exported = true
+	}
+	if strings.Contains(options.unset, "x") {
+		variable.Exported = false
+	}
+	if hasValue {
+		// The value was expanded with the command; only the elements of a
+		// compound value, quoted since, are expanded again.
+		if isCompoundValue(assignment.Value) {
+			elements, err := ctx.expandCompound(assignment.Value)
+			if err != nil {
+				return err
+			}
+			assignment.Compound = elements
+		}
+		if err := ctx.Assign(assignment); err != nil {
+			return err
+		}
+	}
+	if strings.Contains(options.set, "r") {
+		variable.ReadOnly = true
+	}
+	return nil
+}
+
+// makeLocal makes name local to the function being run. It starts out
+// unset, the variable it shadows being put back when the function returns.
+func (ctx *ShellCtx) makeLocal(name string) error {
+	scope := ctx.localScopes[len(ctx.localScopes)-1]
+	variable, found := ctx.Vars[name]
+	if found && variable.ReadOnly {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if _, declared := scope[name]; !declared {
+		scope[name] = variable
+		delete(ctx.Vars, name)
+		if found {
+			ctx.varChanged(name)
+		}
+	}
+	return nil
+}
+
+func declarePrint(shellCtx *ShellCtx, command string, names []string, options declareOptions) error {
+	if len(names) == 0 {
+		for name, variable := range shellCtx.Vars {
+			if hasAttributes(variable, options.set) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		variable, found := shellCtx.Vars[name]
+		if !found {
+			shellCtx.Serr += fmt.Sprintf("%s: %s: not found\n", command, name)
+			shellCtx.Status = 1
+			continue
+		}
+		if !options.print {
+			shellCtx.Sout += fmt.Sprintf("%s=%s\n", name, formatValue(variable))
+			continue
+		}
+		shellCtx.Sout += fmt.Sprintf("declare %s %s=%s\n", declareFlags(variable), name, formatValue(variable))
+	}
+	return nil
+}
+
+func hasAttributes(variable *Variable, attributes string) bool {
+	for _, flag := range attributes {
+		if !strings.ContainsRune(declareFlags(variable), flag) {
+			return false
+		}
+	}
+	return true
+}
+
+func declareFunctions(shellCtx *ShellCtx, names []string, namesOnly bool) error {
+	if len(names) == 0 {
+		for name := range shellCtx.Functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		def, found := shellCtx.Functions[name]
+		switch {
+		case !found:
+			shellCtx.Status = 1
+		case namesOnly:
+			shellCtx.Sout += fmt.Sprintf("declare -f %s\n", name)
+		default:
+			shellCtx.Sout += def.Source + "\n"
+		}
+	}
+	return nil
+}
+
+// lookupSubscripted is GetVar for names that may have a subscript, as
+// arithmetic and [[ -v ]] accept.
+func (ctx *ShellCtx) lookupSubscripted(word string) (string, bool, error) {
+	if name, subscript, ok := splitSubscript(word); ok {
+		return ctx.GetElement(name, subscript)
+	}
+	value, found := ctx.GetVar(word)
+	return value, found, nil
+}
+
+func (ctx *ShellCtx) assignSubscripted(word string, value int64) error {
+	if name, subscript, ok := splitSubscript(word); ok {
+		return ctx.SetElement(name, subscript, strconv.FormatInt(value, 10))
+	}
+	return ctx.SetVar(word, strconv.FormatInt(value, 10))
+}
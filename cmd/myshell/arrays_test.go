@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestIndexedArrays(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"arr=(a b c); echo ${arr[1]} ${arr[@]} ${#arr[@]} ${#arr[2]}", "b a b c 3 1\n"},
+		{"arr=(a b c); arr[5]=f; echo ${#arr[@]} ${!arr[@]}", "4 0 1 2 5\n"},
+		{"arr=(a b); arr+=(c d); echo ${arr[@]}", "a b c d\n"},
+		{"arr=(a b c); echo $arr ${arr[-1]}", "a c\n"},
+		{"arr=(a b c); unset 'arr[0]'; echo ${arr[@]} ${!arr[@]}", "b c 1 2\n"},
+		{`q=("a b" c); for v in "${q[@]}"; do echo "<$v>"; done`, "<a b>\n<c>\n"},
+		{`q=("a b" c); for v in "${q[*]}"; do echo "<$v>"; done`, "<a b c>\n"},
+		{"declare -a e; echo ${#e[@]}", "0\n"},
+		{"arr=(a b); arr[5]=f; declare -p arr", "declare -a arr=([0]=\"a\" [1]=\"b\" [5]=\"f\")\n"},
+	})
+}
+
+func TestDeclare(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"declare x=1 y; echo $x; declare -p x", "1\ndeclare -- x=\"1\"\n"},
+		{"typeset t=5; echo $t", "5\n"},
+		{"declare -i n=2+3; echo $n; n+=4; echo $n; n=abc; echo $n", "5\n9\n0\n"},
+		{`f() { declare loc=1; }; f; echo "[$loc]"`, "[]\n"},
+	})
+}
+
+func TestAssociativeArrays(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`declare -A m; m[one]=1; m["two words"]=2; echo ${m[one]} ${m[two words]} ${#m[@]}`, "1 2 2\n"},
+		{`declare -A m=([b]=2 [a]=1); for k in "${!m[@]}"; do echo "$k=${m[$k]}"; done`, "a=1\nb=2\n"},
+		{`declare -A m; m[x]=1; m[y]=2; unset 'm[x]'; echo ${!m[@]} ${#m[@]}`, "y 1\n"},
+		{`declare -A m; k=key; m[$k]=v; echo ${m[key]} "[${m[nope]}]"`, "v []\n"},
+		{"declare -A m; m[a]=1; m[a]=2; m[b]=3; m[b]+=4; echo ${m[a]} ${m[b]} ${#m[@]}", "2 34 2\n"},
+		{"declare -A m; m[1+1]=x; echo ${!m[@]}", "1+1\n"},
+		{"declare -A m=([k]=v); declare -p m", "declare -A m=([k]=\"v\" )\n"},
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CommandIndex caches the executables of the PATH directories completion
+// needs, as looking at every file of every one of them on Tab takes seconds
+// with large PATHs. It is kept in a file between sessions, and directories
+// modified since they were listed are listed again in the background, so
+// a Tab may still miss a command installed just before.
+type CommandIndex struct {
+	path       string
+	mutex      sync.Mutex
+	dirs       map[string]indexedDir
+	refreshing bool
+}
+
+// indexedDir is what a directory held when it was last modified.
+type indexedDir struct {
+	modified int64
+	names    []string
+}
+
+// NewCommandIndex returns the index kept in path, none when it's empty,
+// which once loaded is brought up to date for folders.
+func NewCommandIndex(path string, folders []string) *Lazy[*CommandIndex] {
+	return NewLazy(func() *CommandIndex {
+		index := &CommandIndex{path: path, dirs: map[string]indexedDir{}}
+		index.load()
+		index.Refresh(folders)
+		return index
+	})
+}
+
+// CommandIndexPath is where the command index is kept:
+// $XDG_CACHE_HOME/myshell/commands, defaulting to ~/.cache/myshell/commands.
+func (ctx *ShellCtx) CommandIndexPath() string {
+	if cacheHome, found := ctx.GetVar("XDG_CACHE_HOME"); found && filepath.IsAbs(cacheHome) {
+		return filepath.Join(cacheHome, "myshell", "commands")
+	}
+	homeDir, _ := ctx.GetVar("HOME")
+	return filepath.Join(homeDir, ".cache", "myshell", "commands")
+}
+
+// Names returns the executables in folders as last listed, listing those
+// never seen now, and starts bringing the others up to date for the next
+// time.
+func (index *CommandIndex) Names(folders []string) []string {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	names := []string{}
+	for _, folder := range folders {
+		dir, found := index.dirs[folder]
+		if !found {
+			dir = listExecutables(folder)
+			index.dirs[folder] = dir
+		}
+		names = append(names, dir.names...)
+	}
+	if !index.refreshing {
+		index.refreshing = true
+		go func() {
+			index.Refresh(folders)
+			index.mutex.Lock()
+			index.refreshing = false
+			index.mutex.Unlock()
+		}()
+	}
+	return names
+}
+
+// Refresh lists again the folders modified since they were listed, and
+// saves the index when any was.
+func (index *CommandIndex) Refresh(folders []string) {
+	changed := false
+	for _, folder := range folders {
+		index.mutex.Lock()
+		dir, found := index.dirs[folder]
+		index.mutex.Unlock()
+		if found && dir.modified == modifiedTime(folder) {
+			continue
+		}
+		dir = listExecutables(folder)
+		index.mutex.Lock()
+		index.dirs[folder] = dir
+		index.mutex.Unlock()
+		changed = true
+	}
+	if changed {
+		index.save()
+	}
+}
+
+func modifiedTime(folder string) int64 {
+	info, err := os.Stat(folder)
+	if err != nil {
+		return 0
+	}
+	return info.ModTime().UnixNano()
+}
+
+// listExecutables lists the executable files of folder. The time it was
+// modified is taken first, so that a change while listing it isn't missed
+// the next time.
+func listExecutables(folder string) indexedDir {
+	dir := indexedDir{modified: modifiedTime(folder)}
+	entries, _ := os.ReadDir(folder)
+	for _, entry := range entries {
+		if info, err := os.Stat(filepath.Join(folder, entry.Name())); err == nil && !info.IsDir() && IsExecAny(info.Mode()) {
+			dir.names = append(dir.names, entry.Name())
+		}
+	}
+	return dir
+}
+
+// load reads the index file: a line per directory, with its name, the time
+// it was modified and the names of its executables, separated by tabs.
+func (index *CommandIndex) load() {
+	if index.path == "" {
+		return
+	}
+	data, err := os.ReadFile(index.path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		modified, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		index.dirs[fields[0]] = indexedDir{modified: modified, names: fields[2:]}
+	}
+}
+
+// save writes the index file through a temporary one, so that another shell
+// never reads half of it.
+func (index *CommandIndex) save() {
+	if index.path == "" {
+		return
+	}
+	text := strings.Builder{}
+	index.mutex.Lock()
+	for folder, dir := range index.dirs {
+		if strings.ContainsAny(folder, "\t\n") {
+			continue
+		}
+		text.WriteString(folder + "\t" + strconv.FormatInt(dir.modified, 10))
+		for _, name := range dir.names {
+			if !strings.ContainsAny(name, "\t\n") {
+				text.WriteString("\t" + name)
+			}
+		}
+		text.WriteString("\n")
+	}
+	index.mutex.Unlock()
+	if err := os.MkdirAll(filepath.Dir(index.path), 0o755); err != nil {
+		return
+	}
+	file, err := os.CreateTemp(filepath.Dir(index.path), ".commands-*")
+	if err != nil {
+		return
+	}
+	_, err = file.WriteString(text.String())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(file.Name(), index.path) != nil {
+		os.Remove(file.Name())
+	}
+}
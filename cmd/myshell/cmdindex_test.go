@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// settle waits for the refresh Names starts in the background.
+func settle(index *CommandIndex) {
+	for {
+		index.mutex.Lock()
+		refreshing := index.refreshing
+		index.mutex.Unlock()
+		if !refreshing {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func sortedCopy(names []string) []string {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	return names
+}
+
+func TestCommandIndex(t *testing.T) {
+	bin := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"tool": 0o755, "other": 0o700, "plain": 0o644} {
+		if err := os.WriteFile(filepath.Join(bin, name), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(bin, "subdir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cache", "commands")
+	index := NewCommandIndex(path, []string{bin}).Get()
+	if got := sortedCopy(index.Names([]string{bin})); !slices.Equal(got, []string{"other", "tool"}) {
+		t.Errorf("names: got %q", got)
+	}
+	settle(index)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("index not saved: %v", err)
+	}
+
+	// Another session takes the names from the file while the directory
+	// is unchanged, and lists it again once it changed.
+	modified := strconv.FormatInt(modifiedTime(bin), 10)
+	if err := os.WriteFile(path, []byte(bin+"\t"+modified+"\tcached\nbad line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	index = NewCommandIndex(path, []string{bin}).Get()
+	if got := index.Names([]string{bin}); !slices.Equal(got, []string{"cached"}) {
+		t.Errorf("from the file: got %q", got)
+	}
+	settle(index)
+	if err := os.WriteFile(filepath.Join(bin, "new"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(bin, later, later); err != nil {
+		t.Fatal(err)
+	}
+	index.Refresh([]string{bin})
+	if got := sortedCopy(index.Names([]string{bin})); !slices.Equal(got, []string{"new", "other", "tool"}) {
+		t.Errorf("after a change: got %q", got)
+	}
+	settle(index)
+
+	// A directory it doesn't have is listed on the spot, and one that
+	// doesn't exist has nothing.
+	more := t.TempDir()
+	if err := os.WriteFile(filepath.Join(more, "extra"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if got := index.Names([]string{more, filepath.Join(more, "nosuch")}); !slices.Equal(got, []string{"extra"}) {
+		t.Errorf("new directory: got %q", got)
+	}
+	settle(index)
+
+	// Without a file nothing is saved.
+	index = NewCommandIndex("", []string{bin}).Get()
+	if got := len(index.Names([]string{bin})); got != 3 {
+		t.Errorf("without a file: got %d names", got)
+	}
+	settle(index)
+}
+
+func TestCommandIndexPath(t *testing.T) {
+	tests := []struct {
+		cacheHome string
+		want      string
+	}{
+		{"/cache", "/cache/myshell/commands"},
+		{"relative", "/home/me/.cache/myshell/commands"},
+		{"", "/home/me/.cache/myshell/commands"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("HOME", "/home/me")
+		shellCtx.UnsetVar("XDG_CACHE_HOME")
+		if test.cacheHome != "" {
+			shellCtx.SetVar("XDG_CACHE_HOME", test.cacheHome)
+		}
+		if got := shellCtx.CommandIndexPath(); got != test.want {
+			t.Errorf("XDG_CACHE_HOME=%s: got %q, want %q", test.cacheHome, got, test.want)
+		}
+	}
+}
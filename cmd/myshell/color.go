@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ColorDepth is how many colors the terminal can show. It is detected once at
+// startup and published as MYSHELL_COLORS; setting that variable, or passing
+// -colors, forces a depth instead.
+type ColorDepth int
+
+const (
+	ColorNone ColorDepth = iota
+	Color16
+	Color256
+	ColorTrue
+)
+
+func (depth ColorDepth) String() string {
+	switch depth {
+	case Color16:
+		return "16"
+	case Color256:
+		return "256"
+	case ColorTrue:
+		return "truecolor"
+	}
+	return "none"
+}
+
+func ParseColorDepth(value string) (ColorDepth, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "none", "0", "never":
+		return ColorNone, nil
+	case "16", "8":
+		return Color16, nil
+	case "256":
+		return Color256, nil
+	case "truecolor", "24bit":
+		return ColorTrue, nil
+	}
+	return ColorNone, fmt.Errorf("invalid color depth %q, expected none, 16, 256 or truecolor", value)
+}
+
+// DetectColorDepth guesses the color depth from the environment the way most
+// terminal programs do: NO_COLOR and dumb or missing terminals get none,
+// COLORTERM announces truecolor and TERM tells about 256 colors.
+func DetectColorDepth(getenv func(string) string, isTerminal bool) ColorDepth {
+	term := getenv("TERM")
+	if getenv("NO_COLOR") != "" || !isTerminal || term == "" || term == "dumb" {
+		return ColorNone
+	}
+	switch colorTerm := getenv("COLORTERM"); {
+	case colorTerm == "truecolor" || colorTerm == "24bit":
+		return ColorTrue
+	case strings.Contains(term, "256color"):
+		return Color256
+	}
+	return Color16
+}
+
+// Foreground returns the escape sequence setting the foreground to the given
+// color, degraded to what the terminal supports.
+func (depth ColorDepth) Foreground(r, g, b uint8) string {
+	switch depth {
+	case ColorTrue:
+		return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", r, g, b)
+	case Color256:
+		return fmt.Sprintf("\x1b[38;5;%dm", rgbTo256(r, g, b))
+	case Color16:
+		return fmt.Sprintf("\x1b[%dm", rgbTo16(r, g, b))
+	}
+	return ""
+}
+
+// rgbTo256 picks the nearest entry of the 6x6x6 color cube, or of the
+// grayscale ramp for grays.
+func rgbTo256(r, g, b uint8) int {
+	if r == g && g == b {
+		if r < 8 {
+			return 16
+		}
+		if r > 248 {
+			return 231
+		}
+		return 232 + (int(r)-8)*24/241
+	}
+	level := func(c uint8) int { return (int(c)*5 + 127) / 255 }
+	return 16 + 36*level(r) + 6*level(g) + level(b)
+}
+
+// rgbTo16 maps a color to one of the 8 basic ANSI colors, using the bright
+// variant for light colors.
+func rgbTo16(r, g, b uint8) int {
+	code := 30
+	if r >= 128 {
+		code += 1
+	}
+	if g >= 128 {
+		code += 2
+	}
+	if b >= 128 {
+		code += 4
+	}
+	if int(r)+int(g)+int(b) > 3*192 {
+		code += 60
+	}
+	return code
+}
+
+// InitColorDepth settles the color depth at startup: forced by the -colors
+// flag or an inherited MYSHELL_COLORS, detected otherwise.
+func (ctx *ShellCtx) InitColorDepth(forced string) error {
+	if len(forced) == 0 {
+		forced, _ = ctx.GetVar("MYSHELL_COLORS")
+	}
+	depth := DetectColorDepth(os.Getenv, isTerminalFile(os.Stdout))
+	if len(forced) > 0 && forced != "auto" {
+		parsed, err := ParseColorDepth(forced)
+		if err != nil {
+			return err
+		}
+		depth = parsed
+	}
+	ctx.ColorDepth = depth
+	ctx.SetVar("MYSHELL_COLORS", depth.String())
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+func TestDetectColorDepth(t *testing.T) {
+	tests := []struct {
+		env        map[string]string
+		isTerminal bool
+		want       ColorDepth
+	}{
+		{map[string]string{"TERM": "xterm"}, true, Color16},
+		{map[string]string{"TERM": "xterm-256color"}, true, Color256},
+		{map[string]string{"TERM": "xterm-256color", "COLORTERM": "truecolor"}, true, ColorTrue},
+		{map[string]string{"TERM": "xterm", "COLORTERM": "24bit"}, true, ColorTrue},
+		{map[string]string{"TERM": "xterm-256color"}, false, ColorNone},
+		{map[string]string{"TERM": "dumb"}, true, ColorNone},
+		{map[string]string{}, true, ColorNone},
+		{map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}, true, ColorNone},
+	}
+	for _, test := range tests {
+		getenv := func(name string) string { return test.env[name] }
+		if got := DetectColorDepth(getenv, test.isTerminal); got != test.want {
+			t.Errorf("%v, terminal %v: got %s, want %s", test.env, test.isTerminal, got, test.want)
+		}
+	}
+}
+
+func TestParseColorDepth(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ColorDepth
+		ok    bool
+	}{
+		{"none", ColorNone, true},
+		{"16", Color16, true},
+		{" 256 ", Color256, true},
+		{"TrueColor", ColorTrue, true},
+		{"24bit", ColorTrue, true},
+		{"lots", ColorNone, false},
+	}
+	for _, test := range tests {
+		got, err := ParseColorDepth(test.value)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("ParseColorDepth(%q) = %s, %v", test.value, got, err)
+		}
+		if test.ok && got.String() != "none" {
+			if again, _ := ParseColorDepth(got.String()); again != got {
+				t.Errorf("%s doesn't parse back", got)
+			}
+		}
+	}
+}
+
+func TestForeground(t *testing.T) {
+	tests := []struct {
+		depth   ColorDepth
+		r, g, b uint8
+		want    string
+	}{
+		{ColorTrue, 10, 20, 30, "\x1b[38;2;10;20;30m"},
+		{Color256, 255, 0, 0, "\x1b[38;5;196m"},
+		{Color256, 0, 0, 0, "\x1b[38;5;16m"},
+		{Color256, 128, 128, 128, "\x1b[38;5;243m"},
+		{Color16, 255, 0, 0, "\x1b[31m"},
+		{Color16, 255, 255, 255, "\x1b[97m"},
+		{ColorNone, 255, 0, 0, ""},
+	}
+	for _, test := range tests {
+		if got := test.depth.Foreground(test.r, test.g, test.b); got != test.want {
+			t.Errorf("%s Foreground(%d, %d, %d) = %q, want %q", test.depth, test.r, test.g, test.b, got, test.want)
+		}
+	}
+}
+
+func TestInitColorDepth(t *testing.T) {
+	tests := []struct {
+		forced, inherited, want string
+		ok                      bool
+	}{
+		{"256", "", "256", true},
+		{"", "truecolor", "truecolor", true},
+		{"16", "truecolor", "16", true},
+		{"bad", "", "", false},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.UnsetVar("MYSHELL_COLORS")
+		if len(test.inherited) > 0 {
+			shellCtx.SetVar("MYSHELL_COLORS", test.inherited)
+		}
+		err := shellCtx.InitColorDepth(test.forced)
+		if (err == nil) != test.ok {
+			t.Errorf("InitColorDepth(%q): %v", test.forced, err)
+			continue
+		}
+		if got, _ := shellCtx.GetVar("MYSHELL_COLORS"); test.ok && (got != test.want || shellCtx.ColorDepth.String() != test.want) {
+			t.Errorf("InitColorDepth(%q) with %q: got %s", test.forced, test.inherited, got)
+		}
+	}
+}
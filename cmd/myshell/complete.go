@@ -0,0 +1,712 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// completionWord is the word Tab completes, the one the cursor is at the end
+// of. start is where it begins in the text before the cursor, value is what
+// it reads with quotes and backslashes removed and quote the quote still
+// open at its end, if any. words holds the values of the words of the
+// command before it, empty when it is the command name itself, and
+// redirect is set when it is the file of a < or > redirection instead. line
+// is the whole text before the cursor. When the word ends in a $name or
+// ${name} reference being typed, variable is set and start and value are
+// those of the reference alone, as typed.
+type completionWord struct {
+	line     string
+	start    int
+	value    string
+	quote    byte
+	words    []string
+	redirect bool
+	variable bool
+}
+
+// parseCompletionWord finds the word being completed at the end of text.
+func parseCompletionWord(text string) completionWord {
+	word := completionWord{line: text, start: len(text)}
+	value := strings.Builder{}
+	inWord := false
+	begin := func(i int) {
+		if !inWord {
+			inWord = true
+			word.start = i
+		}
+	}
+	end := func() {
+		if !inWord {
+			return
+		}
+		// A reserved word like then or do is followed by another command.
+		if len(word.words) == 0 && !word.redirect && reservedWords[value.String()] {
+			value.Reset()
+		} else if word.redirect {
+			word.redirect = false
+		} else {
+			word.words = append(word.words, value.String())
+		}
+		value.Reset()
+		inWord = false
+		word.start = len(text)
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case word.quote == '\'':
+			if c == '\'' {
+				word.quote = 0
+			} else {
+				value.WriteByte(c)
+			}
+		case word.quote == '"':
+			if c == '"' {
+				word.quote = 0
+			} else if c == '\\' && i+1 < len(text) && strings.IndexByte("$`\"\\", text[i+1]) >= 0 {
+				i++
+				value.WriteByte(text[i])
+			} else {
+				value.WriteByte(c)
+			}
+		case c == '\\':
+			begin(i)
+			if i+1 < len(text) {
+				i++
+				value.WriteByte(text[i])
+			}
+		case c == '\'' || c == '"':
+			begin(i)
+			word.quote = c
+		case c == ' ' || c == '\t':
+			end()
+		case c == '<' || c == '>':
+			end()
+			word.redirect = true
+		case c == '\n' || strings.IndexByte(";&|()", c) >= 0:
+			end()
+			word.words = nil
+			word.redirect = false
+		default:
+			begin(i)
+			value.WriteByte(c)
+		}
+	}
+	word.value = value.String()
+	if !inWord {
+		word.start = len(text)
+	}
+	if word.quote != '\'' {
+		if start := variableReferenceStart(text[word.start:]); start >= 0 {
+			word.start += start
+			word.value = text[word.start:]
+			word.variable = true
+		}
+	}
+	return word
+}
+
+// variableReferenceStart finds where the $ of a variable reference the text
+// ends with is, -1 when it doesn't end with one or the $ is escaped.
+func variableReferenceStart(text string) int {
+	dollar := strings.LastIndexByte(text, '$')
+	if dollar < 0 {
+		return -1
+	}
+	name := strings.TrimPrefix(text[dollar+1:], "{")
+	if name != "" && !IsValidName(name) {
+		return -1
+	}
+	escapes := 0
+	for escapes < dollar && text[dollar-escapes-1] == '\\' {
+		escapes++
+	}
+	if escapes%2 == 1 {
+		return -1
+	}
+	return dollar
+}
+
+// text is how candidate is written in place of the word, final when nothing
+// is left to complete after it.
+func (word completionWord) text(candidate string, final bool) string {
+	if word.variable {
+		return candidate
+	}
+	return quoteCompletion(candidate, word.quote, final)
+}
+
+// Complete returns what the word being completed can become, sorted, and
+// whether a final completion is left without a space after it. Variable
+// references complete to the names of variables. The arguments of commands
+// with a spec set by complete are completed as it says; otherwise it's the
+// options of a builtin for a word starting with -, names of commands for
+// the first word of a command, directories for cd and pushd, hosts for ssh,
+// scp and rsync and paths of files for the rest. With fuzzycomplete, a word
+// no candidate starts with gets those it is a substring or a subsequence of
+// instead, best matches first, and with nocasecomplete case is ignored.
+func (ctx *ShellCtx) Complete(word completionWord) ([]string, bool) {
+	candidates, nospace := ctx.completeMatching(word)
+	if len(candidates) > 0 || !ctx.Options["fuzzycomplete"] {
+		return candidates, nospace
+	}
+	ctx.fuzzyCompletion = true
+	defer func() { ctx.fuzzyCompletion = false }()
+	candidates, nospace = ctx.completeMatching(word)
+	// Paths are ranked by their last component, the one being typed.
+	cut := strings.LastIndexByte(word.value, '/') + 1
+	score := func(candidate string) int {
+		from := cut
+		if !ctx.completionHasPrefix(candidate, word.value[:cut]) {
+			from = 0
+		}
+		score, _ := fuzzyScore(ctx.foldCompletion(candidate[from:]), ctx.foldCompletion(word.value[from:]))
+		return score
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return score(candidates[i]) < score(candidates[j]) })
+	return candidates, nospace
+}
+
+func (ctx *ShellCtx) completeMatching(word completionWord) ([]string, bool) {
+	if word.variable {
+		// Within quotes the space would be part of the word.
+		return ctx.completeVariables(word.value), word.quote != 0
+	}
+	if spec, found := ctx.Completions[word.command()]; found && !word.redirect {
+		return ctx.completeBySpec(spec, word), spec.hasOption("nospace")
+	}
+	if remoteCommands[word.command()] && !word.redirect {
+		return ctx.completeRemote(word)
+	}
+	return ctx.completeDefault(word), false
+}
+
+// completionMatches tells whether a candidate matches what was typed of it:
+// starts with it, or with fuzzyCompletion contains its characters in order.
+func (ctx *ShellCtx) completionMatches(candidate, typed string) bool {
+	if !ctx.fuzzyCompletion {
+		return ctx.completionHasPrefix(candidate, typed)
+	}
+	_, matches := fuzzyScore(ctx.foldCompletion(candidate), ctx.foldCompletion(typed))
+	return matches
+}
+
+// completionHasPrefix is strings.HasPrefix, ignoring case with
+// nocasecomplete.
+func (ctx *ShellCtx) completionHasPrefix(candidate, typed string) bool {
+	return strings.HasPrefix(ctx.foldCompletion(candidate), ctx.foldCompletion(typed))
+}
+
+// foldCompletion lowers the valid characters of text with nocasecomplete,
+// keeping other bytes as they are.
+func (ctx *ShellCtx) foldCompletion(text string) string {
+	if !ctx.Options["nocasecomplete"] {
+		return text
+	}
+	folded := strings.Builder{}
+	for len(text) > 0 {
+		c := decodeChar(text).lower()
+		if c.valid {
+			folded.WriteRune(c.value)
+		} else {
+			folded.WriteByte(text[0])
+		}
+		text = text[c.width:]
+	}
+	return folded.String()
+}
+
+// fuzzyScore rates how well typed matches candidate, lower being better: a
+// substring by where it starts, and a mere subsequence after all of those,
+// by how spread out it is.
+func fuzzyScore(candidate, typed string) (int, bool) {
+	if index := strings.Index(candidate, typed); index >= 0 {
+		return index, true
+	}
+	// A character is a valid UTF-8 sequence or else a single byte.
+	first, position := -1, 0
+	for len(typed) > 0 {
+		_, width := utf8.DecodeRuneInString(typed)
+		found := strings.Index(candidate[position:], typed[:width])
+		if found < 0 {
+			return 0, false
+		}
+		if first < 0 {
+			first = position + found
+		}
+		position += found + width
+		typed = typed[width:]
+	}
+	return len(candidate) + position - first, true
+}
+
+func (word completionWord) command() string {
+	if len(word.words) == 0 {
+		return ""
+	}
+	return word.words[0]
+}
+
+func (ctx *ShellCtx) completeDefault(word completionWord) []string {
+	switch {
+	case word.redirect:
+		return ctx.completeFiles(word.value)
+	case len(word.words) > 0 && strings.HasPrefix(word.value, "-"):
+		if _, found := builtinUsages[word.words[0]]; found {
+			return CompleteBuiltinFlags(word.words[0], word.value)
+		}
+	case len(word.words) == 0 && !strings.ContainsAny(word.value, "/~"):
+		return ctx.completeCommands(word.value)
+	case len(word.words) > 0 && (word.words[0] == "cd" || word.words[0] == "pushd"):
+		return ctx.completeDirectories(word.value)
+	}
+	return ctx.completeFiles(word.value)
+}
+
+// completeCommands lists the aliases, functions, builtins, reserved words
+// and executables along PATH, as the command index has them, starting with
+// prefix.
+func (ctx *ShellCtx) completeCommands(prefix string) []string {
+	names := map[string]bool{}
+	add := func(name string) {
+		if ctx.completionMatches(name, prefix) {
+			names[name] = true
+		}
+	}
+	for name := range ctx.Aliases {
+		add(name)
+	}
+	for name := range ctx.Functions {
+		add(name)
+	}
+	for name := range ctx.Builtins {
+		add(name)
+	}
+	for name := range reservedWords {
+		add(name)
+	}
+	for _, name := range ctx.CommandIndex.Get().Names(ctx.PathFolders) {
+		add(name)
+	}
+	return sortedNames(names)
+}
+
+// completeVariables lists the references to shell and environment
+// variables that reference, a $ or ${ and the start of a name, can become.
+func (ctx *ShellCtx) completeVariables(reference string) []string {
+	opening, closing := "$", ""
+	if strings.HasPrefix(reference, "${") {
+		opening, closing = "${", "}"
+	}
+	prefix := reference[len(opening):]
+	names := map[string]bool{}
+	add := func(name string) {
+		if ctx.completionMatches(name, prefix) {
+			names[opening+name+closing] = true
+		}
+	}
+	for name := range ctx.Vars {
+		add(name)
+	}
+	for name := range ctx.DynamicVars {
+		add(name)
+	}
+	return sortedNames(names)
+}
+
+// completeFiles lists the paths starting with prefix, directories with a
+// slash at the end. A ~ prefix stands for the directory it names.
+func (ctx *ShellCtx) completeFiles(prefix string) []string {
+	if strings.HasPrefix(prefix, "~") && !strings.Contains(prefix, "/") {
+		if _, found := ctx.ExpandTilde(prefix[1:]); found {
+			return []string{prefix + "/"}
+		}
+		return nil
+	}
+	dirPart, base := splitCompletionPath(prefix)
+	names := map[string]bool{}
+	ctx.addPathMatches(names, ctx.ResolvePath(ctx.expandTildeWord(dirPart)), dirPart, base, false)
+	return sortedNames(names)
+}
+
+// completeDirectories completes the argument of cd: directories only, with
+// those CDPATH leads to for a relative name and named directories for a ~
+// prefix.
+func (ctx *ShellCtx) completeDirectories(prefix string) []string {
+	names := map[string]bool{}
+	if strings.HasPrefix(prefix, "~") && !strings.Contains(prefix, "/") {
+		for name := range ctx.NamedDirs {
+			if ctx.completionMatches("~"+name, prefix) {
+				names["~"+name+"/"] = true
+			}
+		}
+	}
+	for _, path := range ctx.completeFiles(prefix) {
+		if strings.HasSuffix(path, "/") {
+			names[path] = true
+		}
+	}
+	cdPath, _ := ctx.GetVar("CDPATH")
+	if cdPath == "" || strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "~") || strings.HasPrefix(prefix, ".") {
+		return sortedNames(names)
+	}
+	dirPart, base := splitCompletionPath(prefix)
+	for _, entry := range strings.Split(cdPath, ":") {
+		if entry != "" {
+			ctx.addPathMatches(names, filepath.Join(ctx.ResolvePath(entry), dirPart), dirPart, base, true)
+		}
+	}
+	return sortedNames(names)
+}
+
+// splitCompletionPath splits a path being completed into its directories,
+// up to the last slash, and the start of the name in the last one.
+func splitCompletionPath(prefix string) (string, string) {
+	if slash := strings.LastIndexByte(prefix, '/'); slash >= 0 {
+		return prefix[:slash+1], prefix[slash+1:]
+	}
+	return "", prefix
+}
+
+// addPathMatches adds the entries of dir whose name starts with base,
+// written after dirPart, and with a slash for directories. A hidden entry
+// only matches when base starts with a dot.
+func (ctx *ShellCtx) addPathMatches(names map[string]bool, dir, dirPart, base string, dirsOnly bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !ctx.completionMatches(name, base) || strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.IsDir() {
+			name += "/"
+		} else if dirsOnly {
+			continue
+		}
+		names[dirPart+name] = true
+	}
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// commonPrefix is the longest string all the candidates start with, short
+// of a character split in two. Bytes that aren't valid UTF-8 are compared
+// as they are.
+func commonPrefix(candidates []string) string {
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	start := len(prefix)
+	for start > 0 && len(prefix)-start < utf8.UTFMax && !utf8.RuneStart(prefix[start-1]) {
+		start--
+	}
+	if start > 0 {
+		start--
+		if r, size := utf8.DecodeRuneInString(candidates[0][start:]); (r != utf8.RuneError || size > 1) && start+size > len(prefix) {
+			prefix = prefix[:start]
+		}
+	}
+	return prefix
+}
+
+// quoteCompletion writes a completed value the way the word was being
+// typed: inside the quote it was opened with, closed when the completion is
+// final, or else with the characters special to the shell escaped.
+func quoteCompletion(value string, quote byte, final bool) string {
+	closing := ""
+	if final && quote != 0 {
+		closing = string(quote)
+	}
+	switch quote {
+	case '\'':
+		return "'" + strings.ReplaceAll(value, "'", `'\''`) + closing
+	case '"':
+		return `"` + escapeBytes(value, "$`\"\\") + closing
+	}
+	return escapeBytes(value, " \t\n\\'\"$`&;|<>()*?[]{}!#")
+}
+
+// escapeBytes puts a backslash before the bytes of value that are in
+// special, leaving the others, valid UTF-8 or not, as they are.
+func escapeBytes(value, special string) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(special, value[i]) >= 0 {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(value[i])
+	}
+	return escaped.String()
+}
+
+// CompletionSpec is how the arguments of a command are completed, as set
+// with complete. The candidates are the words of Words, once expanded, the
+// paths matching Glob, what Function leaves in COMPREPLY, the lines Command
+// prints and the names of the kinds Actions lists: file, directory or
+// command. Options holds those of -o: default and dirnames fall back to
+// files or directories when nothing matches, and nospace leaves out the
+// space after a final completion.
+type CompletionSpec struct {
+	Words    string
+	Glob     string
+	Function string
+	Command  string
+	Actions  []string
+	Options  []string
+}
+
+func (spec *CompletionSpec) hasOption(name string) bool {
+	return slices.Contains(spec.Options, name)
+}
+
+// completeBySpec runs a completion spec for the word. A function or a
+// command is given the name of the command, the word and the one before it
+// as arguments, with COMP_WORDS, COMP_CWORD, COMP_LINE and COMP_POINT set
+// like bash does.
+func (ctx *ShellCtx) completeBySpec(spec *CompletionSpec, word completionWord) []string {
+	names := map[string]bool{}
+	addMatching := func(candidates []string) {
+		for _, candidate := range candidates {
+			if ctx.completionMatches(candidate, word.value) {
+				names[candidate] = true
+			}
+		}
+	}
+	for _, action := range spec.Actions {
+		switch action {
+		case "file":
+			addMatching(ctx.completeFiles(word.value))
+		case "directory":
+			addMatching(ctx.completeDirectories(word.value))
+		case "command":
+			addMatching(ctx.completeCommands(word.value))
+		}
+	}
+	if spec.Words != "" {
+		addMatching(strings.Fields(ctx.CommandSubstitution("printf '%s\\n' " + spec.Words)))
+	}
+	if spec.Glob != "" {
+		addMatching(ctx.Glob(spec.Glob))
+	}
+
+	if spec.Function != "" || spec.Command != "" {
+		words := append(slices.Clone(word.words), word.value)
+		previous := words[max(len(words)-2, 0)]
+		call := strings.Join([]string{SingleQuote(words[0]), SingleQuote(word.value), SingleQuote(previous)}, " ")
+		lastPipeline, lastStatus := ctx.LastPipeline, ctx.LastStatus
+		// The variables are set only while the function or command runs,
+		// and those the user has of the same names are kept.
+		output := ctx.captureOutput(func() {
+			ctx.WithTempVars([]Assignment{
+				{Name: "COMP_LINE", Value: word.line},
+				{Name: "COMP_POINT", Value: strconv.Itoa(len(word.line))},
+				{Name: "COMP_CWORD", Value: strconv.Itoa(len(words) - 1)},
+				{Name: "COMP_WORDS"},
+				{Name: "COMPREPLY"},
+			}, func() {
+				ctx.SetArray("COMP_WORDS", words)
+				if spec.Function != "" {
+					ctx.SetArray("COMPREPLY", nil)
+					ctx.RunLine(spec.Function + " " + call)
+					for _, candidate := range ctx.ArrayValues("COMPREPLY") {
+						names[candidate] = true
+					}
+				}
+				if spec.Command != "" {
+					for _, line := range strings.Split(ctx.CommandSubstitution(spec.Command+" "+call), "\n") {
+						if line != "" {
+							names[line] = true
+						}
+					}
+				}
+			})
+		})
+		if output != "" {
+			// Printed above the line once the editor has drawn it again.
+			go io.WriteString(ctx.Output, output)
+		}
+		if lastPipeline != nil {
+			ctx.SetPipelineResult(lastPipeline)
+		}
+		ctx.LastStatus = lastStatus
+	}
+
+	if len(names) == 0 && spec.hasOption("default") {
+		return ctx.completeFiles(word.value)
+	}
+	if len(names) == 0 && spec.hasOption("dirnames") {
+		return ctx.completeDirectories(word.value)
+	}
+	return sortedNames(names)
+}
+
+// captureOutput runs fn with the terminal out of raw mode and what the shell
+// prints going to what it returns instead, so that a completion function
+// or command doesn't write over the line being edited.
+func (ctx *ShellCtx) captureOutput(fn func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		fn()
+		return ""
+	}
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		output <- data
+	}()
+	resume := ctx.Terminal.Release()
+	saved := ctx.Streams
+	ctx.Streams.Stdout, ctx.Streams.Stderr = writer, writer
+	fn()
+	ctx.Streams = saved
+	resume(false)
+	writer.Close()
+	return string(<-output)
+}
+
+// completeActions are the letters complete has for its actions.
+var completeActions = map[byte]string{'f': "file", 'd': "directory", 'c': "command"}
+
+// CompleteExecutor implements `complete [-pr] [-cdf] [-A action] [-o option]
+// [-W wordlist] [-G glob] [-F function] [-C command] [name ...]`, which sets
+// how the arguments of the named commands are completed. -p, or no options
+// at all, prints the specs in a form that can be reused as input and -r
+// removes them, all of them when no name is given.
+func CompleteExecutor(shellCtx *ShellCtx, args []string) error {
+	spec := &CompletionSpec{}
+	print, remove := false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for i := 1; i < len(option); i++ {
+			flag := option[i]
+			switch flag {
+			case 'p':
+				print = true
+				continue
+			case 'r':
+				remove = true
+				continue
+			}
+			if action, found := completeActions[flag]; found {
+				spec.Actions = append(spec.Actions, action)
+				continue
+			}
+			if strings.IndexByte("AoWGFC", flag) < 0 {
+				return fmt.Errorf("complete command got invalid option -%c", flag)
+			}
+			if len(args) == 0 {
+				return fmt.Errorf("complete command -%c requires an argument", flag)
+			}
+			value := args[0]
+			args = args[1:]
+			switch flag {
+			case 'A':
+				valid := false
+				for _, action := range completeActions {
+					valid = valid || action == value
+				}
+				if !valid {
+					shellCtx.Serr = fmt.Sprintf("complete: %s: invalid action name\n", value)
+					shellCtx.Status = 1
+					return nil
+				}
+				spec.Actions = append(spec.Actions, value)
+			case 'o':
+				if value != "default" && value != "dirnames" && value != "nospace" {
+					shellCtx.Serr = fmt.Sprintf("complete: %s: invalid option name\n", value)
+					shellCtx.Status = 1
+					return nil
+				}
+				spec.Options = append(spec.Options, value)
+			case 'W':
+				spec.Words = value
+			case 'G':
+				spec.Glob = value
+			case 'F':
+				spec.Function = value
+			case 'C':
+				spec.Command = value
+			}
+		}
+	}
+
+	defining := len(spec.Actions) > 0 || len(spec.Options) > 0 || spec.Words != "" || spec.Glob != "" ||
+		spec.Function != "" || spec.Command != ""
+	switch {
+	case remove && len(args) == 0:
+		clear(shellCtx.Completions)
+	case remove:
+		for _, name := range args {
+			delete(shellCtx.Completions, name)
+		}
+	case print || !defining:
+		names := args
+		if len(names) == 0 {
+			for name := range shellCtx.Completions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			spec, found := shellCtx.Completions[name]
+			if !found {
+				shellCtx.Serr += fmt.Sprintf("complete: %s: no completion specification\n", name)
+				shellCtx.Status = 1
+				continue
+			}
+			shellCtx.Sout += spec.format(name)
+		}
+	case len(args) == 0:
+		return fmt.Errorf("complete command takes at least one name")
+	default:
+		for _, name := range args {
+			shellCtx.Completions[name] = spec
+		}
+	}
+	return nil
+}
+
+// format writes the spec as the complete command that sets it.
+func (spec *CompletionSpec) format(name string) string {
+	command := []string{"complete"}
+	for _, option := range spec.Options {
+		command = append(command, "-o", option)
+	}
+	for _, action := range spec.Actions {
+		command = append(command, "-A", action)
+	}
+	for _, option := range []struct {
+		flag, value string
+	}{{"-W", spec.Words}, {"-G", spec.Glob}, {"-F", spec.Function}, {"-C", spec.Command}} {
+		if option.value != "" {
+			command = append(command, option.flag, traceQuote(option.value))
+		}
+	}
+	return strings.Join(append(command, traceQuote(name)), " ") + "\n"
+}
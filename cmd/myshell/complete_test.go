@@ -0,0 +1,450 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompletePathAsCommand(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, "xscript"), nil, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, typed := range []string{"./x", "/nonexistent/x", "~/nonexistent/x"} {
+		candidates, _ := shellCtx.Complete(parseCompletionWord(typed))
+		if typed == "./x" && !slices.Equal(candidates, []string{"./xscript"}) {
+			t.Errorf("completing %q: got %q, want [./xscript]", typed, candidates)
+		}
+	}
+}
+
+func TestCompleteKeepsInvalidUTF8(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	for _, name := range []string{"caf\xe9 noir", "caf\xe9 cr\xe8me", "na\xc3\xafve", "na\xc3\xaff"} {
+		if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct{ typed, want string }{
+		{"cat caf", "cat caf\xe9\\ "},
+		{"cat caf\xe9\\ n", "cat caf\xe9\\ noir"},
+		{"cat 'caf\xe9 c", "cat 'caf\xe9 cr\xe8me'"},
+		{"cat na", "cat na\xc3\xaf"},
+	}
+	for _, test := range tests {
+		word := parseCompletionWord(test.typed)
+		candidates, _ := shellCtx.Complete(word)
+		if len(candidates) == 0 {
+			t.Errorf("completing %q: no candidates", test.typed)
+			continue
+		}
+		buffer := NewEditBuffer()
+		buffer.InsertString(test.typed[:word.start])
+		buffer.InsertString(word.text(commonPrefix(candidates), len(candidates) == 1))
+		if got := buffer.String(); got != test.want {
+			t.Errorf("completing %q: got %q, want %q", test.typed, got, test.want)
+		}
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	tests := []struct {
+		candidates []string
+		want       string
+	}{
+		{[]string{"x\xc3\xa9", "x\xc3\xa8"}, "x"},
+		{[]string{"x\xe9a", "x\xe9b"}, "x\xe9"},
+		{[]string{"x\xe9", "x\xe8"}, "x"},
+	}
+	for _, test := range tests {
+		if got := commonPrefix(test.candidates); got != test.want {
+			t.Errorf("commonPrefix(%q) = %q, want %q", test.candidates, got, test.want)
+		}
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		candidate, typed string
+		want             bool
+	}{
+		{"git-clang-format", "gclf", true},
+		{"caf\xe9-noir", "c\xe9n", true},
+		{"caf\xc3\xa9-noir", "c\xe9n", false},
+		{"abc", "acb", false},
+	}
+	for _, test := range tests {
+		if _, got := fuzzyScore(test.candidate, test.typed); got != test.want {
+			t.Errorf("fuzzyScore(%q, %q) matches = %v, want %v", test.candidate, test.typed, got, test.want)
+		}
+	}
+}
+
+func TestCompleteNoCase(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	shellCtx.Options["nocasecomplete"] = true
+	for _, name := range []string{"README.md", "CAF\xe9", "caf\xef\xbf\xbd"} {
+		if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		typed string
+		want  []string
+	}{
+		{"cat read", []string{"README.md"}},
+		{"cat caf\xe9", []string{"CAF\xe9"}},
+		{"cat caf\xef\xbf\xbd", []string{"caf\xef\xbf\xbd"}},
+	}
+	for _, test := range tests {
+		if got, _ := shellCtx.Complete(parseCompletionWord(test.typed)); !slices.Equal(got, test.want) {
+			t.Errorf("completing %q: got %q, want %q", test.typed, got, test.want)
+		}
+	}
+}
+
+func TestCompleteBySpecKeepsVariables(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	setup := []string{
+		"COMP_WORDS=(mine too) COMP_CWORD=7 COMPREPLY=kept",
+		"_f() { echo noise; echo more >&2; COMPREPLY=(${COMP_WORDS[1]}x $COMP_CWORD); }",
+		"complete -F _f foo",
+		"complete -C \"sh -c 'echo from-command'\" bar",
+	}
+	for _, line := range setup {
+		shellCtx.RunLine(line)
+	}
+	tests := []struct {
+		typed string
+		want  []string
+	}{
+		{"foo a", []string{"1", "ax"}},
+		{"bar ", []string{"from-command"}},
+	}
+	for _, test := range tests {
+		if got, _ := shellCtx.Complete(parseCompletionWord(test.typed)); !slices.Equal(got, test.want) {
+			t.Errorf("Complete(%q) = %q, want %q", test.typed, got, test.want)
+		}
+		if got := shellCtx.ArrayValues("COMP_WORDS"); !slices.Equal(got, []string{"mine", "too"}) {
+			t.Errorf("after %q: COMP_WORDS = %q", test.typed, got)
+		}
+		if got, _ := shellCtx.GetVar("COMP_CWORD"); got != "7" {
+			t.Errorf("after %q: COMP_CWORD = %q", test.typed, got)
+		}
+		if got, _ := shellCtx.GetVar("COMPREPLY"); got != "kept" {
+			t.Errorf("after %q: COMPREPLY = %q", test.typed, got)
+		}
+	}
+}
+
+func TestCaptureOutput(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	stdout := shellCtx.Streams.Stdout
+	output := shellCtx.captureOutput(func() { shellCtx.RunLine("echo out; echo err >&2; sh -c 'echo child'") })
+	if output != "out\nerr\nchild\n" {
+		t.Errorf("got %q", output)
+	}
+	if shellCtx.Streams.Stdout != stdout {
+		t.Errorf("the streams weren't given back")
+	}
+}
+
+func TestCompleteFiles(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	home := t.TempDir()
+	shellCtx.SetVar("HOME", home)
+	for _, dir := range []string{filepath.Join(shellCtx.CurrentDir, "src/main"), filepath.Join(home, "notes")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []string{filepath.Join(home, "todo")}
+	for _, name := range []string{"README", "my file", "a&b", "it's", ".env", "src/main.go"} {
+		files = append(files, filepath.Join(shellCtx.CurrentDir, name))
+	}
+	for _, file := range files {
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	editor := NewLineEditor(shellCtx, nil)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{typed("cat RE", "\t"), "cat README "},
+		{typed("cat sr", "\t"), "cat src/"},
+		{typed("cat src/", "\t"), "cat src/main"},
+		{typed("cat src/main.", "\t"), "cat src/main.go "},
+		{typed("cat ./sr", "\t"), "cat ./src/"},
+		{typed("cat my", "\t"), "cat my\\ file "},
+		{typed("cat 'my", "\t"), "cat 'my file' "},
+		{typed("cat \"my", "\t"), "cat \"my file\" "},
+		{typed("cat a", "\t"), "cat a\\&b "},
+		{typed("cat it", "\t"), "cat it\\'s "},
+		{typed("cat 'it", "\t"), "cat 'it'\\''s' "},
+		{typed("cat .e", "\t"), "cat .env "},
+		{typed("cat ~/no", "\t"), "cat ~/notes/"},
+		{typed("cat ~/t", "\t"), "cat ~/todo "},
+		{typed("cat ~", "\t"), "cat ~/"},
+		{typed("cat < RE", "\t"), "cat < README "},
+		{typed("cat x", "\t"), "cat x"},
+	}
+	for _, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+
+	// Hidden files aren't offered unless the name starts with a dot.
+	if got, _ := shellCtx.Complete(parseCompletionWord("cat ")); slices.Contains(got, ".env") {
+		t.Errorf("completing nothing: got %q", got)
+	}
+}
+
+func TestCompleteDirectoriesForCd(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	elsewhere := t.TempDir()
+	for _, dir := range []string{filepath.Join(shellCtx.CurrentDir, "src/cmd"), filepath.Join(shellCtx.CurrentDir, "scripts"), filepath.Join(elsewhere, "site")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(shellCtx.CurrentDir, "setup.sh"), filepath.Join(shellCtx.CurrentDir, "src/main.go"), filepath.Join(elsewhere, "sitemap")} {
+		if err := os.WriteFile(file, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shellCtx.RunLine("hash -d work=" + SingleQuote(elsewhere) + " wiki=" + SingleQuote(elsewhere))
+	tests := []struct {
+		typed string
+		want  []string
+	}{
+		{"cd s", []string{"scripts/", "src/"}},
+		{"pushd s", []string{"scripts/", "src/"}},
+		{"cd src/", []string{"src/cmd/"}},
+		{"cat s", []string{"scripts/", "setup.sh", "src/"}},
+		{"CDPATH=; cd s", []string{"scripts/", "src/"}},
+		{"cd ~w", []string{"~wiki/", "~work/"}},
+		{"cd ~work/s", []string{"~work/site/"}},
+		{"cd x", nil},
+	}
+	for _, test := range tests {
+		if got, _ := shellCtx.Complete(parseCompletionWord(test.typed)); !slices.Equal(got, test.want) {
+			t.Errorf("completing %q: got %q, want %q", test.typed, got, test.want)
+		}
+	}
+
+	// CDPATH adds the directories it leads to, but not for a name relative
+	// to the current directory or an absolute one.
+	shellCtx.SetVar("CDPATH", elsewhere)
+	tests = []struct {
+		typed string
+		want  []string
+	}{
+		{"cd s", []string{"scripts/", "site/", "src/"}},
+		{"cd ./s", []string{"./scripts/", "./src/"}},
+		{"cd " + elsewhere + "/s", []string{elsewhere + "/site/"}},
+	}
+	for _, test := range tests {
+		if got, _ := shellCtx.Complete(parseCompletionWord(test.typed)); !slices.Equal(got, test.want) {
+			t.Errorf("completing %q with CDPATH: got %q, want %q", test.typed, got, test.want)
+		}
+	}
+}
+
+func TestCompleteBuiltin(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"complete -W 'start stop' svc; complete -p svc", "complete -W 'start stop' svc\n"},
+		{"complete -o nospace -d -F _f x; complete", "complete -o nospace -A directory -F _f x\n"},
+		{"complete -f a; complete -c b; complete -p", "complete -A file a\ncomplete -A command b\n"},
+		{"complete -A directory -G '*.go' a; complete -p a", "complete -A directory -G '*.go' a\n"},
+		{"complete -W x a b; complete -r a; complete", "complete -W x b\n"},
+		{"complete -W x a b; complete -r; complete; echo $?", "0\n"},
+		{"complete -p nosuch; echo $?", "1\n"},
+		{"complete -A nosuch x; echo $?; complete -o nosuch x; echo $?", "1\n1\n"},
+		{"complete -W x; echo $?", "1\n"},
+		{"complete -q x; echo $?", "1\n"},
+	})
+}
+
+func TestCompleteBySpec(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	if err := os.Mkdir(filepath.Join(shellCtx.CurrentDir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"main.go", "main_test.go", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setup := []string{
+		"words='start stop status'",
+		"complete -W '$words restart' svc",
+		"complete -G '*.go' gorun",
+		"complete -d only",
+		"_args() { COMPREPLY=(\"$1:$2:$3\" \"${COMP_WORDS[0]}/$COMP_CWORD\"); }",
+		"complete -F _args fn",
+		"complete -C \"sh -c 'echo one; echo two'\" cmd",
+		"complete -W none -o default fallback",
+		"complete -W none -o dirnames dirs",
+		"complete -W 'a b' -o nospace tight",
+	}
+	for _, line := range setup {
+		shellCtx.RunLine(line)
+	}
+	tests := []struct {
+		typed   string
+		want    []string
+		nospace bool
+	}{
+		{"svc st", []string{"start", "status", "stop"}, false},
+		{"svc re", []string{"restart"}, false},
+		{"gorun m", []string{"main.go", "main_test.go"}, false},
+		{"only ", []string{"sub/"}, false},
+		// What a function or command gives isn't narrowed down to the word.
+		{"fn x y", []string{"fn/2", "fn:y:x"}, false},
+		{"cmd t", []string{"one", "two"}, false},
+		{"fallback not", []string{"notes.txt"}, false},
+		{"dirs s", []string{"sub/"}, false},
+		{"tight ", []string{"a", "b"}, true},
+		{"svc > no", []string{"notes.txt"}, false},
+	}
+	for _, test := range tests {
+		got, nospace := shellCtx.Complete(parseCompletionWord(test.typed))
+		if !slices.Equal(got, test.want) || nospace != test.nospace {
+			t.Errorf("completing %q: got %q, %v, want %q, %v", test.typed, got, nospace, test.want, test.nospace)
+		}
+	}
+}
+
+func TestFuzzyCompletion(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	shellCtx.Options["fuzzycomplete"] = true
+	if err := os.MkdirAll(filepath.Join(shellCtx.CurrentDir, "src/lib/config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"config.go", "logcfg", "go.mod", "Makefile"} {
+		if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shellCtx.RunLine("PATH=" + SingleQuote(t.TempDir()) + "; git_clang_format() { :; }")
+	tests := []struct {
+		typed string
+		want  []string
+	}{
+		// Substrings come first, by where they start, then subsequences.
+		{"cat cfg", []string{"logcfg", "config.go"}},
+		{"cat o", []string{"config.go", "go.mod", "logcfg"}},
+		{"cat go", []string{"go.mod"}},
+		{"cat src/lib/cnf", []string{"src/lib/config/"}},
+		{"cat s/cnf", nil},
+		{"cat xyz", nil},
+		{"gcf", []string{"git_clang_format"}},
+	}
+	for _, test := range tests {
+		if got, _ := shellCtx.Complete(parseCompletionWord(test.typed)); !slices.Equal(got, test.want) {
+			t.Errorf("completing %q: got %q, want %q", test.typed, got, test.want)
+		}
+	}
+
+	// The typed word stays when the candidates have nothing in common to
+	// add, and the menu offers them.
+	editor := NewLineEditor(shellCtx, nil)
+	if got := typeLine(editor, typed("cat cfg", "\t")); got != "cat cfg" || editor.menu == nil {
+		t.Errorf("first Tab: got %q", got)
+	}
+	if got := typeLine(editor, typed("cat cfg", "\t", "\t")); got != "cat logcfg" {
+		t.Errorf("second Tab: got %q", got)
+	}
+
+	shellCtx.Options["fuzzycomplete"] = false
+	if got, _ := shellCtx.Complete(parseCompletionWord("cat cfg")); len(got) != 0 {
+		t.Errorf("without fuzzycomplete: got %q", got)
+	}
+}
+
+func TestCompleteVariables(t *testing.T) {
+	t.Setenv("MYSHELL_TEST_FROM_ENV", "1")
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	shellCtx.RunLine("LONG_VARIABLE_NAME=1; LONG_VARIABLE_NUMBER=2")
+	editor := NewLineEditor(shellCtx, nil)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{typed("echo $LONG_VARIABLE_NA", "\t"), "echo $LONG_VARIABLE_NAME "},
+		{typed("echo ${LONG_VARIABLE_NA", "\t"), "echo ${LONG_VARIABLE_NAME} "},
+		{typed("echo $LONG", "\t"), "echo $LONG_VARIABLE_N"},
+		{typed("echo $LONG", "\t", "\t", "\t"), "echo $LONG_VARIABLE_NUMBER"},
+		{typed("echo \"$LONG_VARIABLE_NA", "\t"), "echo \"$LONG_VARIABLE_NAME"},
+		{typed("echo a$LONG_VARIABLE_NA", "\t"), "echo a$LONG_VARIABLE_NAME "},
+		{typed("echo $MYSHELL_TEST_FROM", "\t"), "echo $MYSHELL_TEST_FROM_ENV "},
+		{typed("echo $RANDO", "\t"), "echo $RANDOM "},
+		{typed("$LONG_VARIABLE_NA", "\t"), "$LONG_VARIABLE_NAME "},
+		{typed("echo '$LONG_VARIABLE_NA", "\t"), "echo '$LONG_VARIABLE_NA"},
+		{typed("echo \\$LONG_VARIABLE_NA", "\t"), "echo \\$LONG_VARIABLE_NA"},
+		{typed("echo $NO_SUCH_VARIABLE", "\t"), "echo $NO_SUCH_VARIABLE"},
+	}
+	for _, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+		editor.menu = nil
+	}
+}
+
+func TestCompleteNoCaseInsertsCasing(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	if err := os.Mkdir(filepath.Join(shellCtx.CurrentDir, "Docs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Makefile", "Notes.txt", "notes.md"} {
+		if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shellCtx.RunLine("My_Setting=1; Deploy_App() { :; }")
+	editor := NewLineEditor(shellCtx, nil)
+	tests := []struct {
+		keys   []string
+		nocase string
+		exact  string
+	}{
+		{typed("cat make", "\t"), "cat Makefile ", "cat make"},
+		{typed("cd docs", "\t"), "cd Docs/", "cd docs"},
+		{typed("cat docs", "\t"), "cat Docs/", "cat docs"},
+		{typed("echo $my_s", "\t"), "echo $My_Setting ", "echo $my_s"},
+		{typed("deploy_a", "\t"), "Deploy_App ", "deploy_a"},
+		{typed("cat no", "\t"), "cat no", "cat notes.md "},
+		{typed("cat no", "\t", "\t"), "cat Notes.txt", "cat notes.md "},
+		{typed("cat NOTES.M", "\t"), "cat notes.md ", "cat NOTES.M"},
+	}
+	for _, nocase := range []bool{true, false} {
+		shellCtx.Options["nocasecomplete"] = nocase
+		for _, test := range tests {
+			want := test.exact
+			if nocase {
+				want = test.nocase
+			}
+			if got := typeLine(editor, test.keys); got != want {
+				t.Errorf("nocasecomplete %v, %q: got %q, want %q", nocase, test.keys, got, want)
+			}
+			editor.menu = nil
+		}
+	}
+}
@@ -0,0 +1,812 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// reservedWords are recognized only unquoted and in command position.
+var reservedWords = map[string]bool{
+	"if": true, "then": true, "elif": true, "else": true, "fi": true,
+	"for": true, "in": true, "while": true, "until": true, "do": true, "done": true,
+	"case": true, "esac": true, "select": true, "function": true,
+	"{": true, "}": true, "!": true, "[[": true, "time": true,
+}
+
+// closingWords can only continue or end a compound command, never start one.
+var closingWords = map[string]bool{
+	"then": true, "elif": true, "else": true, "fi": true,
+	"do": true, "done": true, "esac": true, "}": true,
+}
+
+// namingWords are followed by a name or word rather than a command.
+var namingWords = map[string]bool{
+	"for": true, "case": true, "select": true, "function": true, "in": true,
+	"[[": true,
+}
+
+// BraceGroup is `{ list; }`, a list run in the current shell.
+type BraceGroup struct {
+	Body      *CommandList
+	Redirects []Redirect
+	Source    string
+}
+
+func (group *BraceGroup) commandSource() string {
+	return group.Source
+}
+
+func (p *parser) parseBraceGroup() (*BraceGroup, error) {
+	from := p.pos
+	p.pos++
+	body, err := p.parseBody("}")
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+	group := &BraceGroup{Body: body, Source: p.source(from, p.pos)}
+	return group, p.parseRedirects(&group.Redirects)
+}
+
+// Subshell is `( list )`, a list run in a copy of the shell so that it
+// can't change the state of the shell itself.
+type Subshell struct {
+	Body      *CommandList
+	Redirects []Redirect
+	Source    string
+}
+
+func (subshell *Subshell) commandSource() string {
+	return subshell.Source
+}
+
+func (p *parser) parseSubshell() (*Subshell, error) {
+	from := p.pos
+	p.pos++
+	body, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	token, ok := p.peek()
+	if !ok {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if token.Kind != TokenRParen || len(body.Items) == 0 {
+		return nil, unexpectedToken(token)
+	}
+	p.pos++
+	subshell := &Subshell{Body: body, Source: p.source(from, p.pos)}
+	return subshell, p.parseRedirects(&subshell.Redirects)
+}
+
+// IfClause is `if list; then list; [elif list; then list;]... [else list;] fi`.
+type IfClause struct {
+	Conditions []*CommandList
+	Bodies     []*CommandList
+	Else       *CommandList
+	Redirects  []Redirect
+	Source     string
+}
+
+func (clause *IfClause) commandSource() string {
+	return clause.Source
+}
+
+func (p *parser) parseIf() (*IfClause, error) {
+	from := p.pos
+	p.pos++
+	clause := &IfClause{}
+	for {
+		condition, err := p.parseBody("then")
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		body, err := p.parseBody("elif", "else", "fi")
+		if err != nil {
+			return nil, err
+		}
+		clause.Conditions = append(clause.Conditions, condition)
+		clause.Bodies = append(clause.Bodies, body)
+
+		keyword, _ := p.peek()
+		p.pos++
+		if keyword.Value == "elif" {
+			continue
+		}
+		if keyword.Value == "else" {
+			if clause.Else, err = p.parseBody("fi"); err != nil {
+				return nil, err
+			}
+			p.pos++
+		}
+		break
+	}
+	clause.Source = p.source(from, p.pos)
+	if err := p.parseRedirects(&clause.Redirects); err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+// ForClause is `for name [in word ...]; do list; done`. Without in, it
+// iterates over the positional parameters.
+type ForClause struct {
+	Name      string
+	Words     []string
+	HasWords  bool
+	Body      *CommandList
+	Redirects []Redirect
+	Source    string
+}
+
+func (clause *ForClause) commandSource() string {
+	return clause.Source
+}
+
+// ArithForClause is the C-style `for ((init; condition; update)); do list;
+// done`. An empty condition is always true.
+type ArithForClause struct {
+	Init      string
+	Condition string
+	Update    string
+	Body      *CommandList
+	Redirects []Redirect
+	Source    string
+}
+
+func (clause *ArithForClause) commandSource() string {
+	return clause.Source
+}
+
+// ArithCommand is `((expression))`, which succeeds when the expression is
+// not zero.
+type ArithCommand struct {
+	Expr      string
+	Redirects []Redirect
+	Source    string
+}
+
+func (command *ArithCommand) commandSource() string {
+	return command.Source
+}
+
+func (p *parser) parseArithCommand() (*ArithCommand, error) {
+	from := p.pos
+	token, _ := p.peek()
+	p.pos++
+	expr, _ := arithCommandBody(token.Value)
+	command := &ArithCommand{Expr: expr}
+	err := p.parseRedirects(&command.Redirects)
+	command.Source = p.source(from, p.pos)
+	return command, err
+}
+
+func (p *parser) parseFor() (Command, error) {
+	from := p.pos
+	p.pos++
+	token, ok := p.peek()
+	if !ok {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if token.Kind != TokenWord {
+		return nil, unexpectedToken(token)
+	}
+	p.pos++
+
+	if expressions, ok := arithCommandBody(token.Value); ok {
+		parts := splitArithFor(expressions)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("syntax error: arithmetic expression required")
+		}
+		clause := &ArithForClause{Init: parts[0], Condition: parts[1], Update: parts[2]}
+		body, err := p.parseDoGroup()
+		if err != nil {
+			return nil, err
+		}
+		clause.Body, clause.Source = body, p.source(from, p.pos)
+		return clause, p.parseRedirects(&clause.Redirects)
+	}
+
+	if !IsValidName(token.Value) {
+		return nil, fmt.Errorf("`%s': not a valid identifier", token.Value)
+	}
+	clause := &ForClause{Name: token.Value}
+	return clause, p.parseWordLoop(clause, from)
+}
+
+// parseWordLoop parses what follows the name in the loops of for and
+// select: `[in word ...]; do list; done` and redirections.
+func (p *parser) parseWordLoop(clause *ForClause, from int) error {
+	if !p.skipNewlines() {
+		return incomplete("syntax error: unexpected end of file")
+	}
+	if next, _ := p.peek(); next.Kind == TokenWord && next.Value == "in" {
+		p.pos++
+		clause.HasWords = true
+		for next, ok := p.peek(); ok && next.Kind == TokenWord; next, ok = p.peek() {
+			clause.Words = append(clause.Words, next.Value)
+			p.pos++
+		}
+	}
+	body, err := p.parseDoGroup()
+	if err != nil {
+		return err
+	}
+	clause.Body, clause.Source = body, p.source(from, p.pos)
+	return p.parseRedirects(&clause.Redirects)
+}
+
+// SelectClause is `select name [in word ...]; do list; done`, which shows
+// the words as a numbered menu and runs the body for each choice read.
+type SelectClause struct {
+	ForClause
+}
+
+func (p *parser) parseSelect() (*SelectClause, error) {
+	from := p.pos
+	p.pos++
+	token, ok := p.peek()
+	if !ok {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if token.Kind != TokenWord {
+		return nil, unexpectedToken(token)
+	}
+	if !IsValidName(token.Value) {
+		return nil, fmt.Errorf("`%s': not a valid identifier", token.Value)
+	}
+	p.pos++
+	clause := &SelectClause{ForClause{Name: token.Value}}
+	return clause, p.parseWordLoop(&clause.ForClause, from)
+}
+
+// parseDoGroup parses the `; do list; done` ending a loop. The separator
+// before do is optional.
+func (p *parser) parseDoGroup() (*CommandList, error) {
+	if next, ok := p.peek(); ok && next.Kind == TokenSemicolon {
+		p.pos++
+	}
+	if !p.skipNewlines() {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody("done")
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+	return body, nil
+}
+
+// WhileClause is `while list; do list; done`, or with Until set, the
+// `until` loop that runs while the condition fails.
+type WhileClause struct {
+	Until     bool
+	Condition *CommandList
+	Body      *CommandList
+	Redirects []Redirect
+	Source    string
+}
+
+func (clause *WhileClause) commandSource() string {
+	return clause.Source
+}
+
+func (p *parser) parseWhile() (*WhileClause, error) {
+	from := p.pos
+	keyword, _ := p.peek()
+	p.pos++
+	clause := &WhileClause{Until: keyword.Value == "until"}
+	condition, err := p.parseBody("do")
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseDoGroup()
+	if err != nil {
+		return nil, err
+	}
+	clause.Condition, clause.Body = condition, body
+	clause.Source = p.source(from, p.pos)
+	return clause, p.parseRedirects(&clause.Redirects)
+}
+
+// CaseClause is `case word in pattern [| pattern]...) list ;; ... esac`.
+type CaseClause struct {
+	Word      string
+	Items     []*CaseItem
+	Redirects []Redirect
+	Source    string
+}
+
+// CaseItem is one branch of a case. Terminator is how its list ends: ;;
+// stops, ;& falls through into the next body and ;;& tests the next
+// patterns. The last item may have none.
+type CaseItem struct {
+	Patterns   []string
+	Body       *CommandList
+	Terminator string
+}
+
+func (clause *CaseClause) commandSource() string {
+	return clause.Source
+}
+
+func (p *parser) parseCase() (*CaseClause, error) {
+	from := p.pos
+	p.pos++
+	token, ok := p.peek()
+	if !ok {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if token.Kind != TokenWord {
+		return nil, unexpectedToken(token)
+	}
+	p.pos++
+	clause := &CaseClause{Word: token.Value}
+	if !p.skipNewlines() {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	for {
+		if !p.skipNewlines() {
+			return nil, incomplete("syntax error: unexpected end of file")
+		}
+		token, _ := p.peek()
+		if token.Kind == TokenWord && token.Value == "esac" {
+			p.pos++
+			break
+		}
+		item, err := p.parseCaseItem()
+		if err != nil {
+			return nil, err
+		}
+		clause.Items = append(clause.Items, item)
+		if item.Terminator == "" {
+			// Without ;; the item can only be the last one.
+			if err := p.expect("esac"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	clause.Source = p.source(from, p.pos)
+	return clause, p.parseRedirects(&clause.Redirects)
+}
+
+func (p *parser) parseCaseItem() (*CaseItem, error) {
+	item := &CaseItem{}
+	if token, _ := p.peek(); token.Kind == TokenLParen {
+		p.pos++
+	}
+	for {
+		token, ok := p.peek()
+		if !ok {
+			return nil, incomplete("syntax error: unexpected end of file")
+		}
+		if token.Kind != TokenWord {
+			return nil, unexpectedToken(token)
+		}
+		item.Patterns = append(item.Patterns, token.Value)
+		p.pos++
+		token, ok = p.peek()
+		if !ok {
+			return nil, incomplete("syntax error: unexpected end of file")
+		}
+		if token.Kind == TokenRParen {
+			p.pos++
+			break
+		}
+		if token.Kind != TokenPipe {
+			return nil, unexpectedToken(token)
+		}
+		p.pos++
+	}
+
+	body, err := p.parseList("esac")
+	if err != nil {
+		return nil, err
+	}
+	item.Body = body
+	token, ok := p.peek()
+	switch {
+	case !ok:
+		return nil, incomplete("syntax error: unexpected end of file")
+	case token.Kind == TokenCaseBreak:
+		item.Terminator = token.Value
+		p.pos++
+	case token.Kind != TokenWord || token.Value != "esac":
+		return nil, unexpectedToken(token)
+	}
+	return item, nil
+}
+
+// arithCommandBody returns the expression of a ((...)) word.
+func arithCommandBody(word string) (string, bool) {
+	if len(word) < 4 || !strings.HasPrefix(word, "((") || !strings.HasSuffix(word, "))") {
+		return "", false
+	}
+	return word[2 : len(word)-2], true
+}
+
+// splitArithFor splits the header of a C-style for at the semicolons that
+// aren't nested in parentheses.
+func splitArithFor(header string) []string {
+	parts := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ';':
+			if depth == 0 {
+				parts = append(parts, header[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, header[start:])
+}
+
+// ExecuteCommand runs one element of a pipeline with the given streams and
+// returns its status.
+func (ctx *ShellCtx) ExecuteCommand(command Command, streams Streams) int {
+	switch command := command.(type) {
+	case *SimpleCommand:
+		return ctx.RunCommand(command, streams)
+	case *FunctionDef:
+		ctx.Functions[command.Name] = command
+		return 0
+	case *BraceGroup:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			ctx.RunCommandList(command.Body)
+			return ctx.LastStatus
+		})
+	case *Subshell:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			sub := ctx.Clone()
+			defer sub.leaveSubshell()
+			sub.RunCommandList(command.Body)
+			sub.RunExitTrap()
+			return sub.LastStatus
+		})
+	case *IfClause:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runIf(command)
+		})
+	case *ForClause:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runFor(command)
+		})
+	case *ArithForClause:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runArithFor(command)
+		})
+	case *CaseClause:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runCase(command)
+		})
+	case *SelectClause:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runSelect(command)
+		})
+	case *WhileClause:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runWhile(command)
+		})
+	case *ArithCommand:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runArith(command)
+		})
+	case *CondCommand:
+		return ctx.withRedirects(command.Redirects, streams, func() int {
+			return ctx.runConditional(command)
+		})
+	}
+	panic(fmt.Sprintf("unknown command type %T", command))
+}
+
+// withRedirects makes the redirected streams the default ones of the shell
+// while fn runs the body of a compound command.
+func (ctx *ShellCtx) withRedirects(redirects []Redirect, streams Streams, fn func() int) int {
+	closeRedirects, err := ctx.applyRedirects(redirects, &streams)
+	defer closeRedirects()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	savedStreams := ctx.Streams
+	ctx.Streams = streams
+	defer ctx.restoreStreams(savedStreams)
+	return fn()
+}
+
+func (ctx *ShellCtx) runCondition(condition *CommandList) {
+	ctx.conditionDepth++
+	ctx.RunCommandList(condition)
+	ctx.conditionDepth--
+}
+
+func (ctx *ShellCtx) runIf(clause *IfClause) int {
+	for i, condition := range clause.Conditions {
+		ctx.runCondition(condition)
+		if ctx.LastStatus == 0 {
+			ctx.RunCommandList(clause.Bodies[i])
+			return ctx.LastStatus
+		}
+	}
+	if clause.Else != nil {
+		ctx.RunCommandList(clause.Else)
+		return ctx.LastStatus
+	}
+	return 0
+}
+
+func (ctx *ShellCtx) runFor(clause *ForClause) int {
+	words := ctx.PositionalArgs
+	if clause.HasWords {
+		var err error
+		if words, err = ctx.ExpandWords(clause.Words); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+	}
+	status := 0
+	for _, word := range slices.Clone(words) {
+		if err := ctx.SetVar(clause.Name, word); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+		stop := ctx.runLoopBody(clause.Body)
+		status = ctx.LastStatus
+		if stop {
+			break
+		}
+	}
+	return status
+}
+
+const defaultSelectPrompt = "#? "
+
+// runSelect shows the menu on stderr and reads choices from stdin until
+// the input ends or the body breaks out. The line read is kept in REPLY;
+// the variable gets the chosen word, or is emptied for an invalid choice.
+// An empty line shows the menu again.
+func (ctx *ShellCtx) runSelect(clause *SelectClause) int {
+	words := ctx.PositionalArgs
+	if clause.HasWords {
+		var err error
+		if words, err = ctx.ExpandWords(clause.Words); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+	}
+	if len(words) == 0 {
+		return 0
+	}
+	words = slices.Clone(words)
+
+	status := 0
+	showMenu := true
+	for {
+		if showMenu {
+			for i, word := range words {
+				fmt.Fprintf(ctx.Streams.Stderr, "%d) %s\n", i+1, word)
+			}
+		}
+		prompt, found := ctx.GetVar("PS3")
+		if !found {
+			prompt = defaultSelectPrompt
+		}
+		fmt.Fprint(ctx.Streams.Stderr, prompt)
+		line, err := readInputLine(ctx.Streams.Stdin)
+		if err != nil {
+			// Running out of choices fails, like the read it ends in.
+			fmt.Fprintln(ctx.Streams.Stderr)
+			return 1
+		}
+		ctx.SetVar("REPLY", line)
+		showMenu = strings.TrimSpace(line) == ""
+		if showMenu {
+			continue
+		}
+		choice := ""
+		if index, err := strconv.Atoi(strings.TrimSpace(line)); err == nil && index >= 1 && index <= len(words) {
+			choice = words[index-1]
+		}
+		if err := ctx.SetVar(clause.Name, choice); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+		stop := ctx.runLoopBody(clause.Body)
+		status = ctx.LastStatus
+		if stop {
+			return status
+		}
+	}
+}
+
+func (ctx *ShellCtx) runArith(command *ArithCommand) int {
+	value, err := ctx.EvalArithmetic(command.Expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "((: %s\n", err.Error())
+		return 1
+	}
+	if value == 0 {
+		return 1
+	}
+	return 0
+}
+
+func (ctx *ShellCtx) runArithFor(clause *ArithForClause) int {
+	eval := func(expr string) (int64, bool) {
+		value, err := ctx.EvalArithmetic(expr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "((: %s\n", err.Error())
+			return 0, false
+		}
+		return value, true
+	}
+	if _, ok := eval(clause.Init); !ok {
+		return 1
+	}
+	status := 0
+	for {
+		if strings.TrimSpace(clause.Condition) != "" {
+			value, ok := eval(clause.Condition)
+			if !ok {
+				return 1
+			}
+			if value == 0 {
+				return status
+			}
+		}
+		stop := ctx.runLoopBody(clause.Body)
+		status = ctx.LastStatus
+		if stop {
+			return status
+		}
+		if _, ok := eval(clause.Update); !ok {
+			return 1
+		}
+	}
+}
+
+func (ctx *ShellCtx) runCase(clause *CaseClause) int {
+	word, err := ctx.ExpandString(clause.Word)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	status := 0
+	fallThrough := false
+	for _, item := range clause.Items {
+		if !fallThrough {
+			matched, err := ctx.matchCaseItem(item, word)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+				return 1
+			}
+			if !matched {
+				continue
+			}
+		}
+		ctx.RunCommandList(item.Body)
+		status = ctx.LastStatus
+		if ctx.unwinding() {
+			break
+		}
+		switch item.Terminator {
+		case ";&":
+			fallThrough = true
+		case ";;&":
+			fallThrough = false
+		default:
+			return status
+		}
+	}
+	return status
+}
+
+func (ctx *ShellCtx) matchCaseItem(item *CaseItem, word string) (bool, error) {
+	for _, raw := range item.Patterns {
+		pattern, err := ctx.ExpandPattern(raw)
+		if err != nil {
+			return false, err
+		}
+		if MatchPattern(pattern, word) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (ctx *ShellCtx) runWhile(clause *WhileClause) int {
+	status := 0
+	for {
+		ctx.runCondition(clause.Condition)
+		if (ctx.LastStatus == 0) == clause.Until {
+			return status
+		}
+		stop := ctx.runLoopBody(clause.Body)
+		status = ctx.LastStatus
+		if stop {
+			return status
+		}
+	}
+}
+
+// runLoopBody runs one iteration of a loop and reports whether a break or
+// continue ended the loop itself.
+func (ctx *ShellCtx) runLoopBody(body *CommandList) bool {
+	ctx.loopDepth++
+	ctx.RunCommandList(body)
+	ctx.loopDepth--
+	if ctx.returning || ctx.exiting || ctx.interrupted() {
+		return true
+	}
+	if ctx.breakLoops > 0 {
+		ctx.breakLoops--
+		return true
+	}
+	if ctx.continueLoops > 0 {
+		// continue n goes on with the n-th loop, ending the inner ones.
+		ctx.continueLoops--
+		return ctx.continueLoops > 0
+	}
+	return false
+}
+
+// unwinding reports whether a break, continue, return, the exit of a
+// subshell or a signal is leaving the commands being run.
+func (ctx *ShellCtx) unwinding() bool {
+	return ctx.breakLoops > 0 || ctx.continueLoops > 0 || ctx.returning || ctx.exiting || ctx.interrupted()
+}
+
+// interrupted reports whether a signal interrupted the commands being run.
+func (ctx *ShellCtx) interrupted() bool {
+	return ctx.interrupt != nil && ctx.interrupt.Load() != 0
+}
+
+func BreakExecutor(shellCtx *ShellCtx, args []string) error {
+	return loopControl(shellCtx, "break", args, &shellCtx.breakLoops)
+}
+
+func ContinueExecutor(shellCtx *ShellCtx, args []string) error {
+	return loopControl(shellCtx, "continue", args, &shellCtx.continueLoops)
+}
+
+func loopControl(shellCtx *ShellCtx, command string, args []string, loops *int) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%s command takes at most 1 argument of type int", command)
+	}
+	count := 1
+	if len(args) == 1 {
+		var err error
+		if count, err = strconv.Atoi(args[0]); err != nil {
+			shellCtx.Serr = fmt.Sprintf("%s: %s: numeric argument required\n", command, args[0])
+			shellCtx.Status = 1
+			return nil
+		}
+		if count < 1 {
+			shellCtx.Serr = fmt.Sprintf("%s: %d: loop count out of range\n", command, count)
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+	if shellCtx.loopDepth == 0 {
+		shellCtx.Serr = fmt.Sprintf("%s: only meaningful in a `for', `while', or `until' loop\n", command)
+		return nil
+	}
+	*loops = min(count, shellCtx.loopDepth)
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestSubshellLeavesProcessState(t *testing.T) {
+	var limits syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limits); err != nil {
+		t.Fatal(err)
+	}
+	mask := currentUmask()
+	lines := []string{
+		"(umask 077)",
+		"(umask 0 | cat)",
+		"echo $(umask 070)",
+		"(ulimit -Sn 64)",
+		"(ulimit -Sn 16; ulimit -Hn 32)",
+		"(cd /)",
+	}
+	for _, line := range lines {
+		shellCtx := NewShellCtx()
+		dir := t.TempDir()
+		shellCtx.CurrentDir = dir
+		shellCtx.RunLine(line)
+		var after syscall.Rlimit
+		syscall.Getrlimit(syscall.RLIMIT_NOFILE, &after)
+		if got := currentUmask(); got != mask {
+			t.Errorf("%s: umask %04o, want %04o", line, got, mask)
+		}
+		if after != limits {
+			t.Errorf("%s: open files limits %+v, want %+v", line, after, limits)
+		}
+		if shellCtx.CurrentDir != dir {
+			t.Errorf("%s: directory %s, want %s", line, shellCtx.CurrentDir, dir)
+		}
+	}
+}
+
+func TestSubshellHardLimit(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	sub := shellCtx.Clone()
+	defer sub.leaveSubshell()
+	sub.RunLine("ulimit -n 64")
+	if limits, _ := sub.getLimit(syscall.RLIMIT_NOFILE); limits.Cur != 64 || limits.Max != 64 {
+		t.Errorf("in the subshell: got %+v", limits)
+	}
+	if status := sub.RunLine("ulimit -n 128 2> /dev/null"); status == 0 {
+		t.Errorf("raised the hard limit the subshell lowered")
+	}
+}
+
+func TestIf(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"if true; then echo yes; fi", "yes\n"},
+		{"if false; then echo yes; fi; echo $?", "0\n"},
+		{"if false; then echo a; else echo b; fi", "b\n"},
+		{"if false; then echo a; elif true; then echo b; else echo c; fi", "b\n"},
+		{"if false; then echo a; elif false; then echo b; else echo c; fi", "c\n"},
+		{"if false; then :; elif (exit 3); then :; fi; echo $?", "0\n"},
+		{"if true; then (exit 4); fi; echo $?", "4\n"},
+		{"if true\nthen\n  echo multi\nfi", "multi\n"},
+		{"if true; false; then echo a; else echo b; fi", "b\n"},
+		{"if if true; then false; fi; then echo a; else echo nested; fi", "nested\n"},
+		{"if true; then echo in; fi > out; cat out", "in\n"},
+		{"set -e; if false; then :; fi; echo survived", "survived\n"},
+		{"if true; then fi; echo $?", ""},
+	})
+}
+
+func TestForLoops(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"for x in a b c; do echo $x; done", "a\nb\nc\n"},
+		{`v="1 2"; for x in $v "3 4"; do echo "<$x>"; done`, "<1>\n<2>\n<3 4>\n"},
+		{"touch f1 f2; for f in f*; do echo $f; done", "f1\nf2\n"},
+		{"for x in; do echo never; done; echo $?", "0\n"},
+		{"set -- p q; for x; do echo $x; done", "p\nq\n"},
+		{"for x in a b; do :; done; echo $x", "b\n"},
+		{"for ((i = 0; i < 3; i++)); do echo $i; done", "0\n1\n2\n"},
+		{"for ((i = 10; i > 0; i -= 4)); do echo $i; done", "10\n6\n2\n"},
+		{"for ((i = 0; ; i++)); do [ $i = 2 ] && break; done; echo $i", "2\n"},
+		{"for ((;;)); do echo once; break; done", "once\n"},
+		{"for x in a b c; do [ $x = b ] && continue; echo $x; done", "a\nc\n"},
+		{"for x in 1 2; do for y in a b; do [ $y = b ] && continue 2; echo $x$y; done; done", "1a\n2a\n"},
+		{"for x in a b\ndo\n  echo $x\ndone", "a\nb\n"},
+		{"for x in a b; do echo $x; done | tr a-z A-Z", "A\nB\n"},
+	})
+}
+
+func TestWhileAndUntilLoops(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"i=0; while [ $i -lt 3 ]; do echo $i; i=$((i + 1)); done", "0\n1\n2\n"},
+		{"i=0; until [ $i = 2 ]; do echo $i; i=$((i + 1)); done", "0\n1\n"},
+		{"while false; do :; done; echo $?", "0\n"},
+		{"i=0; while :; do i=$((i + 1)); [ $i = 5 ] && break; done; echo $i", "5\n"},
+		{"printf 'a b\\nc\\n' > in; while read line; do echo \"<$line>\"; done < in", "<a b>\n<c>\n"},
+		{"printf 'x\\ny\\n' | while read v; do echo got $v; done", "got x\ngot y\n"},
+		{"printf 'k v w\\n' | while read a b; do echo $b; done", "v w\n"},
+		{"i=0; while [ $i -lt 4 ]; do i=$((i + 1)); [ $i = 2 ] && continue; echo $i; done", "1\n3\n4\n"},
+		{"i=0; while true; do while true; do break 2; done; done; echo out", "out\n"},
+		{"i=0\nwhile [ $i -lt 2 ]\ndo\n  echo $i\n  i=$((i + 1))\ndone", "0\n1\n"},
+	})
+}
+
+func TestCase(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"case x in [a-z]) echo class;; esac", "class\n"},
+		{"case abc in a*c) echo glob;; esac", "glob\n"},
+		{`case "a b" in "a b") echo quoted;; esac`, "quoted\n"},
+		{"case z in a|z) echo alt;; esac", "alt\n"},
+		{"case x in y) echo no;; esac; echo $?", "0\n"},
+		{"case x in y) :;; *) echo default; esac", "default\n"},
+		{`p="a*"; case abc in $p) echo pattern;; esac`, "pattern\n"},
+		{`p="a*"; case abc in "$p") echo literal;; *) echo not;; esac`, "not\n"},
+		{"case x in (x) echo paren;; esac", "paren\n"},
+		{"case a in a) echo first;; a) echo second;; esac", "first\n"},
+		{"case a in a) (exit 3);; esac; echo $?", "3\n"},
+		{"case a in\n  a)\n    echo multi\n    ;;\nesac", "multi\n"},
+		{"v=b; case $v in a) echo a;; b) echo b;; esac", "b\n"},
+	})
+}
+
+func TestBraceGroup(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"{ echo a; echo b; } > out; cat out", "a\nb\n"},
+		{"{ x=in; }; echo $x", "in\n"},
+		{"mkdir sub; T=$(pwd); { cd sub; }; [ \"$PWD\" = \"$T/sub\" ] && echo moved", "moved\n"},
+		{"{ echo a; echo b; } | wc -l | tr -d ' '", "2\n"},
+		{"{ false; }; echo $?", "1\n"},
+		{"{ echo out; echo err >&2; } 2> err > out; cat err out", "err\nout\n"},
+		{"echo 1 > in; { read v; echo got $v; } < in", "got 1\n"},
+		{"{\n  echo multi\n}", "multi\n"},
+		{"{ echo a; }; { echo b; }", "a\nb\n"},
+		{"echo {; echo }", "{\n}\n"},
+	})
+}
+
+func TestSelect(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`printf '2\n' > in; select x in a b; do echo "[$x][$REPLY]"; break; done < in`, "[b][2]\n"},
+		{`printf 'nope\n3\n1\n' > in; select x in a b; do echo "[$x][$REPLY]"; [ -n "$x" ] && break; done < in`, "[][nope]\n[][3]\n[a][1]\n"},
+		{`printf '\n1\n' > in; select x in a; do echo $x; break; done < in 2> menu; grep -c '1) a' menu`, "a\n2\n"},
+		{`printf '1\n' > in; PS3='pick: '; select x in a; do break; done < in 2> menu; grep -c 'pick: ' menu`, "1\n"},
+		{`printf '1\n' > in; select x in a; do echo $x; done < in; echo $?`, "a\n1\n"},
+		{`select x in a; do echo never; done < /dev/null; echo $?`, "1\n"},
+		{`printf '2\n' > in; set -- p q; select x; do echo $x; break; done < in`, "q\n"},
+		{`select x in; do echo never; done; echo $?`, "0\n"},
+	})
+}
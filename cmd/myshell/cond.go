@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// CondCommand is `[[ expression ]]`. Unlike test, its words are neither
+// split nor globbed, so [[ $f == *.go ]] works with $f unquoted.
+type CondCommand struct {
+	Expr      *CondExpr
+	Redirects []Redirect
+	Source    string
+}
+
+func (command *CondCommand) commandSource() string { return command.Source }
+
+// CondExpr is a node of a [[ ]] expression. Op is &&, ||, ! or a test
+// operator, with the raw words it applies to; a lone word has no Op and
+// holds when it expands to something non-empty.
+type CondExpr struct {
+	Op       string
+	Words    []string
+	Operands []*CondExpr
+}
+
+// condToken is a word of a [[ ]] expression, or one of the operators that
+// are only meaningful between the brackets: && || ( ) < >.
+type condToken struct {
+	value    string
+	operator bool
+}
+
+func (p *parser) parseConditional() (*CondCommand, error) {
+	from := p.pos
+	p.pos++
+	tokens, err := p.condTokens()
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("syntax error: unexpected token `]]'")
+	}
+	cp := &condParser{tokens: tokens}
+	expr := cp.or()
+	if cp.err == nil && cp.pos < len(tokens) {
+		cp.err = fmt.Errorf("syntax error in conditional expression: unexpected token `%s'", tokens[cp.pos].value)
+	}
+	if cp.err != nil {
+		return nil, cp.err
+	}
+	command := &CondCommand{Expr: expr, Source: p.source(from, p.pos)}
+	return command, p.parseRedirects(&command.Redirects)
+}
+
+// condTokens consumes the tokens up to and including the closing ]]. The
+// right side of =~ is a regular expression whose | and parentheses the
+// tokenizer splits off, so the tokens it spans without blanks in between
+// are joined back into one word.
+func (p *parser) condTokens() ([]condToken, error) {
+	tokens := []condToken{}
+	for {
+		p.skipNewlines()
+		token, ok := p.peek()
+		if !ok {
+			return nil, incomplete("unexpected EOF while looking for `]]'")
+		}
+		p.pos++
+		switch {
+		case token.Kind == TokenWord && token.Value == "]]":
+			return tokens, nil
+		case len(tokens) > 0 && tokens[len(tokens)-1].value == "=~" && !tokens[len(tokens)-1].operator:
+			regex := token.Value
+			for next, ok := p.peek(); ok && next.Start == token.End && isRegexToken(next); next, ok = p.peek() {
+				regex += next.Value
+				token = next
+				p.pos++
+			}
+			tokens = append(tokens, condToken{value: regex})
+		case token.Kind == TokenWord:
+			tokens = append(tokens, condToken{value: token.Value})
+		case token.Kind == TokenAnd, token.Kind == TokenOr, token.Kind == TokenLParen, token.Kind == TokenRParen,
+			token.Kind == TokenRedirect && (token.Value == "<" || token.Value == ">"):
+			tokens = append(tokens, condToken{value: token.Value, operator: true})
+		default:
+			return nil, fmt.Errorf("syntax error in conditional expression: unexpected token `%s'", token.Value)
+		}
+	}
+}
+
+func isRegexToken(token Token) bool {
+	switch token.Kind {
+	case TokenWord:
+		return token.Value != "]]"
+	case TokenPipe, TokenLParen, TokenRParen:
+		return true
+	}
+	return false
+}
+
+// condParser builds the expression with ! binding tighter than &&, and &&
+// tighter than ||.
+type condParser struct {
+	tokens []condToken
+	pos    int
+	err    error
+}
+
+func (p *condParser) next(value string, operator bool) bool {
+	if p.err != nil || p.pos >= len(p.tokens) {
+		return false
+	}
+	token := p.tokens[p.pos]
+	if token.value != value || token.operator != operator {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *condParser) or() *CondExpr {
+	expr := p.and()
+	for p.next("||", true) {
+		expr = &CondExpr{Op: "||", Operands: []*CondExpr{expr, p.and()}}
+	}
+	return expr
+}
+
+func (p *condParser) and() *CondExpr {
+	expr := p.not()
+	for p.next("&&", true) {
+		expr = &CondExpr{Op: "&&", Operands: []*CondExpr{expr, p.not()}}
+	}
+	return expr
+}
+
+func (p *condParser) not() *CondExpr {
+	if p.next("!", false) {
+		return &CondExpr{Op: "!", Operands: []*CondExpr{p.not()}}
+	}
+	return p.primary()
+}
+
+func (p *condParser) primary() *CondExpr {
+	if p.err != nil {
+		return nil
+	}
+	if p.pos >= len(p.tokens) {
+		p.err = fmt.Errorf("syntax error in conditional expression: unexpected token `]]'")
+		return nil
+	}
+	if p.next("(", true) {
+		expr := p.or()
+		if p.err == nil && !p.next(")", true) {
+			p.err = fmt.Errorf("syntax error in conditional expression: expected `)'")
+		}
+		return expr
+	}
+	token := p.tokens[p.pos]
+	if token.operator {
+		p.err = fmt.Errorf("syntax error in conditional expression: unexpected token `%s'", token.value)
+		return nil
+	}
+	if p.pos+2 < len(p.tokens) && isCondBinaryOp(p.tokens[p.pos+1]) && !p.tokens[p.pos+2].operator {
+		op, right := p.tokens[p.pos+1].value, p.tokens[p.pos+2].value
+		p.pos += 3
+		return &CondExpr{Op: op, Words: []string{token.value, right}}
+	}
+	if isTestUnaryOp(token.value) && p.pos+1 < len(p.tokens) && !p.tokens[p.pos+1].operator {
+		operand := p.tokens[p.pos+1].value
+		p.pos += 2
+		return &CondExpr{Op: token.value, Words: []string{operand}}
+	}
+	p.pos++
+	return &CondExpr{Words: []string{token.value}}
+}
+
+func isCondBinaryOp(token condToken) bool {
+	if token.operator {
+		return token.value == "<" || token.value == ">"
+	}
+	return token.value == "=~" || (isTestBinaryOp(token.value) && token.value != "<" && token.value != ">")
+}
+
+// runConditional is 0 when the expression holds, 1 when it doesn't and 2
+// when it can't be evaluated.
+func (ctx *ShellCtx) runConditional(command *CondCommand) int {
+	result, err := ctx.evalCond(command.Expr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 2
+	}
+	if !result {
+		return 1
+	}
+	return 0
+}
+
+func (ctx *ShellCtx) evalCond(expr *CondExpr) (bool, error) {
+	switch expr.Op {
+	case "&&", "||":
+		left, err := ctx.evalCond(expr.Operands[0])
+		if err != nil || left == (expr.Op == "||") {
+			return left, err
+		}
+		return ctx.evalCond(expr.Operands[1])
+	case "!":
+		result, err := ctx.evalCond(expr.Operands[0])
+		return !result, err
+	case "":
+		word, err := ctx.ExpandString(expr.Words[0])
+		return word != "", err
+	case "-eq", "-ne", "-lt", "-le", "-gt", "-ge":
+		// Both sides are arithmetic expressions here.
+		left, err := ctx.EvalArithmetic(expr.Words[0])
+		if err != nil {
+			return false, err
+		}
+		right, err := ctx.EvalArithmetic(expr.Words[1])
+		if err != nil {
+			return false, err
+		}
+		return ctx.testBinary(strconv.FormatInt(left, 10), expr.Op, strconv.FormatInt(right, 10))
+	}
+
+	left, err := ctx.ExpandString(expr.Words[0])
+	if err != nil {
+		return false, err
+	}
+	if len(expr.Words) == 1 {
+		return ctx.testUnary(expr.Op, left)
+	}
+	switch expr.Op {
+	case "=", "==", "!=":
+		pattern, err := ctx.ExpandPattern(expr.Words[1])
+		if err != nil {
+			return false, err
+		}
+		return MatchPattern(pattern, left) == (expr.Op != "!="), nil
+	case "=~":
+		return ctx.matchRegex(left, expr.Words[1])
+	}
+	right, err := ctx.ExpandString(expr.Words[1])
+	if err != nil {
+		return false, err
+	}
+	return ctx.testBinary(left, expr.Op, right)
+}
+
+// matchRegex matches value against a POSIX extended regular expression,
+// anywhere in the string. Quoted parts of the expression match literally.
+// The BASH_REMATCH array gets the matched text followed by the text of each
+// capture group.
+func (ctx *ShellCtx) matchRegex(value, raw string) (bool, error) {
+	expr, err := ctx.ExpandRegex(raw)
+	if err != nil {
+		return false, err
+	}
+	re, err := regexp.CompilePOSIX(expr)
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid regular expression", expr)
+	}
+	match := re.FindStringSubmatch(value)
+	ctx.SetArray("BASH_REMATCH", match)
+	return match != nil, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCond(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`[[ abc == a* ]]; echo $?`, "0\n"},
+		{`[[ abc == "a*" ]]; echo $?`, "1\n"},
+		{`[[ 'a*' == "a*" ]]; echo $?`, "0\n"},
+		{`[[ abc != a?c ]]; echo $?`, "1\n"},
+		{`x="a b"; [[ $x == "a b" ]]; echo $?`, "0\n"},
+		{`[[ -n $unset ]]; echo $?`, "1\n"},
+		{`[[ 10 -gt 9 && b > a ]]; echo $?`, "0\n"},
+		{`[[ 1 -eq 2 || ( a != b && ! -z a ) ]]; echo $?`, "0\n"},
+		{`[[ 2+3 -eq 5 ]]; echo $?`, "0\n"},
+		{`[[ -d . && ! -f . ]]; echo $?`, "0\n"},
+		{`[[ abc123 =~ ^([a-z]+)([0-9]+)$ ]]; echo $? ${BASH_REMATCH[@]}`, "0 abc123 abc 123\n"},
+		{`[[ c =~ c$ ]]; echo $?`, "0\n"},
+		{`[[ c =~ "c$" ]]; echo $?`, "1\n"},
+		{`[[ a.c =~ a"."c ]]; [[ abc =~ a"."c ]]; echo $?`, "1\n"},
+		{`re='^a.c$'; [[ abc =~ $re ]]; echo $?`, "0\n"},
+		{`[[ xyz =~ q ]]; echo $? ${#BASH_REMATCH[@]}`, "1 0\n"},
+		{`re='('; [[ a =~ $re ]]; echo $?`, "2\n"},
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The directory stack as the user sees it starts with the current directory,
+// which is not stored in DirStack itself: entry 0 is always CurrentDir.
+func (ctx *ShellCtx) dirStackEntries() []string {
+	return append([]string{ctx.CurrentDir}, ctx.DirStack...)
+}
+
+// parseStackIndex turns +N (counting from the left) or -N (counting from the
+// right) into an index of the full directory stack.
+func parseStackIndex(arg string, size int) (int, bool, error) {
+	if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') || !isAllDigits(arg[1:]) {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil || n >= size {
+		return 0, true, fmt.Errorf("%s: directory stack index out of range", arg)
+	}
+	if arg[0] == '-' {
+		n = size - 1 - n
+	}
+	return n, true, nil
+}
+
+// formatDirStack renders the stack for dirs: on one line, one per line (-p)
+// or numbered (-v). Unless long is set, $HOME is shown as ~.
+func (ctx *ShellCtx) formatDirStack(long, perLine, verbose bool) string {
+	entries := ctx.dirStackEntries()
+	if !long {
+		for i, dir := range entries {
+			entries[i] = ctx.AbbreviateDir(dir)
+		}
+	}
+	if verbose {
+		lines := strings.Builder{}
+		for i, dir := range entries {
+			lines.WriteString(fmt.Sprintf("%2d  %s\n", i, dir))
+		}
+		return lines.String()
+	}
+	if perLine {
+		return strings.Join(entries, "\n") + "\n"
+	}
+	return strings.Join(entries, " ") + "\n"
+}
+
+func DirsExecutor(shellCtx *ShellCtx, args []string) error {
+	long, perLine, verbose := false, false, false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && !isAllDigits(args[0][1:]) {
+		option := args[0]
+		args = args[1:]
+		switch option {
+		case "-c":
+			shellCtx.DirStack = nil
+			return nil
+		case "-l":
+			long = true
+		case "-p":
+			perLine = true
+		case "-v":
+			verbose = true
+		default:
+			return fmt.Errorf("dirs command got invalid option %s", option)
+		}
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("dirs command takes at most 1 argument of type +N or -N")
+	}
+
+	if len(args) == 1 {
+		entries := shellCtx.dirStackEntries()
+		index, ok, err := parseStackIndex(args[0], len(entries))
+		if !ok && err == nil {
+			return fmt.Errorf("dirs command got invalid argument %s", args[0])
+		}
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("dirs: %s\n", err.Error())
+			shellCtx.Status = 1
+			return nil
+		}
+		dir := entries[index]
+		if !long {
+			dir = shellCtx.AbbreviateDir(dir)
+		}
+		if verbose {
+			shellCtx.Sout = fmt.Sprintf("%2d  %s\n", index, dir)
+		} else {
+			shellCtx.Sout = dir + "\n"
+		}
+		return nil
+	}
+	shellCtx.Sout = shellCtx.formatDirStack(long, perLine, verbose)
+	return nil
+}
+
+// PushdExecutor implements `pushd [-n] [dir | +N | -N]`. Without arguments
+// the top two directories are exchanged, +N and -N rotate the stack so that
+// the given entry ends up on top.
+func PushdExecutor(shellCtx *ShellCtx, args []string) error {
+	noChange := false
+	if len(args) > 0 && args[0] == "-n" {
+		noChange = true
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("pushd command takes at most 1 argument of type string")
+	}
+
+	entries := shellCtx.dirStackEntries()
+	if len(args) == 0 {
+		if len(entries) < 2 {
+			shellCtx.Serr = "pushd: no other directory\n"
+			shellCtx.Status = 1
+			return nil
+		}
+		entries[0], entries[1] = entries[1], entries[0]
+		if !shellCtx.enterStackTop("pushd", entries) {
+			return nil
+		}
+		shellCtx.Sout = shellCtx.formatDirStack(false, false, false)
+		return nil
+	}
+
+	index, isIndex, err := parseStackIndex(args[0], len(entries))
+	if err != nil {
+		shellCtx.Serr = fmt.Sprintf("pushd: %s\n", err.Error())
+		shellCtx.Status = 1
+		return nil
+	}
+	if isIndex {
+		rotated := append(slices.Clone(entries[index:]), entries[:index]...)
+		if noChange {
+			shellCtx.DirStack = rotated[1:]
+		} else if !shellCtx.enterStackTop("pushd", rotated) {
+			return nil
+		}
+		shellCtx.Sout = shellCtx.formatDirStack(false, false, false)
+		return nil
+	}
+
+	if noChange {
+		shellCtx.DirStack = slices.Insert(shellCtx.DirStack, 0, shellCtx.ResolvePath(args[0]))
+		shellCtx.Sout = shellCtx.formatDirStack(false, false, false)
+		return nil
+	}
+	previousDir := shellCtx.CurrentDir
+	if shellCtx.changeDirTo("pushd", args[0], false); shellCtx.Status != 0 {
+		return nil
+	}
+	shellCtx.DirStack = slices.Insert(shellCtx.DirStack, 0, previousDir)
+	shellCtx.Sout = shellCtx.formatDirStack(false, false, false)
+	return nil
+}
+
+// PopdExecutor implements `popd [-n] [+N | -N]`, removing the top entry and
+// changing to the new top, or removing the given entry only.
+func PopdExecutor(shellCtx *ShellCtx, args []string) error {
+	noChange := false
+	if len(args) > 0 && args[0] == "-n" {
+		noChange = true
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("popd command takes at most 1 argument of type +N or -N")
+	}
+	if len(shellCtx.DirStack) == 0 {
+		shellCtx.Serr = "popd: directory stack empty\n"
+		shellCtx.Status = 1
+		return nil
+	}
+
+	entries := shellCtx.dirStackEntries()
+	index := 0
+	if noChange {
+		index = 1
+	}
+	if len(args) == 1 {
+		var isIndex bool
+		var err error
+		index, isIndex, err = parseStackIndex(args[0], len(entries))
+		if !isIndex && err == nil {
+			return fmt.Errorf("popd command got invalid argument %s", args[0])
+		}
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("popd: %s\n", err.Error())
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+
+	entries = slices.Delete(entries, index, index+1)
+	if index == 0 {
+		if !shellCtx.enterStackTop("popd", entries) {
+			return nil
+		}
+	} else {
+		shellCtx.DirStack = entries[1:]
+	}
+	shellCtx.Sout = shellCtx.formatDirStack(false, false, false)
+	return nil
+}
+
+// enterStackTop changes to the first of the given stack entries and keeps
+// the rest as the new DirStack. The stack is left alone if the directory
+// can't be entered.
+func (ctx *ShellCtx) enterStackTop(command string, entries []string) bool {
+	if ctx.changeDirTo(command, entries[0], false); ctx.Status != 0 {
+		return false
+	}
+	ctx.DirStack = entries[1:]
+	return true
+}
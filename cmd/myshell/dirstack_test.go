@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestDirStack(t *testing.T) {
+	// Each script shows the stack with the test directory as T.
+	const setup = `mkdir a b c; T=$(pwd); show() { dirs "$@" | sed "s|$T|T|g"; }; `
+	checkScripts(t, []scriptTest{
+		{setup + "pushd a > /dev/null; pushd ../b > /dev/null; show", "T/b T/a T\n"},
+		{setup + "pushd a | sed \"s|$T|T|g\"", "T/a T\n"},
+		{setup + "pushd a > /dev/null; pushd ../b > /dev/null; pushd ../c > /dev/null; pushd +2 > /dev/null; show; pwd | sed \"s|$T|T|g\"", "T/a T T/c T/b\nT/a\n"},
+		{setup + "pushd a > /dev/null; pushd ../b > /dev/null; pushd -0 > /dev/null; show", "T T/b T/a\n"},
+		{setup + "pushd a > /dev/null; pushd > /dev/null; show", "T T/a\n"},
+		{setup + "pushd a > /dev/null; pushd ../b > /dev/null; popd > /dev/null; show; pwd | sed \"s|$T|T|g\"", "T/a T\nT/a\n"},
+		{setup + "pushd a > /dev/null; pushd ../b > /dev/null; popd +1 > /dev/null; show", "T/b T\n"},
+		{setup + "pushd a > /dev/null; show -v", " 0  T/a\n 1  T\n"},
+		{setup + "pushd a > /dev/null; show -p", "T/a\nT\n"},
+		{setup + "pushd a > /dev/null; dirs -c; show", "T/a\n"},
+		{"popd; echo $?", "1\n"},
+		{"pushd; echo $?", "1\n"},
+		{"pushd missing; echo $?", "1\n"},
+		{"pushd +3; echo $?", "1\n"},
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EditBuffer is the text being edited at the prompt. It may span several
+// lines; the cursor is a line and a rune offset within that line.
+type EditBuffer struct {
+	lines [][]rune
+	row   int
+	col   int
+}
+
+// rawByteRune is where bytes that aren't valid UTF-8, like those of a
+// Latin-1 file name, are kept as runes of their own, at rawByteRune plus the
+// byte, so that text comes out of the buffer exactly as it went in.
+const rawByteRune = 0xDC00
+
+func decodeRunes(text string) []rune {
+	runes := make([]rune, 0, len(text))
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		if r == utf8.RuneError && size == 1 {
+			r = rawByteRune + rune(text[0])
+		}
+		runes = append(runes, r)
+		text = text[size:]
+	}
+	return runes
+}
+
+func encodeRunes(runes []rune) string {
+	text := strings.Builder{}
+	for _, r := range runes {
+		if r >= rawByteRune+0x80 && r <= rawByteRune+0xff {
+			text.WriteByte(byte(r - rawByteRune))
+		} else {
+			text.WriteRune(r)
+		}
+	}
+	return text.String()
+}
+
+func NewEditBuffer() *EditBuffer {
+	return &EditBuffer{lines: [][]rune{{}}}
+}
+
+// SetText replaces the contents, leaving the cursor at the very end.
+func (buffer *EditBuffer) SetText(text string) {
+	buffer.lines = buffer.lines[:0]
+	for _, line := range strings.Split(text, "\n") {
+		buffer.lines = append(buffer.lines, decodeRunes(line))
+	}
+	buffer.row = len(buffer.lines) - 1
+	buffer.col = len(buffer.lines[buffer.row])
+}
+
+func (buffer *EditBuffer) String() string {
+	lines := make([]string, len(buffer.lines))
+	for i, line := range buffer.lines {
+		lines[i] = encodeRunes(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// BeforeCursor is the text from the start of the buffer up to the cursor.
+func (buffer *EditBuffer) BeforeCursor() string {
+	lines := make([]string, buffer.row+1)
+	for i, line := range buffer.lines[:buffer.row] {
+		lines[i] = encodeRunes(line)
+	}
+	lines[buffer.row] = encodeRunes(buffer.lines[buffer.row][:buffer.col])
+	return strings.Join(lines, "\n")
+}
+
+func (buffer *EditBuffer) Lines() []string {
+	return strings.Split(buffer.String(), "\n")
+}
+
+func (buffer *EditBuffer) Cursor() (int, int) {
+	return buffer.row, buffer.col
+}
+
+func (buffer *EditBuffer) Insert(r rune) {
+	line := buffer.lines[buffer.row]
+	line = append(line[:buffer.col], append([]rune{r}, line[buffer.col:]...)...)
+	buffer.lines[buffer.row] = line
+	buffer.col++
+}
+
+func (buffer *EditBuffer) InsertString(s string) {
+	for _, r := range decodeRunes(s) {
+		if r == '\n' {
+			buffer.InsertNewline()
+		} else {
+			buffer.Insert(r)
+		}
+	}
+}
+
+// InsertNewline splits the current line at the cursor.
+func (buffer *EditBuffer) InsertNewline() {
+	line := buffer.lines[buffer.row]
+	rest := append([]rune{}, line[buffer.col:]...)
+	buffer.lines[buffer.row] = line[:buffer.col]
+	buffer.lines = append(buffer.lines[:buffer.row+1], append([][]rune{rest}, buffer.lines[buffer.row+1:]...)...)
+	buffer.row++
+	buffer.col = 0
+}
+
+// Backspace deletes the rune before the cursor, joining the line with the
+// previous one at the start of a line.
+func (buffer *EditBuffer) Backspace() {
+	if buffer.col > 0 {
+		line := buffer.lines[buffer.row]
+		buffer.lines[buffer.row] = append(line[:buffer.col-1], line[buffer.col:]...)
+		buffer.col--
+		return
+	}
+	if buffer.row == 0 {
+		return
+	}
+	previous := buffer.lines[buffer.row-1]
+	buffer.col = len(previous)
+	buffer.lines[buffer.row-1] = append(previous, buffer.lines[buffer.row]...)
+	buffer.lines = append(buffer.lines[:buffer.row], buffer.lines[buffer.row+1:]...)
+	buffer.row--
+}
+
+func (buffer *EditBuffer) MoveLeft() {
+	if buffer.col > 0 {
+		buffer.col--
+	} else if buffer.row > 0 {
+		buffer.row--
+		buffer.col = len(buffer.lines[buffer.row])
+	}
+}
+
+func (buffer *EditBuffer) MoveRight() {
+	if buffer.col < len(buffer.lines[buffer.row]) {
+		buffer.col++
+	} else if buffer.row < len(buffer.lines)-1 {
+		buffer.row++
+		buffer.col = 0
+	}
+}
+
+func (buffer *EditBuffer) MoveHome() {
+	buffer.col = 0
+}
+
+func (buffer *EditBuffer) MoveEnd() {
+	buffer.col = len(buffer.lines[buffer.row])
+}
+
+// MoveUp goes to the previous line, keeping the column where possible. It
+// reports false when the cursor already is on the first line.
+func (buffer *EditBuffer) MoveUp() bool {
+	if buffer.row == 0 {
+		return false
+	}
+	buffer.row--
+	buffer.col = min(buffer.col, len(buffer.lines[buffer.row]))
+	return true
+}
+
+// MoveDown is the counterpart of MoveUp for the last line.
+func (buffer *EditBuffer) MoveDown() bool {
+	if buffer.row == len(buffer.lines)-1 {
+		return false
+	}
+	buffer.row++
+	buffer.col = min(buffer.col, len(buffer.lines[buffer.row]))
+	return true
+}
+
+func (buffer *EditBuffer) MoveToFirstLine() {
+	buffer.row = 0
+	buffer.col = len(buffer.lines[0])
+}
+
+// Delete removes the rune under the cursor, joining the next line at the
+// end of a line.
+func (buffer *EditBuffer) Delete() {
+	line := buffer.lines[buffer.row]
+	if buffer.col < len(line) {
+		buffer.lines[buffer.row] = append(line[:buffer.col], line[buffer.col+1:]...)
+		return
+	}
+	if buffer.row == len(buffer.lines)-1 {
+		return
+	}
+	buffer.lines[buffer.row] = append(line, buffer.lines[buffer.row+1]...)
+	buffer.lines = append(buffer.lines[:buffer.row+1], buffer.lines[buffer.row+2:]...)
+}
+
+// DeleteWordBackward removes the whitespace-delimited word before the
+// cursor, along with the blanks after it, like Ctrl-W in a terminal.
+func (buffer *EditBuffer) DeleteWordBackward() {
+	line := buffer.lines[buffer.row]
+	start := buffer.col
+	for start > 0 && unicode.IsSpace(line[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(line[start-1]) {
+		start--
+	}
+	buffer.lines[buffer.row] = append(line[:start], line[buffer.col:]...)
+	buffer.col = start
+}
+
+// KillToStart removes the current line up to the cursor.
+func (buffer *EditBuffer) KillToStart() {
+	buffer.lines[buffer.row] = append([]rune{}, buffer.lines[buffer.row][buffer.col:]...)
+	buffer.col = 0
+}
+
+// KillToEnd removes the current line from the cursor on.
+func (buffer *EditBuffer) KillToEnd() {
+	buffer.lines[buffer.row] = buffer.lines[buffer.row][:buffer.col]
+}
+
+// MoveWordLeft and MoveWordRight jump over a word, made of letters and
+// digits, on the current line.
+func (buffer *EditBuffer) MoveWordLeft() {
+	line := buffer.lines[buffer.row]
+	for buffer.col > 0 && !isWordRune(line[buffer.col-1]) {
+		buffer.col--
+	}
+	for buffer.col > 0 && isWordRune(line[buffer.col-1]) {
+		buffer.col--
+	}
+}
+
+func (buffer *EditBuffer) MoveWordRight() {
+	line := buffer.lines[buffer.row]
+	for buffer.col < len(line) && !isWordRune(line[buffer.col]) {
+		buffer.col++
+	}
+	for buffer.col < len(line) && isWordRune(line[buffer.col]) {
+		buffer.col++
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// MoveToEnd puts the cursor at the end of the last line.
+func (buffer *EditBuffer) MoveToEnd() {
+	buffer.row = len(buffer.lines) - 1
+	buffer.col = len(buffer.lines[buffer.row])
+}
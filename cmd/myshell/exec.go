@@ -0,0 +1,736 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type StageResult struct {
+	Command  string
+	Status   int
+	Duration time.Duration
+}
+
+type PipelineResult struct {
+	Stages   []StageResult
+	Duration time.Duration
+}
+
+type Streams struct {
+	Stdin  *os.File
+	Stdout *os.File
+	Stderr *os.File
+	// Extra holds the descriptors above 2 redirected for the command, like
+	// the 3 of 3> file, passed on to the commands it starts. A nil file is
+	// one closed with 3>&-. The standard streams are nil when closed too.
+	Extra map[int]*os.File
+}
+
+// file returns the file at descriptor fd, and whether it is open.
+func (streams Streams) file(ctx *ShellCtx, fd int) (*os.File, bool) {
+	switch fd {
+	case 0:
+		return streams.Stdin, streams.Stdin != nil
+	case 1:
+		return streams.Stdout, streams.Stdout != nil
+	case 2:
+		return streams.Stderr, streams.Stderr != nil
+	}
+	if file, found := streams.Extra[fd]; found {
+		return file, file != nil
+	}
+	file, found := ctx.Fds[fd]
+	return file, found
+}
+
+// set puts file at descriptor fd, nil closing it.
+func (streams *Streams) set(fd int, file *os.File) {
+	switch fd {
+	case 0:
+		streams.Stdin = file
+	case 1:
+		streams.Stdout = file
+	case 2:
+		streams.Stderr = file
+	default:
+		// The streams are copied for each command, so the table is too.
+		extra := maps.Clone(streams.Extra)
+		if extra == nil {
+			extra = map[int]*os.File{}
+		}
+		extra[fd] = file
+		streams.Extra = extra
+	}
+}
+
+// restoreStreams makes saved the streams of the shell again once a command
+// is done with its own, keeping what exec redirected for good in a
+// subshell.
+func (ctx *ShellCtx) restoreStreams(saved Streams) {
+	for fd, file := range ctx.permanent {
+		saved.set(fd, file)
+	}
+	ctx.Streams = saved
+}
+
+// extraFiles lists the descriptors above 2 an external command gets, those
+// exec left open in the shell and those redirected for it, at the index of
+// their number less 3. Gaps are left nil, closed in the command.
+func (ctx *ShellCtx) extraFiles(streams Streams) []*os.File {
+	files := []*os.File{}
+	for _, table := range []map[int]*os.File{ctx.Fds, streams.Extra} {
+		for fd, file := range table {
+			for len(files) <= fd-3 {
+				files = append(files, nil)
+			}
+			files[fd-3] = file
+		}
+	}
+	for len(files) > 0 && files[len(files)-1] == nil {
+		files = files[:len(files)-1]
+	}
+	return files
+}
+
+func (ctx *ShellCtx) RunLine(line string) int {
+	list, err := ParseCommandList(line, ctx.Aliases)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		ctx.LastStatus = 2
+		return ctx.LastStatus
+	}
+	ctx.RunCommandList(list)
+	return ctx.LastStatus
+}
+
+func (ctx *ShellCtx) RunCommandList(list *CommandList) {
+	for _, item := range list.Items {
+		ctx.RunPendingTraps()
+		if ctx.unwinding() {
+			return
+		}
+		if item.Background {
+			ctx.StartBackgroundJob(item.AndOr)
+			ctx.LastStatus = 0
+			continue
+		}
+		ctx.RunAndOr(item.AndOr)
+		ctx.RunPendingTraps()
+	}
+}
+
+// RunAndOr runs the first pipeline of the list and then each following one
+// whose operator agrees with the status so far: && needs success, || failure.
+func (ctx *ShellCtx) RunAndOr(andOr *AndOrList) {
+	last := 0
+	for i, pipeline := range andOr.Pipelines {
+		if i > 0 {
+			if ctx.unwinding() {
+				return
+			}
+			if (andOr.Operators[i-1] == "&&") != (ctx.LastStatus == 0) {
+				continue
+			}
+		}
+		if i < len(andOr.Pipelines)-1 {
+			ctx.conditionDepth++
+			ctx.RunPipeline(pipeline)
+			ctx.conditionDepth--
+		} else {
+			ctx.RunPipeline(pipeline)
+		}
+		last = i
+	}
+	// Only the failure of the last pipeline counts as an error; the ones
+	// before it are tested by && and ||.
+	if ctx.LastStatus != 0 && last == len(andOr.Pipelines)-1 && ctx.conditionDepth == 0 && !ctx.unwinding() {
+		ctx.reportFailure(andOr.Pipelines[last])
+	}
+}
+
+// reportFailure runs the ERR trap for a failed pipeline and, under set -e,
+// exits. A compound command run in this shell has already reported the
+// command that failed inside it, and a pipeline negated with ! is tested
+// like a condition.
+func (ctx *ShellCtx) reportFailure(pipeline *Pipeline) {
+	if pipeline.Negated {
+		return
+	}
+	switch pipeline.Commands[len(pipeline.Commands)-1].(type) {
+	case *SimpleCommand, *Subshell, *CondCommand, *ArithCommand:
+		ctx.runConditionTrap("ERR")
+		if !ctx.Options["errexit"] || ctx.inTrap {
+			return
+		}
+		if ctx.subshell {
+			ctx.exiting = true
+			return
+		}
+		ctx.Exit(ctx.LastStatus)
+	}
+}
+
+func (ctx *ShellCtx) RunPipeline(pipeline *Pipeline) {
+	stageCount := len(pipeline.Commands)
+	streams := make([]Streams, stageCount)
+	for i := range streams {
+		streams[i] = ctx.Streams
+	}
+	for i := 0; i < stageCount-1; i++ {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pipe: %s\n", err.Error())
+			ctx.LastStatus = 1
+			return
+		}
+		streams[i].Stdout = writer
+		streams[i+1].Stdin = reader
+	}
+
+	result := &PipelineResult{Stages: make([]StageResult, stageCount)}
+	if pipeline.Timed {
+		defer ctx.reportTime(pipeline, time.Now(), readCPUTimes())
+	}
+	resume := func(bool) {}
+	if !ctx.Background {
+		resume = ctx.Terminal.Release()
+	}
+	var group *ProcessGroup
+	if ctx.jobControl() {
+		group = &ProcessGroup{tty: ctx.Terminal.fd, command: pipeline.Source}
+		outer := ctx.Group
+		ctx.Group = group
+		defer func() { ctx.Group = outer }()
+	}
+	start := time.Now()
+
+	runStage := func(i int, stageCtx *ShellCtx) {
+		stageStart := time.Now()
+		command := pipeline.Commands[i]
+		status := stageCtx.ExecuteCommand(command, streams[i])
+		result.Stages[i] = StageResult{
+			Command:  command.commandSource(),
+			Status:   status,
+			Duration: time.Since(stageStart),
+		}
+		if i > 0 {
+			streams[i].Stdin.Close()
+		}
+		if i < stageCount-1 {
+			streams[i].Stdout.Close()
+		}
+	}
+
+	if stageCount == 1 {
+		runStage(0, ctx)
+	} else {
+		// Every stage of a multi-stage pipeline runs concurrently in its own
+		// copy of the shell context, like a subshell would.
+		var wg sync.WaitGroup
+		for i := range pipeline.Commands {
+			wg.Add(1)
+			go func(i int, stageCtx *ShellCtx) {
+				defer wg.Done()
+				runStage(i, stageCtx)
+				stageCtx.leaveSubshell()
+			}(i, ctx.Clone())
+		}
+		wg.Wait()
+	}
+
+	result.Duration = time.Since(start)
+	lastStatus := result.Stages[stageCount-1].Status
+	if group != nil {
+		ctx.Terminal.TakeForeground()
+	}
+	resume(lastStatus == 0)
+	if group != nil && group.job != nil {
+		fmt.Fprint(ctx.Streams.Stderr, "\n"+ctx.Jobs.Describe(group.job))
+	}
+
+	ctx.SetPipelineResult(result)
+	if pipeline.Negated {
+		// PIPESTATUS keeps the statuses of the commands themselves.
+		if ctx.LastStatus == 0 {
+			ctx.LastStatus = 1
+		} else {
+			ctx.LastStatus = 0
+		}
+	}
+	ctx.auditPipeline(pipeline, result)
+}
+
+// SetPipelineResult records the outcome of a pipeline for $? and the
+// PIPESTATUS and PIPETIME arrays, which is what prompt hooks and the audit
+// log look at.
+func (ctx *ShellCtx) SetPipelineResult(result *PipelineResult) {
+	ctx.LastPipeline = result
+	statuses := make([]string, len(result.Stages))
+	durations := make([]string, len(result.Stages))
+	for i, stage := range result.Stages {
+		statuses[i] = strconv.Itoa(stage.Status)
+		durations[i] = formatSeconds(stage.Duration)
+	}
+	ctx.SetArray("PIPESTATUS", statuses)
+	ctx.SetArray("PIPETIME", durations)
+	ctx.LastStatus = result.Stages[len(result.Stages)-1].Status
+	if ctx.Options["pipefail"] {
+		// The status is that of the last stage to fail.
+		for _, stage := range result.Stages {
+			if stage.Status != 0 {
+				ctx.LastStatus = stage.Status
+			}
+		}
+	}
+}
+
+func formatSeconds(duration time.Duration) string {
+	return strconv.FormatFloat(duration.Seconds(), 'f', 3, 64)
+}
+
+func (ctx *ShellCtx) auditPipeline(pipeline *Pipeline, result *PipelineResult) {
+	logPath, found := ctx.GetVar("MYSHELL_AUDIT_LOG")
+	if !found || len(logPath) == 0 {
+		return
+	}
+	file, err := os.OpenFile(ctx.ResolvePath(logPath), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintf(file, "%s\t%s\tstatus=%s\ttime=%s\ttotal=%s\n",
+		time.Now().Format(time.RFC3339), pipeline.Source,
+		strings.Join(ctx.ArrayValues("PIPESTATUS"), ","), strings.Join(ctx.ArrayValues("PIPETIME"), ","),
+		formatSeconds(result.Duration))
+}
+
+// RunPromptCommand runs the PROMPT_COMMAND hook before a prompt is shown,
+// without letting it clobber the status of the user's last pipeline.
+func (ctx *ShellCtx) RunPromptCommand() {
+	hook, found := ctx.GetVar("PROMPT_COMMAND")
+	if !found || len(strings.TrimSpace(hook)) == 0 {
+		return
+	}
+	lastPipeline := ctx.LastPipeline
+	lastStatus := ctx.LastStatus
+	ctx.RunLine(hook)
+	if lastPipeline != nil {
+		ctx.SetPipelineResult(lastPipeline)
+	}
+	ctx.LastStatus = lastStatus
+}
+
+// CommandSubstitution runs command in a copy of the shell and returns what
+// it wrote to stdout, minus trailing newlines.
+func (ctx *ShellCtx) CommandSubstitution(command string) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pipe: %s\n", err.Error())
+		return ""
+	}
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		output <- data
+	}()
+
+	sub := ctx.Clone()
+	sub.Streams.Stdout = writer
+	// As in bash, set -e doesn't carry over into command substitutions.
+	delete(sub.Options, "errexit")
+	sub.RunLine(command)
+	sub.RunExitTrap()
+	sub.leaveSubshell()
+	writer.Close()
+	ctx.LastStatus = sub.LastStatus
+	ctx.substituted = true
+	return strings.TrimRight(string(<-output), "\n")
+}
+
+func (ctx *ShellCtx) ResolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(ctx.CurrentDir, path)
+}
+
+func (ctx *ShellCtx) RunCommand(command *SimpleCommand, streams Streams) int {
+	ctx.runConditionTrap("DEBUG")
+	ctx.substituted = false
+	rawAssignments, rawArgs := SplitAssignmentWords(command.Words)
+	assignments := make([]Assignment, 0, len(rawAssignments))
+	for _, assignment := range rawAssignments {
+		if err := ctx.ExpandAssignment(&assignment); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+		assignments = append(assignments, assignment)
+	}
+	args, err := ctx.expandArgs(rawArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	closeRedirects, err := ctx.applyRedirects(command.Redirects, &streams)
+	defer closeRedirects()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+
+	if ctx.Options["xtrace"] {
+		ctx.traceCommand(assignments, args)
+	}
+	if err := ctx.CheckAssignable(assignments); err != nil {
+		fmt.Fprintf(streams.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	if len(args) == 0 {
+		for _, assignment := range assignments {
+			if err := ctx.Assign(assignment); err != nil {
+				fmt.Fprintf(streams.Stderr, "%s\n", err.Error())
+				return 1
+			}
+		}
+		if ctx.substituted {
+			return ctx.LastStatus
+		}
+		return 0
+	}
+
+	return ctx.ExecuteArgs(args, assignments, nil, streams)
+}
+
+// traceCommand shows a command about to run for set -x: PS4, then the
+// expanded assignments and words, quoted where they need to be.
+func (ctx *ShellCtx) traceCommand(assignments []Assignment, args []string) {
+	prefix := "+ "
+	if ps4, found := ctx.GetVar("PS4"); found {
+		prefix, _ = ctx.ExpandString(ps4)
+	}
+	words := []string{}
+	for _, assignment := range assignments {
+		words = append(words, assignment.Name+"="+traceQuote(assignment.Value))
+	}
+	for _, arg := range args {
+		words = append(words, traceQuote(arg))
+	}
+	fmt.Fprintf(ctx.Streams.Stderr, "%s%s\n", prefix, strings.Join(words, " "))
+}
+
+func traceQuote(word string) string {
+	if word != "" && ShellQuote(word) == word {
+		return word
+	}
+	return SingleQuote(word)
+}
+
+// expandArgs expands the words of a command. The assignments given to
+// declare and the like are expanded as they would be on their own, without
+// being split.
+func (ctx *ShellCtx) expandArgs(raws []string) ([]string, error) {
+	if len(raws) == 0 || !isDeclarationCommand(raws[0]) {
+		return ctx.ExpandWords(raws)
+	}
+	args := []string{}
+	for _, raw := range raws {
+		assignment, ok := ParseAssignmentWord(raw)
+		if !ok {
+			fields, err := ctx.ExpandWord(raw)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, fields...)
+			continue
+		}
+		arg, err := ctx.expandDeclarationArg(raw, assignment)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// ExecuteArgs runs an already expanded argv, either as a builtin or as an
+// external command found in PATH. Temporary assignments are visible to
+// builtins as shell variables; externals get them in their environment
+// unless an explicit env is given.
+func (ctx *ShellCtx) ExecuteArgs(args []string, assignments []Assignment, env []string, streams Streams) int {
+	name := args[0]
+	args = args[1:]
+
+	if def, found := ctx.Functions[name]; found {
+		return ctx.callFunction(def, args, assignments, streams)
+	}
+
+	executor, found := ctx.Builtins[name]
+	if found {
+		savedStreams := ctx.Streams
+		ctx.Streams = streams
+		defer ctx.restoreStreams(savedStreams)
+
+		var err error
+		ctx.Reset()
+		ctx.WithTempVars(assignments, func() {
+			err = executor(ctx, args)
+		})
+		if err != nil {
+			fmt.Printf("Failed execute command %s with args %s: %s\n", name, args, err.Error())
+			ctx.Status = 1
+		}
+		if streams.Stdout == nil && ctx.Sout != "" {
+			// Closed with >&-.
+			ctx.Serr += name + ": write error: Bad file descriptor\n"
+			ctx.Status = 1
+		}
+		// A reader that went away ends the builtin the way SIGPIPE would end
+		// an external command, without a copy error on top. A subshell, such
+		// as a pipeline stage looping over echo, goes down with it.
+		brokenPipe := false
+		if streams.Stdout != nil {
+			if _, err := io.WriteString(streams.Stdout, ctx.Sout); IsBrokenPipe(err) {
+				brokenPipe = true
+			} else if err != nil {
+				fmt.Printf("Failed to copy to stdout: %s", err.Error())
+			}
+		}
+		if streams.Stderr != nil {
+			if _, err := io.WriteString(streams.Stderr, ctx.Serr); IsBrokenPipe(err) {
+				brokenPipe = true
+			} else if err != nil {
+				fmt.Printf("Failed to copy to stderr: %s", err.Error())
+			}
+		}
+		if brokenPipe {
+			ctx.Status = StatusBrokenPipe
+			ctx.exiting = ctx.exiting || ctx.subshell
+		}
+		status := ctx.Status
+		ctx.Reset()
+		return status
+	}
+
+	execPath, found := ctx.Runner.LookPath(ctx, name)
+	if !found && ctx.Options["autocd"] && ctx.isDirectory(name) {
+		return ctx.ExecuteArgs(append([]string{"cd", "--", name}, args...), assignments, env, streams)
+	}
+	if !found {
+		if strings.Contains(name, "/") {
+			// A path is run as given, so what's wrong with it is reported.
+			if info, err := os.Stat(ctx.ResolvePath(name)); err == nil && info.IsDir() {
+				fmt.Fprintf(streams.Stderr, "%s: Is a directory\n", name)
+				return 126
+			}
+			fmt.Fprintf(streams.Stderr, "%s: No such file or directory\n", name)
+			return 127
+		}
+		fmt.Fprintf(streams.Stderr, "%s: command not found\n", name)
+		return 127
+	}
+	ctx.countHashHit(name)
+	if env == nil {
+		env = ctx.Environ(assignments...)
+	}
+	status, err := ctx.RunExternalCommand(execPath, name, args, env, streams)
+	if err != nil {
+		fmt.Fprintf(streams.Stderr, "%s: %s\n", name, describeOpenError(err))
+		// A command that was found but can't be run, like a file without
+		// execute permission, is told apart from one that doesn't exist,
+		// which is still possible when its interpreter doesn't.
+		status = 126
+		if errors.Is(err, syscall.ENOENT) {
+			status = 127
+		}
+	}
+	return status
+}
+
+func (ctx *ShellCtx) isDirectory(path string) bool {
+	info, err := os.Stat(ctx.ResolvePath(path))
+	return err == nil && info.IsDir()
+}
+
+func (ctx *ShellCtx) LookupExecutable(name string) (string, bool) {
+	if strings.Contains(name, "/") {
+		execPath := ctx.ResolvePath(name)
+		if info, err := os.Stat(execPath); err != nil || info.IsDir() {
+			return "", false
+		}
+		return execPath, true
+	}
+	if entry, found := ctx.PathCache[name]; found {
+		if info, err := os.Stat(entry.Path); err == nil && !info.IsDir() {
+			return entry.Path, true
+		}
+		delete(ctx.PathCache, name)
+	}
+	execPath, found := SearchExecInPathFolders(name, ctx.PathFolders)
+	if found && ctx.PathCache != nil {
+		ctx.PathCache[name] = HashEntry{Path: execPath}
+	}
+	return execPath, found
+}
+
+func (ctx *ShellCtx) applyRedirects(redirects []Redirect, streams *Streams) (func(), error) {
+	opened := []*os.File{}
+	closeAll := func() {
+		for _, file := range opened {
+			file.Close()
+		}
+	}
+
+	for _, redirect := range redirects {
+		target, err := ctx.ExpandString(redirect.Target)
+		if err != nil {
+			return closeAll, err
+		}
+		if redirect.Op == ">&" || redirect.Op == "<&" {
+			if target == "-" {
+				streams.set(redirect.Fd, nil)
+				continue
+			}
+			source, err := strconv.Atoi(target)
+			if err != nil || source < 0 {
+				return closeAll, fmt.Errorf("%s: ambiguous redirect", target)
+			}
+			file, open := streams.file(ctx, source)
+			if !open {
+				return closeAll, fmt.Errorf("%d: bad file descriptor", source)
+			}
+			streams.set(redirect.Fd, file)
+			continue
+		}
+		flags := os.O_TRUNC | os.O_WRONLY | os.O_CREATE
+		switch redirect.Op {
+		case "<":
+			flags = os.O_RDONLY
+		case ">>":
+			flags = os.O_APPEND | os.O_WRONLY | os.O_CREATE
+		}
+		file, err := os.OpenFile(ctx.ResolvePath(target), flags, 0644)
+		if err != nil {
+			return closeAll, fmt.Errorf("%s: %s", target, describeOpenError(err))
+		}
+		opened = append(opened, file)
+
+		streams.set(redirect.Fd, file)
+	}
+	return closeAll, nil
+}
+
+func describeOpenError(err error) string {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	switch {
+	case errors.Is(err, syscall.ENOENT):
+		return "No such file or directory"
+	case errors.Is(err, syscall.EACCES):
+		return "Permission denied"
+	case errors.Is(err, syscall.EISDIR):
+		return "Is a directory"
+	case errors.Is(err, syscall.ENOTDIR):
+		return "Not a directory"
+	case errors.Is(err, syscall.EPERM):
+		return "Operation not permitted"
+	case errors.Is(err, syscall.EINVAL):
+		return "Invalid argument"
+	case errors.Is(err, syscall.ENOEXEC):
+		return "cannot execute binary file: Exec format error"
+	}
+	return err.Error()
+}
+
+// isBinaryFile tells a binary, which has a NUL byte in its first line, from
+// a script. Only scripts are run by the shell when they can't be executed.
+func isBinaryFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	head := make([]byte, 80)
+	n, _ := file.Read(head)
+	line, _, _ := bytes.Cut(head[:n], []byte("\n"))
+	return bytes.IndexByte(line, 0) != -1
+}
+
+// startProcess starts the command, immune to hangups when run by nohup.
+func (ctx *ShellCtx) startProcess(spec *CommandSpec) (Process, error) {
+	var process Process
+	var err error
+	start := func() { process, err = ctx.Runner.Start(spec) }
+	if ctx.ignoreHangups {
+		ctx.Traps.startIgnoringHangups(start)
+	} else {
+		start()
+	}
+	return process, err
+}
+
+func (ctx *ShellCtx) RunExternalCommand(execPath string, name string, args []string, env []string, streams Streams) (int, error) {
+	spec := &CommandSpec{
+		Path:       execPath,
+		Args:       append([]string{name}, args...),
+		Env:        env,
+		Dir:        ctx.CurrentDir,
+		Stdin:      streams.Stdin,
+		Stdout:     streams.Stdout,
+		Stderr:     streams.Stderr,
+		Background: ctx.Background,
+		Group:      ctx.Group,
+		ExtraFiles: ctx.extraFiles(streams),
+	}
+	process, err := ctx.startProcess(spec)
+	if errors.Is(err, syscall.ENOEXEC) && !isBinaryFile(execPath) {
+		// A text file without a #! line is a script for the shell itself,
+		// which is run by a new instance of the shell, or by sh.
+		spec.Path = "/bin/sh"
+		if shell, err := os.Executable(); err == nil {
+			spec.Path = shell
+		}
+		// The script is named as it was given, or by the path it was found
+		// at along PATH, which is what it gets as $0.
+		script := execPath
+		if strings.Contains(name, "/") {
+			script = name
+		}
+		spec.Args = append([]string{spec.Path, script}, args...)
+		process, err = ctx.startProcess(spec)
+	}
+	if err != nil {
+		return 1, err
+	}
+	if ctx.OnProcessStart != nil {
+		ctx.OnProcessStart(process.Pid())
+	}
+	status, err := process.Wait()
+	if errors.Is(err, errProcessStopped) {
+		ctx.Group.stopped(ctx, process)
+		return status, nil
+	}
+	if ctx.OnProcessExit != nil {
+		ctx.OnProcessExit(process.Pid(), status)
+	}
+	if ctx.Group != nil && ctx.interrupt != nil && status == 128+int(syscall.SIGINT) {
+		// The terminal sent SIGINT to the command's process group only, and
+		// what the shell runs stops as if it got it too.
+		ctx.interrupt.Store(int32(syscall.SIGINT))
+	}
+	return status, err
+}
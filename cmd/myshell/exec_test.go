@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNegatedPipelineStatus(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"! true; echo $?", "1\n"},
+		{"! false; echo $?", "0\n"},
+		{"! true | false; echo $? ${PIPESTATUS[@]}", "0 0 1\n"},
+		{"! ! false; echo $?", "1\n"},
+		{"!; echo $?", "1\n"},
+		{"if ! false; then echo negated; fi", "negated\n"},
+		{"set -e; ! true; echo survived", "survived\n"},
+		{"trap 'echo trapped' ERR; ! true; echo $?", "1\n"},
+	})
+}
+
+func TestAssignmentPrefixes(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`x=1 sh -c 'echo $x'`, "1\n"},
+		{`a=1 b=2 sh -c 'echo $a$b'`, "12\n"},
+		{`x=1 x=2 sh -c 'echo $x'`, "2\n"},
+		{`x=1 true; echo "[$x]"`, "[]\n"},
+		{`x=outer; x=inner sh -c 'echo $x'; echo $x`, "inner\nouter\n"},
+		{`x=tmp eval 'echo $x'; echo "[$x]"`, "tmp\n[]\n"},
+		{`f() { echo $z; }; z=fn f; echo "[$z]"`, "fn\n[]\n"},
+		{`x=5; echo $x`, "5\n"},
+	})
+}
+
+func TestPipelineResult(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"false | true | (exit 4); echo ${PIPESTATUS[@]}", "1 0 4\n"},
+		{"false | true | (exit 4); echo ${#PIPETIME[@]}", "3\n"},
+		{"true; echo ${#PIPESTATUS[@]} ${#PIPETIME[@]}", "1 1\n"},
+		{"set -o pipefail; false | (exit 3) | true; echo $?", "3\n"},
+		{"time true | false; echo ${PIPESTATUS[@]}", "0 1\n"},
+	})
+}
+
+func TestPromptCommandKeepsLastPipeline(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	runShell(t, shellCtx, "PROMPT_COMMAND='true | true | true'; false | (exit 2)")
+	shellCtx.RunPromptCommand()
+	if output, _ := runShell(t, shellCtx, "echo $? ${PIPESTATUS[@]}"); output != "2 1 2\n" {
+		t.Errorf("after PROMPT_COMMAND: got %q", output)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	runShell(t, shellCtx, "MYSHELL_AUDIT_LOG=audit.log")
+	runShell(t, shellCtx, "true | false")
+	data, err := os.ReadFile(filepath.Join(shellCtx.CurrentDir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The assignment that set the log path is logged too.
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	fields := strings.Split(lines[len(lines)-1], "\t")
+	if len(fields) != 5 || fields[1] != "true | false" || fields[2] != "status=0,1" ||
+		!strings.HasPrefix(fields[3], "time=") || strings.Count(fields[3], ",") != 1 {
+		t.Errorf("audit log: got %q", data)
+	}
+}
+
+func TestBuiltinBrokenPipe(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"while true; do echo y; done | head -2; echo ${PIPESTATUS[@]}", "y\ny\n141 0\n"},
+		{"f() { while :; do printf 'x\\n'; done; }; f | head -1; echo ${PIPESTATUS[@]}", "x\n141 0\n"},
+		{"(while :; do echo z; done; echo never) | head -1; echo after", "z\nafter\n"},
+		{"for i in $(seq 1 20000); do echo $i; done | head -1; echo ${PIPESTATUS[@]}", "1\n141 0\n"},
+		{"printf '%s\\n' $(seq 1 50000) | head -1; echo ${PIPESTATUS[@]}", "1\n141 0\n"},
+	})
+}
+
+func TestAutocd(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`mkdir sub; T=$(pwd); shopt -s autocd; sub; [ "$PWD" = "$T/sub" ] && echo in`, "in\n"},
+		{`mkdir -p a/b; T=$(pwd); shopt -s autocd; a/b; ..; [ "$PWD" = "$T/a" ] && echo up`, "up\n"},
+		{"mkdir sub; sub; echo $?", "127\n"},
+		{"mkdir sub; shopt -s autocd; shopt -u autocd; sub; echo $?", "127\n"},
+		{"shopt -s autocd; missing; echo $?", "127\n"},
+		{"mkdir echo; shopt -s autocd; echo hi", "hi\n"},
+		{"mkdir sub; shopt -s autocd; sub extra; echo $?", "1\n"},
+	})
+}
+
+func TestEval(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"eval echo hi", "hi\n"},
+		{"eval 'x=1; y=2'; echo $x$y", "12\n"},
+		{`cmd='echo a; echo b'; eval "$cmd"`, "a\nb\n"},
+		{`v=x; eval "$v=set"; echo $x`, "set\n"},
+		{`eval 'echo $1' second; set -- first; eval 'echo $1'`, "second\nfirst\n"},
+		{"eval 'f() { echo fn; }'; f", "fn\n"},
+		{"eval; echo $?", "0\n"},
+		{"(eval 'exit 5; echo no'; echo no); echo $?", "5\n"},
+		{"eval '(exit 3)'; echo $?", "3\n"},
+		{"eval 'if'; echo $?", "2\n"},
+		{"eval echo '$(echo nested)'", "nested\n"},
+		{"for i in 1 2; do eval 'echo $i; [ $i = 1 ] && continue'; echo after; done", "1\n2\nafter\n"},
+	})
+}
+
+func TestCommandNotRunnable(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"nosuch; echo $?", "127\n"},
+		{"nosuch 2>&1", "nosuch: command not found\n"},
+		{"nosuch 2>/dev/null; echo $?", "127\n"},
+		{"./nosuch 2>&1; echo $?", "./nosuch: No such file or directory\n127\n"},
+		{"touch plain; ./plain 2>&1; echo $?", "./plain: Permission denied\n126\n"},
+		{"mkdir dir; ./dir 2>&1; echo $?", "./dir: Is a directory\n126\n"},
+		{`printf '\177ELF\000binary' > bin; chmod +x bin; ./bin 2>&1; echo $?`, "./bin: cannot execute binary file: Exec format error\n126\n"},
+		{`printf '#!/nonexistent/interp\n' > bad; chmod +x bad; ./bad 2>/dev/null; echo $?`, "127\n"},
+	})
+}
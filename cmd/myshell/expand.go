@@ -0,0 +1,564 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const defaultIFS = " \t\n"
+
+type expander struct {
+	ctx   *ShellCtx
+	split bool
+	// assignment enables tilde expansion after every ':' as well, so that
+	// PATH=~/bin:~/go/bin works.
+	assignment bool
+	ifs        string
+	fields     []string
+	field      strings.Builder
+	// pattern mirrors field with quoted glob characters escaped, so that
+	// only unquoted *, ? and [ take part in pathname expansion.
+	pattern strings.Builder
+	// regex makes pattern a regular expression instead, with the quoted
+	// text escaped for regexp.
+	regex   bool
+	hasMeta bool
+	// hasField is set as soon as the current field exists, even when it is
+	// still empty: "" must produce an empty argument, unquoted $EMPTY none.
+	hasField bool
+	err      error
+}
+
+func (ctx *ShellCtx) newExpander(split bool) *expander {
+	ifs, found := ctx.GetVar("IFS")
+	if !found {
+		ifs = defaultIFS
+	}
+	return &expander{ctx: ctx, split: split, ifs: ifs}
+}
+
+// ExpandWords expands raw words into the final list of arguments: parameters
+// are substituted, unquoted results are split on IFS and quotes are removed.
+func (ctx *ShellCtx) ExpandWords(raws []string) ([]string, error) {
+	fields := []string{}
+	for _, raw := range raws {
+		expanded, err := ctx.ExpandWord(raw)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, expanded...)
+	}
+	return fields, nil
+}
+
+func (ctx *ShellCtx) ExpandWord(raw string) ([]string, error) {
+	e := ctx.newExpander(true)
+	e.expand(raw)
+	e.endField()
+	return e.fields, e.err
+}
+
+// ExpandString expands a raw word without field splitting, as is done for
+// redirection targets.
+func (ctx *ShellCtx) ExpandString(raw string) (string, error) {
+	e := ctx.newExpander(false)
+	e.expand(raw)
+	e.endField()
+	return strings.Join(e.fields, " "), e.err
+}
+
+// ExpandPattern expands a raw word into a pattern for MatchPattern, with
+// the glob characters that were quoted escaped so they match literally.
+func (ctx *ShellCtx) ExpandPattern(raw string) (string, error) {
+	e := ctx.newExpander(false)
+	e.expand(raw)
+	return e.pattern.String(), e.err
+}
+
+// ExpandRegex expands the right side of =~ into a regular expression in
+// which only the unquoted characters are special.
+func (ctx *ShellCtx) ExpandRegex(raw string) (string, error) {
+	e := ctx.newExpander(false)
+	e.regex = true
+	e.expand(raw)
+	return e.pattern.String(), e.err
+}
+
+func (ctx *ShellCtx) ExpandAssignmentValue(raw string) (string, error) {
+	e := ctx.newExpander(false)
+	e.assignment = true
+	e.expand(raw)
+	e.endField()
+	return strings.Join(e.fields, " "), e.err
+}
+
+// ExpandUntrusted parses a command line and expands the words of each of its
+// commands in safe mode, without running anything, for callers that want to
+// evaluate input they don't trust. Aliases don't apply, and command
+// substitution makes it fail instead of executing. The words are expanded
+// in a copy of the shell, so that what arithmetic like $((x++)) assigns
+// doesn't stay.
+func (ctx *ShellCtx) ExpandUntrusted(line string) ([][]string, error) {
+	list, err := ParseCommandList(line, nil)
+	if err != nil {
+		return nil, err
+	}
+	sub := ctx.Clone()
+	defer sub.leaveSubshell()
+	sub.SafeMode = true
+
+	commands := [][]string{}
+	for _, item := range list.Items {
+		for _, pipeline := range item.AndOr.Pipelines {
+			for _, command := range pipeline.Commands {
+				simple, ok := command.(*SimpleCommand)
+				if !ok {
+					return nil, fmt.Errorf("compound commands are not allowed in untrusted input")
+				}
+				words, err := sub.ExpandWords(simple.Words)
+				if err != nil {
+					return nil, err
+				}
+				commands = append(commands, words)
+			}
+		}
+	}
+	return commands, nil
+}
+
+// fail records the first error hit while expanding; the rest of the word is
+// still processed but its result won't be used.
+func (e *expander) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *expander) addLiteral(s string) {
+	e.field.WriteString(s)
+	e.hasField = true
+	if e.regex {
+		e.pattern.WriteString(regexp.QuoteMeta(s))
+		return
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte("*?[\\", s[i]) != -1 {
+			e.pattern.WriteByte('\\')
+		}
+		e.pattern.WriteByte(s[i])
+	}
+}
+
+func (e *expander) addUnquoted(s string) {
+	e.field.WriteString(s)
+	e.pattern.WriteString(s)
+	if strings.ContainsAny(s, "*?[") {
+		e.hasMeta = true
+	}
+	e.hasField = true
+}
+
+func (e *expander) endField() {
+	if e.hasField {
+		matches := []string{}
+		globbed := e.split && e.hasMeta && HasGlobMeta(e.pattern.String())
+		if globbed {
+			matches = e.ctx.Glob(e.pattern.String())
+		}
+		switch {
+		case len(matches) > 0:
+			e.fields = append(e.fields, matches...)
+		case globbed && e.ctx.Options["nullglob"]:
+			// The pattern matched nothing and goes away.
+		default:
+			e.fields = append(e.fields, e.field.String())
+		}
+	}
+	e.field.Reset()
+	e.pattern.Reset()
+	e.hasField = false
+	e.hasMeta = false
+}
+
+// addSplittable appends the result of an unquoted expansion, breaking it
+// into fields on IFS characters. Runs of IFS whitespace count as a single
+// separator, any other IFS character delimits exactly one field.
+func (e *expander) addSplittable(s string) {
+	if !e.split || len(e.ifs) == 0 {
+		if len(s) > 0 {
+			e.addUnquoted(s)
+		}
+		return
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if strings.IndexByte(e.ifs, c) == -1 {
+			e.addUnquoted(s[i : i+1])
+			continue
+		}
+		if strings.IndexByte(defaultIFS, c) == -1 {
+			e.hasField = true
+			e.endField()
+			continue
+		}
+		e.endField()
+	}
+}
+
+func (e *expander) expand(raw string) {
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch c {
+		case '\\':
+			if i+1 < len(raw) {
+				i++
+				e.addLiteral(raw[i : i+1])
+			}
+		case '\'':
+			end := strings.IndexByte(raw[i+1:], '\'')
+			if end == -1 {
+				end = len(raw) - i - 1
+			}
+			e.addLiteral(raw[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			end := findClosingDoubleQuote(raw, i+1)
+			if end == -1 {
+				end = len(raw)
+			}
+			e.expandDoubleQuoted(raw[i+1 : end])
+			i = end
+		case '$':
+			i = e.expandDollar(raw, i, false)
+		case '`':
+			i = e.expandBackquoted(raw, i, false)
+		case '~':
+			if i == 0 || (e.assignment && raw[i-1] == ':') {
+				if dir, length, ok := e.ctx.tildePrefix(raw, i); ok {
+					e.addLiteral(dir)
+					i += length - 1
+					continue
+				}
+			}
+			e.addUnquoted(raw[i : i+1])
+		default:
+			e.addUnquoted(raw[i : i+1])
+		}
+	}
+}
+
+func (e *expander) expandDoubleQuoted(s string) {
+	empty := len(s) == 0
+	setBefore := e.hasField
+	onlyEmptyAt := !empty
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] == '\n':
+			i++
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\", s[i+1]) != -1:
+			i++
+			e.addLiteral(s[i : i+1])
+			onlyEmptyAt = false
+		case c == '$':
+			next := e.expandDollar(s, i, true)
+			name := s[i+1 : next+1]
+			if !(name == "@" && len(e.ctx.PositionalArgs) == 0) {
+				onlyEmptyAt = false
+			}
+			i = next
+		case c == '`':
+			i = e.expandBackquoted(s, i, true)
+			onlyEmptyAt = false
+		default:
+			e.addLiteral(s[i : i+1])
+			onlyEmptyAt = false
+		}
+	}
+	// "$@" with no positional parameters vanishes entirely instead of
+	// producing an empty argument.
+	if onlyEmptyAt {
+		e.hasField = setBefore
+	} else {
+		e.hasField = true
+	}
+}
+
+// addDollar adds a $ that starts no expansion. Unquoted in a regex it is an
+// anchor, as in [[ $x =~ ^a$ ]].
+func (e *expander) addDollar(quoted bool) {
+	if quoted {
+		e.addLiteral("$")
+	} else {
+		e.addUnquoted("$")
+	}
+}
+
+// expandDollar expands the parameter starting at s[i] == '$' and returns the
+// index of the last byte it consumed.
+func (e *expander) expandDollar(s string, i int, quoted bool) int {
+	if i+1 >= len(s) {
+		e.addDollar(quoted)
+		return i
+	}
+
+	name := ""
+	end := i + 1
+	c := s[i+1]
+	switch {
+	case c == '(':
+		closing := findClosingParen(s, i+2)
+		if closing == -1 {
+			e.addLiteral(s[i:])
+			return len(s) - 1
+		}
+		// $((...)) is arithmetic when its two opening parentheses are closed
+		// together, unlike in $((cmd) | cmd).
+		if i+2 < len(s) && s[i+2] == '(' && findClosingParen(s, i+3) == closing-1 {
+			e.substituteArithmetic(s[i+3:closing-1], quoted)
+		} else {
+			e.substituteCommand(s[i+2:closing], quoted)
+		}
+		return closing
+	case c == '{':
+		closing := findClosingBrace(s, i+2)
+		if closing == -1 {
+			e.addLiteral(s[i:])
+			return len(s) - 1
+		}
+		e.expandParameter(s[i+2:closing], quoted)
+		return closing
+	case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		end = i + 2
+		for end < len(s) && isNameByte(s[end]) {
+			end++
+		}
+		name = s[i+1 : end]
+		end--
+	case (c >= '0' && c <= '9') || strings.IndexByte("?$!#@*-", c) != -1:
+		name = string(c)
+	default:
+		e.addDollar(quoted)
+		return i
+	}
+
+	if name == "@" || name == "*" {
+		e.expandPositional(name, quoted)
+		return end
+	}
+	e.addExpansion(e.lookup(name), quoted)
+	return end
+}
+
+// expandParameter expands what is between ${ and }: a parameter, an array
+// element as in ${arr[1]}, all elements with [@] or [*], or with a leading
+// # the length of any of those.
+func (e *expander) expandParameter(expr string, quoted bool) {
+	length := len(expr) > 1 && expr[0] == '#'
+	if length {
+		expr = expr[1:]
+	}
+	name, subscript, hasSubscript := splitSubscript(expr)
+	value := ""
+	switch {
+	case !length && strings.HasPrefix(expr, "!"):
+		// ${!name[@]} lists the keys of an array.
+		name, subscript, hasSubscript = splitSubscript(expr[1:])
+		if !hasSubscript || (subscript != "@" && subscript != "*") {
+			e.fail(fmt.Errorf("${%s}: bad substitution", expr))
+			return
+		}
+		e.expandList(e.ctx.ArrayKeys(name), subscript, quoted)
+		return
+	case hasSubscript && (subscript == "@" || subscript == "*"):
+		values := e.ctx.ArrayValues(name)
+		if !length {
+			e.expandList(values, subscript, quoted)
+			return
+		}
+		value = strconv.Itoa(len(values))
+		length = false
+	case hasSubscript:
+		var found bool
+		var err error
+		if value, found, err = e.ctx.GetElement(name, subscript); err != nil {
+			e.fail(err)
+			return
+		}
+		if !found && e.ctx.Options["nounset"] {
+			e.fail(e.ctx.unboundVariable(expr))
+		}
+	case length && (expr == "@" || expr == "*"):
+		value, _ = e.ctx.LookupParam("#")
+		length = false
+	case expr == "@" || expr == "*":
+		e.expandPositional(expr, quoted)
+		return
+	default:
+		value = e.lookup(expr)
+	}
+	if length {
+		value = strconv.Itoa(utf8.RuneCountInString(value))
+	}
+	e.addExpansion(value, quoted)
+}
+
+// lookup resolves a parameter, which under set -u must be set.
+func (e *expander) lookup(name string) string {
+	value, found := e.ctx.LookupParam(name)
+	if !found && e.ctx.Options["nounset"] {
+		e.fail(e.ctx.unboundVariable(name))
+	}
+	return value
+}
+
+// addExpansion adds the result of an expansion, which is only split when it
+// wasn't quoted.
+func (e *expander) addExpansion(value string, quoted bool) {
+	if quoted {
+		e.addLiteral(value)
+	} else {
+		e.addSplittable(value)
+	}
+}
+
+// expandBackquoted handles the old `command` form of command substitution,
+// where a backslash only escapes $, ` and another backslash.
+func (e *expander) expandBackquoted(s string, i int, quoted bool) int {
+	end := findClosingBackquote(s, i+1)
+	if end == -1 {
+		end = len(s)
+	}
+	command := strings.Builder{}
+	for j := i + 1; j < end; j++ {
+		if s[j] == '\\' && j+1 < end && strings.IndexByte("$`\\", s[j+1]) != -1 {
+			j++
+		}
+		command.WriteByte(s[j])
+	}
+	e.substituteCommand(command.String(), quoted)
+	return end
+}
+
+func (e *expander) substituteCommand(command string, quoted bool) {
+	if e.ctx.SafeMode {
+		e.fail(fmt.Errorf("command substitution is disabled in safe mode"))
+		return
+	}
+	e.addExpansion(e.ctx.CommandSubstitution(command), quoted)
+}
+
+func (e *expander) substituteArithmetic(expr string, quoted bool) {
+	value, err := e.ctx.EvalArithmetic(expr)
+	if err != nil {
+		e.fail(err)
+		return
+	}
+	e.addExpansion(strconv.FormatInt(value, 10), quoted)
+}
+
+func (e *expander) expandPositional(name string, quoted bool) {
+	e.expandList(e.ctx.PositionalArgs, name, quoted)
+}
+
+// expandList expands a list of values the way $@ and $* are expanded,
+// according to name: quoted, @ gives one field per value and * joins them
+// with the first character of IFS.
+func (e *expander) expandList(args []string, name string, quoted bool) {
+	if !quoted {
+		for i, arg := range args {
+			if i > 0 && e.split {
+				e.endField()
+			} else if i > 0 {
+				e.addSplittable(" ")
+			}
+			e.addSplittable(arg)
+		}
+		return
+	}
+	if name == "*" || !e.split {
+		separator := " "
+		if name == "*" {
+			separator = ""
+			if len(e.ifs) > 0 {
+				separator = e.ifs[:1]
+			}
+		}
+		e.addLiteral(strings.Join(args, separator))
+		return
+	}
+	for i, arg := range args {
+		if i > 0 {
+			e.hasField = true
+			e.endField()
+		}
+		e.addLiteral(arg)
+	}
+}
+
+func findClosingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// unboundVariable is the error for expanding name while it is unset under
+// set -u. A shell that isn't interactive doesn't go on after it.
+func (ctx *ShellCtx) unboundVariable(name string) error {
+	if !ctx.Interactive {
+		ctx.exiting = true
+	}
+	return fmt.Errorf("%s: unbound variable", name)
+}
+
+// LookupParam resolves a parameter name: a special parameter, a positional
+// parameter or a shell variable.
+func (ctx *ShellCtx) LookupParam(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(ctx.LastStatus), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		if ctx.LastBackgroundPid == 0 {
+			return "", false
+		}
+		return strconv.Itoa(ctx.LastBackgroundPid), true
+	case "#":
+		return strconv.Itoa(len(ctx.PositionalArgs)), true
+	case "0":
+		return ctx.ShellName, true
+	case "-":
+		return ctx.OptionFlags(), true
+	case "@", "*":
+		return strings.Join(ctx.PositionalArgs, " "), true
+	}
+	if isAllDigits(name) {
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 1 || index > len(ctx.PositionalArgs) {
+			return "", false
+		}
+		return ctx.PositionalArgs[index-1], true
+	}
+	return ctx.GetVar(name)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExpandUntrusted(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"echo $x", "echo 1", true},
+		{"echo $((x + 1))", "echo 2", true},
+		{"echo $((x++)) $((x = 5)) $x", "echo 1 5 5", true},
+		{"echo $((y = 2)) $((x += y))", "echo 2 3", true},
+		{"echo $(touch file)", "", false},
+		{"if true; then echo; fi", "", false},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.CurrentDir = t.TempDir()
+		shellCtx.SetVar("x", "1")
+		commands, err := shellCtx.ExpandUntrusted(test.line)
+		if (err == nil) != test.ok {
+			t.Errorf("ExpandUntrusted(%q): %v", test.line, err)
+			continue
+		}
+		if err == nil && strings.Join(commands[0], " ") != test.want {
+			t.Errorf("ExpandUntrusted(%q) = %q, want %q", test.line, commands, test.want)
+		}
+		if x, _ := shellCtx.GetVar("x"); x != "1" {
+			t.Errorf("ExpandUntrusted(%q) left x=%s", test.line, x)
+		}
+		if _, found := shellCtx.GetVar("y"); found {
+			t.Errorf("ExpandUntrusted(%q) left y set", test.line)
+		}
+		if shellCtx.SafeMode {
+			t.Errorf("ExpandUntrusted(%q) left safe mode on", test.line)
+		}
+	}
+	shellCtx := NewShellCtx()
+	commands, _ := shellCtx.ExpandUntrusted("echo a; echo b | cat")
+	if got := len(commands); got != 3 || !slices.Equal(commands[2], []string{"cat"}) {
+		t.Errorf("commands: got %q", commands)
+	}
+}
+
+func TestSpecialParameters(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"echo $#", "0\n"},
+		{`set -- a "b c" d; echo $# $1 $3`, "3 a d\n"},
+		{`set -- a "b c"; for x in "$@"; do echo "<$x>"; done`, "<a>\n<b c>\n"},
+		{`set -- a "b c"; for x in "$*"; do echo "<$x>"; done`, "<a b c>\n"},
+		{`set -- a "b c"; for x in $@; do echo "<$x>"; done`, "<a>\n<b>\n<c>\n"},
+		{`set -- a b; IFS=,; echo "$*"`, "a,b\n"},
+		{`set --; for x in "$@"; do echo "<$x>"; done; echo done`, "done\n"},
+		{`f() { echo $# $1; }; set -- x y; f z; echo $1`, "1 z\nx\n"},
+		{"[ $$ -gt 0 ] && echo pid", "pid\n"},
+		{"(echo $$) | grep -qx $$ && echo same", "same\n"},
+		{"sleep 0 & [ $! -gt 0 ] && echo bg", "bg\n"},
+	})
+
+	shellCtx := NewShellCtx()
+	shellCtx.ShellName = "myshell"
+	if got, _ := runShell(t, shellCtx, "echo $0"); got != "myshell\n" {
+		t.Errorf("$0: got %q", got)
+	}
+}
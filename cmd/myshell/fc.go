@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FcExecutor implements `fc -l [-nr] [first [last]]` and `fc [-e editor]
+// [first [last]]`. first and last are history numbers, negative offsets
+// from the end or the start of a command. With -l the commands are listed,
+// the last 16 by default, without their numbers with -n and newest first
+// with -r. Otherwise they are opened in the editor, the previous command by
+// default, and what is saved is run as the replacement of the fc command in
+// the history. An editor of - runs the commands again without editing.
+func FcExecutor(shellCtx *ShellCtx, args []string) error {
+	list, numbered, reversed := false, true, false
+	editor := ""
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if _, err := strconv.Atoi(args[0]); err == nil {
+			break
+		}
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for i := 1; i < len(option); i++ {
+			switch option[i] {
+			case 'l':
+				list = true
+			case 'n':
+				numbered = false
+			case 'r':
+				reversed = true
+			case 'e':
+				if len(args) == 0 {
+					return fmt.Errorf("fc command -e requires an editor")
+				}
+				editor, args = args[0], args[1:]
+			default:
+				return fmt.Errorf("fc command got invalid option -%c", option[i])
+			}
+		}
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("fc command takes at most first and last")
+	}
+
+	history := shellCtx.History
+	// At the prompt the fc command is already the newest entry, and not one
+	// it works on.
+	entries := history.Entries
+	if shellCtx.Interactive && len(entries) > 0 {
+		entries = entries[:len(entries)-1]
+	}
+	first, last := len(entries)-1, len(entries)-1
+	if list {
+		first = max(len(entries)-16, 0)
+	}
+	var ok bool
+	if len(args) > 0 {
+		if first, ok = findFcEntry(history, entries, args[0]); !ok {
+			shellCtx.Serr = "fc: history specification out of range\n"
+			shellCtx.Status = 1
+			return nil
+		}
+		if !list {
+			last = first
+		}
+	}
+	if len(args) > 1 {
+		if last, ok = findFcEntry(history, entries, args[1]); !ok {
+			shellCtx.Serr = "fc: history specification out of range\n"
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+	if last < 0 {
+		// An empty history lists as nothing.
+		if list {
+			return nil
+		}
+		shellCtx.Serr = "fc: no command found\n"
+		shellCtx.Status = 1
+		return nil
+	}
+	if first > last {
+		first, last = last, first
+		reversed = !reversed
+	}
+
+	if list {
+		for n := 0; n <= last-first; n++ {
+			i := first + n
+			if reversed {
+				i = last - n
+			}
+			if numbered {
+				shellCtx.Sout += fmt.Sprintf("%d\t %s\n", history.Base+i+1, entries[i])
+			} else {
+				shellCtx.Sout += fmt.Sprintf("\t %s\n", entries[i])
+			}
+		}
+		return nil
+	}
+
+	commands := append([]string{}, entries[first:last+1]...)
+	if reversed {
+		for i, j := 0, len(commands)-1; i < j; i, j = i+1, j-1 {
+			commands[i], commands[j] = commands[j], commands[i]
+		}
+	}
+	if editor == "" {
+		editor = shellCtx.defaultEditor("FCEDIT")
+	}
+	edited := strings.Join(commands, "\n") + "\n"
+	if editor != "-" {
+		status := 0
+		if edited, status = shellCtx.editText(edited, editor); status != 0 {
+			shellCtx.Status = status
+			return nil
+		}
+	}
+	if len(entries) < len(history.Entries) {
+		history.Delete(len(entries), len(entries))
+	}
+	if strings.TrimSpace(edited) == "" {
+		return nil
+	}
+	fmt.Fprint(shellCtx.Streams.Stderr, edited)
+	history.Add(edited)
+	shellCtx.Status = shellCtx.RunLine(edited)
+	return nil
+}
+
+// findFcEntry finds the entry a first or last argument of fc names, with the
+// out of range numbers of -l clamped to the history.
+func findFcEntry(history *History, entries []string, spec string) (int, bool) {
+	if number, err := strconv.Atoi(spec); err == nil {
+		index := number - 1 - history.Base
+		if number <= 0 {
+			index = len(entries) + number
+		}
+		return min(max(index, 0), len(entries)-1), len(entries) > 0
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i], spec) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// defaultEditor is the editor commands are edited with: the variables
+// given, then $VISUAL and $EDITOR, and vi when none is set.
+func (ctx *ShellCtx) defaultEditor(names ...string) string {
+	for _, variable := range append(names, "VISUAL", "EDITOR") {
+		if editor, _ := ctx.GetVar(variable); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// editText lets the user edit text in a temporary file with editor, a
+// command line the file name is added to, and returns what was saved with
+// the status the editor exited with.
+func (ctx *ShellCtx) editText(text string, editor string) (string, int) {
+	file, err := os.CreateTemp("", "myshell-edit-*.sh")
+	if err != nil {
+		fmt.Fprintf(ctx.Streams.Stderr, "myshell: cannot create temp file: %s\n", describeOpenError(err))
+		return "", 1
+	}
+	path := file.Name()
+	defer os.Remove(path)
+	_, err = file.WriteString(text)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(ctx.Streams.Stderr, "myshell: %s: %s\n", path, describeOpenError(err))
+		return "", 1
+	}
+	if status := ctx.RunLine(editor + " " + SingleQuote(path)); status != 0 {
+		return "", status
+	}
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(ctx.Streams.Stderr, "myshell: %s: %s\n", path, describeOpenError(err))
+		return "", 1
+	}
+	return string(edited), 0
+}
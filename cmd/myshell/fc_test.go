@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestFc(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"fc -l", "1\t echo one\n2\t echo two\n3\t echo three\n"},
+		{"fc -l 2", "2\t echo two\n3\t echo three\n"},
+		{"fc -l -1", "3\t echo three\n"},
+		{"fc -l 'echo t'", "3\t echo three\n"},
+		{"fc -ln 1 2", "\t echo one\n\t echo two\n"},
+		{"fc -lr", "3\t echo three\n2\t echo two\n1\t echo one\n"},
+		{"fc -l 3 2", "3\t echo three\n2\t echo two\n"},
+		{"fc -l 0 99", "3\t echo three\n"},
+		{"fc -l nosuch; echo $?", "1\n"},
+		{"fc -e -", "three\n"},
+		{"fc -e - 1; fc -l -1", "one\n4\t echo one\n"},
+		{"fc -e - 1 2", "one\ntwo\n"},
+		{"fc -e - 2 1", "two\none\n"},
+		{"FCEDIT='sed -i s/three/four/'; fc", "four\n"},
+		{"EDITOR='sed -i s/t/T/'; fc 2", "Two\n"},
+		{"fc -e 'sed -i d'; echo $?; fc -l -1", "0\n3\t echo three\n"},
+		{"fc -e false; echo $?", "1\n"},
+		{"fc -q; echo $?", "1\n"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.CurrentDir = t.TempDir()
+		for _, entry := range []string{"echo one", "echo two", "echo three"} {
+			shellCtx.History.Add(entry)
+		}
+		if got, _ := runShell(t, shellCtx, test.line); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestFcAtPrompt(t *testing.T) {
+	// At the prompt the fc command is in the history already: it isn't one
+	// of the commands, and its entry is replaced by the one run.
+	shellCtx := NewShellCtx()
+	shellCtx.Interactive = true
+	for _, entry := range []string{"echo one", "echo two", "fc -e -"} {
+		shellCtx.History.Add(entry)
+	}
+	if got, _ := runShell(t, shellCtx, "fc -e -"); got != "two\n" {
+		t.Errorf("got %q", got)
+	}
+	if got := shellCtx.History.Entries; len(got) != 3 || got[2] != "echo two" {
+		t.Errorf("history: got %q", got)
+	}
+
+	empty := NewShellCtx()
+	empty.Interactive = true
+	empty.History.Add("fc -l")
+	if got, status := runShell(t, empty, "fc -l"); got != "" || status != 0 {
+		t.Errorf("empty history: got %q, %d", got, status)
+	}
+	if got, _ := runShell(t, empty, "fc -e -; echo $?"); got != "1\n" {
+		t.Errorf("empty history: got %q", got)
+	}
+}
+
+func TestDefaultEditor(t *testing.T) {
+	tests := []struct {
+		vars []string
+		want string
+	}{
+		{nil, "vi"},
+		{[]string{"EDITOR", "nano"}, "nano"},
+		{[]string{"EDITOR", "nano", "VISUAL", "code -w"}, "code -w"},
+		{[]string{"EDITOR", "nano", "FCEDIT", "ed"}, "ed"},
+		{[]string{"EDITOR", "", "VISUAL", ""}, "vi"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.UnsetVar("FCEDIT")
+		shellCtx.UnsetVar("EDITOR")
+		shellCtx.UnsetVar("VISUAL")
+		for i := 0; i < len(test.vars); i += 2 {
+			shellCtx.SetVar(test.vars[i], test.vars[i+1])
+		}
+		if got := shellCtx.defaultEditor("FCEDIT"); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.vars, got, test.want)
+		}
+	}
+}
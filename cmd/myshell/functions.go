@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FunctionDef is `name() compound-command` or `function name
+// compound-command`. Running it only defines the function; the body, with
+// its redirections, runs each time the function is called.
+type FunctionDef struct {
+	Name   string
+	Body   Command
+	Source string
+}
+
+func (def *FunctionDef) commandSource() string {
+	return def.Source
+}
+
+// isFunctionDefinition reports whether the tokens ahead read `name ()`.
+func (p *parser) isFunctionDefinition() bool {
+	return p.pos+2 < len(p.tokens) &&
+		p.tokens[p.pos+1].Kind == TokenLParen && p.tokens[p.pos+2].Kind == TokenRParen
+}
+
+func (p *parser) parseFunction() (*FunctionDef, error) {
+	from := p.pos
+	token, _ := p.peek()
+	keyword := token.Value == "function" && !p.isFunctionDefinition()
+	if keyword {
+		p.pos++
+		var ok bool
+		if token, ok = p.peek(); !ok {
+			return nil, incomplete("syntax error: unexpected end of file")
+		}
+	}
+	if token.Kind != TokenWord {
+		return nil, unexpectedToken(token)
+	}
+	if !isFunctionName(token.Value) {
+		return nil, fmt.Errorf("`%s': not a valid identifier", token.Value)
+	}
+	p.pos++
+	// The parentheses are optional after the function keyword.
+	if p.isParens() {
+		p.pos += 2
+	} else if !keyword {
+		next, _ := p.peek()
+		return nil, unexpectedToken(next)
+	}
+
+	if !p.skipNewlines() {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	start, _ := p.peek()
+	body, err := p.parseCommand()
+	if err != nil {
+		return nil, err
+	}
+	if _, simple := body.(*SimpleCommand); simple {
+		return nil, unexpectedToken(start)
+	}
+	return &FunctionDef{Name: token.Value, Body: body, Source: p.source(from, p.pos)}, nil
+}
+
+func (p *parser) isParens() bool {
+	return p.pos+1 < len(p.tokens) &&
+		p.tokens[p.pos].Kind == TokenLParen && p.tokens[p.pos+1].Kind == TokenRParen
+}
+
+// isFunctionName accepts what bash does outside of POSIX mode: any word
+// without quoting or expansions that isn't a reserved word or an
+// assignment.
+func isFunctionName(word string) bool {
+	return len(word) > 0 && !reservedWords[word] && !isAllDigits(word) &&
+		!strings.ContainsAny(word, "$`'\"\\=")
+}
+
+// callFunction runs a function with its own positional parameters. Loops
+// of the caller can't be left with break or continue from inside it.
+func (ctx *ShellCtx) callFunction(def *FunctionDef, args []string, assignments []Assignment, streams Streams) int {
+	savedArgs, savedLoopDepth := ctx.PositionalArgs, ctx.loopDepth
+	ctx.PositionalArgs = args
+	ctx.loopDepth = 0
+	ctx.functionDepth++
+	defer func() {
+		ctx.PositionalArgs, ctx.loopDepth = savedArgs, savedLoopDepth
+		ctx.functionDepth--
+		ctx.returning = false
+	}()
+
+	status := 0
+	ctx.WithTempVars(assignments, func() {
+		ctx.localScopes = append(ctx.localScopes, map[string]*Variable{})
+		defer ctx.leaveLocalScope()
+		status = ctx.ExecuteCommand(def.Body, streams)
+	})
+	return status
+}
+
+// leaveLocalScope puts back the variables that the locals of the returning
+// function shadowed.
+func (ctx *ShellCtx) leaveLocalScope() {
+	scope := ctx.localScopes[len(ctx.localScopes)-1]
+	ctx.localScopes = ctx.localScopes[:len(ctx.localScopes)-1]
+	for name, saved := range scope {
+		if saved == nil {
+			delete(ctx.Vars, name)
+		} else {
+			ctx.Vars[name] = saved
+		}
+		ctx.varChanged(name)
+	}
+}
+
+// LocalExecutor implements `local [-aAix] [name[=value] ...]`. A local variable
+// starts out unset unless given a value, and functions called from this
+// one see it as well, as with bash's dynamic scoping.
+func LocalExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(shellCtx.localScopes) == 0 {
+		shellCtx.Serr = "local: can only be used in a function\n"
+		shellCtx.Status = 1
+		return nil
+	}
+	scope := shellCtx.localScopes[len(shellCtx.localScopes)-1]
+	if len(args) == 0 {
+		names := make([]string, 0, len(scope))
+		for name := range scope {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if variable, found := shellCtx.Vars[name]; found {
+				shellCtx.Sout += fmt.Sprintf("%s=%s\n", name, formatValue(variable))
+			}
+		}
+		return nil
+	}
+
+	return declareVariables(shellCtx, "local", args)
+}
+
+// ReturnExecutor implements `return [n]`, leaving the current function or
+// sourced file with status n, or with the last status when n is omitted.
+func ReturnExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("return command takes at most 1 argument of type int")
+	}
+	if shellCtx.functionDepth == 0 && shellCtx.sourceDepth == 0 {
+		shellCtx.Serr = "return: can only `return' from a function or sourced script\n"
+		shellCtx.Status = 1
+		return nil
+	}
+	status := shellCtx.LastStatus
+	if len(args) == 1 {
+		code, err := strconv.Atoi(args[0])
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("return: %s: numeric argument required\n", args[0])
+			code = 2
+		}
+		status = code & 0xff
+	}
+	shellCtx.Status = status
+	shellCtx.returning = true
+	return nil
+}
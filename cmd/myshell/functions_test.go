@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestFunctions(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`f() { echo "f:$1:$#"; }; f a b`, "f:a:2\n"},
+		{"function g { echo g; }; g", "g\n"},
+		{"function g() { echo g; }; g", "g\n"},
+		{"f() { echo one; }; f() { echo two; }; f", "two\n"},
+		{"r() { return 7; echo no; }; r; echo $?", "7\n"},
+		{"r() { false; }; r; echo $?", "1\n"},
+		{"f() { echo $1; }; set -- outer; f inner; echo $1", "inner\nouter\n"},
+		{"f() { x=set; }; f; echo $x", "set\n"},
+		{"f() ( x=sub ); f; echo \"[$x]\"", "[]\n"},
+		{"f() { echo hidden; } > /dev/null; f", ""},
+		{"f() { echo $1; [ $1 -lt 2 ] && f $(($1 + 1)); }; f 0", "0\n1\n2\n"},
+		{"f() { :; }; unset -f f; f; echo $?", "127\n"},
+		{"f() { :; }; type -t f", "function\n"},
+		{"f() { :; }; type f | head -1", "f is a function\n"},
+		{"f() {\n  echo multi\n}\nf", "multi\n"},
+	})
+}
+
+func TestLocal(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"x=g; f() { local x=l; echo $x; }; f; echo $x", "l\ng\n"},
+		{"x=g; f() { local x=l; g; }; g() { echo $x; }; f", "l\n"},
+		{"x=g; f() { local x; x=inner; }; f; echo $x", "g\n"},
+		{`f() { local a b=2; echo "[$a][$b]"; }; f`, "[][2]\n"},
+		{"f() { local new=1; }; f; echo \"[$new]\"", "[]\n"},
+		{"x=g; f() { local x=1; unset x; echo \"[$x]\"; }; f; echo $x", "[]\ng\n"},
+		{"x=0; f() { local x=$(($1 + 1)); [ $x -lt 3 ] && f $x; echo $x; }; f 0; echo $x", "3\n2\n1\n0\n"},
+		{"local y=1; echo $?", "1\n"},
+		{"f() { local 1x=2; }; f; echo $?", "1\n"},
+	})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Patterns and names are byte strings. A ? or bracket expression consumes a
+// whole UTF-8 sequence where there is a valid one and a single byte
+// otherwise, so names in Latin-1 or with arbitrary bytes still match.
+type patternChar struct {
+	value rune
+	width int
+	valid bool
+}
+
+func decodeChar(s string) patternChar {
+	r, width := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError && width <= 1 {
+		return patternChar{value: rune(s[0]), width: 1}
+	}
+	return patternChar{value: r, width: width, valid: true}
+}
+
+func (c patternChar) equal(other patternChar) bool {
+	return c.value == other.value && c.valid == other.valid
+}
+
+func (c patternChar) lower() patternChar {
+	if c.valid {
+		c.value = unicode.ToLower(c.value)
+	}
+	return c
+}
+
+func (c patternChar) upper() patternChar {
+	if c.valid {
+		c.value = unicode.ToUpper(c.value)
+	}
+	return c
+}
+
+func MatchPattern(pattern, name string) bool {
+	return matchPattern(pattern, name, false)
+}
+
+// MatchPatternNoCase is MatchPattern ignoring the case of valid characters,
+// for nocaseglob. Other bytes still have to be the same.
+func MatchPatternNoCase(pattern, name string) bool {
+	return matchPattern(pattern, name, true)
+}
+
+func matchPattern(pattern, name string, fold bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchPattern(pattern, name[i:], fold) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+			name = name[decodeChar(name).width:]
+			pattern = pattern[1:]
+			continue
+		case '[':
+			if len(name) > 0 {
+				if matched, patternWidth, ok := matchBracket(pattern, name, fold); ok {
+					if !matched {
+						return false
+					}
+					pattern = pattern[patternWidth:]
+					name = name[decodeChar(name).width:]
+					continue
+				}
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if fold && len(name) > 0 {
+			want, got := decodeChar(pattern), decodeChar(name)
+			if !want.lower().equal(got.lower()) {
+				return false
+			}
+			pattern = pattern[want.width:]
+			name = name[got.width:]
+			continue
+		}
+		if len(name) == 0 || name[0] != pattern[0] {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchBracket matches the first character of name against the bracket
+// expression at the start of pattern. ok is false when the bracket is not
+// closed, in which case [ is an ordinary character.
+func matchBracket(pattern, name string, fold bool) (matched bool, patternWidth int, ok bool) {
+	target := decodeChar(name)
+	targets := []patternChar{target}
+	if fold && target.valid {
+		targets = append(targets, target.lower(), target.upper())
+	}
+	i := 1
+	negate := false
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		negate = true
+		i++
+	}
+
+	first := true
+	for i < len(pattern) {
+		if pattern[i] == ']' && !first {
+			return matched != negate, i + 1, true
+		}
+		first = false
+
+		if pattern[i] == '[' && i+1 < len(pattern) && pattern[i+1] == ':' {
+			if end := strings.Index(pattern[i+2:], ":]"); end != -1 {
+				if matchClass(pattern[i+2:i+2+end], target) {
+					matched = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		low := decodeChar(pattern[i:])
+		i += low.width
+		high := low
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			i++
+			if pattern[i] == '\\' && i+1 < len(pattern) {
+				i++
+			}
+			high = decodeChar(pattern[i:])
+			i += high.width
+		}
+		for _, target := range targets {
+			if target.equal(low) || (target.valid == low.valid && target.value >= low.value && target.value <= high.value) {
+				matched = true
+			}
+		}
+	}
+	return false, 0, false
+}
+
+func matchClass(class string, c patternChar) bool {
+	if !c.valid {
+		return false
+	}
+	switch class {
+	case "alpha":
+		return unicode.IsLetter(c.value)
+	case "digit":
+		return c.value >= '0' && c.value <= '9'
+	case "alnum":
+		return unicode.IsLetter(c.value) || unicode.IsDigit(c.value)
+	case "upper":
+		return unicode.IsUpper(c.value)
+	case "lower":
+		return unicode.IsLower(c.value)
+	case "space":
+		return unicode.IsSpace(c.value)
+	case "blank":
+		return c.value == ' ' || c.value == '\t'
+	case "punct":
+		return unicode.IsPunct(c.value) || unicode.IsSymbol(c.value)
+	case "xdigit":
+		return strings.ContainsRune("0123456789abcdefABCDEF", c.value)
+	}
+	return false
+}
+
+// HasGlobMeta reports whether pattern contains an unescaped *, ? or [.
+func HasGlobMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+func unescapePattern(pattern string) string {
+	result := strings.Builder{}
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		result.WriteByte(pattern[i])
+	}
+	return result.String()
+}
+
+func joinGlobPath(prefix, name string) string {
+	if len(prefix) == 0 {
+		return name
+	}
+	if strings.HasSuffix(prefix, "/") {
+		return prefix + name
+	}
+	return prefix + "/" + name
+}
+
+// Glob performs pathname expansion relative to the shell's current
+// directory. Results keep the form the pattern was written in and are
+// sorted bytewise; hidden entries only match a pattern starting with a dot,
+// unless dotglob is set. With globstar, a ** segment matches any number of
+// directories, and files as well when it comes last.
+func (ctx *ShellCtx) Glob(pattern string) []string {
+	segments := strings.Split(pattern, "/")
+	candidates := []string{""}
+	if strings.HasPrefix(pattern, "/") {
+		candidates = []string{"/"}
+		segments = segments[1:]
+	}
+
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		next := []string{}
+		for _, prefix := range candidates {
+			if len(segment) == 0 {
+				next = append(next, prefix+"/")
+				continue
+			}
+			if segment == "**" && ctx.Options["globstar"] {
+				// Zero directories, unless that would leave **/ matching "/".
+				if !last && (prefix != "" || segments[i+1] != "") {
+					next = append(next, prefix)
+				}
+				next = append(next, ctx.globTree(prefix, !last)...)
+				continue
+			}
+			if !HasGlobMeta(segment) {
+				path := joinGlobPath(prefix, unescapePattern(segment))
+				if _, err := os.Lstat(ctx.ResolvePath(path)); err == nil || !last {
+					next = append(next, path)
+				}
+				continue
+			}
+
+			dir := prefix
+			if len(dir) == 0 {
+				dir = "."
+			}
+			entries, err := os.ReadDir(ctx.ResolvePath(dir))
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				name := entry.Name()
+				if name[0] == '.' && segment[0] != '.' && !ctx.Options["dotglob"] {
+					continue
+				}
+				if !ctx.matchGlob(segment, name) {
+					continue
+				}
+				path := joinGlobPath(prefix, name)
+				if !last {
+					if info, err := os.Stat(ctx.ResolvePath(path)); err != nil || !info.IsDir() {
+						continue
+					}
+				}
+				next = append(next, path)
+			}
+		}
+		candidates = next
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
+
+func (ctx *ShellCtx) matchGlob(pattern, name string) bool {
+	if ctx.Options["nocaseglob"] {
+		return MatchPatternNoCase(pattern, name)
+	}
+	return MatchPattern(pattern, name)
+}
+
+// globTree lists what ** matches below prefix: the files and directories at
+// any depth, or only the directories. Symbolic links to directories aren't
+// followed.
+func (ctx *ShellCtx) globTree(prefix string, dirsOnly bool) []string {
+	dir := prefix
+	if len(dir) == 0 {
+		dir = "."
+	}
+	entries, err := os.ReadDir(ctx.ResolvePath(dir))
+	if err != nil {
+		return nil
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		if entry.Name()[0] == '.' && !ctx.Options["dotglob"] {
+			continue
+		}
+		path := joinGlobPath(prefix, entry.Name())
+		if entry.IsDir() {
+			paths = append(paths, path)
+			paths = append(paths, ctx.globTree(path, dirsOnly)...)
+		} else if !dirsOnly {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestMatchPatternNoCase(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"readme*", "README.md", true},
+		{"*.TXT", "notes.txt", true},
+		{"[a-c]at", "Bat", true},
+		{"[A-C]AT", "bat", true},
+		{"caf\xe9*", "CAF\xe9 noir", true},
+		{"caf\xe9", "caf\xc9", false},
+		{"caf?", "CAF\xe9", true},
+		{"\xe9t\xe9", "\xef\xbf\xbdt\xef\xbf\xbd", false},
+		{"\xc3\xa9T\xc3\xa9", "\xc3\x89t\xc3\x89", true},
+	}
+	for _, test := range tests {
+		if got := MatchPatternNoCase(test.pattern, test.name); got != test.want {
+			t.Errorf("MatchPatternNoCase(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchPatternBytes(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"caf?", "caf\xe9", true},
+		{"caf\xe9", "caf\xe9", true},
+		{"caf\xe9", "caf\xc3\xa9", false},
+		{"na?ve", "na\xc3\xafve", true},
+		{"na??ve", "na\xc3\xafve", false},
+		{"[\xe9]", "\xe9", true},
+		{"[a-z]", "\xe9", false},
+		{"*\xff", "x\xfe\xff", true},
+	}
+	for _, test := range tests {
+		if got := MatchPattern(test.pattern, test.name); got != test.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestGlobInvalidUTF8(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`touch "$(printf 'caf\351')" b; printf '%s\n' caf?`, "caf\xe9\n"},
+		{`touch "$(printf 'caf\351')" "$(printf 'na\303\257ve')"; printf '%s\n' *`, "caf\xe9\nna\xc3\xafve\n"},
+		{`touch "$(printf '\377')"; for f in *; do printf '%s|' "$f"; done`, "\xff|"},
+		{"echo caf\xe9 > out; cat out", "caf\xe9\n"},
+	})
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expand performs history expansion on a line typed at the prompt, which is
+// what set -H turns on. A ! starts an event that refers to an earlier entry:
+// !! is the previous one, !n entry n, !-n the nth one back, !prefix the
+// latest one starting with prefix and !?text? the latest one containing
+// text. After a colon, a word designator picks some of the words of the
+// entry instead: a number, ^ for the first argument, $ for the last one, *
+// for all of them, or a range like 1-3. !$, !^ and !* are short for !!:$,
+// !!:^ and !!:*. Nothing is expanded in single quotes, after a backslash or
+// when the ! is followed by a blank, = or (.
+func (history *History) Expand(line string) (string, error) {
+	expanded := strings.Builder{}
+	singleQuoted, doubleQuoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && !singleQuoted && i+1 < len(line):
+			expanded.WriteString(line[i : i+2])
+			i++
+			continue
+		case c == '\'' && !doubleQuoted:
+			singleQuoted = !singleQuoted
+		case c == '"' && !singleQuoted:
+			doubleQuoted = !doubleQuoted
+		case c == '!' && !singleQuoted && startsEvent(line, i):
+			text, length, err := history.expandEvent(line[i+1:])
+			if err != nil {
+				return "", err
+			}
+			expanded.WriteString(text)
+			i += length
+			continue
+		}
+		expanded.WriteByte(c)
+	}
+	return expanded.String(), nil
+}
+
+// startsEvent tells whether the ! at line[i] is a history expansion, rather
+// than a lone !, a negation like ! cmd or [!a-z], or part of $! or ${!name}.
+func startsEvent(line string, i int) bool {
+	if i+1 == len(line) || strings.IndexByte(" \t\n=(\"", line[i+1]) >= 0 {
+		return false
+	}
+	return !strings.HasSuffix(line[:i], "$") && !strings.HasSuffix(line[:i], "${")
+}
+
+// expandEvent expands the event spec starts with, the ! left out. It
+// returns the text, and how much of spec it replaces.
+func (history *History) expandEvent(spec string) (string, int, error) {
+	entries := history.Entries
+	index, length := -1, 0
+	switch c := spec[0]; {
+	case c == '!':
+		index, length = len(entries)-1, 1
+	case c == '^' || c == '$' || c == '*':
+		index = len(entries) - 1
+	case c == '-' || c >= '0' && c <= '9':
+		length = 1
+		for length < len(spec) && spec[length] >= '0' && spec[length] <= '9' {
+			length++
+		}
+		number, err := strconv.Atoi(spec[:length])
+		if err != nil {
+			return "", 0, fmt.Errorf("!%s: event not found", spec[:length])
+		}
+		if number < 0 {
+			index = len(entries) + number
+		} else {
+			index = number - 1 - history.Base
+		}
+	case c == '?':
+		end := strings.IndexByte(spec[1:], '?')
+		text := spec[1:]
+		length = len(spec)
+		if end >= 0 {
+			text, length = spec[1:end+1], end+2
+		}
+		index = history.latest(func(entry string) bool { return strings.Contains(entry, text) })
+	default:
+		length = strings.IndexAny(spec, " \t\n:;&|<>()\"'`")
+		if length < 0 {
+			length = len(spec)
+		}
+		prefix := spec[:length]
+		index = history.latest(func(entry string) bool { return strings.HasPrefix(entry, prefix) })
+	}
+	if index < 0 || index >= len(entries) {
+		return "", 0, fmt.Errorf("!%s: event not found", spec[:max(length, 1)])
+	}
+
+	entry := entries[index]
+	designator := spec[length:]
+	if strings.HasPrefix(designator, ":") {
+		designator = designator[1:]
+		length++
+	} else if designator == "" || strings.IndexByte("^$*", designator[0]) < 0 {
+		return entry, length, nil
+	}
+	words, taken, err := selectWords(historyWords(entry), designator)
+	if err != nil {
+		return "", 0, err
+	}
+	return words, length + taken, nil
+}
+
+// latest returns the index of the newest entry matching, -1 for none.
+func (history *History) latest(matches func(entry string) bool) int {
+	for i := len(history.Entries) - 1; i >= 0; i-- {
+		if matches(history.Entries[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// historyWords splits an entry into the words designators count, operators
+// being words of their own and quoted words staying whole.
+func historyWords(entry string) []string {
+	tokens, err := Tokenize(entry)
+	if err != nil {
+		return strings.Fields(entry)
+	}
+	words := []string{}
+	for _, token := range tokens {
+		if token.Kind != TokenNewline {
+			words = append(words, entry[token.Start:token.End])
+		}
+	}
+	return words
+}
+
+// selectWords picks the words a designator asks for out of those of an
+// entry, and returns how much of spec the designator took.
+func selectWords(words []string, spec string) (string, int, error) {
+	last := len(words) - 1
+	position := func(at int) (int, int) {
+		if at >= len(spec) {
+			return -1, at
+		}
+		switch spec[at] {
+		case '^':
+			return 1, at + 1
+		case '$':
+			return last, at + 1
+		}
+		end := at
+		for end < len(spec) && spec[end] >= '0' && spec[end] <= '9' {
+			end++
+		}
+		if end == at {
+			return -1, at
+		}
+		number, _ := strconv.Atoi(spec[at:end])
+		return number, end
+	}
+
+	if strings.HasPrefix(spec, "*") {
+		return strings.Join(words[min(1, len(words)):], " "), 1, nil
+	}
+	from, i := position(0)
+	switch {
+	case i < len(spec) && spec[i] == '-' && from < 0:
+		from = 0
+		fallthrough
+	case i < len(spec) && spec[i] == '-':
+		until, end := position(i + 1)
+		if until < 0 {
+			until = last - 1
+		}
+		i = end
+		return joinWords(words, from, until, spec[:i])
+	case i < len(spec) && spec[i] == '*':
+		return joinWords(words, from, last, spec[:i+1])
+	}
+	return joinWords(words, from, from, spec[:i])
+}
+
+func joinWords(words []string, from, until int, spec string) (string, int, error) {
+	if from < 0 || until >= len(words) || from > until+1 {
+		return "", 0, fmt.Errorf(":%s: bad word specifier", spec)
+	}
+	return strings.Join(words[from:until+1], " "), len(spec), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestHistoryExpand(t *testing.T) {
+	history := &History{}
+	for _, entry := range []string{"cd src", "grep -r main *.go | wc -l", "make test"} {
+		history.Add(entry)
+	}
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"sudo !!", "sudo make test", true},
+		{"ls !$", "ls test", true},
+		{"echo !^ !*", "echo test test", true},
+		{"!1", "cd src", true},
+		{"!-2", "grep -r main *.go | wc -l", true},
+		{"!cd; !ma", "cd src; make test", true},
+		{"!?main?", "grep -r main *.go | wc -l", true},
+		{"!-2:0 !-2:2-3", "grep main *.go", true},
+		{"!-2:$ !-2:4*", "-l | wc -l", true},
+		{"!-2:-1", "grep -r", true},
+		{"!-2:3-", "*.go | wc", true},
+		{"!!:0", "make", true},
+		{"echo '!!' \\!! \"!!\"", "echo '!!' \\!! \"make test\"", true},
+		{"[ ! -f x ] && echo $! ${!name} !", "[ ! -f x ] && echo $! ${!name} !", true},
+		{"[ x != y ] && ls !(a)", "[ x != y ] && ls !(a)", true},
+		{"!nosuch", "", false},
+		{"!9", "", false},
+		{"!!:7", "", false},
+	}
+	for _, test := range tests {
+		got, err := history.Expand(test.line)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("Expand(%q) = %q, %v, want %q", test.line, got, err, test.want)
+		}
+	}
+}
+
+func TestHistoryExpansionAtPrompt(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "MYSHELL_TEST_MAIN=1", "HOME="+t.TempDir())
+	cmd.Dir = t.TempDir()
+	cmd.Stdin = strings.NewReader("echo one two\necho !$\n!nosuch\necho $?\nset +H\necho !!\n")
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	output, _ := cmd.Output()
+	// The expanded line is shown before it runs, and a failed expansion runs
+	// nothing.
+	if got, want := strings.ReplaceAll(string(output), "$ ", ""), "one two\ntwo\n1\n!!\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("output: got %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "echo two\nmyshell: !nosuch: event not found\n"; got != want {
+		t.Errorf("stderr: got %q, want %q", got, want)
+	}
+}
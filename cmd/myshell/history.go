@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History holds the commands entered so far. An entry is a whole command as
+// it was typed, so a construct spanning several lines stays a single entry,
+// newlines included. In the history file an entry takes the lines its
+// command needs, after a #seconds comment line with its time when
+// timestamps are written.
+type History struct {
+	Entries []string
+	// times holds when each entry was added, the zero time for those read
+	// from a file without timestamps.
+	times []time.Time
+	// Base counts the entries dropped from the start to keep to $HISTSIZE;
+	// they still count in the numbers of the others.
+	Base int
+	// saved counts the entries already written to the history file, and
+	// synced is how much of the file a shell sharing it has seen.
+	saved  int
+	synced int64
+}
+
+func (history *History) Add(entry string) {
+	history.add(entry, time.Now())
+}
+
+func (history *History) add(entry string, added time.Time) {
+	entry = strings.TrimRight(entry, "\n")
+	if len(strings.TrimSpace(entry)) == 0 {
+		return
+	}
+	history.Entries = append(history.Entries, entry)
+	history.times = append(history.times, added)
+}
+
+// Erase removes every copy of an entry.
+func (history *History) Erase(entry string) {
+	history.remove(func(i int) bool { return history.Entries[i] == entry })
+}
+
+// Delete removes the entries from index from to index to, both included.
+func (history *History) Delete(from, to int) {
+	history.remove(func(i int) bool { return i >= from && i <= to })
+}
+
+func (history *History) remove(removed func(i int) bool) {
+	entries, times := []string{}, []time.Time{}
+	saved := history.saved
+	for i := range history.Entries {
+		if !removed(i) {
+			entries = append(entries, history.Entries[i])
+			times = append(times, history.times[i])
+		} else if i < history.saved {
+			saved--
+		}
+	}
+	history.Entries, history.times, history.saved = entries, times, saved
+}
+
+// Truncate drops the oldest entries beyond size, a negative size keeping
+// them all.
+func (history *History) Truncate(size int) {
+	dropped := len(history.Entries) - size
+	if size < 0 || dropped <= 0 {
+		return
+	}
+	history.Entries = append([]string{}, history.Entries[dropped:]...)
+	history.times = append([]time.Time{}, history.times[dropped:]...)
+	history.Base += dropped
+	history.saved = max(history.saved-dropped, 0)
+}
+
+// HistoryBrowser walks the history from an edit buffer. Up and Down first
+// move between the lines of the entry being edited and only switch to the
+// previous or next entry from its first or last line. What the user typed
+// before browsing is kept as a draft and comes back below the newest entry.
+type HistoryBrowser struct {
+	history *History
+	index   int
+	draft   string
+}
+
+func (history *History) Browse() *HistoryBrowser {
+	return &HistoryBrowser{history: history, index: len(history.Entries)}
+}
+
+func (browser *HistoryBrowser) Up(buffer *EditBuffer) {
+	if buffer.MoveUp() || browser.index == 0 {
+		return
+	}
+	if browser.index == len(browser.history.Entries) {
+		browser.draft = buffer.String()
+	}
+	browser.index--
+	buffer.SetText(browser.history.Entries[browser.index])
+}
+
+func (browser *HistoryBrowser) Down(buffer *EditBuffer) {
+	if buffer.MoveDown() || browser.index == len(browser.history.Entries) {
+		return
+	}
+	browser.index++
+	if browser.index == len(browser.history.Entries) {
+		buffer.SetText(browser.draft)
+	} else {
+		buffer.SetText(browser.history.Entries[browser.index])
+	}
+	buffer.MoveToFirstLine()
+}
+
+// OperateAndGetNext is readline's Ctrl-O: the entry being edited is accepted
+// and the one after it should be preloaded into the next prompt. It returns
+// the index of that entry, or false when the buffer isn't a history entry
+// with a successor.
+func (browser *HistoryBrowser) OperateAndGetNext() (int, bool) {
+	next := browser.index + 1
+	if next >= len(browser.history.Entries) {
+		return 0, false
+	}
+	return next, true
+}
+
+// BrowseAt starts browsing with the entry at index already loaded into the
+// buffer, which is how the prompt after a Ctrl-O begins.
+func (history *History) BrowseAt(index int, buffer *EditBuffer) *HistoryBrowser {
+	browser := history.Browse()
+	if index < 0 || index >= len(history.Entries) {
+		return browser
+	}
+	browser.index = index
+	buffer.SetText(history.Entries[index])
+	return browser
+}
+
+// Load appends the entries of a history file, which then count as saved.
+func (history *History) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = history.read(file)
+	history.saved = len(history.Entries)
+	return err
+}
+
+func (history *History) read(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	for _, record := range historyRecords(lines) {
+		added := time.Time{}
+		if isTimestampLine(record[0]) {
+			seconds, _ := strconv.ParseInt(record[0][1:], 10, 64)
+			added = time.Unix(seconds, 0)
+			record = record[1:]
+		}
+		if len(record) > 0 {
+			history.add(strings.Join(record, "\n"), added)
+		}
+	}
+	return scanner.Err()
+}
+
+// historyRecords splits the lines of a history file into those of each
+// entry, with its timestamp first if it has one. An entry goes on over the
+// lines its command needs to be complete, like the body of a loop or a
+// quoted newline, up to the next timestamp.
+func historyRecords(lines []string) [][]string {
+	records := [][]string{}
+	start := 0
+	for i, line := range lines {
+		if isTimestampLine(line) {
+			if i > start {
+				records = append(records, lines[start:i])
+			}
+			start = i
+			continue
+		}
+		command := lines[start : i+1]
+		if isTimestampLine(command[0]) {
+			command = command[1:]
+		}
+		entry := strings.Join(command, "\n")
+		if endsWithLineContinuation(entry) {
+			continue
+		}
+		if _, err := ParseCommandList(entry, nil); IsIncomplete(err) {
+			continue
+		}
+		records = append(records, lines[start:i+1])
+		start = i + 1
+	}
+	if start < len(lines) {
+		records = append(records, lines[start:])
+	}
+	return records
+}
+
+// Merge adds the entries other shells sharing the history file appended to
+// it since it was last synced. A file that shrank, having been truncated,
+// is taken as it is now; a last line not finished yet is left for later.
+func (history *History) Merge(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < history.synced {
+		history.synced = int64(len(data))
+		return nil
+	}
+	appended := data[history.synced:]
+	appended = appended[:bytes.LastIndexByte(appended, '\n')+1]
+	history.synced += int64(len(appended))
+
+	// The entries of this session not saved yet go after the new ones, as
+	// they will in the file.
+	unsaved, times := slices.Clone(history.Entries[history.saved:]), slices.Clone(history.times[history.saved:])
+	history.Entries, history.times = history.Entries[:history.saved], history.times[:history.saved]
+	err = history.read(bytes.NewReader(appended))
+	history.saved = len(history.Entries)
+	history.Entries = append(history.Entries, unsaved...)
+	history.times = append(history.times, times...)
+	return err
+}
+
+// Write replaces the history file with the whole history, with the time of
+// each entry when stamped.
+func (history *History) Write(path string, stamped bool) error {
+	return history.writeFile(path, os.O_TRUNC, 0, stamped)
+}
+
+// Append adds the entries not yet saved to the history file.
+func (history *History) Append(path string, stamped bool) error {
+	return history.writeFile(path, os.O_APPEND, min(history.saved, len(history.Entries)), stamped)
+}
+
+func (history *History) writeFile(path string, mode int, from int, stamped bool) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|mode, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for i := from; i < len(history.Entries); i++ {
+		if stamped && !history.times[i].IsZero() {
+			fmt.Fprintf(writer, "#%d\n", history.times[i].Unix())
+		}
+		writer.WriteString(history.Entries[i] + "\n")
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	history.saved = len(history.Entries)
+	return file.Close()
+}
+
+func (history *History) Clear() {
+	history.Entries = nil
+	history.times = nil
+	history.Base = 0
+	history.saved = 0
+}
+
+// AddHistory adds a command typed at the prompt to the history, as
+// HISTCONTROL allows, and keeps the history to $HISTSIZE entries.
+// HISTCONTROL is a colon-separated list: ignorespace leaves out commands
+// starting with a space, ignoredups those equal to the previous entry,
+// ignoreboth means both and erasedups removes earlier copies of a command.
+func (ctx *ShellCtx) AddHistory(command string) {
+	history := ctx.History
+	control := map[string]bool{}
+	if value, found := ctx.GetVar("HISTCONTROL"); found {
+		for _, name := range strings.Split(value, ":") {
+			control[name] = true
+		}
+	}
+	entry := strings.TrimRight(command, "\n")
+	if (control["ignorespace"] || control["ignoreboth"]) && strings.HasPrefix(entry, " ") {
+		return
+	}
+	last := len(history.Entries) - 1
+	if (control["ignoredups"] || control["ignoreboth"]) && last >= 0 && history.Entries[last] == entry {
+		return
+	}
+	if control["erasedups"] {
+		history.Erase(entry)
+	}
+	history.Add(entry)
+	history.Truncate(ctx.historySize("HISTSIZE"))
+}
+
+// historySize reads HISTSIZE or HISTFILESIZE; anything but a number from 0
+// up, unset included, means no limit and is -1.
+func (ctx *ShellCtx) historySize(name string) int {
+	value, _ := ctx.GetVar(name)
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 0 {
+		return -1
+	}
+	return size
+}
+
+// truncateHistoryFile keeps the history file to its last $HISTFILESIZE
+// lines, dropping whole entries.
+func (ctx *ShellCtx) truncateHistoryFile(path string) error {
+	size := ctx.historySize("HISTFILESIZE")
+	if size < 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 || len(lines) <= size {
+		return nil
+	}
+	records := historyRecords(lines)
+	kept, start := 0, len(records)
+	for start > 0 && kept+len(records[start-1]) <= size {
+		start--
+		kept += len(records[start])
+	}
+	text := strings.Builder{}
+	for _, record := range records[start:] {
+		text.WriteString(strings.Join(record, "\n") + "\n")
+	}
+	return os.WriteFile(path, []byte(text.String()), 0600)
+}
+
+// historyFile is where the history is kept between sessions: $HISTFILE, or
+// nowhere when it's unset or empty.
+func (ctx *ShellCtx) historyFile() string {
+	path, _ := ctx.GetVar("HISTFILE")
+	if path == "" {
+		return ""
+	}
+	return ctx.ResolvePath(path)
+}
+
+func isTimestampLine(line string) bool {
+	line = strings.TrimSuffix(line, "\n")
+	return len(line) > 1 && line[0] == '#' && isAllDigits(line[1:])
+}
+
+// LoadHistory reads the history file when an interactive shell starts,
+// defaulting HISTFILE to ~/.myshell_history, HISTSIZE to 500 and
+// HISTFILESIZE to HISTSIZE.
+func (ctx *ShellCtx) LoadHistory() {
+	if _, found := ctx.GetVar("HISTFILE"); !found {
+		if home, found := ctx.GetVar("HOME"); found {
+			ctx.SetVar("HISTFILE", filepath.Join(home, ".myshell_history"))
+		}
+	}
+	if _, found := ctx.GetVar("HISTSIZE"); !found {
+		ctx.SetVar("HISTSIZE", "500")
+	}
+	if _, found := ctx.GetVar("HISTFILESIZE"); !found {
+		size, _ := ctx.GetVar("HISTSIZE")
+		ctx.SetVar("HISTFILESIZE", size)
+	}
+	if path := ctx.historyFile(); path != "" {
+		ctx.History.Load(path)
+		ctx.History.Truncate(ctx.historySize("HISTSIZE"))
+		if info, err := os.Stat(path); err == nil {
+			ctx.History.synced = info.Size()
+		}
+	}
+}
+
+// ShareHistory, with histshare on, appends the commands of the session to
+// the history file as soon as they are entered and merges those that other
+// sessions sharing the file appended meanwhile, so that they show up here
+// too.
+func (ctx *ShellCtx) ShareHistory() {
+	path := ctx.historyFile()
+	if !ctx.Interactive || !ctx.Options["histshare"] || path == "" {
+		return
+	}
+	ctx.shareHistory(path)
+}
+
+func (ctx *ShellCtx) shareHistory(path string) error {
+	history := ctx.History
+	// The file is merged first, so that what is appended next isn't taken
+	// for entries of another session.
+	if err := history.Merge(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, stamped := ctx.GetVar("HISTTIMEFORMAT")
+	if err := history.Append(path, stamped); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		history.synced = info.Size()
+	}
+	history.Truncate(ctx.historySize("HISTSIZE"))
+	return nil
+}
+
+// SaveHistory writes the history file when an interactive shell exits. With
+// histappend or histshare the session's entries are added to the file,
+// otherwise they replace it.
+func (ctx *ShellCtx) SaveHistory() {
+	path := ctx.historyFile()
+	if !ctx.Interactive || path == "" {
+		return
+	}
+	_, stamped := ctx.GetVar("HISTTIMEFORMAT")
+	var err error
+	if ctx.Options["histshare"] {
+		err = ctx.shareHistory(path)
+	} else if ctx.Options["histappend"] {
+		err = ctx.History.Append(path, stamped)
+	} else {
+		err = ctx.History.Write(path, stamped)
+	}
+	if err == nil {
+		ctx.truncateHistoryFile(path)
+	}
+}
+
+// HistoryExecutor implements `history [-c] [-d offset] [n]` and `history
+// -a|-r|-w [file]`. The entries are listed with their numbers, only the last
+// n of them when n is given, and with their times formatted by
+// HISTTIMEFORMAT when it's set. -c clears the list and -d deletes the entry
+// at an offset, negative ones counting back from the end, or those of a
+// range like 3-5. -r reads the history file into the list, -w writes it to
+// the file and -a appends the entries the file doesn't have yet. The file
+// is $HISTFILE unless another one is given.
+func HistoryExecutor(shellCtx *ShellCtx, args []string) error {
+	history := shellCtx.History
+	action, edited := byte(0), false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for i := 1; i < len(option); i++ {
+			switch option[i] {
+			case 'c':
+				history.Clear()
+				edited = true
+			case 'd':
+				if len(args) == 0 {
+					return fmt.Errorf("history command -d requires an offset")
+				}
+				if !shellCtx.deleteHistory(args[0]) {
+					shellCtx.Serr = fmt.Sprintf("history: %s: history position out of range\n", args[0])
+					shellCtx.Status = 1
+					return nil
+				}
+				args = args[1:]
+				edited = true
+			case 'a', 'r', 'w':
+				if action != 0 && action != option[i] {
+					return fmt.Errorf("history command takes only one of -a, -r and -w")
+				}
+				action = option[i]
+			default:
+				return fmt.Errorf("history command got invalid option -%c", option[i])
+			}
+		}
+	}
+
+	if action != 0 {
+		if len(args) > 1 {
+			return fmt.Errorf("history command takes at most 1 file")
+		}
+		path := shellCtx.historyFile()
+		if len(args) == 1 {
+			path = shellCtx.ResolvePath(args[0])
+		}
+		if path == "" {
+			shellCtx.Serr = "history: HISTFILE not set\n"
+			shellCtx.Status = 1
+			return nil
+		}
+		_, stamped := shellCtx.GetVar("HISTTIMEFORMAT")
+		var err error
+		switch action {
+		case 'a':
+			err = history.Append(path, stamped)
+		case 'r':
+			err = history.Load(path)
+			history.Truncate(shellCtx.historySize("HISTSIZE"))
+		case 'w':
+			err = history.Write(path, stamped)
+		}
+		if err == nil && action != 'r' {
+			err = shellCtx.truncateHistoryFile(path)
+		}
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("history: %s: %s\n", path, describeOpenError(err))
+			shellCtx.Status = 1
+		}
+		return nil
+	}
+	// Clearing or deleting entries doesn't list what's left.
+	if edited {
+		return nil
+	}
+
+	if len(args) > 1 {
+		return fmt.Errorf("history command takes at most 1 count")
+	}
+	start := 0
+	if len(args) == 1 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil || count < 0 {
+			shellCtx.Serr = fmt.Sprintf("history: %s: numeric argument required\n", args[0])
+			shellCtx.Status = 1
+			return nil
+		}
+		start = max(len(history.Entries)-count, 0)
+	}
+	timeFormat, stamped := shellCtx.GetVar("HISTTIMEFORMAT")
+	for i := start; i < len(history.Entries); i++ {
+		added := ""
+		if stamped && history.times[i].IsZero() {
+			added = "?? "
+		} else if stamped {
+			added = strftime(timeFormat, history.times[i])
+		}
+		shellCtx.Sout += fmt.Sprintf("%5d  %s%s\n", history.Base+i+1, added, history.Entries[i])
+	}
+	return nil
+}
+
+// deleteHistory deletes the entries history -d names, reporting false when
+// they aren't all in the history.
+func (ctx *ShellCtx) deleteHistory(spec string) bool {
+	history := ctx.History
+	position := func(offset string) (int, bool) {
+		number, err := strconv.Atoi(offset)
+		if err != nil || number == 0 {
+			return 0, false
+		}
+		index := number - 1 - history.Base
+		if number < 0 {
+			index = len(history.Entries) + number
+		}
+		return index, index >= 0 && index < len(history.Entries)
+	}
+	from, to := spec, spec
+	if dash := strings.Index(spec[min(1, len(spec)):], "-"); dash >= 0 {
+		from, to = spec[:dash+1], spec[dash+2:]
+	}
+	first, ok := position(from)
+	last, ok2 := position(to)
+	if !ok || !ok2 || first > last {
+		return false
+	}
+	history.Delete(first, last)
+	return true
+}
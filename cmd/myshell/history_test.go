@@ -0,0 +1,404 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHistoryFileRoundTrip(t *testing.T) {
+	entries := []string{
+		"ls -l",
+		"for x in 1 2\ndo\n  echo $x\ndone",
+		"echo 'two\nlines'",
+		"echo one \\\ntwo",
+		"f() {\n  :\n}",
+		"# a comment",
+		"pwd",
+	}
+	for _, stamped := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "history")
+		written := &History{}
+		for _, entry := range entries {
+			written.Add(entry)
+		}
+		if err := written.Write(path, stamped); err != nil {
+			t.Fatal(err)
+		}
+		read := &History{}
+		if err := read.Load(path); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(read.Entries, entries) {
+			t.Errorf("stamped %v: got %q", stamped, read.Entries)
+		}
+	}
+}
+
+func TestTruncateHistoryFileKeepsWholeEntries(t *testing.T) {
+	tests := []struct {
+		size string
+		want []string
+	}{
+		{"10", []string{"ls", "while :\ndo\n  break\ndone", "pwd"}},
+		{"5", []string{"while :\ndo\n  break\ndone", "pwd"}},
+		{"4", []string{"pwd"}},
+		{"0", nil},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		path := filepath.Join(t.TempDir(), "history")
+		content := "ls\nwhile :\ndo\n  break\ndone\npwd\n"
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		shellCtx.SetVar("HISTFILESIZE", test.size)
+		if err := shellCtx.truncateHistoryFile(path); err != nil {
+			t.Fatal(err)
+		}
+		read := &History{}
+		read.Load(path)
+		if !slices.Equal(read.Entries, test.want) {
+			data, _ := os.ReadFile(path)
+			t.Errorf("HISTFILESIZE=%s: got %q from %q", test.size, read.Entries, strings.Split(string(data), "\n"))
+		}
+	}
+}
+
+func TestReadCommandJoinsLines(t *testing.T) {
+	tests := []struct {
+		lines []string
+		want  string
+	}{
+		{[]string{"echo hi"}, "echo hi"},
+		{[]string{"for x in 1 2", "do", "  echo $x", "done"}, "for x in 1 2\ndo\n  echo $x\ndone"},
+		{[]string{"echo one \\", "two"}, "echo one \\\ntwo"},
+		{[]string{"echo 'a", "b'"}, "echo 'a\nb'"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		next := 0
+		command, err := shellCtx.ReadCommand(func(continued bool) (string, error) {
+			if continued != (next > 0) {
+				t.Errorf("%q: line %d read with continued %v", test.want, next, continued)
+			}
+			next++
+			return test.lines[next-1], nil
+		})
+		if err != nil || command != test.want || next != len(test.lines) {
+			t.Errorf("ReadCommand: got %q after %d lines, want %q", command, next, test.want)
+		}
+		shellCtx.AddHistory(command)
+		if !slices.Equal(shellCtx.History.Entries, []string{test.want}) {
+			t.Errorf("history of %q: got %q", test.want, shellCtx.History.Entries)
+		}
+	}
+}
+
+func TestHistoryBrowserMultiline(t *testing.T) {
+	history := &History{}
+	history.Add("ls")
+	history.Add("for x in 1 2\ndo\n  echo $x\ndone")
+	tests := []struct {
+		keys string
+		text string
+		row  int
+	}{
+		{"U", "for x in 1 2\ndo\n  echo $x\ndone", 3},
+		{"UU", "for x in 1 2\ndo\n  echo $x\ndone", 2},
+		{"UUUU", "for x in 1 2\ndo\n  echo $x\ndone", 0},
+		{"UUUUU", "ls", 0},
+		{"UUUUUD", "for x in 1 2\ndo\n  echo $x\ndone", 0},
+		{"UUUUUDD", "for x in 1 2\ndo\n  echo $x\ndone", 1},
+		{"UDDDD", "draft", 0},
+		{"UUUUUUUU", "ls", 0},
+	}
+	for _, test := range tests {
+		buffer := NewEditBuffer()
+		buffer.SetText("draft")
+		browser := history.Browse()
+		for _, key := range test.keys {
+			if key == 'U' {
+				browser.Up(buffer)
+			} else {
+				browser.Down(buffer)
+			}
+		}
+		if row, _ := buffer.Cursor(); buffer.String() != test.text || row != test.row {
+			t.Errorf("%s: got %q on line %d, want %q on line %d", test.keys, buffer.String(), row, test.text, test.row)
+		}
+	}
+}
+
+func TestHistoryRecall(t *testing.T) {
+	shellCtx := NewShellCtx()
+	for _, entry := range []string{"ls", "cd src", "make"} {
+		shellCtx.History.Add(entry)
+	}
+	editor := NewLineEditor(shellCtx, nil)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"<up>", "\r"}, "make"},
+		{[]string{"<up>", "<up>", "\r"}, "cd src"},
+		{[]string{"\x10", "\x10", "\x10", "\x10", "\x10", "\r"}, "ls"},
+		{[]string{"<up>", "<up>", "<down>", "\r"}, "make"},
+		{[]string{"\x10", "\x0e", "\r"}, ""},
+		{typed("draft", "<up>", "<up>", "<down>", "<down>", "\r"), "draft"},
+		{typed("draft", "<down>", "\r"), "draft"},
+		{[]string{"<up>", " ", "-", "j", "\r"}, "make -j"},
+		{[]string{"<up>", "\x01", "\x0b", "\r"}, ""},
+	}
+	for _, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+	// Editing a recalled line leaves the history as it was.
+	if len(shellCtx.History.Entries) != 3 || shellCtx.History.Entries[2] != "make" {
+		t.Errorf("history changed: %q", shellCtx.History.Entries)
+	}
+}
+
+func TestHistoryBuiltin(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"history", "    1  ls\n    2  cd src\n    3  make\n"},
+		{"history 2", "    2  cd src\n    3  make\n"},
+		{"history 0", ""},
+		{"history -c; history", ""},
+		{"history -d 2; history", "    1  ls\n    2  make\n"},
+		{"history -d -1; history", "    1  ls\n    2  cd src\n"},
+		{"history -d 1-2; history", "    1  make\n"},
+		{"history -d 9; echo $?; history 1", "1\n    3  make\n"},
+		{"history -w; cat $HISTFILE", "ls\ncd src\nmake\n"},
+		{"history -w other; cat other", "ls\ncd src\nmake\n"},
+		{"echo old > $HISTFILE; history -a; history -a; cat $HISTFILE", "old\nls\ncd src\nmake\n"},
+		{"printf 'pwd\\nid\\n' > other; history -r other; history 3", "    3  make\n    4  pwd\n    5  id\n"},
+		{"history -w; history -c; history -r; history", "    1  ls\n    2  cd src\n    3  make\n"},
+		{"unset HISTFILE; history -w; echo $?", "1\n"},
+		{"history -w nosuch/file; echo $?", "1\n"},
+		{"history x; echo $?", "1\n"},
+		{"history -q; echo $?", "1\n"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.CurrentDir = t.TempDir()
+		shellCtx.SetVar("HISTFILE", filepath.Join(shellCtx.CurrentDir, "history"))
+		for _, entry := range []string{"ls", "cd src", "make"} {
+			shellCtx.History.Add(entry)
+		}
+		if got, _ := runShell(t, shellCtx, test.line); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestHistorySearch(t *testing.T) {
+	shellCtx := NewShellCtx()
+	for _, entry := range []string{"make", "cd src", "make test", "ls"} {
+		shellCtx.History.Add(entry)
+	}
+	editor := NewLineEditor(shellCtx, nil)
+	search := func(query string, keys ...string) []string {
+		return append([]string{"\x12"}, typed(query, keys...)...)
+	}
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{search("make", "\r"), "make test"},
+		{search("make", "\x12", "\r"), "make"},
+		{search("make", "\x12", "\x12", "\r"), "make"},
+		{search("mk", "\r"), "make test"},
+		{search("make", "\x12", "\x7f", "\r"), "make test"},
+		{search("cd", "\x05", " ", ".", "\r"), "cd src ."},
+		{search("test", "<up>", "\r"), "cd src"},
+		{typed("draft", "\x12", "m", "<esc>", "\r"), "draft"},
+		{typed("draft", "\x12", "m", "\x07", "\r"), "draft"},
+		{search("", "\r"), ""},
+	}
+	for _, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+}
+
+func TestHistorySearchPrompt(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.History.Add("make")
+	editor := NewLineEditor(shellCtx, nil)
+	typeLine(editor, []string{"\x12", "m"})
+	if got, want := editor.search.prompt(), "(reverse-i-search)`m': "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	editor.handleKey("x")
+	if got, want := editor.search.prompt(), "(failed reverse-i-search)`mx': "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAddHistory(t *testing.T) {
+	commands := []string{"ls", "ls", " secret", "pwd", "ls\n"}
+	tests := []struct {
+		control string
+		size    string
+		want    []string
+		base    int
+	}{
+		{"", "", []string{"ls", "ls", " secret", "pwd", "ls"}, 0},
+		{"ignoredups", "", []string{"ls", " secret", "pwd", "ls"}, 0},
+		{"ignorespace", "", []string{"ls", "ls", "pwd", "ls"}, 0},
+		{"ignoreboth", "", []string{"ls", "pwd", "ls"}, 0},
+		{"erasedups", "", []string{" secret", "pwd", "ls"}, 0},
+		{"ignorespace:erasedups", "", []string{"pwd", "ls"}, 0},
+		{"", "2", []string{"pwd", "ls"}, 3},
+		{"ignoreboth", "2", []string{"pwd", "ls"}, 1},
+		{"", "0", []string{}, 5},
+		{"", "x", []string{"ls", "ls", " secret", "pwd", "ls"}, 0},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("HISTCONTROL", test.control)
+		shellCtx.SetVar("HISTSIZE", test.size)
+		for _, command := range commands {
+			shellCtx.AddHistory(command)
+		}
+		if history := shellCtx.History; !slices.Equal(history.Entries, test.want) || history.Base != test.base {
+			t.Errorf("HISTCONTROL=%s HISTSIZE=%s: got %q from %d, want %q from %d",
+				test.control, test.size, history.Entries, history.Base, test.want, test.base)
+		}
+	}
+}
+
+func TestLoadHistoryDefaults(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".myshell_history"), []byte("one\ntwo\nthree\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shellCtx := NewShellCtx()
+	shellCtx.SetVar("HOME", home)
+	shellCtx.SetVar("HISTSIZE", "2")
+	shellCtx.LoadHistory()
+	if got, _ := shellCtx.GetVar("HISTFILESIZE"); got != "2" {
+		t.Errorf("HISTFILESIZE: got %q, want the HISTSIZE", got)
+	}
+	if got, want := shellCtx.History.Entries, []string{"two", "three"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// History written to the file is kept to $HISTFILESIZE lines.
+	shellCtx.SetVar("HISTFILESIZE", "1")
+	if got, _ := runShell(t, shellCtx, "history -w; cat $HISTFILE"); got != "three\n" {
+		t.Errorf("history file: got %q", got)
+	}
+}
+
+func TestHistoryTimestamps(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"history -r; HISTTIMEFORMAT='%s '; history", "    1  1700000000 ls\n    2  ?? pwd\n    3  1700000060 make\n"},
+		{"history -r; history", "    1  ls\n    2  pwd\n    3  make\n"},
+		{"history -r; HISTTIMEFORMAT=; history -w out; cat out", "#1700000000\nls\npwd\n#1700000060\nmake\n"},
+		{"history -r; history -w out; cat out", "ls\npwd\nmake\n"},
+		{"history -r; history -r; history -d 2-4; history", "    1  ls\n    2  pwd\n    3  make\n"},
+		{"history -r; history -r; history -d -3--2; history", "    1  ls\n    2  pwd\n    3  make\n    4  make\n"},
+		{"history -r; history -d 3-1; echo $?; history -d 2-9; echo $?; history 1", "1\n1\n    3  make\n"},
+		{"history -r; history -d 0; echo $?; history -d x; echo $?", "1\n1\n"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.CurrentDir = t.TempDir()
+		path := filepath.Join(shellCtx.CurrentDir, "history")
+		if err := os.WriteFile(path, []byte("#1700000000\nls\npwd\n#1700000060\nmake\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		shellCtx.SetVar("HISTFILE", path)
+		if got, _ := runShell(t, shellCtx, test.line); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestShareHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	session := func() *ShellCtx {
+		shellCtx := NewShellCtx()
+		shellCtx.Interactive = true
+		shellCtx.Options["histshare"] = true
+		shellCtx.SetVar("HISTFILE", path)
+		shellCtx.LoadHistory()
+		return shellCtx
+	}
+	enter := func(shellCtx *ShellCtx, command string) {
+		shellCtx.AddHistory(command)
+		shellCtx.ShareHistory()
+	}
+	first, second := session(), session()
+	enter(first, "one")
+	enter(second, "two")
+	enter(first, "three")
+	enter(second, "four")
+	if want := []string{"one", "two", "three", "four"}; !slices.Equal(second.History.Entries, want) {
+		t.Errorf("second session: got %q, want %q", second.History.Entries, want)
+	}
+	// The first session gets four when it next syncs, on exit here.
+	first.SaveHistory()
+	if want := []string{"one", "two", "three", "four"}; !slices.Equal(first.History.Entries, want) {
+		t.Errorf("first session: got %q, want %q", first.History.Entries, want)
+	}
+	data, _ := os.ReadFile(path)
+	if want := "one\ntwo\nthree\nfour\n"; string(data) != want {
+		t.Errorf("file: got %q, want %q", data, want)
+	}
+	// A new session starts with all of it.
+	if got := session().History.Entries; len(got) != 4 {
+		t.Errorf("new session: got %q", got)
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		appended string
+		want     []string
+	}{
+		{"new entries", "b\nc\n", []string{"a", "b", "c", "mine"}},
+		{"unfinished line", "b\nc", []string{"a", "b", "mine"}},
+		{"stamped entry", "#1700000000\nb\n", []string{"a", "b", "mine"}},
+		{"nothing", "", []string{"a", "mine"}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "history")
+		if err := os.WriteFile(path, []byte("a\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		history := &History{}
+		history.Load(path)
+		history.synced = 2
+		history.Add("mine")
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(test.appended)
+		file.Close()
+		if err := history.Merge(path); err != nil || !slices.Equal(history.Entries, test.want) {
+			t.Errorf("%s: got %q, %v, want %q", test.name, history.Entries, err, test.want)
+		}
+	}
+	// A file truncated meanwhile adds nothing, and is read from its new end.
+	path := filepath.Join(t.TempDir(), "history")
+	os.WriteFile(path, []byte("x\n"), 0o600)
+	history := &History{synced: 100}
+	if history.Merge(path); len(history.Entries) != 0 || history.synced != 2 {
+		t.Errorf("truncated file: got %q, synced %d", history.Entries, history.synced)
+	}
+}
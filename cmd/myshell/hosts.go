@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// remoteCommands are the commands whose arguments name hosts: all of them
+// for ssh, and for scp and rsync those that are not local paths, written
+// host:path.
+var remoteCommands = map[string]bool{"ssh": true, "scp": true, "rsync": true}
+
+// completeRemote completes an argument of ssh, scp or rsync: a host, after
+// the user@ given if any, and for scp and rsync also a local path. A host
+// for scp or rsync gets the colon its path follows, and no space after it.
+func (ctx *ShellCtx) completeRemote(word completionWord) ([]string, bool) {
+	local := strings.HasPrefix(word.value, "-") || strings.ContainsAny(word.value, "/") ||
+		strings.HasPrefix(word.value, ".") || strings.HasPrefix(word.value, "~")
+	if local {
+		return ctx.completeFiles(word.value), false
+	}
+	if word.command() != "ssh" && strings.Contains(word.value, ":") {
+		// Paths on the remote host aren't looked up.
+		return nil, false
+	}
+	user, prefix := "", word.value
+	if at := strings.LastIndexByte(prefix, '@'); at >= 0 {
+		user, prefix = prefix[:at+1], prefix[at+1:]
+	}
+	suffix := ""
+	if word.command() != "ssh" {
+		suffix = ":"
+	}
+	names := map[string]bool{}
+	for _, host := range ctx.knownHosts() {
+		if ctx.completionMatches(host, prefix) {
+			names[user+host+suffix] = true
+		}
+	}
+	candidates := sortedNames(names)
+	if suffix == "" {
+		return candidates, false
+	}
+	if user == "" {
+		candidates = append(candidates, ctx.completeFiles(word.value)...)
+	}
+	return candidates, len(candidates) == 1 && strings.HasSuffix(candidates[0], suffix)
+}
+
+// knownHosts lists the hosts of ~/.ssh/config, those of its Host lines that
+// aren't patterns, and of ~/.ssh/known_hosts, those not hashed, without the
+// port of a [host]:port.
+func (ctx *ShellCtx) knownHosts() []string {
+	home, _ := ctx.GetVar("HOME")
+	hosts := []string{}
+	if config, err := os.ReadFile(filepath.Join(home, ".ssh", "config")); err == nil {
+		for _, line := range strings.Split(string(config), "\n") {
+			fields := strings.Fields(strings.ReplaceAll(line, "=", " "))
+			if len(fields) < 2 || !strings.EqualFold(fields[0], "Host") {
+				continue
+			}
+			for _, host := range fields[1:] {
+				if !strings.ContainsAny(host, "*?!") {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	if known, err := os.ReadFile(filepath.Join(home, ".ssh", "known_hosts")); err == nil {
+		for _, line := range strings.Split(string(known), "\n") {
+			fields := strings.Fields(line)
+			// A marker like @cert-authority comes before the hosts.
+			if len(fields) > 1 && strings.HasPrefix(fields[0], "@") {
+				fields = fields[1:]
+			}
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "|") {
+				continue
+			}
+			for _, host := range strings.Split(fields[0], ",") {
+				if strings.HasPrefix(host, "[") {
+					host, _, _ = strings.Cut(host[1:], "]")
+				}
+				if host != "" && !strings.ContainsAny(host, "*?!") {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sshHome makes a home directory whose ~/.ssh has the config and
+// known_hosts given, an empty one being left out.
+func sshHome(t *testing.T, config, knownHosts string) string {
+	t.Helper()
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"config": config, "known_hosts": knownHosts} {
+		if content == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(home, ".ssh", name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return home
+}
+
+func TestKnownHosts(t *testing.T) {
+	tests := []struct {
+		config, knownHosts string
+		want               []string
+	}{
+		{"Host web db\n  HostName 10.0.0.1\nHost=backup\n", "", []string{"web", "db", "backup"}},
+		{"host lower\nHost *.internal !bad ok?\nMatch all\n", "", []string{"lower"}},
+		{"", "alpha,10.0.0.2 ssh-ed25519 AAAA\n[beta]:2222 ssh-rsa AAAA\n", []string{"alpha", "10.0.0.2", "beta"}},
+		{"", "# comment\n|1|hashed= ssh-rsa AAAA\n@cert-authority *.corp ssh-rsa AAAA\n@revoked gamma ssh-rsa AAAA\n\n", []string{"gamma"}},
+		{"Host one\n", "two ssh-rsa AAAA\n", []string{"one", "two"}},
+		{"", "", []string{}},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("HOME", sshHome(t, test.config, test.knownHosts))
+		if got := shellCtx.knownHosts(); !slices.Equal(got, test.want) {
+			t.Errorf("config %q, known_hosts %q: got %q, want %q", test.config, test.knownHosts, got, test.want)
+		}
+	}
+}
+
+func TestCompleteRemote(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	shellCtx.SetVar("HOME", sshHome(t, "Host web webdev db\n", "wiki ssh-rsa AAAA\n"))
+	if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, "website.tar"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		typed   string
+		want    []string
+		nospace bool
+	}{
+		{"ssh w", []string{"web", "webdev", "wiki"}, false},
+		{"ssh d", []string{"db"}, false},
+		{"ssh me@we", []string{"me@web", "me@webdev"}, false},
+		{"ssh -o Port=22 d", []string{"db"}, false},
+		{"scp d", []string{"db:"}, true},
+		{"scp we", []string{"web:", "webdev:", "website.tar"}, false},
+		{"rsync me@d", []string{"me@db:"}, true},
+		{"scp db:/e", nil, false},
+		{"scp ./we", []string{"./website.tar"}, false},
+		{"ssh x", []string{}, false},
+		{"ssh > we", []string{"website.tar"}, false},
+		{"cat we", []string{"website.tar"}, false},
+	}
+	for _, test := range tests {
+		got, nospace := shellCtx.Complete(parseCompletionWord(test.typed))
+		if !slices.Equal(got, test.want) || nospace != test.nospace {
+			t.Errorf("completing %q: got %q, %v, want %q, %v", test.typed, got, nospace, test.want, test.nospace)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+const defaultSecondaryPrompt = "> "
+
+// SecondaryPrompt renders PS2, shown while a command continues over several
+// lines.
+func (ctx *ShellCtx) SecondaryPrompt() string {
+	ps2, found := ctx.GetVar("PS2")
+	if !found {
+		return defaultSecondaryPrompt
+	}
+	return ctx.ExpandPromptEscapes(ps2)
+}
+
+// ReadCommand reads lines until they form a complete command: as long as a
+// line ends in a backslash or leaves a quote, a $( or a trailing | or && open,
+// the next line is read as its continuation. readLine is told whether it is
+// reading such a continuation. When readLine fails, what was read so far is
+// returned along with the error.
+func (ctx *ShellCtx) ReadCommand(readLine func(continued bool) (string, error)) (string, error) {
+	command := ""
+	for continued := false; ; continued = true {
+		line, err := readLine(continued)
+		if err != nil {
+			return command, err
+		}
+		if continued {
+			command += "\n"
+		}
+		command += line
+		if endsWithLineContinuation(command) {
+			continue
+		}
+		if _, err := ParseCommandList(command, ctx.Aliases); !IsIncomplete(err) {
+			return command, nil
+		}
+	}
+}
+
+// endsWithLineContinuation tells whether the input ends in a backslash that
+// isn't itself escaped. Inside single quotes the parse is incomplete anyway.
+func endsWithLineContinuation(input string) bool {
+	trimmed := strings.TrimRight(input, "\\")
+	return (len(input)-len(trimmed))%2 == 1
+}
+
+// readInputLine reads a line from a command's standard input one byte at a
+// time, so that nothing after the newline is consumed and what follows is
+// left for the next reader. A last line without a newline is still
+// returned; io.EOF only comes when there was nothing left to read.
+func readInputLine(reader io.Reader) (string, error) {
+	line := []byte{}
+	buffer := make([]byte, 1)
+	for {
+		n, err := reader.Read(buffer)
+		if n == 1 {
+			if buffer[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, buffer[0])
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return string(line), nil
+			}
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEndsWithLineContinuation(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{`echo a \`, true},
+		{`echo a \\`, false},
+		{`echo a \\\`, true},
+		{`echo a`, false},
+		{``, false},
+	}
+	for _, test := range tests {
+		if got := endsWithLineContinuation(test.input); got != test.want {
+			t.Errorf("endsWithLineContinuation(%q) = %v", test.input, got)
+		}
+	}
+}
+
+func TestReadCommandPrompts(t *testing.T) {
+	tests := []struct {
+		lines   []string
+		prompts []string
+		output  string
+	}{
+		{[]string{"echo one \\", "two"}, []string{"$ ", "> "}, "one two\n"},
+		{[]string{`echo "a`, "b", `c"`}, []string{"$ ", "> ", "> "}, "a\nb\nc\n"},
+		{[]string{"echo $(echo x", ")"}, []string{"$ ", "> "}, "x\n"},
+		{[]string{"true &&", "echo and"}, []string{"$ ", "> "}, "and\n"},
+		{[]string{"echo piped |", "cat"}, []string{"$ ", "> "}, "piped\n"},
+		{[]string{"echo done"}, []string{"$ "}, "done\n"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("PS2", "> ")
+		prompts := []string{}
+		next := 0
+		command, err := shellCtx.ReadCommand(func(continued bool) (string, error) {
+			if continued {
+				prompts = append(prompts, shellCtx.SecondaryPrompt())
+			} else {
+				prompts = append(prompts, "$ ")
+			}
+			next++
+			return test.lines[next-1], nil
+		})
+		if err != nil || !slices.Equal(prompts, test.prompts) {
+			t.Errorf("%q: prompts %q, %v", test.lines, prompts, err)
+			continue
+		}
+		if output, _ := runShell(t, shellCtx, command); output != test.output {
+			t.Errorf("%q: got %q, want %q", test.lines, output, test.output)
+		}
+	}
+}
+
+func TestSecondaryPrompt(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.UnsetVar("PS2")
+	if got := shellCtx.SecondaryPrompt(); got != defaultSecondaryPrompt {
+		t.Errorf("default PS2: got %q", got)
+	}
+	shellCtx.SetVar("PS2", `\L+ `)
+	shellCtx.SetVar("SHLVL", "2")
+	if got := shellCtx.SecondaryPrompt(); got != "2+ " {
+		t.Errorf("PS2 with escapes: got %q", got)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// errProcessStopped is what waiting on a process that is part of a
+// foreground job returns when the process gets stopped, by Ctrl-Z or a
+// signal, instead of exiting.
+var errProcessStopped = errors.New("process stopped")
+
+// ProcessGroup gathers the processes of a foreground pipeline when job
+// control is on. The first one started leads the group and is given the
+// terminal, the others join it, so that the signals the terminal sends
+// reach the whole pipeline and not the shell.
+type ProcessGroup struct {
+	mu      sync.Mutex
+	tty     int
+	command string
+	pgid    int
+	// job is the job the pipeline becomes once it's stopped, and running
+	// counts its processes that haven't exited yet.
+	job     *Job
+	running int
+}
+
+// jobControl reports whether foreground pipelines get process groups of
+// their own: in an interactive shell on a terminal, with the monitor
+// option on.
+func (ctx *ShellCtx) jobControl() bool {
+	return ctx.Interactive && !ctx.Background && ctx.Options["monitor"] && ctx.Terminal.IsTerminal()
+}
+
+func (group *ProcessGroup) start(cmd *exec.Cmd) error {
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	// A group lives as long as one of its processes does, so a stage may
+	// have to start a new one when the earlier stages are all gone.
+	if group.pgid != 0 && syscall.Kill(-group.pgid, 0) == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: group.pgid}
+		return cmd.Start()
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Foreground: true, Ctty: group.tty}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	group.pgid = cmd.Process.Pid
+	return nil
+}
+
+// stopped turns the pipeline into a stopped job, if it isn't one already,
+// and keeps waiting for the process in the background.
+func (group *ProcessGroup) stopped(ctx *ShellCtx, process Process) {
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	if group.job == nil {
+		group.job = ctx.Jobs.Add(group.command)
+		ctx.Jobs.SetState(group.job, JobStopped)
+	}
+	job := group.job
+	ctx.Jobs.AddPid(job, process.Pid())
+	group.running++
+
+	go func() {
+		status, err := process.Wait()
+		for errors.Is(err, errProcessStopped) {
+			ctx.Jobs.SetState(job, JobStopped)
+			status, err = process.Wait()
+		}
+		ctx.Jobs.ProcessExited(job, process.Pid(), status)
+		group.mu.Lock()
+		group.running--
+		last := group.running == 0
+		group.mu.Unlock()
+		if last {
+			ctx.Jobs.Finish(job, status)
+		}
+	}()
+}
+
+// Linux si_code values of a SIGCHLD siginfo.
+const (
+	cldStopped = 5
+)
+
+// siginfo is the start of the Linux siginfo_t, as filled by waitid for a
+// child.
+type siginfo struct {
+	signo  int32
+	errno  int32
+	code   int32
+	_      int32
+	pid    int32
+	uid    uint32
+	status int32
+	_      [100]byte
+}
+
+// waitUntraced blocks until the process exits or stops. A stop is consumed
+// and reported as the status of the stopping signal; an exit is left for
+// the caller to reap.
+func waitUntraced(pid int) (status int, stopped bool) {
+	for {
+		info := siginfo{}
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, 1, uintptr(pid), uintptr(unsafe.Pointer(&info)),
+			syscall.WEXITED|syscall.WSTOPPED|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 || info.code != cldStopped {
+			return 0, false
+		}
+		syscall.Syscall6(syscall.SYS_WAITID, 1, uintptr(pid), uintptr(unsafe.Pointer(&info)),
+			syscall.WSTOPPED|syscall.WNOHANG, 0, 0)
+		return 128 + int(info.status), true
+	}
+}
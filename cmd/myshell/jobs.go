@@ -0,0 +1,769 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
+)
+
+func (state JobState) String() string {
+	switch state {
+	case JobDone:
+		return "Done"
+	case JobStopped:
+		return "Stopped"
+	}
+	return "Running"
+}
+
+// signalDescriptions are how jobs describe the signals that commonly end a
+// job.
+var signalDescriptions = map[syscall.Signal]string{
+	syscall.SIGHUP: "Hangup", syscall.SIGINT: "Interrupt", syscall.SIGQUIT: "Quit",
+	syscall.SIGKILL: "Killed", syscall.SIGPIPE: "Broken pipe", syscall.SIGTERM: "Terminated",
+	syscall.SIGUSR1: "User defined signal 1", syscall.SIGUSR2: "User defined signal 2",
+}
+
+type Job struct {
+	Id      int
+	Command string
+	// Pid is what $! gives for the job: its last process, or for a job the
+	// shell runs itself, without one to begin with, a number above those
+	// the kernel hands out.
+	Pid    int
+	Pids   []int
+	State  JobState
+	Status int
+	// NoHup jobs stay in the table but aren't sent SIGHUP by the shell.
+	NoHup bool
+
+	started   chan struct{}
+	isStarted bool
+	done      chan struct{}
+	// interrupt is set to a signal sent to the job by its Pid, which ends
+	// the commands the shell runs for it.
+	interrupt *atomic.Int32
+	// statuses are the exit statuses of the job's processes reaped so far.
+	statuses map[int]int
+}
+
+// markStarted must be called with the job table lock held.
+func (job *Job) markStarted() {
+	if !job.isStarted {
+		job.isStarted = true
+		close(job.started)
+	}
+}
+
+type JobTable struct {
+	mu   sync.Mutex
+	jobs []*Job
+	// exited keeps the statuses of the processes of jobs that have left the
+	// table, so wait can still report them.
+	exited map[int]int
+	// lastSynthetic numbers the Pids of the jobs without a process.
+	lastSynthetic int
+}
+
+func (table *JobTable) Add(command string) *Job {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	id := 1
+	for _, job := range table.jobs {
+		id = max(id, job.Id+1)
+	}
+	job := &Job{Id: id, Command: command, started: make(chan struct{}), done: make(chan struct{}), interrupt: &atomic.Int32{}, statuses: map[int]int{}}
+	table.jobs = append(table.jobs, job)
+	return job
+}
+
+// syntheticPidBase is above the largest pid_max Linux allows, so that the
+// Pid of a job the shell runs itself is never a process's.
+const syntheticPidBase = 1 << 22
+
+// Announce settles which Pid the job is known by and returns it. With
+// waitStart, that is once the job started a process.
+func (table *JobTable) Announce(job *Job, waitStart bool) int {
+	if waitStart {
+		<-job.started
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if len(job.Pids) > 0 {
+		job.Pid = job.Pids[len(job.Pids)-1]
+	} else {
+		table.lastSynthetic++
+		job.Pid = syntheticPidBase + table.lastSynthetic
+	}
+	return job.Pid
+}
+
+func (table *JobTable) AddPid(job *Job, pid int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	job.Pids = append(job.Pids, pid)
+	job.markStarted()
+}
+
+// ProcessExited records the status of one of the job's processes once it
+// has been reaped.
+func (table *JobTable) ProcessExited(job *Job, pid int, status int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	job.statuses[pid] = status
+}
+
+func (table *JobTable) Finish(job *Job, status int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	job.State = JobDone
+	job.Status = status
+	job.markStarted()
+	close(job.done)
+}
+
+// FindPid returns the job that started the process pid.
+func (table *JobTable) FindPid(pid int) (*Job, bool) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, job := range table.jobs {
+		if job.Pid == pid {
+			return job, true
+		}
+		for _, jobPid := range job.Pids {
+			if jobPid == pid {
+				return job, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Jobs returns the jobs currently in the table.
+func (table *JobTable) Jobs() []*Job {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return append([]*Job{}, table.jobs...)
+}
+
+func (table *JobTable) Remove(job *Job) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for i, other := range table.jobs {
+		if other == job {
+			table.jobs = append(table.jobs[:i], table.jobs[i+1:]...)
+			table.keepStatuses(job)
+			return
+		}
+	}
+}
+
+// keepStatuses must be called with the job table lock held.
+func (table *JobTable) keepStatuses(job *Job) {
+	if table.exited == nil {
+		table.exited = map[int]int{}
+	}
+	for pid, status := range job.statuses {
+		table.exited[pid] = status
+	}
+	if job.Pid >= syntheticPidBase {
+		table.exited[job.Pid] = job.Status
+	}
+}
+
+// WaitPid waits for the process pid of a background job and returns its
+// status. A process whose job already left the table is reported, and
+// forgotten, only once.
+func (table *JobTable) WaitPid(pid int) (int, bool) {
+	job, found := table.FindPid(pid)
+	if !found {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		status, found := table.exited[pid]
+		delete(table.exited, pid)
+		return status, found
+	}
+	jobStatus := table.Wait(job)
+	table.Remove(job)
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	delete(table.exited, pid)
+	if status, found := job.statuses[pid]; found {
+		return status, true
+	}
+	return jobStatus, true
+}
+
+func (table *JobTable) State(job *Job) JobState {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return job.State
+}
+
+func (table *JobTable) SetState(job *Job, state JobState) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if job.State != JobDone {
+		job.State = state
+	}
+}
+
+// Describe renders a job as jobs lists it.
+func (table *JobTable) Describe(job *Job) string {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for i, other := range table.jobs {
+		if other == job {
+			return formatJob(job, jobMarker(i, len(table.jobs)))
+		}
+	}
+	return formatJob(job, " ")
+}
+
+func (table *JobTable) SetNoHup(job *Job) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	job.NoHup = true
+}
+
+// Wait blocks until job finishes and returns its exit status.
+func (table *JobTable) Wait(job *Job) int {
+	<-job.done
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return job.Status
+}
+
+// Find resolves a job spec: %n for job n, %% or %+ for the current job, the
+// most recent one, %- for the one before it, %string for the job whose
+// command starts with string and %?string for the one that contains it.
+func (table *JobTable) Find(spec string) (*Job, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	ref := strings.TrimPrefix(spec, "%")
+	switch ref {
+	case "", "%", "+":
+		if len(table.jobs) > 0 {
+			return table.jobs[len(table.jobs)-1], nil
+		}
+		return nil, fmt.Errorf("%s: no current job", spec)
+	case "-":
+		if len(table.jobs) > 1 {
+			return table.jobs[len(table.jobs)-2], nil
+		}
+		if len(table.jobs) == 1 {
+			return table.jobs[0], nil
+		}
+		return nil, fmt.Errorf("%s: no current job", spec)
+	}
+	if id, err := strconv.Atoi(ref); err == nil {
+		for _, job := range table.jobs {
+			if job.Id == id {
+				return job, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	var found *Job
+	for _, job := range table.jobs {
+		matches := strings.HasPrefix(job.Command, ref)
+		if text, contains := strings.CutPrefix(ref, "?"); contains {
+			matches = strings.Contains(job.Command, text)
+		}
+		if !matches {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+		}
+		found = job
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+// Pids returns the processes started for a job so far.
+func (table *JobTable) Pids(job *Job) []int {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return append([]int{}, job.Pids...)
+}
+
+func (table *JobTable) LastPid(job *Job) int {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if len(job.Pids) == 0 {
+		return 0
+	}
+	return job.Pids[len(job.Pids)-1]
+}
+
+// Snapshot returns copies of the jobs, safe to read while jobs keep running.
+func (table *JobTable) Snapshot() []Job {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	jobs := make([]Job, 0, len(table.jobs))
+	for _, job := range table.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// Report drops the finished jobs from the table and returns them, along
+// with the running ones when all is set, as jobs lists them.
+func (table *JobTable) Report(all bool) []string {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	lines := []string{}
+	running := []*Job{}
+	for i, job := range table.jobs {
+		if job.State == JobDone || all {
+			lines = append(lines, formatJob(job, jobMarker(i, len(table.jobs))))
+		}
+		if job.State != JobDone {
+			running = append(running, job)
+		} else {
+			table.keepStatuses(job)
+		}
+	}
+	table.jobs = running
+	return lines
+}
+
+// jobMarker flags the current job, the most recent one, with + and the one
+// before it with -.
+func jobMarker(index, count int) string {
+	switch index {
+	case count - 1:
+		return "+"
+	case count - 2:
+		return "-"
+	}
+	return " "
+}
+
+func formatJob(job *Job, marker string) string {
+	state := job.State.String()
+	if job.State == JobDone && job.Status != 0 {
+		state = fmt.Sprintf("Exit %d", job.Status)
+		if description, found := signalDescriptions[syscall.Signal(job.Status-128)]; found {
+			state = description
+		}
+	}
+	return fmt.Sprintf("[%d]%s  %-24s%s\n", job.Id, marker, state, job.Command)
+}
+
+// NotifyJobs reports the background jobs that finished since the last
+// report. An interactive shell does so before each prompt, or as soon as
+// a job finishes when the notify option is set.
+func (ctx *ShellCtx) NotifyJobs() {
+	for _, line := range ctx.Jobs.Report(false) {
+		io.WriteString(ctx.Output, line)
+	}
+}
+
+// StartBackgroundJob runs an and-or list asynchronously in a copy of the
+// shell context. Unless the terminal has tostop set, the job's output is funneled
+// through the output guard so it can't splice into the line being typed.
+func (ctx *ShellCtx) StartBackgroundJob(andOr *AndOrList) {
+	job := ctx.Jobs.Add(andOr.Source + " &")
+	jobCtx := ctx.Clone()
+	jobCtx.Background = true
+	jobCtx.Group = nil
+	jobCtx.OnProcessStart = func(pid int) { ctx.Jobs.AddPid(job, pid) }
+	jobCtx.OnProcessExit = func(pid, status int) { ctx.Jobs.ProcessExited(job, pid, status) }
+
+	stdin, err := os.Open(os.DevNull)
+	ownStdin := err == nil
+	if !ownStdin {
+		stdin = ctx.Streams.Stdin
+	}
+	jobCtx.Streams = Streams{Stdin: stdin, Stdout: ctx.Streams.Stdout, Stderr: ctx.Streams.Stderr, Extra: ctx.Streams.Extra}
+
+	var guarded *os.File
+	if ctx.Streams.Stdout == os.Stdout && ctx.Terminal.IsTerminal() && !ctx.Terminal.OutputStops() {
+		reader, writer, err := os.Pipe()
+		if err == nil {
+			guarded = writer
+			jobCtx.Streams.Stdout = writer
+			if ctx.Streams.Stderr == os.Stderr {
+				jobCtx.Streams.Stderr = writer
+			}
+			go ctx.Output.Forward(reader)
+		}
+	}
+
+	jobCtx.interrupt = job.interrupt
+	go func() {
+		jobCtx.RunAndOr(andOr)
+		status := jobCtx.LastStatus
+		if sig := job.interrupt.Load(); sig != 0 {
+			status = 128 + int(sig)
+		}
+		jobCtx.leaveSubshell()
+		if guarded != nil {
+			guarded.Close()
+		}
+		if ownStdin {
+			stdin.Close()
+		}
+		ctx.Jobs.Finish(job, status)
+		if ctx.Interactive && ctx.Options["notify"] {
+			ctx.NotifyJobs()
+		}
+	}()
+
+	// Waiting for a job made of builtins and compound commands to start a
+	// process might never end, so it's given a Pid of its own.
+	ctx.LastBackgroundPid = ctx.Jobs.Announce(job, ctx.startsProcess(andOr))
+	fmt.Fprintf(ctx.Streams.Stderr, "[%d] %d\n", job.Id, ctx.LastBackgroundPid)
+}
+
+// startsProcess reports whether a job starts with a command the shell
+// starts a process for right away: a simple one found in PATH, without
+// command substitutions to wait for first.
+func (ctx *ShellCtx) startsProcess(andOr *AndOrList) bool {
+	command, ok := andOr.Pipelines[0].Commands[0].(*SimpleCommand)
+	if !ok {
+		return false
+	}
+	words := command.Words
+	for len(words) > 0 {
+		if _, ok := ParseAssignmentWord(words[0]); !ok {
+			break
+		}
+		words = words[1:]
+	}
+	for _, word := range command.Words {
+		if strings.Contains(word, "$(") || strings.ContainsAny(word, "`") {
+			return false
+		}
+	}
+	for _, redirect := range command.Redirects {
+		if strings.Contains(redirect.Target, "$(") || strings.ContainsAny(redirect.Target, "`") {
+			return false
+		}
+	}
+	if len(words) == 0 || strings.ContainsAny(words[0], "$'\"\\*?[~") {
+		return false
+	}
+	if _, found := ctx.Builtins[words[0]]; found {
+		return false
+	}
+	if _, found := ctx.Functions[words[0]]; found {
+		return false
+	}
+	_, found := ctx.Runner.LookPath(ctx, words[0])
+	return found
+}
+
+func backgroundProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+func JobsExecutor(shellCtx *ShellCtx, _ []string) error {
+	shellCtx.Sout = strings.Join(shellCtx.Jobs.Report(true), "")
+	return nil
+}
+
+// HangupJobs sends SIGHUP to the jobs in the table, except those marked
+// with disown -h, and continues the stopped ones so they get it.
+func (ctx *ShellCtx) HangupJobs() {
+	for _, job := range ctx.Jobs.Snapshot() {
+		if job.State == JobDone || job.NoHup {
+			continue
+		}
+		for _, pid := range job.Pids {
+			syscall.Kill(pid, syscall.SIGHUP)
+			if job.State == JobStopped {
+				syscall.Kill(pid, syscall.SIGCONT)
+			}
+		}
+	}
+}
+
+// parseSignal reads a signal given by number or by name, with or without
+// SIG and in any case.
+func parseSignal(spec string) (syscall.Signal, bool) {
+	if number, err := strconv.Atoi(spec); err == nil {
+		return syscall.Signal(number), number >= 0 && number < 65
+	}
+	sig, found := signalNumbers[strings.TrimPrefix(strings.ToUpper(spec), "SIG")]
+	return sig, found
+}
+
+// KillExecutor implements `kill [-s sig | -n num | -sig] pid | %job ...` and
+// `kill -l [status ...]`. A job is signaled through every process it
+// started. The default signal is TERM.
+func KillExecutor(shellCtx *ShellCtx, args []string) error {
+	sig := syscall.SIGTERM
+	if len(args) > 0 && args[0] == "-l" {
+		if len(args) == 1 {
+			shellCtx.Sout = listSignals()
+			return nil
+		}
+		for _, arg := range args[1:] {
+			number, err := strconv.Atoi(arg)
+			if err == nil && number > 128 {
+				// An exit status tells the signal that ended a command.
+				number -= 128
+			}
+			name, found := signalName(syscall.Signal(number))
+			if err != nil {
+				var parsed syscall.Signal
+				if parsed, found = parseSignal(arg); found {
+					name = strconv.Itoa(int(parsed))
+				}
+			}
+			if !found {
+				shellCtx.Serr += fmt.Sprintf("kill: %s: invalid signal specification\n", arg)
+				shellCtx.Status = 1
+				continue
+			}
+			shellCtx.Sout += name + "\n"
+		}
+		return nil
+	}
+	if len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' && args[0] != "--" {
+		spec := args[0][1:]
+		args = args[1:]
+		if spec == "s" || spec == "n" {
+			if len(args) == 0 {
+				return fmt.Errorf("kill command -%s requires a signal", spec)
+			}
+			spec, args = args[0], args[1:]
+		}
+		parsed, found := parseSignal(spec)
+		if !found {
+			shellCtx.Serr = fmt.Sprintf("kill: %s: invalid signal specification\n", spec)
+			shellCtx.Status = 1
+			return nil
+		}
+		sig = parsed
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("kill command takes at least 1 pid or job spec")
+	}
+
+	for _, target := range args {
+		pids := []int{}
+		resume := false
+		if strings.HasPrefix(target, "%") {
+			job, err := shellCtx.Jobs.Find(target)
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("kill: %s\n", err.Error())
+				shellCtx.Status = 1
+				continue
+			}
+			pids = shellCtx.Jobs.Pids(job)
+			if shellCtx.Jobs.Interrupt(job, sig) {
+				continue
+			}
+			if len(pids) == 0 {
+				shellCtx.Serr += fmt.Sprintf("kill: %s: no processes to signal\n", target)
+				shellCtx.Status = 1
+				continue
+			}
+			// A stopped job only gets most signals once it's continued.
+			resume = shellCtx.Jobs.State(job) == JobStopped && sig != syscall.SIGKILL && sig != syscall.SIGSTOP
+			if sig == syscall.SIGCONT {
+				shellCtx.Jobs.SetState(job, JobRunning)
+			}
+		} else {
+			pid, err := strconv.Atoi(target)
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("kill: %s: arguments must be process or job IDs\n", target)
+				shellCtx.Status = 1
+				continue
+			}
+			if job, found := shellCtx.Jobs.FindPid(pid); found && pid >= syntheticPidBase {
+				if !shellCtx.Jobs.Interrupt(job, sig) {
+					shellCtx.Serr += fmt.Sprintf("kill: (%d) - %s\n", pid, describeKillError(syscall.ESRCH))
+					shellCtx.Status = 1
+				}
+				continue
+			}
+			pids = append(pids, pid)
+		}
+		for _, pid := range pids {
+			var err error
+			if pid == os.Getpid() {
+				err = shellCtx.Traps.SignalShell(sig)
+			} else {
+				err = syscall.Kill(pid, sig)
+			}
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("kill: (%d) - %s\n", pid, describeKillError(err))
+				shellCtx.Status = 1
+				continue
+			}
+			if resume && sig != syscall.SIGCONT {
+				syscall.Kill(pid, syscall.SIGCONT)
+			}
+		}
+	}
+	return nil
+}
+
+// Interrupt passes sig on to a job the shell runs itself, by ending the
+// commands it runs when the signal would end a process, and to the
+// processes it started. It reports whether the job is one such that is
+// still running.
+func (table *JobTable) Interrupt(job *Job, sig syscall.Signal) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if job.Pid < syntheticPidBase || job.State == JobDone {
+		return false
+	}
+	switch sig {
+	case 0, syscall.SIGCHLD, syscall.SIGCONT, syscall.SIGSTOP, syscall.SIGTSTP,
+		syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGURG, syscall.SIGWINCH:
+	default:
+		job.interrupt.CompareAndSwap(0, int32(sig))
+	}
+	for _, pid := range job.Pids {
+		if _, exited := job.statuses[pid]; !exited {
+			syscall.Kill(pid, sig)
+		}
+	}
+	return true
+}
+
+func describeKillError(err error) string {
+	switch err {
+	case syscall.ESRCH:
+		return "No such process"
+	case syscall.EPERM:
+		return "Operation not permitted"
+	}
+	return err.Error()
+}
+
+// WaitExecutor implements `wait [pid | %job ...]`. It waits for each job
+// given, or for every background job without arguments, and removes it from
+// the job table. The status is the last awaited job's, or 0 when waiting
+// for all of them.
+func WaitExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, job := range shellCtx.Jobs.Jobs() {
+			shellCtx.Jobs.Wait(job)
+			shellCtx.Jobs.Remove(job)
+		}
+		return nil
+	}
+
+	for _, target := range args {
+		var job *Job
+		if strings.HasPrefix(target, "%") {
+			found, err := shellCtx.Jobs.Find(target)
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("wait: %s\n", err.Error())
+				shellCtx.Status = 127
+				continue
+			}
+			job = found
+		} else {
+			pid, err := strconv.Atoi(target)
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("wait: `%s': not a pid or valid job spec\n", target)
+				shellCtx.Status = 2
+				continue
+			}
+			status, found := shellCtx.Jobs.WaitPid(pid)
+			if !found {
+				shellCtx.Serr += fmt.Sprintf("wait: pid %d is not a child of this shell\n", pid)
+				status = 127
+			}
+			shellCtx.Status = status
+			continue
+		}
+		shellCtx.Status = shellCtx.Jobs.Wait(job)
+		shellCtx.Jobs.Remove(job)
+	}
+	return nil
+}
+
+// DisownExecutor implements `disown [-ahr] [%job ...]`, dropping jobs, the
+// current one by default, from the job table so the shell no longer
+// reports or signals them. -a takes every job, -r only the running ones,
+// and -h keeps the jobs in the table but spares them the SIGHUP sent when
+// the shell exits.
+func DisownExecutor(shellCtx *ShellCtx, args []string) error {
+	all, running, keep := false, false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 'a':
+				all = true
+			case 'r':
+				running = true
+			case 'h':
+				keep = true
+			default:
+				return fmt.Errorf("disown command got invalid option -%c", flag)
+			}
+		}
+	}
+
+	jobs := []*Job{}
+	switch {
+	case len(args) > 0:
+		for _, target := range args {
+			job, err := shellCtx.Jobs.Find(target)
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("disown: %s\n", err.Error())
+				shellCtx.Status = 1
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+	case all || running:
+		jobs = shellCtx.Jobs.Jobs()
+	default:
+		job, err := shellCtx.Jobs.Find("%+")
+		if err != nil {
+			shellCtx.Serr = "disown: current: no such job\n"
+			shellCtx.Status = 1
+			return nil
+		}
+		jobs = append(jobs, job)
+	}
+
+	for _, job := range jobs {
+		if running && shellCtx.Jobs.State(job) != JobRunning {
+			continue
+		}
+		if keep {
+			shellCtx.Jobs.SetNoHup(job)
+		} else {
+			shellCtx.Jobs.Remove(job)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBackgroundJobs(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"builtin job", "echo hi & wait $!; echo $?", "hi\n0\n"},
+		{"status of a compound job", "(exit 3) & wait $!; echo $?", "3\n"},
+		{"endless job killed", "while :; do :; done & kill $!; wait $!; echo $?", "143\n"},
+		{"job killed by number", "{ sleep 5; echo never; } & kill %1; wait %1; echo $?", "143\n"},
+		{"process job", "sleep 0 & [ $! -lt 4194304 ] && echo process; wait", "process\n"},
+		{"gone job", "kill -0 4194399 || echo gone", "gone\n"},
+		{"disowned running job", "sleep 1 & disown -r; jobs", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shellCtx := NewShellCtx()
+			shellCtx.CurrentDir = t.TempDir()
+			if got, _ := runShell(t, shellCtx, test.script); got != test.want {
+				t.Errorf("%s: got %q, want %q", test.script, got, test.want)
+			}
+		})
+	}
+}
+
+func TestBackgroundPidsAreDistinct(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	got, _ := runShell(t, shellCtx, "true & a=$!; true & b=$!; wait; echo $a $b")
+	pids := strings.Fields(got)
+	if len(pids) != 2 || pids[0] == pids[1] || pids[0] == "0" {
+		t.Errorf("got pids %q", got)
+	}
+}
+
+func TestKill(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"sleep 5 & kill -9 %1; wait %1; echo $?", "137\n"},
+		{"sleep 5 & kill -s HUP $!; wait $!; echo $?", "129\n"},
+		{"sleep 5 & kill -SIGINT %%; wait %%; echo $?", "130\n"},
+		{"sleep 5 & kill -n 15 %+; wait $!; echo $?", "143\n"},
+		{"sleep 5 & sleep 5 & kill %1 %2; wait %1; echo $?; wait %2; echo $?", "143\n143\n"},
+		{"sleep 5 & kill -0 $!; echo $?; kill $!", "0\n"},
+		{"kill -l | head -2", " 1) SIGHUP\n 2) SIGINT\n"},
+		{"kill -l 9 15 SIGTERM 137", "KILL\nTERM\n15\nKILL\n"},
+		{"kill -BOGUS $$; echo $?", "1\n"},
+		{"kill %9; echo $?", "1\n"},
+		{"kill; echo $?", "1\n"},
+	})
+}
+
+func TestWait(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"(exit 3) & (exit 4) & wait; echo $?", "0\n"},
+		{"(exit 3) & a=$!; (exit 4) & b=$!; wait $a $b; echo $?", "4\n"},
+		{"(exit 5) & wait %1; echo $?", "5\n"},
+		{"(exit 3) & p=$!; sleep 0.2; echo done; wait $p; echo $?", "done\n3\n"},
+		{"{ sleep 0.1; echo job; } & wait; echo after", "job\nafter\n"},
+		{"wait; echo $?", "0\n"},
+		{"wait 4194399; echo $?", "127\n"},
+		{"wait %5; echo $?", "127\n"},
+		{"wait abc; echo $?", "2\n"},
+	})
+}
+
+func TestNotifyJobs(t *testing.T) {
+	shellCtx := NewShellCtx()
+	output := &strings.Builder{}
+	shellCtx.Output = NewOutputGuard(output)
+	jobs := shellCtx.Jobs
+	done, failed, killed, running := jobs.Add("true &"), jobs.Add("false &"), jobs.Add("sleep 9 &"), jobs.Add("sleep 5 &")
+	jobs.Finish(done, 0)
+	jobs.Finish(failed, 1)
+	jobs.Finish(killed, 128+int(syscall.SIGKILL))
+
+	shellCtx.NotifyJobs()
+	want := "[1]   Done                    true &\n" +
+		"[2]   Exit 1                  false &\n" +
+		"[3]-  Killed                  sleep 9 &\n"
+	if got := output.String(); got != want {
+		t.Errorf("first report: got %q, want %q", got, want)
+	}
+	// Reported jobs are gone; the running one is only reported once done.
+	output.Reset()
+	shellCtx.NotifyJobs()
+	if got := output.String(); got != "" {
+		t.Errorf("second report: got %q", got)
+	}
+	jobs.Finish(running, 0)
+	shellCtx.NotifyJobs()
+	if want := "[4]+  Done                    sleep 5 &\n"; output.String() != want {
+		t.Errorf("last report: got %q, want %q", output.String(), want)
+	}
+}
+
+func TestJobsListing(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"sleep 0.1 & (exit 3) & sleep 5 & sleep 0.3; jobs; jobs; kill %3",
+			"[1]   Done                    sleep 0.1 &\n[2]-  Exit 3                  (exit 3) &\n[3]+  Running                 sleep 5 &\n[3]+  Running                 sleep 5 &\n"},
+		{"set -b; set -o | grep notify; set +b; set -o | grep notify", "notify         \ton\nnotify         \toff\n"},
+	})
+}
+
+func TestWaitPidStatuses(t *testing.T) {
+	jobs := &JobTable{}
+	job := jobs.Add("a | b &")
+	jobs.AddPid(job, 101)
+	jobs.AddPid(job, 102)
+	jobs.ProcessExited(job, 101, 3)
+	jobs.ProcessExited(job, 102, 4)
+	jobs.Announce(job, false)
+	jobs.Finish(job, 4)
+	jobs.Report(false)
+
+	// The job left the table with its report, but its processes' statuses
+	// can each be waited for once.
+	tests := []struct {
+		pid    int
+		status int
+		found  bool
+	}{
+		{101, 3, true},
+		{102, 4, true},
+		{101, 0, false},
+		{103, 0, false},
+	}
+	for _, test := range tests {
+		if status, found := jobs.WaitPid(test.pid); status != test.status || found != test.found {
+			t.Errorf("WaitPid(%d) = %d, %v, want %d, %v", test.pid, status, found, test.status, test.found)
+		}
+	}
+}
+
+func TestBackgroundProcessesAreReaped(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	got, _ := runShell(t, shellCtx, "sleep 0.05 & echo $!")
+	pid := strings.TrimSpace(got)
+	// Nothing waits for the job, yet its process must not linger as a zombie.
+	for range 100 {
+		if _, err := os.Stat("/proc/" + pid); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	stat, _ := os.ReadFile("/proc/" + pid + "/stat")
+	t.Errorf("process %s not reaped: %s", pid, stat)
+}
+
+func TestHangupJobs(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	runShell(t, shellCtx, "sleep 5 & sleep 5 & disown -h %2; (exit 2) & wait %3")
+	shellCtx.HangupJobs()
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"wait %1; echo $?", "129\n"},
+		{"kill -0 %2 && echo spared; kill %2; wait %2; echo $?", "spared\n143\n"},
+	}
+	for _, test := range tests {
+		if got, _ := runShell(t, shellCtx, test.line); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+// processGone reports whether pid ended, waiting a little for it to: the
+// process is either gone or a zombie nobody reaped.
+func processGone(pid string) bool {
+	for range 25 {
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil {
+			return true
+		}
+		if fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:])); len(fields) > 0 && fields[0] == "Z" {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestHuponexit(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		hupped bool
+	}{
+		{"jobs kept by default", "sleep 5 > /dev/null 2>&1 &\necho pid=$!\nexit\n", false},
+		{"jobs hung up with huponexit", "shopt -s huponexit\nsleep 5 > /dev/null 2>&1 &\necho pid=$!\nexit\n", true},
+		{"job disowned with -h", "shopt -s huponexit\nsleep 5 > /dev/null 2>&1 &\necho pid=$!\ndisown -h\nexit\n", false},
+	}
+	for _, test := range tests {
+		cmd := exec.Command(os.Args[0])
+		cmd.Env = append(os.Environ(), "MYSHELL_TEST_MAIN=1", "HOME="+t.TempDir())
+		cmd.Dir = t.TempDir()
+		cmd.Stdin = strings.NewReader(test.script)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		_, pid, found := strings.Cut(strings.TrimSpace(string(output)), "pid=")
+		if !found {
+			t.Fatalf("%s: no pid in %q", test.name, output)
+		}
+		pid, _, _ = strings.Cut(pid, "\n")
+		if hupped := processGone(pid); hupped != test.hupped {
+			t.Errorf("%s: job hung up %v, want %v", test.name, hupped, test.hupped)
+		}
+		if n, err := strconv.Atoi(pid); err == nil {
+			syscall.Kill(n, syscall.SIGKILL)
+		}
+	}
+}
+
+func TestHangupPassedOnToJobs(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "MYSHELL_TEST_MAIN=1", "HOME="+t.TempDir())
+	cmd.Dir = t.TempDir()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	io.WriteString(stdin, "sleep 5 > /dev/null 2>&1 &\necho pid=$!\n")
+	output := []byte{}
+	buffer := make([]byte, 256)
+	for !bytes.Contains(output, []byte("\n")) {
+		n, err := stdout.Read(buffer)
+		if err != nil {
+			t.Fatalf("reading the pid: %v, got %q", err, output)
+		}
+		output = append(output, buffer[:n]...)
+	}
+	_, pid, _ := strings.Cut(string(output), "pid=")
+	pid, _, _ = strings.Cut(pid, "\n")
+
+	cmd.Process.Signal(syscall.SIGHUP)
+	err = cmd.Wait()
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || !status.Signaled() || status.Signal() != syscall.SIGHUP {
+		t.Errorf("shell ended with %v, want SIGHUP", err)
+	}
+	if !processGone(pid) {
+		t.Errorf("job %s not hung up", pid)
+	}
+	if n, err := strconv.Atoi(pid); err == nil {
+		syscall.Kill(n, syscall.SIGKILL)
+	}
+}
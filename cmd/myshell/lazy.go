@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// Lazy holds state that is too expensive to build before the first prompt,
+// like the completion index or a large history file. The value is built at
+// most once: either by Prefetch in the background once the shell is idle, or
+// by the first Get, which then waits for it.
+type Lazy[T any] struct {
+	once  sync.Once
+	load  func() T
+	value T
+}
+
+func NewLazy[T any](load func() T) *Lazy[T] {
+	return &Lazy[T]{load: load}
+}
+
+func (lazy *Lazy[T]) Get() T {
+	lazy.once.Do(func() {
+		lazy.value = lazy.load()
+		lazy.load = nil
+	})
+	return lazy.value
+}
+
+func (lazy *Lazy[T]) Prefetch() {
+	go lazy.Get()
+}
+
+// DeferPrefetch registers a lazy value to be prefetched once the first
+// prompt is up, so it is usually ready by the time the user needs it
+// without delaying startup.
+func (ctx *ShellCtx) DeferPrefetch(prefetch func()) {
+	ctx.prefetches = append(ctx.prefetches, prefetch)
+}
+
+func (ctx *ShellCtx) startPrefetches() {
+	for _, prefetch := range ctx.prefetches {
+		prefetch()
+	}
+	ctx.prefetches = nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLazyLoadsOnce(t *testing.T) {
+	loads := atomic.Int32{}
+	lazy := NewLazy(func() int {
+		loads.Add(1)
+		return 42
+	})
+	if loads.Load() != 0 {
+		t.Fatal("loaded before the first Get")
+	}
+	lazy.Prefetch()
+	group := sync.WaitGroup{}
+	for range 8 {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			if value := lazy.Get(); value != 42 {
+				t.Errorf("Get: got %d", value)
+			}
+		}()
+	}
+	group.Wait()
+	if loads.Load() != 1 {
+		t.Errorf("loaded %d times", loads.Load())
+	}
+}
+
+func TestDeferPrefetch(t *testing.T) {
+	shellCtx := NewShellCtx()
+	started := 0
+	shellCtx.DeferPrefetch(func() { started++ })
+	shellCtx.DeferPrefetch(func() { started++ })
+	if started != 0 {
+		t.Fatal("prefetch ran before the prompt")
+	}
+	shellCtx.startPrefetches()
+	shellCtx.startPrefetches()
+	if started != 2 {
+		t.Errorf("prefetches ran %d times, want 2", started)
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// errLineInterrupted is what the line editor returns when Ctrl-C discards
+// the line being typed.
+var errLineInterrupted = errors.New("interrupted")
+
+// LineEditor reads command lines from the terminal in raw mode, echoing and
+// editing them itself. It is the EditedLine the output guard redraws when
+// a background job prints.
+type LineEditor struct {
+	ctx   *ShellCtx
+	input *os.File
+	// pending holds bytes read from the terminal but not handled yet, like
+	// the lines of a paste after the first one.
+	pending []byte
+
+	buffer  *EditBuffer
+	browser *HistoryBrowser
+	search  *historySearch
+	// prefix is the Ctrl-X that started a two key binding, and
+	// editExternally is set once Ctrl-X Ctrl-E asked for the editor.
+	prefix         string
+	editExternally bool
+	prompt         string
+	continuation   string
+	// nextEntry is the history entry Ctrl-O asked to preload into the next
+	// prompt, -1 for none. It counts from the history's Base, so that it
+	// still finds the entry once the command run drops the oldest one.
+	nextEntry int
+	// cursorRow is the screen row of the cursor, counted from the first row
+	// of the drawing, and clearScreen asks the next drawing to start from a
+	// blank screen.
+	cursorRow   int
+	clearScreen bool
+	// bell rings the terminal bell with the next drawing, when Tab has
+	// nothing to add.
+	bell bool
+	// menu lists the candidates of an ambiguous completion below the line
+	// until a key other than Tab is pressed.
+	menu *completionMenu
+}
+
+// completionMenu holds the candidates Tab cycles through. selected is the
+// one inserted in place of the word, -1 before the first cycle, inserted
+// the text of the word now and typed what it was when the menu opened.
+type completionMenu struct {
+	candidates []string
+	word       completionWord
+	selected   int
+	inserted   string
+	typed      string
+}
+
+func NewLineEditor(ctx *ShellCtx, input *os.File) *LineEditor {
+	return &LineEditor{ctx: ctx, input: input, nextEntry: -1}
+}
+
+// ReadLine shows prompt and returns the line typed after it. Ctrl-C drops
+// the line with errLineInterrupted, and Ctrl-D on an empty line ends the
+// input with io.EOF.
+func (editor *LineEditor) ReadLine(prompt string) (string, error) {
+	if err := editor.ctx.Terminal.EnterRaw(); err != nil {
+		return "", err
+	}
+	defer editor.ctx.Terminal.Restore()
+
+	editor.buffer = NewEditBuffer()
+	editor.browser = editor.ctx.History.BrowseAt(editor.nextEntry-editor.ctx.History.Base, editor.buffer)
+	editor.nextEntry = -1
+	editor.search = nil
+	editor.prefix = ""
+	editor.editExternally = false
+	editor.menu = nil
+	editor.prompt = prompt
+	editor.continuation = editor.ctx.SecondaryPrompt()
+	editor.cursorRow = 0
+	guard := editor.ctx.Output
+	guard.ShowPrompt(prompt, editor)
+	for {
+		key, err := editor.readKey()
+		if err != nil {
+			guard.LeavePrompt()
+			return "", err
+		}
+		done := false
+		guard.Edit(func() { done, err = editor.handleKey(key) })
+		if !done {
+			continue
+		}
+		guard.LeavePrompt()
+		if errors.Is(err, errLineInterrupted) {
+			io.WriteString(os.Stdout, "^C")
+		}
+		io.WriteString(os.Stdout, "\r\n")
+		if editor.editExternally {
+			editor.ctx.Terminal.Restore()
+			return editor.editLine(), nil
+		}
+		return editor.buffer.String(), err
+	}
+}
+
+// editLine opens the line in $VISUAL or $EDITOR, and returns what was saved,
+// shown like fc shows the commands it runs. The line is dropped when the
+// editor fails.
+func (editor *LineEditor) editLine() string {
+	ctx := editor.ctx
+	lastStatus := ctx.LastStatus
+	defer func() { ctx.LastStatus = lastStatus }()
+	edited, status := ctx.editText(editor.buffer.String()+"\n", ctx.defaultEditor())
+	if status != 0 {
+		return ""
+	}
+	edited = strings.TrimRight(edited, "\n")
+	if edited != "" {
+		fmt.Fprintln(ctx.Streams.Stderr, edited)
+	}
+	return edited
+}
+
+// handleKey applies a key to the buffer. It reports true once the line is
+// finished, with an error when it was abandoned rather than entered.
+func (editor *LineEditor) handleKey(key string) (bool, error) {
+	if editor.search != nil && editor.handleSearchKey(key) {
+		return false, nil
+	}
+	if editor.prefix != "" {
+		key, editor.prefix = editor.prefix+key, ""
+	}
+	if editor.menu != nil {
+		switch key {
+		case "\t":
+			editor.cycleMenu(1)
+			return false, nil
+		case "<backtab>":
+			editor.cycleMenu(-1)
+			return false, nil
+		case "<esc>", "\x07":
+			editor.replaceWord(editor.menu.typed)
+			editor.menu = nil
+			return false, nil
+		}
+		editor.menu = nil
+	}
+	buffer := editor.buffer
+	switch key {
+	case "\x18":
+		editor.prefix = key
+	case "\x18\x05":
+		editor.editExternally = true
+		return true, nil
+	case "\r", "\n":
+		buffer.MoveToEnd()
+		return true, nil
+	case "\x0f":
+		if next, found := editor.browser.OperateAndGetNext(); found {
+			editor.nextEntry = editor.ctx.History.Base + next
+		}
+		buffer.MoveToEnd()
+		return true, nil
+	case "\x03":
+		buffer.MoveToEnd()
+		return true, errLineInterrupted
+	case "\x04":
+		if buffer.String() == "" {
+			return true, io.EOF
+		}
+		buffer.Delete()
+	case "<delete>":
+		buffer.Delete()
+	case "\x7f", "\x08":
+		buffer.Backspace()
+	case "\x01", "<home>":
+		buffer.MoveHome()
+	case "\x05", "<end>":
+		buffer.MoveEnd()
+	case "\x02", "<left>":
+		buffer.MoveLeft()
+	case "\x06", "<right>":
+		buffer.MoveRight()
+	case "<alt-b>":
+		buffer.MoveWordLeft()
+	case "<alt-f>":
+		buffer.MoveWordRight()
+	case "<up>", "\x10":
+		editor.browser.Up(buffer)
+	case "<down>", "\x0e":
+		editor.browser.Down(buffer)
+	case "\x0b":
+		buffer.KillToEnd()
+	case "\x15":
+		buffer.KillToStart()
+	case "\x17":
+		buffer.DeleteWordBackward()
+	case "\x0c":
+		editor.clearScreen = true
+	case "\t":
+		editor.complete()
+	case "\x12":
+		editor.search = &historySearch{index: len(editor.ctx.History.Entries), saved: buffer.String()}
+	default:
+		if r, _ := utf8.DecodeRuneInString(key); utf8.RuneCountInString(key) == 1 && r >= ' ' && r != 0x7f {
+			buffer.Insert(r)
+		}
+	}
+	return false, nil
+}
+
+// historySearch is the state of a Ctrl-R search: what is searched for, the
+// entry it was last found in and the line from before the search.
+type historySearch struct {
+	query  string
+	index  int
+	failed bool
+	saved  string
+}
+
+func (search *historySearch) prompt() string {
+	if search.failed {
+		return "(failed reverse-i-search)`" + search.query + "': "
+	}
+	return "(reverse-i-search)`" + search.query + "': "
+}
+
+// handleSearchKey applies a key during a Ctrl-R search: typing extends the
+// search, Ctrl-R looks for an older match, Esc or Ctrl-G cancels and any
+// other key takes the match and is then handled as usual, which is when it
+// reports false.
+func (editor *LineEditor) handleSearchKey(key string) bool {
+	search := editor.search
+	switch key {
+	case "\x12":
+		editor.findMatch(search.index - 1)
+	case "\x7f", "\x08":
+		if search.query != "" {
+			query := []rune(search.query)
+			search.query = string(query[:len(query)-1])
+			editor.findMatch(len(editor.ctx.History.Entries) - 1)
+		}
+	case "<esc>", "\x07":
+		editor.buffer.SetText(search.saved)
+		editor.search = nil
+	default:
+		if r, _ := utf8.DecodeRuneInString(key); utf8.RuneCountInString(key) == 1 && r >= ' ' && r != 0x7f {
+			search.query += key
+			editor.findMatch(search.index)
+			return true
+		}
+		if search.index < len(editor.ctx.History.Entries) {
+			editor.browser = editor.ctx.History.BrowseAt(search.index, editor.buffer)
+		}
+		editor.search = nil
+		return false
+	}
+	return true
+}
+
+// findMatch looks for the search text in the history, from entry from
+// back to the oldest one.
+func (editor *LineEditor) findMatch(from int) {
+	search := editor.search
+	entries := editor.ctx.History.Entries
+	for i := min(from, len(entries)-1); i >= 0; i-- {
+		if strings.Contains(entries[i], search.query) {
+			search.index = i
+			search.failed = false
+			editor.buffer.SetText(entries[i])
+			return
+		}
+	}
+	search.failed = true
+}
+
+// complete completes the word before the cursor: a single candidate
+// replaces it, followed by a space unless it's a directory, and several are
+// narrowed down to the text they all start with, when that is more than
+// what was typed, and listed in a menu that further Tabs cycle through.
+func (editor *LineEditor) complete() {
+	buffer := editor.buffer
+	before := buffer.BeforeCursor()
+	word := parseCompletionWord(before)
+	candidates, nospace := editor.ctx.Complete(word)
+	if len(candidates) == 0 {
+		editor.bell = true
+		return
+	}
+	typed := before[word.start:]
+	if len(candidates) > 1 {
+		if value := commonPrefix(candidates); editor.ctx.completionHasPrefix(value, word.value) && value != word.value {
+			typed = word.text(value, false)
+			editor.replaceWord(typed)
+		}
+		editor.menu = &completionMenu{candidates: candidates, word: word, selected: -1, inserted: typed, typed: typed}
+		return
+	}
+	value := candidates[0]
+	final := !strings.HasSuffix(value, "/")
+	completed := word.text(value, final)
+	if final && !nospace {
+		completed += " "
+	}
+	for range utf8.RuneCountInString(typed) {
+		buffer.Backspace()
+	}
+	buffer.InsertString(completed)
+}
+
+// cycleMenu inserts the next candidate of the menu, or the previous one.
+func (editor *LineEditor) cycleMenu(step int) {
+	menu := editor.menu
+	count := len(menu.candidates)
+	if menu.selected < 0 && step < 0 {
+		menu.selected = count - 1
+	} else {
+		menu.selected = (menu.selected + step + count) % count
+	}
+	candidate := menu.candidates[menu.selected]
+	editor.replaceWord(menu.word.text(candidate, !strings.HasSuffix(candidate, "/")))
+}
+
+// replaceWord puts text in place of the word the menu completes.
+func (editor *LineEditor) replaceWord(text string) {
+	if menu := editor.menu; menu != nil {
+		for range utf8.RuneCountInString(menu.inserted) {
+			editor.buffer.Backspace()
+		}
+		menu.inserted = text
+	} else {
+		word := parseCompletionWord(editor.buffer.BeforeCursor())
+		for range utf8.RuneCountInString(word.line[word.start:]) {
+			editor.buffer.Backspace()
+		}
+	}
+	editor.buffer.InsertString(text)
+}
+
+// drawMenu lays the candidates of the menu out in columns, filled down
+// first, showing only the last component of paths and only as many rows
+// as leave room for the line. The selected candidate is in reverse video.
+func (editor *LineEditor) drawMenu(width, rows int) []string {
+	menu := editor.menu
+	names := make([]string, len(menu.candidates))
+	longest := 0
+	for i, candidate := range menu.candidates {
+		names[i] = candidate[strings.LastIndexByte(strings.TrimSuffix(candidate, "/"), '/')+1:]
+		longest = max(longest, utf8.RuneCountInString(names[i]))
+	}
+	columnWidth := min(longest+2, width-1)
+	columns := max((width-1)/columnWidth, 1)
+	height := (len(names) + columns - 1) / columns
+	first := 0
+	if height > rows {
+		// Scroll so that the selected candidate stays in sight.
+		first = max(menu.selected%height-rows+1, 0)
+	}
+	lines := []string{}
+	for row := first; row < min(height, first+rows); row++ {
+		line := strings.Builder{}
+		for column := 0; column < columns; column++ {
+			i := column*height + row
+			if i >= len(names) {
+				break
+			}
+			name := []rune(names[i])
+			name = name[:min(len(name), columnWidth-1)]
+			padding := strings.Repeat(" ", columnWidth-len(name))
+			if i == menu.selected {
+				line.WriteString("\x1b[7m" + string(name) + "\x1b[0m" + padding)
+			} else {
+				line.WriteString(string(name) + padding)
+			}
+		}
+		lines = append(lines, strings.TrimRight(line.String(), " "))
+	}
+	if height > rows {
+		lines[len(lines)-1] = fmt.Sprintf("%s (%d more rows)", lines[len(lines)-1], height-rows)
+	}
+	return lines
+}
+
+func (editor *LineEditor) readByte() (byte, error) {
+	if len(editor.pending) == 0 {
+		chunk := make([]byte, 256)
+		n, err := editor.input.Read(chunk)
+		if n == 0 {
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		editor.pending = chunk[:n]
+	}
+	b := editor.pending[0]
+	editor.pending = editor.pending[1:]
+	return b, nil
+}
+
+// csiKeys names the keys that terminals send as ESC [ sequences, by what
+// follows the bracket.
+var csiKeys = map[string]string{
+	"A": "<up>", "B": "<down>", "C": "<right>", "D": "<left>",
+	"H": "<home>", "F": "<end>", "1~": "<home>", "7~": "<home>",
+	"4~": "<end>", "8~": "<end>", "3~": "<delete>", "Z": "<backtab>",
+}
+
+// escapeTimeout is how long the rest of an escape sequence may take to
+// come after its ESC, which over a slow link can arrive on its own.
+const escapeTimeout = 100 * time.Millisecond
+
+// readKey returns the next key: a character, or the name of a special key
+// in angle brackets, like <up> or <alt-b>. A lone ESC, with nothing typed
+// after it within escapeTimeout, is <esc>.
+func (editor *LineEditor) readKey() (string, error) {
+	b, err := editor.readByte()
+	if err != nil {
+		return "", err
+	}
+	if b == 0x1b {
+		if len(editor.pending) == 0 && !waitReadable(int(editor.input.Fd()), time.Now().Add(escapeTimeout)) {
+			return "<esc>", nil
+		}
+		next, _ := editor.readByte()
+		if next != '[' && next != 'O' {
+			return fmt.Sprintf("<alt-%c>", next), nil
+		}
+		sequence := []byte{}
+		for {
+			c, err := editor.readByte()
+			if err != nil {
+				return "", err
+			}
+			sequence = append(sequence, c)
+			if c >= 0x40 && c <= 0x7e {
+				break
+			}
+		}
+		return csiKeys[string(sequence)], nil
+	}
+	if b < utf8.RuneSelf {
+		return string(b), nil
+	}
+	encoded := []byte{b}
+	for !utf8.FullRune(encoded) && len(encoded) < utf8.UTFMax {
+		c, err := editor.readByte()
+		if err != nil {
+			break
+		}
+		encoded = append(encoded, c)
+	}
+	return string(encoded), nil
+}
+
+func (editor *LineEditor) Erase() string {
+	erase := "\r\x1b[J"
+	if editor.cursorRow > 0 {
+		erase = fmt.Sprintf("\x1b[%dA", editor.cursorRow) + erase
+	}
+	editor.cursorRow = 0
+	return erase
+}
+
+// Redraw draws the prompt and the buffer from the start of the current
+// row, wrapping at the width of the terminal, and puts the cursor in place.
+// Lines of the buffer after the first one follow the secondary prompt.
+func (editor *LineEditor) Redraw() string {
+	width := editor.ctx.Terminal.Width()
+	drawing := strings.Builder{}
+	if editor.bell {
+		drawing.WriteString("\a")
+		editor.bell = false
+	}
+	if editor.clearScreen {
+		drawing.WriteString("\x1b[H\x1b[2J")
+		editor.clearScreen = false
+	}
+	row := 0
+	prompt := editor.prompt
+	if editor.search != nil {
+		prompt = editor.search.prompt()
+	}
+	promptLines := strings.Split(prompt, "\n")
+	for _, line := range promptLines[:len(promptLines)-1] {
+		drawing.WriteString(line + "\r\n")
+		row += rowsSpanned(visibleWidth(line), width)
+	}
+
+	lines := editor.buffer.Lines()
+	cursorLine, cursorCol := editor.buffer.Cursor()
+	cursorRow, cursorColumn := 0, 0
+	for i, line := range lines {
+		prefix := editor.continuation
+		if i == 0 {
+			prefix = promptLines[len(promptLines)-1]
+		} else {
+			drawing.WriteString("\r\n")
+		}
+		drawing.WriteString(prefix + line)
+		prefixWidth := visibleWidth(prefix)
+		if i == cursorLine {
+			position := prefixWidth + cursorCol
+			cursorRow, cursorColumn = row+position/width, position%width
+		}
+		length := prefixWidth + utf8.RuneCountInString(line)
+		if i < len(lines)-1 {
+			row += rowsSpanned(length, width)
+			continue
+		}
+		// A line that fills the last column leaves the cursor there until
+		// something else is written, so move it to the next row for real.
+		if length > 0 && length%width == 0 {
+			drawing.WriteString("\r\n")
+		}
+		row += length / width
+	}
+
+	if editor.menu != nil {
+		for _, line := range editor.drawMenu(width, max(editor.ctx.Terminal.Height()-row-2, 1)) {
+			drawing.WriteString("\r\n" + line)
+			row++
+		}
+	}
+
+	if row > cursorRow {
+		fmt.Fprintf(&drawing, "\x1b[%dA", row-cursorRow)
+	}
+	drawing.WriteString("\r")
+	if cursorColumn > 0 {
+		fmt.Fprintf(&drawing, "\x1b[%dC", cursorColumn)
+	}
+	editor.cursorRow = cursorRow
+	return drawing.String()
+}
+
+func rowsSpanned(length, width int) int {
+	return max(1, (length+width-1)/width)
+}
+
+// visibleWidth counts the columns text takes on the terminal, leaving out
+// escape sequences, like the colors of a prompt, and control characters.
+func visibleWidth(text string) int {
+	width := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == 0x1b && i+1 < len(text) && text[i+1] == '[':
+			i += 2
+			for i < len(text) && (text[i] < 0x40 || text[i] > 0x7e) {
+				i++
+			}
+		case c == 0x1b && i+1 < len(text) && text[i+1] == ']':
+			for i < len(text) && text[i] != '\a' && !strings.HasPrefix(text[i:], "\x1b\\") {
+				i++
+			}
+			if i < len(text) && text[i] == 0x1b {
+				i++
+			}
+		case c < ' ' || c == 0x7f:
+		case c < utf8.RuneSelf:
+			width++
+		default:
+			_, size := utf8.DecodeRuneInString(text[i:])
+			i += size - 1
+			width++
+		}
+	}
+	return width
+}
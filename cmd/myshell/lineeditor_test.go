@@ -0,0 +1,260 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadKeySplitEscapeSequence(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{"whole sequence", []string{"\x1b[A"}, []string{"<up>"}},
+		{"split after ESC", []string{"\x1b", "[B"}, []string{"<down>"}},
+		{"split inside", []string{"\x1b[", "3~"}, []string{"<delete>"}},
+		{"alt key split", []string{"\x1b", "b"}, []string{"<alt-b>"}},
+		{"lone ESC", []string{"\x1b", "", "x"}, []string{"<esc>", "x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, writer, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			go func() {
+				defer writer.Close()
+				for _, chunk := range test.chunks {
+					if chunk == "" {
+						// Longer than a sequence may take to arrive.
+						time.Sleep(2 * escapeTimeout)
+						continue
+					}
+					writer.WriteString(chunk)
+					time.Sleep(escapeTimeout / 4)
+				}
+			}()
+			editor := &LineEditor{input: reader}
+			for _, want := range test.want {
+				if got, err := editor.readKey(); err != nil || got != want {
+					t.Errorf("readKey() = %q, %v, want %q", got, err, want)
+				}
+			}
+		})
+	}
+}
+
+// typeLine feeds keys to editor the way ReadLine does after showing the
+// prompt, and returns the line once a key finishes it.
+func typeLine(editor *LineEditor, keys []string) string {
+	editor.buffer = NewEditBuffer()
+	editor.browser = editor.ctx.History.BrowseAt(editor.nextEntry-editor.ctx.History.Base, editor.buffer)
+	editor.nextEntry = -1
+	for _, key := range keys {
+		if done, _ := editor.handleKey(key); done {
+			return editor.buffer.String()
+		}
+	}
+	return editor.buffer.String()
+}
+
+func TestOperateAndGetNext(t *testing.T) {
+	shellCtx := NewShellCtx()
+	for _, entry := range []string{"cd src", "make", "make test", "cd .."} {
+		shellCtx.History.Add(entry)
+	}
+	editor := NewLineEditor(shellCtx, nil)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"<up>", "<up>", "<up>", "<up>", "\x0f"}, "cd src"},
+		{[]string{"\x0f"}, "make"},
+		{[]string{"\r"}, "make test"},
+		{[]string{"x", "\r"}, "x"},
+		{[]string{"<up>", "\x0f"}, "cd .."},
+		{[]string{"e", "\x0f"}, "e"},
+		{[]string{"\r"}, ""},
+	}
+	for i, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("line %d, %q: got %q, want %q", i, test.keys, got, test.want)
+		}
+	}
+}
+
+// typed is the keys of typing text, one character each, followed by keys.
+func typed(text string, keys ...string) []string {
+	return append(strings.Split(text, ""), keys...)
+}
+
+func TestEditingKeys(t *testing.T) {
+	editor := NewLineEditor(NewShellCtx(), nil)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{typed("echo hi", "\r"), "echo hi"},
+		{typed("echo hi", "\x01", "x", "\r"), "xecho hi"},
+		{typed("echo hi", "<home>", "<end>", "!", "\r"), "echo hi!"},
+		{typed("echo hi", "<left>", "<left>", "\x7f", "\r"), "echohi"},
+		{typed("echo hi", "\x02", "\x02", "\x08", "\x06", "X", "\r"), "echohXi"},
+		{typed("echo hi", "\x01", "\x04", "<delete>", "\r"), "ho hi"},
+		{typed("echo hi", "<left>", "<left>", "<left>", "\x0b", "\r"), "echo"},
+		{typed("echo hi", "<left>", "<left>", "\x15", "\r"), "hi"},
+		{typed("echo one two", "\x17", "\r"), "echo one "},
+		{typed("echo one two", "\x17", "\x17", "\r"), "echo "},
+		{typed("echo one two", "<alt-b>", "<alt-b>", "X", "<alt-f>", "Y", "\r"), "echo XoneY two"},
+		{typed("é€", "<left>", "ü", "\r"), "éü€"},
+		{typed("a", "\x00", "\x1b", "\r"), "a"},
+	}
+	for _, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+	}
+}
+
+func TestEditorEndsLine(t *testing.T) {
+	editor := NewLineEditor(NewShellCtx(), nil)
+	tests := []struct {
+		keys []string
+		err  error
+	}{
+		{typed("echo", "\r"), nil},
+		{typed("echo", "\n"), nil},
+		{typed("echo", "\x03"), errLineInterrupted},
+		{[]string{"\x04"}, io.EOF},
+	}
+	for _, test := range tests {
+		editor.buffer = NewEditBuffer()
+		var err error
+		for _, key := range test.keys {
+			var done bool
+			if done, err = editor.handleKey(key); done {
+				break
+			}
+		}
+		if err != test.err {
+			t.Errorf("%q: got %v, want %v", test.keys, err, test.err)
+		}
+	}
+}
+
+func TestEditLineExternally(t *testing.T) {
+	tests := []struct {
+		editor string
+		keys   []string
+		want   string
+		shown  string
+	}{
+		{"sed -i s/one/two/", typed("echo one", "\x18", "\x05"), "echo two", "echo two\n"},
+		{"sed -i '$a echo more'", typed("echo one", "\x18", "\x05"), "echo one\necho more", "echo one\necho more\n"},
+		{"sed -i d", typed("echo one", "\x18", "\x05"), "", ""},
+		{"false", typed("echo one", "\x18", "\x05"), "", ""},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("EDITOR", test.editor)
+		shellCtx.LastStatus = 5
+		stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		shellCtx.Streams.Stderr = stderr
+		editor := NewLineEditor(shellCtx, nil)
+		typeLine(editor, test.keys)
+		if !editor.editExternally {
+			t.Fatalf("%s: Ctrl-X Ctrl-E didn't end the line", test.editor)
+		}
+		if got := editor.editLine(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.editor, got, test.want)
+		}
+		stderr.Close()
+		if shown, _ := os.ReadFile(stderr.Name()); string(shown) != test.shown {
+			t.Errorf("%s: showed %q, want %q", test.editor, shown, test.shown)
+		}
+		if shellCtx.LastStatus != 5 {
+			t.Errorf("%s: $? changed to %d", test.editor, shellCtx.LastStatus)
+		}
+	}
+
+	// Ctrl-X followed by another key is no binding.
+	editor := NewLineEditor(NewShellCtx(), nil)
+	if got := typeLine(editor, typed("ab", "\x18", "x", "c", "\r")); got != "abc" || editor.editExternally {
+		t.Errorf("Ctrl-X x: got %q", got)
+	}
+}
+
+func TestCompletionMenu(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	for _, dir := range []string{"dist", "docs"} {
+		if err := os.Mkdir(filepath.Join(shellCtx.CurrentDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"alpha", "alpine", "my file", "my note"} {
+		if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	editor := NewLineEditor(shellCtx, nil)
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{typed("cat al", "\t"), "cat alp"},
+		{typed("cat al", "\t", "\t"), "cat alpha"},
+		{typed("cat al", "\t", "\t", "\t"), "cat alpine"},
+		{typed("cat al", "\t", "\t", "\t", "\t"), "cat alpha"},
+		{typed("cat al", "\t", "<backtab>"), "cat alpine"},
+		{typed("cat al", "\t", "\t", "\t", "<backtab>"), "cat alpha"},
+		{typed("cat al", "\t", "\t", "<esc>"), "cat alp"},
+		{typed("cat al", "\t", "\t", "\x07"), "cat alp"},
+		{typed("cat al", "\t", "\t", "x"), "cat alphax"},
+		{typed("cat al", "\t", "\t", "\x7f"), "cat alph"},
+		{typed("cat d", "\t", "\t", "\t"), "cat docs/"},
+		{typed("cat my", "\t"), "cat my\\ "},
+		{typed("cat my", "\t", "\t", "\t"), "cat my\\ note"},
+		{typed("cat 'my", "\t", "\t"), "cat 'my file'"},
+	}
+	for _, test := range tests {
+		if got := typeLine(editor, test.keys); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.keys, got, test.want)
+		}
+		editor.menu = nil
+	}
+}
+
+func TestDrawMenu(t *testing.T) {
+	tests := []struct {
+		candidates []string
+		selected   int
+		width      int
+		rows       int
+		want       []string
+	}{
+		{[]string{"a", "bb", "ccc"}, -1, 80, 10, []string{"a    bb   ccc"}},
+		{[]string{"a", "bb", "ccc"}, 1, 80, 10, []string{"a    \x1b[7mbb\x1b[0m   ccc"}},
+		{[]string{"one", "two", "three", "four"}, -1, 15, 10, []string{"one    three", "two    four"}},
+		{[]string{"src/lib/", "src/main.go"}, -1, 80, 10, []string{"lib/     main.go"}},
+		{[]string{"a", "b", "c", "d", "e"}, -1, 4, 2, []string{"a", "b (3 more rows)"}},
+		{[]string{"a", "b", "c", "d", "e"}, 3, 4, 2, []string{"c", "\x1b[7md\x1b[0m (3 more rows)"}},
+		{[]string{"abcdefghij"}, -1, 6, 10, []string{"abcd"}},
+	}
+	for _, test := range tests {
+		editor := NewLineEditor(NewShellCtx(), nil)
+		editor.menu = &completionMenu{candidates: test.candidates, selected: test.selected}
+		if got := editor.drawMenu(test.width, test.rows); !slices.Equal(got, test.want) {
+			t.Errorf("%q selecting %d in %dx%d: got %q, want %q", test.candidates, test.selected, test.width, test.rows, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CommandMatch is one of the things a command name can stand for. Kind is
+// alias, keyword, function, builtin or file, and Value the text of the
+// alias, the source of the function or the path of the file.
+type CommandMatch struct {
+	Kind  string
+	Value string
+}
+
+// FindCommand resolves a command name the way the shell does when it runs
+// it: aliases first, then reserved words, functions, builtins and finally
+// the executables along PATH. With all it returns every match instead of
+// the one that wins.
+func (ctx *ShellCtx) FindCommand(name string, all bool) []CommandMatch {
+	matches := []CommandMatch{}
+	if value, found := ctx.Aliases[name]; found {
+		matches = append(matches, CommandMatch{Kind: "alias", Value: value})
+	}
+	if reservedWords[name] {
+		matches = append(matches, CommandMatch{Kind: "keyword"})
+	}
+	if def, found := ctx.Functions[name]; found {
+		matches = append(matches, CommandMatch{Kind: "function", Value: def.Source})
+	}
+	if _, found := ctx.Builtins[name]; found {
+		matches = append(matches, CommandMatch{Kind: "builtin"})
+	}
+	if len(matches) > 0 && !all {
+		return matches[:1]
+	}
+
+	if !all || strings.Contains(name, "/") {
+		if path, found := ctx.Runner.LookPath(ctx, name); found {
+			matches = append(matches, CommandMatch{Kind: "file", Value: path})
+		}
+		return matches
+	}
+	for _, path := range FindExecsInPathFolders(name, ctx.PathFolders) {
+		matches = append(matches, CommandMatch{Kind: "file", Value: path})
+	}
+	return matches
+}
+
+// FindExecsInPathFolders is SearchExecInPathFolders returning every match,
+// in PATH order.
+func FindExecsInPathFolders(command string, pathFolders []string) []string {
+	paths := []string{}
+	for _, folder := range pathFolders {
+		path := filepath.Join(folder, command)
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() && IsExecAny(info.Mode()) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// WhichExecutor implements `which [-a] name ...`, showing what the shell
+// would run for each name: the path of an executable, or what the name is
+// when it's an alias, a reserved word, a function or a builtin. -a shows
+// every match rather than the first.
+func WhichExecutor(shellCtx *ShellCtx, args []string) error {
+	all := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		if option != "-a" {
+			return fmt.Errorf("which command got invalid option %s", option)
+		}
+		all = true
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("which command takes at least 1 name")
+	}
+
+	for _, name := range args {
+		matches := shellCtx.FindCommand(name, all)
+		if len(matches) == 0 {
+			shellCtx.Serr += fmt.Sprintf("%s not found\n", name)
+			shellCtx.Status = 1
+			continue
+		}
+		for _, match := range matches {
+			switch match.Kind {
+			case "alias":
+				shellCtx.Sout += fmt.Sprintf("%s: aliased to %s\n", name, match.Value)
+			case "keyword":
+				shellCtx.Sout += fmt.Sprintf("%s: shell reserved word\n", name)
+			case "function":
+				shellCtx.Sout += fmt.Sprintf("%s: shell function\n", name)
+			case "builtin":
+				shellCtx.Sout += fmt.Sprintf("%s: shell builtin\n", name)
+			default:
+				shellCtx.Sout += match.Value + "\n"
+			}
+		}
+	}
+	return nil
+}
+
+// HashEntry is a command remembered by the hash table, with the number of
+// times it has been run from there.
+type HashEntry struct {
+	Path string
+	Hits int
+}
+
+func (ctx *ShellCtx) countHashHit(name string) {
+	if entry, found := ctx.PathCache[name]; found {
+		entry.Hits++
+		ctx.PathCache[name] = entry
+	}
+}
+
+// HashExecutor implements `hash [-lrt] [-p path] [name ...]`, managing the
+// table that remembers where commands were found along PATH so they aren't
+// searched for again. Without arguments it lists the table; names are looked
+// up and added, -p adds a name with the path given, -t prints the paths of
+// the names, -r empties the table and -l lists it as hash commands. The
+// table is emptied whenever PATH changes. hash -d manages named directories
+// instead.
+func HashExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 && args[0] == "-d" {
+		return hashNamedDirs(shellCtx, args[1:])
+	}
+	reset, reusable, printPaths := false, false, false
+	path := ""
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for i := 1; i < len(option); i++ {
+			switch option[i] {
+			case 'r':
+				reset = true
+			case 'l':
+				reusable = true
+			case 't':
+				printPaths = true
+			case 'p':
+				path = option[i+1:]
+				if path == "" {
+					if len(args) == 0 {
+						return fmt.Errorf("hash command -p requires a path")
+					}
+					path, args = args[0], args[1:]
+				}
+				i = len(option)
+			default:
+				return fmt.Errorf("hash command got invalid option -%c", option[i])
+			}
+		}
+	}
+
+	if reset {
+		shellCtx.PathCache = map[string]HashEntry{}
+	}
+	if len(args) == 0 {
+		if path != "" || printPaths {
+			return fmt.Errorf("hash command -p and -t require a name")
+		}
+		if !reset {
+			listHashTable(shellCtx, reusable)
+		}
+		return nil
+	}
+
+	for _, name := range args {
+		switch {
+		case path != "":
+			shellCtx.PathCache[name] = HashEntry{Path: shellCtx.ResolvePath(path)}
+		case printPaths:
+			entry, found := shellCtx.PathCache[name]
+			if !found {
+				shellCtx.Serr += fmt.Sprintf("hash: %s: not found\n", name)
+				shellCtx.Status = 1
+				continue
+			}
+			if len(args) > 1 {
+				shellCtx.Sout += name + "\t"
+			}
+			shellCtx.Sout += entry.Path + "\n"
+		case strings.Contains(name, "/"):
+			// Paths are run as they are and never remembered.
+		case shellCtx.Functions[name] != nil || shellCtx.Builtins[name] != nil:
+			// Nor are functions and builtins searched for, which is fine.
+		default:
+			if _, found := shellCtx.PathCache[name]; found {
+				continue
+			}
+			if _, found := shellCtx.LookupExecutable(name); !found {
+				shellCtx.Serr += fmt.Sprintf("hash: %s: not found\n", name)
+				shellCtx.Status = 1
+			}
+		}
+	}
+	return nil
+}
+
+func listHashTable(shellCtx *ShellCtx, reusable bool) {
+	if len(shellCtx.PathCache) == 0 {
+		if !reusable {
+			shellCtx.Serr = "hash: hash table empty\n"
+		}
+		return
+	}
+	names := make([]string, 0, len(shellCtx.PathCache))
+	for name := range shellCtx.PathCache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !reusable {
+		shellCtx.Sout = "hits\tcommand\n"
+	}
+	for _, name := range names {
+		entry := shellCtx.PathCache[name]
+		if reusable {
+			shellCtx.Sout += fmt.Sprintf("builtin hash -p %s %s\n", entry.Path, name)
+		} else {
+			shellCtx.Sout += fmt.Sprintf("%4d\t%s\n", entry.Hits, entry.Path)
+		}
+	}
+}
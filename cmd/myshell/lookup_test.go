@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+// pathDirs puts two directories in front of PATH: a with the executable tool
+// and the plain file noexec, b with another tool and an echo. T is the
+// directory they are in, which each script hides in its output.
+const pathDirs = `mkdir a b; printf '#!/bin/sh\n' > a/tool; cp a/tool b/tool; cp a/tool b/echo; touch a/noexec; ` +
+	`chmod +x a/tool b/tool b/echo; T=$(pwd); PATH=$T/a:$T/b:$PATH; `
+
+func TestWhich(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{pathDirs + `which tool | sed "s|$T|T|"`, "T/a/tool\n"},
+		{pathDirs + `which -a tool | sed "s|$T|T|"`, "T/a/tool\nT/b/tool\n"},
+		{pathDirs + `which echo`, "echo: shell builtin\n"},
+		{pathDirs + `which -a echo | sed "s|$T|T|" | head -2`, "echo: shell builtin\nT/b/echo\n"},
+		{`alias ll='ls -l'; f() { :; }; which ll f`, "ll: aliased to ls -l\nf: shell function\n"},
+		{pathDirs + `which "$T/a/tool" | sed "s|$T|T|"`, "T/a/tool\n"},
+		{pathDirs + `which -a nosuch tool | sed "s|$T|T|"`, "T/a/tool\nT/b/tool\n"},
+		{pathDirs + "which nosuch; echo $?", "1\n"},
+		{pathDirs + "which noexec; echo $?", "1\n"},
+		{"which /nosuch; echo $?", "1\n"},
+	})
+}
+
+func TestHash(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{pathDirs + "hash; echo $?", "0\n"},
+		{pathDirs + `tool; hash | sed "s|$T|T|"`, "hits\tcommand\n   1\tT/a/tool\n"},
+		{pathDirs + `tool; tool; hash -t tool | sed "s|$T|T|"`, "T/a/tool\n"},
+		{pathDirs + `hash tool; hash -l | sed "s|$T|T|"`, "builtin hash -p T/a/tool tool\n"},
+		{pathDirs + `hash -p "$T/b/tool" tool; type tool | sed "s|$T|T|"`, "tool is T/b/tool\n"},
+		{pathDirs + `hash tool; rm a/tool; tool; hash -t tool | sed "s|$T|T|"`, "T/b/tool\n"},
+		{pathDirs + "hash tool; hash -r; hash -t tool; echo $?", "1\n"},
+		{pathDirs + "hash tool; PATH=$PATH; hash -t tool; echo $?", "1\n"},
+		{pathDirs + "hash nosuch; echo $?", "1\n"},
+		{`f() { :; }; hash cd f; echo $?; hash`, "0\n"},
+		{"alias a=ls; hash a; echo $?", "1\n"},
+		{"hash -t; echo $?", "1\n"},
+	})
+}
@@ -2,28 +2,196 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"maps"
+	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type Executor func(*ShellCtx, []string) error
 type ShellCtx struct {
 	Builtins    map[string]Executor
+	Runner      CommandRunner
 	PathFolders []string
 	CurrentDir  string
+	Vars        map[string]*Variable
+	DynamicVars map[string]bool
+	Aliases     map[string]string
+	Functions   map[string]*FunctionDef
+	Traps       *TrapTable
+	NamedDirs   map[string]string
+	PathCache   map[string]HashEntry
+	Options     map[string]bool
+	Terminal    *Terminal
 	Serr        string
 	Sout        string
+	Status      int
+	Streams     Streams
+	// Fds holds the descriptors above 2 that exec opened for the shell, which
+	// the commands it starts inherit.
+	Fds map[int]*os.File
+	// permanent holds what exec redirected for good in a subshell, which
+	// can't move the descriptors of the process it shares with the shell.
+	permanent map[int]*os.File
+	// interrupt holds the signal that interrupted the commands being run,
+	// sent to a background job the shell runs itself, which no process
+	// receives for it.
+	interrupt *atomic.Int32
+	// processState is the process as a subshell found it, given back to its
+	// shell when it is done.
+	processState processState
+	// hardLimits are the hard resource limits a subshell lowered, which
+	// only hold for its own ulimit, as the process's can't be raised back.
+	hardLimits map[int]uint64
+
+	ColorDepth ColorDepth
+
+	// SafeMode refuses command substitution during expansion, so that a
+	// line can be expanded without running anything.
+	SafeMode bool
+
+	LastStatus   int
+	LastPipeline *PipelineResult
+	// substituted is set once a command substitution has run for the
+	// simple command being expanded, whose status it then is when there is
+	// no command name.
+	substituted bool
+
+	StartTime time.Time
+	Random    *rand.Rand
+	LineNo    int
+
+	ShellName         string
+	PositionalArgs    []string
+	LastBackgroundPid int
+
+	Jobs           *JobTable
+	Output         *OutputGuard
+	Background     bool
+	OnProcessStart func(pid int)
+	OnProcessExit  func(pid, status int)
+	Group          *ProcessGroup
+	// Interactive is set for the shell reading commands from its prompt,
+	// not for scripts, -c commands or subshells.
+	Interactive bool
+
+	SourceStack  []string
+	SourcedFiles map[string]bool
+
+	DirStack []string
+
+	// loopDepth counts the loops being run. A break or continue sets
+	// breakLoops or continueLoops to the number of loops it leaves.
+	loopDepth     int
+	breakLoops    int
+	continueLoops int
+
+	// functionDepth and sourceDepth count the function calls and sourced
+	// files being run; returning is set by return until one is left.
+	functionDepth int
+	sourceDepth   int
+	returning     bool
+
+	// subshell is set in copies of the shell, where exit only ends the copy:
+	// exiting makes the commands still running in it unwind.
+	subshell bool
+	exiting  bool
+
+	// ignoreHangups makes the external commands started ignore SIGHUP, for
+	// nohup.
+	ignoreHangups bool
+	// fuzzyCompletion makes completion match candidates by substring and
+	// subsequence rather than prefix, for fuzzycomplete.
+	fuzzyCompletion bool
+
+	// inTrap is set while a trap handler runs, and conditionDepth while the
+	// condition of an if, while or until does, or a pipeline of an && or ||
+	// list other than the last. A failure is expected there and triggers
+	// neither the ERR trap nor errexit.
+	inTrap         bool
+	conditionDepth int
+
+	// localScopes holds, for each function being run, the variables its
+	// locals shadow, nil for those that were unset.
+	localScopes []map[string]*Variable
+
+	// getoptsOffset is how far getopts got into the grouped options of the
+	// argument OPTIND was getoptsIndex at, as in -ab.
+	getoptsIndex  int
+	getoptsOffset int
+
+	History *History
+
+	EnvSnapshots map[string]*EnvSnapshot
+	// Completions holds the specs complete set, by command name.
+	Completions map[string]*CompletionSpec
+	// CommandIndex caches the executables along PATH for completion.
+	CommandIndex *Lazy[*CommandIndex]
+
+	prefetches []func()
 }
 
 func (ctx *ShellCtx) Reset() {
 	ctx.Serr = ""
 	ctx.Sout = ""
+	ctx.Status = 0
+}
+
+func (ctx *ShellCtx) Clone() *ShellCtx {
+	clone := *ctx
+	clone.Vars = copyVariables(ctx.Vars)
+	clone.Aliases = maps.Clone(ctx.Aliases)
+	clone.Functions = maps.Clone(ctx.Functions)
+	clone.Options = maps.Clone(ctx.Options)
+	clone.NamedDirs = maps.Clone(ctx.NamedDirs)
+	clone.Completions = maps.Clone(ctx.Completions)
+	clone.Fds = maps.Clone(ctx.Fds)
+	clone.permanent = nil
+	clone.processState = saveProcessState()
+	clone.hardLimits = maps.Clone(ctx.hardLimits)
+	clone.PathCache = maps.Clone(ctx.PathCache)
+	clone.DynamicVars = maps.Clone(ctx.DynamicVars)
+	clone.Random = rand.New(rand.NewSource(ctx.Random.Int63()))
+	clone.SourceStack = slices.Clone(ctx.SourceStack)
+	clone.SourcedFiles = maps.Clone(ctx.SourcedFiles)
+	clone.DirStack = slices.Clone(ctx.DirStack)
+	clone.localScopes = make([]map[string]*Variable, len(ctx.localScopes))
+	for i, scope := range ctx.localScopes {
+		clone.localScopes[i] = maps.Clone(scope)
+	}
+	clone.EnvSnapshots = maps.Clone(ctx.EnvSnapshots)
+	clone.Traps = ctx.Traps.forSubshell(ctx.Options)
+	clone.subshell = true
+	clone.Interactive = false
+	clone.Reset()
+	return &clone
+}
+
+func (ctx *ShellCtx) Exit(code int) {
+	ctx.LastStatus = code
+	if ctx.Interactive && ctx.Options["huponexit"] {
+		ctx.HangupJobs()
+	}
+	ctx.SaveHistory()
+	ctx.RunExitTrap()
+	ctx.Terminal.Restore()
+	os.Exit(code)
+}
+
+func SplitPath(path string) []string {
+	if len(path) == 0 {
+		return make([]string, 0)
+	}
+	return strings.Split(path, ":")
 }
 
 func IsExecAny(mode os.FileMode) bool {
@@ -51,15 +219,23 @@ func SearchExecInPathFolders(command string, pathFolders []string) (string, bool
 	return "", false
 }
 
-func ExitExecutor(_ *ShellCtx, args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("exit command takes exactly 1 argument of type int")
+func ExitExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("exit command takes at most 1 argument of type int")
 	}
-	code, err := strconv.Atoi(args[0])
-	if err != nil {
-		return fmt.Errorf("exit command failed to parse exit code: %s", err.Error())
+	code := shellCtx.LastStatus
+	if len(args) == 1 {
+		var err error
+		if code, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("exit command failed to parse exit code: %s", err.Error())
+		}
 	}
-	os.Exit(code)
+	if shellCtx.subshell {
+		shellCtx.Status = code & 0xff
+		shellCtx.exiting = true
+		return nil
+	}
+	shellCtx.Exit(code)
 	return nil
 }
 
@@ -69,281 +245,555 @@ func EchoExecutor(shellCtx *ShellCtx, args []string) error {
 	return nil
 }
 
+// TrueExecutor is also `:`, which does nothing but expand its arguments.
+func TrueExecutor(shellCtx *ShellCtx, args []string) error {
+	return nil
+}
+
+func FalseExecutor(shellCtx *ShellCtx, args []string) error {
+	shellCtx.Status = 1
+	return nil
+}
+
+// TypeExecutor implements `type [-afptP] name ...`, telling for each name
+// what the shell would run: -a lists every match, -f skips functions, -t
+// prints only the kind of match and -p the path of the file that would run.
+// -P looks for a file even when something else comes first.
 func TypeExecutor(shellCtx *ShellCtx, args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("exit command takes exactly 1 argument of type string")
+	all, noFunctions, kindOnly, pathOnly, forcePath := false, false, false, false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 'a':
+				all = true
+			case 'f':
+				noFunctions = true
+			case 't':
+				kindOnly = true
+			case 'p':
+				pathOnly = true
+			case 'P':
+				forcePath = true
+			default:
+				return fmt.Errorf("type command got invalid option -%c", flag)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("type command takes at least 1 name")
 	}
-	command := args[0]
-	_, found := shellCtx.Builtins[command]
-	if found {
-		shellCtx.Sout = fmt.Sprintf("%s is a shell builtin\n", command)
-	} else {
-		execPath, found := SearchExecInPathFolders(command, shellCtx.PathFolders)
 
-		if found {
-			shellCtx.Sout = fmt.Sprintf("%s is %s\n", command, execPath)
-		} else {
-			shellCtx.Serr = fmt.Sprintf("%s: not found\n", command)
+	for _, command := range args {
+		matches := []CommandMatch{}
+		for _, match := range shellCtx.FindCommand(command, all || forcePath) {
+			if (match.Kind == "function" && noFunctions) || (forcePath && match.Kind != "file") {
+				continue
+			}
+			matches = append(matches, match)
+		}
+		if !all && len(matches) > 1 {
+			matches = matches[:1]
+		}
+		if len(matches) == 0 {
+			if !kindOnly && !pathOnly && !forcePath {
+				shellCtx.Serr += fmt.Sprintf("%s: not found\n", command)
+			}
+			shellCtx.Status = 1
+			continue
+		}
+		for _, match := range matches {
+			switch {
+			case kindOnly:
+				shellCtx.Sout += match.Kind + "\n"
+			case pathOnly || forcePath:
+				if match.Kind == "file" {
+					shellCtx.Sout += match.Value + "\n"
+				}
+			case match.Kind == "alias":
+				shellCtx.Sout += fmt.Sprintf("%s is aliased to `%s'\n", command, match.Value)
+			case match.Kind == "keyword":
+				shellCtx.Sout += fmt.Sprintf("%s is a shell keyword\n", command)
+			case match.Kind == "function":
+				shellCtx.Sout += fmt.Sprintf("%s is a function\n%s\n", command, match.Value)
+			case match.Kind == "builtin":
+				shellCtx.Sout += fmt.Sprintf("%s is a shell builtin\n", command)
+			default:
+				shellCtx.Sout += fmt.Sprintf("%s is %s\n", command, match.Value)
+			}
 		}
 	}
 	return nil
 }
 
-func PwdExecutor(shellCtx *ShellCtx, _ []string) error {
-	shellCtx.Sout = fmt.Sprintln(shellCtx.CurrentDir)
-	return nil
-}
+func EnvExecutor(shellCtx *ShellCtx, args []string) error {
+	clearEnv := false
+	unset := map[string]bool{}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		switch option {
+		case "-", "-i":
+			clearEnv = true
+		case "-u":
+			if len(args) == 0 {
+				return fmt.Errorf("env option -u requires an argument")
+			}
+			unset[args[0]] = true
+			args = args[1:]
+		default:
+			return fmt.Errorf("env command got invalid option %s", option)
+		}
+	}
 
-func ChangeDirExecutor(shellCtx *ShellCtx, args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("cd command takes exactly 1 argument of type string")
+	overrides, command := SplitAssignments(args)
+	environ := []string{}
+	if !clearEnv {
+		environ = shellCtx.Environ(overrides...)
+	} else {
+		for _, assignment := range overrides {
+			environ = append(environ, assignment.Name+"="+assignment.Value)
+		}
 	}
+	environ = slices.DeleteFunc(environ, func(entry string) bool {
+		name, _, _ := strings.Cut(entry, "=")
+		return unset[name]
+	})
+
+	if len(command) == 0 {
+		for _, entry := range environ {
+			shellCtx.Sout += entry + "\n"
+		}
+		return nil
+	}
+	shellCtx.Status = shellCtx.ExecuteArgs(command, overrides, environ, shellCtx.Streams)
+	return nil
+}
 
-	destPath := args[0]
-	if destPath[0] == '~' {
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			return err
+// parsePathModeOptions consumes the -L and -P options shared by cd and pwd.
+// The last one given wins; logical is the default.
+func parsePathModeOptions(command string, args []string) (bool, []string, error) {
+	physical := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				return false, nil, fmt.Errorf("%s command got invalid option %s", command, option)
+			}
 		}
-		destPath = strings.Replace(destPath, "~", homeDir, 1)
-	} else if destPath[0] == '.' {
-		destPath = filepath.Join(shellCtx.CurrentDir, destPath)
 	}
+	return physical, args, nil
+}
 
-	if _, err := os.Stat(destPath); os.IsNotExist(err) {
-		shellCtx.Serr = fmt.Sprintf("cd: %s: No such file or directory\n", destPath)
-	} else {
-		shellCtx.CurrentDir = destPath
+func PwdExecutor(shellCtx *ShellCtx, args []string) error {
+	physical, _, err := parsePathModeOptions("pwd", args)
+	if err != nil {
+		return err
 	}
+	dir := shellCtx.CurrentDir
+	if physical {
+		if dir, err = filepath.EvalSymlinks(dir); err != nil {
+			shellCtx.Serr = fmt.Sprintf("pwd: %s: %s\n", shellCtx.CurrentDir, describeOpenError(err))
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+	shellCtx.Sout = fmt.Sprintln(dir)
 	return nil
 }
 
-func RunExternalCommand(command string, args []string, shellCtx *ShellCtx) error {
-	cmd := exec.Command(command, args...)
-	output, err := cmd.Output()
+// ChangeDirExecutor implements `cd [-L | -P] [dir]`. By default the new
+// directory is a logical path, with .. removing the previous component even
+// when it was a symlink; -P resolves symlinks instead.
+func ChangeDirExecutor(shellCtx *ShellCtx, args []string) error {
+	physical, args, err := parsePathModeOptions("cd", args)
 	if err != nil {
-		serr, ok := err.(*exec.ExitError)
-		if ok {
-			shellCtx.Serr = string(serr.Stderr)
-		} else {
-			return err
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("cd command takes at most 1 argument of type string")
+	}
+
+	if len(args) == 0 {
+		homeDir, found := shellCtx.GetVar("HOME")
+		if !found || len(homeDir) == 0 {
+			shellCtx.Serr = "cd: HOME not set\n"
+			shellCtx.Status = 1
+			return nil
+		}
+		args = []string{homeDir}
+	}
+
+	destPath := args[0]
+	if len(destPath) == 0 {
+		return nil
+	}
+	printDir := false
+	if destPath == "-" {
+		oldDir, found := shellCtx.GetVar("OLDPWD")
+		if !found || len(oldDir) == 0 {
+			shellCtx.Serr = "cd: OLDPWD not set\n"
+			shellCtx.Status = 1
+			return nil
 		}
+		destPath = oldDir
+		printDir = true
+	}
+	announce := shellCtx.changeDirTo("cd", destPath, physical)
+	if shellCtx.Status == 0 && (printDir || announce) {
+		shellCtx.Sout = fmt.Sprintln(shellCtx.CurrentDir)
 	}
-	shellCtx.Sout = string(output)
 	return nil
 }
 
-func ParseArgs(input string) []string {
-	input = strings.TrimSpace(input)
-	args := []string{}
-	doubleQuotedSpecialCharacters := []rune{'$', '\\', '"'}
-	const (
-		isSingleQouted = iota
-		isDoubleQouted
-		isEscaped
-	)
-	currentState := isEscaped
-	skipNext := false
-	buffer := ""
-	for i, arg := range input {
-		if skipNext {
-			skipNext = false
-			continue
+// changeDirTo resolves a cd target, following CDPATH, and moves the
+// shell there, physically if asked to. Failures are reported with the given
+// command name. It tells whether the directory to announce differs from the
+// one asked for, because it was found through CDPATH or spell corrected.
+func (ctx *ShellCtx) changeDirTo(command string, destPath string, physical bool) bool {
+	announce := false
+	if cdPathDir, found := ctx.SearchCdPath(destPath); found {
+		destPath = cdPathDir
+		announce = true
+	} else if physical && !filepath.IsAbs(destPath) {
+		// .. has to step out of the directory we are physically in.
+		physicalDir, err := filepath.EvalSymlinks(ctx.CurrentDir)
+		if err != nil {
+			physicalDir = ctx.CurrentDir
 		}
-		if i == 0 {
-			if arg == '"' {
-				currentState = isDoubleQouted
-				continue
-			} else if arg == '\'' {
-				currentState = isSingleQouted
-				continue
-			}
+		destPath = filepath.Join(physicalDir, destPath)
+	} else {
+		destPath = ctx.ResolvePath(destPath)
+	}
+
+	err := checkEnterable(destPath)
+	if os.IsNotExist(err) && ctx.Options["cdspell"] {
+		if corrected, found := CorrectDirSpelling(destPath); found {
+			destPath, err = corrected, nil
+			announce = true
 		}
-		switch arg {
-		case '"':
-			if currentState == isEscaped {
-				currentState = isDoubleQouted
-				buffer += string(input[i+1])
-				skipNext = true
-			} else if currentState == isDoubleQouted {
-				currentState = isEscaped
-			} else {
-				buffer += string(arg)
-			}
-		case '\'':
-			if currentState == isEscaped {
-				currentState = isSingleQouted
-				buffer += string(input[i+1])
-				skipNext = true
-			} else if currentState == isSingleQouted {
-				currentState = isEscaped
-			} else {
-				buffer += string(arg)
-			}
-		case '\\':
-			if currentState == isEscaped {
-				buffer += string(input[i+1])
-				skipNext = true
-			} else if currentState == isDoubleQouted {
-				contains := slices.Contains(doubleQuotedSpecialCharacters, rune(input[i+1]))
-				if contains {
-					buffer += string(input[i+1])
-					skipNext = true
-				} else {
-					buffer += string(arg)
-				}
-			} else if currentState == isSingleQouted {
-				buffer += string(arg)
-			}
-		case ' ':
-			if currentState == isEscaped {
-				args = append(args, buffer)
-				buffer = ""
-			} else {
-				buffer += string(arg)
-			}
-		default:
-			buffer += string(arg)
+	}
+	if err != nil {
+		ctx.Serr = fmt.Sprintf("%s: %s: %s\n", command, destPath, describeOpenError(err))
+		ctx.Status = 1
+		return false
+	}
+	if physical {
+		resolved, err := filepath.EvalSymlinks(destPath)
+		if err != nil {
+			ctx.Serr = fmt.Sprintf("%s: %s: %s\n", command, destPath, describeOpenError(err))
+			ctx.Status = 1
+			return false
 		}
+		destPath = resolved
+	}
+	ctx.ChangeDir(destPath)
+	return announce
+}
+
+// checkEnterable tells why dir can't become the current directory, if it
+// can't: it doesn't exist, isn't a directory or can't be searched.
+func checkEnterable(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
 	}
-	if len(buffer) > 0 {
-		args = append(args, buffer)
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return syscall.Access(dir, searchAccess)
+}
+
+// searchAccess is X_OK, which the syscall package doesn't define.
+const searchAccess = 0x1
+
+// SearchCdPath looks a relative cd target up in the directories listed in
+// CDPATH. Targets starting with / . or .. never use CDPATH, and an empty
+// entry means the current directory, which isn't reported as a CDPATH hit.
+func (ctx *ShellCtx) SearchCdPath(target string) (string, bool) {
+	if filepath.IsAbs(target) || target == "." || target == ".." ||
+		strings.HasPrefix(target, "./") || strings.HasPrefix(target, "../") {
+		return "", false
 	}
-	res := []string{}
-	for _, arg := range args {
-		if len(arg) > 0 {
-			res = append(res, arg)
+	cdPath, found := ctx.GetVar("CDPATH")
+	if !found || len(cdPath) == 0 {
+		return "", false
+	}
+	for _, entry := range strings.Split(cdPath, ":") {
+		if len(entry) == 0 {
+			if info, err := os.Stat(ctx.ResolvePath(target)); err == nil && info.IsDir() {
+				return "", false
+			}
+			continue
+		}
+		candidate := filepath.Join(ctx.ResolvePath(entry), target)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, true
 		}
 	}
+	return "", false
+}
 
-	return res
+// ChangeDir moves the shell to dir, keeping PWD and OLDPWD up to date for
+// child processes.
+func (ctx *ShellCtx) ChangeDir(dir string) {
+	ctx.ExportVar("OLDPWD", ctx.CurrentDir)
+	ctx.CurrentDir = dir
+	ctx.ExportVar("PWD", dir)
 }
 
-func main() {
-	var builtins = map[string]Executor{
-		"exit": ExitExecutor,
-		"echo": EchoExecutor,
-		"type": TypeExecutor,
-		"pwd":  PwdExecutor,
-		"cd":   ChangeDirExecutor,
+func isSameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
 	}
+	bInfo, err := os.Stat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
 
-	var pathFolders []string
-	path := os.Getenv("PATH")
-	if len(path) > 0 {
-		pathFolders = strings.Split(path, ":")
-	} else {
-		pathFolders = make([]string, 0)
+func NewShellCtx() *ShellCtx {
+	var builtins = map[string]Executor{
+		"exit":       ExitExecutor,
+		"history":    HistoryExecutor,
+		"fc":         FcExecutor,
+		"complete":   CompleteExecutor,
+		"echo":       EchoExecutor,
+		"type":       TypeExecutor,
+		"pwd":        PwdExecutor,
+		"cd":         ChangeDirExecutor,
+		"env":        EnvExecutor,
+		"source":     SourceExecutor,
+		".":          DotExecutor,
+		"readonly":   ReadonlyExecutor,
+		"unset":      UnsetExecutor,
+		"envsave":    EnvSaveExecutor,
+		"envrestore": EnvRestoreExecutor,
+		"alias":      AliasExecutor,
+		"unalias":    UnaliasExecutor,
+		"run":        RunExecutor,
+		"jobs":       JobsExecutor,
+		"kill":       KillExecutor,
+		"wait":       WaitExecutor,
+		"disown":     DisownExecutor,
+		"nohup":      NohupExecutor,
+		"pushd":      PushdExecutor,
+		"popd":       PopdExecutor,
+		"dirs":       DirsExecutor,
+		"shopt":      ShoptExecutor,
+		"hash":       HashExecutor,
+		"set":        SetExecutor,
+		"shift":      ShiftExecutor,
+		"break":      BreakExecutor,
+		"continue":   ContinueExecutor,
+		"return":     ReturnExecutor,
+		"local":      LocalExecutor,
+		"trap":       TrapExecutor,
+		"eval":       EvalExecutor,
+		"exec":       ExecExecutor,
+		"test":       TestExecutor,
+		"[":          BracketExecutor,
+		"true":       TrueExecutor,
+		"false":      FalseExecutor,
+		":":          TrueExecutor,
+		"printf":     PrintfExecutor,
+		"read":       ReadExecutor,
+		"getopts":    GetoptsExecutor,
+		"umask":      UmaskExecutor,
+		"ulimit":     UlimitExecutor,
+		"times":      TimesExecutor,
+		"help":       HelpExecutor,
+		"which":      WhichExecutor,
+		"mapfile":    MapfileExecutor,
+		"readarray":  MapfileExecutor,
+		"let":        LetExecutor,
+		"declare":    DeclareExecutor,
+		"typeset":    DeclareExecutor,
 	}
 
 	currentDir, err := os.Getwd()
 	if err != nil {
 		panic(err)
 	}
+	// Keep the logical path we were started in when PWD still names it.
+	if pwd := os.Getenv("PWD"); filepath.IsAbs(pwd) && isSameFile(pwd, currentDir) {
+		currentDir = filepath.Clean(pwd)
+	}
 
-	shellCtx := &ShellCtx{Builtins: builtins, PathFolders: pathFolders, CurrentDir: currentDir}
-	for {
-		shellCtx.Serr = ""
-		shellCtx.Sout = ""
+	shellCtx := &ShellCtx{
+		Builtins:    builtins,
+		Runner:      ExecRunner{},
+		PathFolders: SplitPath(os.Getenv("PATH")),
+		CurrentDir:  currentDir,
+		Vars:        NewVariables(os.Environ()),
+		DynamicVars: NewDynamicVars(),
+		Aliases:     map[string]string{},
+		Functions:   map[string]*FunctionDef{},
+		Traps:       NewTrapTable(),
+		Options:     map[string]bool{},
+		NamedDirs:   map[string]string{},
+		PathCache:   map[string]HashEntry{},
+		StartTime:   time.Now(),
+		Random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		ShellName:   os.Args[0],
+		Jobs:        &JobTable{},
+		History:     &History{},
+		Output:      NewOutputGuard(os.Stdout),
+		Terminal:    NewTerminal(int(os.Stdin.Fd())),
+		Streams:     Streams{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr},
+
+		SourcedFiles: map[string]bool{},
+		EnvSnapshots: map[string]*EnvSnapshot{},
+		Completions:  map[string]*CompletionSpec{},
+	}
+	shellCtx.CommandIndex = NewCommandIndex("", nil)
+	shellCtx.ExportVar("PWD", currentDir)
+	shellCtx.SetVar("OPTIND", "1")
+	shellCtx.EnterShellLevel()
+	return shellCtx
+}
 
-		fmt.Fprint(os.Stdout, "$ ")
+type Options struct {
+	RcFile   string
+	SafeMode bool
+	Colors   string
+	Command  string
+	// Args are the operands after the options: the script to run and its
+	// arguments, or $0 and the positional parameters of a -c string.
+	Args []string
+}
 
-		// Wait for user input
-		commandWithArgs, err := bufio.NewReader(os.Stdin).ReadString('\n')
-		if err != nil {
-			fmt.Printf("Failed to read input: %s\n", err.Error())
-			os.Exit(1)
-		}
-		commandWithArgs = commandWithArgs[:len(commandWithArgs)-1]
-		parsedCommand := ParseArgs(commandWithArgs)
+func ParseOptions(args []string) (*Options, error) {
+	options := &Options{}
+	flags := flag.NewFlagSet("myshell", flag.ContinueOnError)
+	flags.StringVar(&options.Command, "c", "", "run the commands in `string` and exit with their status")
+	flags.StringVar(&options.RcFile, "rcfile", "", "read startup commands from `file` instead of the default rc file")
+	flags.BoolVar(&options.SafeMode, "safe", false, "disable command substitution in expansions")
+	flags.StringVar(&options.Colors, "colors", "", "force the terminal color `depth`: auto, none, 16, 256 or truecolor")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	options.Args = flags.Args()
+	return options, nil
+}
 
-		if len(parsedCommand) == 0 {
-			continue
+func main() {
+	options, err := ParseOptions(os.Args[1:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	shellCtx := NewShellCtx()
+	shellCtx.SafeMode = options.SafeMode
+	if err := shellCtx.InitColorDepth(options.Colors); err != nil {
+		fmt.Fprintf(os.Stderr, "myshell: %s\n", err.Error())
+		os.Exit(2)
+	}
+	shellCtx.HandleSignals()
+	CatchBrokenPipes()
+	defer func() {
+		if r := recover(); r != nil {
+			shellCtx.Terminal.Restore()
+			panic(r)
 		}
+	}()
 
-		args := make([]string, 0)
-		command := parsedCommand[0]
-
-		sOut := os.Stdout
-		sErr := os.Stderr
-
-		if len(parsedCommand) > 0 {
-			args = parsedCommand[1:]
-
-			cutIdx := -1
-			for i := range args {
-				if args[i] == ">" || args[i] == "1>" {
-					sOut, err = os.OpenFile(args[i+1], os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-					if err != nil {
-						panic(err)
-					}
-					if cutIdx == -1 {
-						cutIdx = i
-					}
-				} else if args[i] == ">>" || args[i] == "1>>" {
-					sOut, err = os.OpenFile(args[i+1], os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-					if err != nil {
-						panic(err)
-					}
-					if cutIdx == -1 {
-						cutIdx = i
-					}
-				} else if args[i] == "2>" {
-					sErr, err = os.OpenFile(args[i+1], os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-					if err != nil {
-						panic(err)
-					}
-					if cutIdx == -1 {
-						cutIdx = i
-					}
-				} else if args[i] == "2>>" {
-					sErr, err = os.OpenFile(args[i+1], os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-					if err != nil {
-						panic(err)
-					}
-					if cutIdx == -1 {
-						cutIdx = i
-					}
-				}
-			}
+	if len(options.Command) > 0 {
+		if len(options.Args) > 0 {
+			shellCtx.ShellName = options.Args[0]
+			shellCtx.PositionalArgs = options.Args[1:]
+		}
+		shellCtx.Exit(shellCtx.RunLine(options.Command))
+	}
+	if len(options.Args) > 0 {
+		shellCtx.Exit(shellCtx.RunScript(options.Args[0], options.Args[1:]))
+	}
+	shellCtx.Interactive = true
+	shellCtx.HandleInteractiveSignals()
+	shellCtx.Options["monitor"] = true
+	shellCtx.Options["histexpand"] = true
+	shellCtx.LoadRcFile(options.RcFile)
+	shellCtx.LoadHistory()
+	shellCtx.CommandIndex = NewCommandIndex(shellCtx.CommandIndexPath(), shellCtx.PathFolders)
+	shellCtx.DeferPrefetch(shellCtx.CommandIndex.Prefetch)
+
+	reader := bufio.NewReader(os.Stdin)
+	var editor *LineEditor
+	if shellCtx.Terminal.IsTerminal() && isTerminalFile(os.Stdout) {
+		editor = NewLineEditor(shellCtx, os.Stdin)
+	}
+	readLine := func(continued bool) (string, error) {
+		prompt := shellCtx.SecondaryPrompt()
+		if !continued {
+			shellCtx.ShareHistory()
+			shellCtx.NotifyJobs()
+			shellCtx.RunPromptCommand()
+			prompt = shellCtx.Prompt()
+		}
+		if editor != nil {
+			shellCtx.startPrefetches()
+			line, err := editor.ReadLine(prompt)
+			shellCtx.LineNo++
+			return line, err
+		}
+		shellCtx.Output.ShowPrompt(prompt, nil)
+		shellCtx.startPrefetches()
 
-			if cutIdx != -1 {
-				args = args[:cutIdx]
+		// Wait for user input
+		line, err := reader.ReadString('\n')
+		shellCtx.Output.LeavePrompt()
+		shellCtx.LineNo++
+		return strings.TrimSuffix(line, "\n"), err
+	}
+	for {
+		command, err := shellCtx.ReadCommand(readLine)
+		if errors.Is(err, errLineInterrupted) {
+			shellCtx.LastStatus = 130
+			continue
+		}
+		if err != nil {
+			if len(command) > 0 {
+				shellCtx.RunLine(command)
 			}
+			fmt.Printf("Failed to read input: %s\n", err.Error())
+			shellCtx.Exit(1)
 		}
-
-		executor, found := shellCtx.Builtins[command]
-		if found {
-			err = executor(shellCtx, args)
+		shellCtx.RunPendingTraps()
+		if shellCtx.Options["histexpand"] {
+			expanded, err := shellCtx.History.Expand(command)
 			if err != nil {
-				fmt.Printf("Failed execute command %s with args %s: %s\n", command, args, err.Error())
+				fmt.Fprintf(os.Stderr, "myshell: %s\n", err.Error())
+				shellCtx.LastStatus = 1
+				continue
 			}
-		} else {
-			execPath, found := SearchExecInPathFolders(command, shellCtx.PathFolders)
-			if found {
-				err := RunExternalCommand(execPath, args, shellCtx)
-				if err != nil {
-					fmt.Printf("Failed execute external command %s with args %s: %s\n", execPath, args, err.Error())
-				}
-			} else {
-				fmt.Printf("%s: command not found\n", command)
+			if expanded != command {
+				fmt.Fprintln(os.Stderr, expanded)
+				command = expanded
 			}
 		}
-
-		if _, err := io.Copy(sOut, strings.NewReader(shellCtx.Sout)); err != nil {
-			fmt.Printf("Failed to copy to stdout: %s", err.Error())
-		}
-
-		if _, err := io.Copy(sErr, strings.NewReader(shellCtx.Serr)); err != nil {
-			fmt.Printf("Failed to copy to stderr: %s", err.Error())
-		}
-
-		if sOut != os.Stdout {
-			sOut.Close()
-		}
-
-		if sErr != os.Stderr {
-			sErr.Close()
+		shellCtx.AddHistory(command)
+		shellCtx.ShareHistory()
+		shellCtx.RunLine(command)
+		if shellCtx.interrupted() {
+			// The terminal echoed ^C, and the prompt goes below it.
+			fmt.Fprintln(os.Stderr)
+			shellCtx.interrupt.Store(0)
+			shellCtx.LastStatus = 130
 		}
 	}
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain lets tests start the test binary as the shell itself: with
+// MYSHELL_TEST_MAIN set it runs main instead of the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("MYSHELL_TEST_MAIN") != "" {
+		os.Args = append([]string{"myshell"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the shell with args and returns what it printed on stdout
+// and its exit status.
+func runMain(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "MYSHELL_TEST_MAIN=1")
+	cmd.Dir = t.TempDir()
+	output, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(output), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), 0
+}
+
+func TestEnv(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"env -i A=1 B=2", "A=1\nB=2\n"},
+		{"env -i", ""},
+		{"env -i -u B A=1 B=2", "A=1\n"},
+		{`env A=2 B=3 sh -c 'echo $A$B'`, "23\n"},
+		{`env -u HOME sh -c 'echo "[$HOME]"'`, "[]\n"},
+		{"x=1; env | grep -c '^x='", "0\n"},
+		{`A=1; env A=2 sh -c 'echo $A'; echo $A`, "2\n1\n"},
+		{"env -i nosuch; echo $?", "127\n"},
+		{"env -z; echo $?", "1\n"},
+	})
+}
+
+func TestCdMaintainsPwd(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`mkdir sub; cd sub; [ "$PWD" = "$OLDPWD/sub" ] && echo updated`, "updated\n"},
+		{`mkdir sub; cd sub; sh -c '[ "$PWD" = "$OLDPWD/sub" ]' && echo exported`, "exported\n"},
+		{`mkdir sub; cd sub; [ "$PWD" = "$(pwd)" ] && echo matches`, "matches\n"},
+		{`start=$PWD; cd missing; [ "$PWD" = "$start" ] && echo unchanged`, "unchanged\n"},
+		{`mkdir -p a/b; cd a/b; cd ../..; [ "$OLDPWD" = "$PWD/a/b" ] && echo old`, "old\n"},
+	})
+}
+
+func TestCdHome(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`mkdir h; HOME=$(pwd)/h; cd; [ "$PWD" = "$HOME" ] && echo home`, "home\n"},
+		{`mkdir h; HOME=$(pwd)/h; cd --; [ "$PWD" = "$HOME" ] && echo home`, "home\n"},
+		{`mkdir -- -d; start=$(pwd); cd -- -d; [ "$PWD" = "$start/-d" ] && echo dashed`, "dashed\n"},
+		{"HOME=; cd; echo $?", "1\n"},
+		{"cd a b; echo $?", "1\n"},
+	})
+}
+
+func TestCdPrevious(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`mkdir a b; start=$(pwd); cd a; cd ../b; cd - > $start/out; [ "$(cat $start/out)" = "$start/a" ] && echo printed`, "printed\n"},
+		{`mkdir a; start=$(pwd); cd a; cd - > /dev/null; [ "$PWD" = "$start" ] && echo back`, "back\n"},
+		{`mkdir a; start=$(pwd); cd a; cd - > /dev/null; cd - > /dev/null; [ "$PWD" = "$start/a" ] && echo toggled`, "toggled\n"},
+		{"unset OLDPWD; cd -; echo $?", "1\n"},
+	})
+}
+
+func TestCdPath(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`mkdir -p base/proj other; start=$(pwd); CDPATH=$start/base; cd other; cd proj > $start/out; [ "$(cat $start/out)" = "$start/base/proj" ] && echo printed`, "printed\n"},
+		{`mkdir -p base/proj proj; start=$(pwd); CDPATH=:$start/base; cd proj; [ "$PWD" = "$start/proj" ] && echo local`, "local\n"},
+		{`mkdir -p base/proj proj; start=$(pwd); CDPATH=$start/base; cd ./proj; [ "$PWD" = "$start/proj" ] && echo dotted`, "dotted\n"},
+		{`mkdir -p x/a y/a; start=$(pwd); CDPATH=$start/y:$start/x; cd a > /dev/null; [ "$PWD" = "$start/y/a" ] && echo first`, "first\n"},
+		{`mkdir base; CDPATH=$(pwd)/base; cd proj; echo $?`, "1\n"},
+	})
+}
+
+func TestCdErrors(t *testing.T) {
+	const setup = `touch file; mkdir dir; T=$(pwd); `
+	checkScripts(t, []scriptTest{
+		{setup + `cd file 2>&1 | sed "s|$T|T|"`, "cd: T/file: Not a directory\n"},
+		{setup + `cd file/x 2>&1 | sed "s|$T|T|"`, "cd: T/file/x: Not a directory\n"},
+		{setup + `cd missing 2>&1 | sed "s|$T|T|"`, "cd: T/missing: No such file or directory\n"},
+		{setup + `cd file; echo $?; [ "$(pwd)" = "$T" ] && echo stayed`, "1\nstayed\n"},
+		{setup + "cd dir; echo $?", "0\n"},
+	})
+}
+
+func TestCheckEnterable(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file"), nil, 0o644)
+	os.Mkdir(filepath.Join(dir, "locked"), 0)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{".", ""},
+		{"file", "Not a directory"},
+		{"missing", "No such file or directory"},
+		{"missing/x", "No such file or directory"},
+		{"locked", "Permission denied"},
+	}
+	for _, test := range tests {
+		if test.name == "locked" && os.Geteuid() == 0 {
+			// Root may enter any directory.
+			continue
+		}
+		got := ""
+		if err := checkEnterable(filepath.Join(dir, test.name)); err != nil {
+			got = describeOpenError(err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLogicalAndPhysicalPaths(t *testing.T) {
+	const setup = `mkdir -p real/in; ln -s real link; T=$(pwd -P); show() { "$@" | sed "s|$T|T|"; }; `
+	checkScripts(t, []scriptTest{
+		{setup + "cd link/in; show pwd; show pwd -L; show pwd -P", "T/link/in\nT/link/in\nT/real/in\n"},
+		{setup + "cd link/in; cd ..; show pwd", "T/link\n"},
+		{setup + "cd -P link; show pwd", "T/real\n"},
+		{setup + "cd link/in; cd -P ..; show pwd", "T/real\n"},
+		{setup + "cd -P link; cd -L ../link; show pwd", "T/link\n"},
+		{setup + `cd link; show sh -c 'echo $PWD'`, "T/link\n"},
+		{setup + "cd -L -P link; show pwd", "T/real\n"},
+		{"pwd -x; echo $?", "1\n"},
+	})
+}
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		args   []string
+		want   string
+		status int
+	}{
+		{[]string{"-c", "echo hi && echo there"}, "hi\nthere\n", 0},
+		{[]string{"-c", "exit 3"}, "", 3},
+		{[]string{"-c", "false"}, "", 1},
+		{[]string{"-c", "missing-command"}, "", 127},
+		{[]string{"-c", "echo $0 $1 $#", "name", "one", "two"}, "name one 2\n", 0},
+		{[]string{"-c", "echo $0", "name"}, "name\n", 0},
+		{[]string{"-c", "if"}, "", 2},
+		{[]string{"-nosuchflag"}, "", 2},
+	}
+	for _, test := range tests {
+		if got, status := runMain(t, test.args...); got != test.want || status != test.status {
+			t.Errorf("%q: got %q, status %d, want %q, status %d", test.args, got, status, test.want, test.status)
+		}
+	}
+}
+
+func TestScriptArguments(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.sh")
+	os.WriteFile(script, []byte("echo $# \"$1\"\nfor a in \"$@\"; do echo \"<$a>\"; done\nshift\necho $*\nexit 5\n"), 0o644)
+	got, status := runMain(t, script, "one two", "three")
+	if want := "2 one two\n<one two>\n<three>\nthree\n"; got != want || status != 5 {
+		t.Errorf("script: got %q, status %d, want %q, status 5", got, status, want)
+	}
+}
+
+func TestStatusBuiltins(t *testing.T) {
+	// Executables of the same names that say otherwise are never run.
+	runner := &FakeRunner{Responses: map[string]FakeResponse{
+		"true":  {Status: 1},
+		"false": {Status: 0},
+	}}
+	tests := []struct {
+		line   string
+		want   string
+		status int
+	}{
+		{"true", "", 0},
+		{"true ignored args", "", 0},
+		{"false", "", 1},
+		{"false; echo $?", "1\n", 0},
+		{": $((n = 3)); echo $n", "3\n", 0},
+		{"! true", "", 1},
+		{"n=0; while true; do n=$((n+1)); [ $n = 3 ] && break; done; echo $n", "3\n", 0},
+		{"until false; do echo once; break; done", "once\n", 0},
+	}
+	for _, test := range tests {
+		if got, status := runFake(t, runner, test.line); got != test.want || status != test.status {
+			t.Errorf("%s: got %q, status %d, want %q, status %d", test.line, got, status, test.want, test.status)
+		}
+	}
+	if len(runner.Calls) != 0 {
+		t.Errorf("spawned %q", runner.Calls)
+	}
+}
+
+func TestType(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{pathDirs + `alias ll='ls -l'; f() { :; }; type tool ll f echo if | sed "s|$T|T|"`,
+			"tool is T/a/tool\nll is aliased to `ls -l'\nf is a function\nf() { :; }\necho is a shell builtin\nif is a shell keyword\n"},
+		{pathDirs + "alias ll='ls -l'; f() { :; }; type -t tool ll f echo if; echo $?", "file\nalias\nfunction\nbuiltin\nkeyword\n0\n"},
+		{pathDirs + "type -t tool nosuch; echo $?", "file\n1\n"},
+		{pathDirs + "type nosuch; echo $?", "1\n"},
+		{pathDirs + `type -a echo tool | sed "s|$T|T|" | grep -v /bin/echo`, "echo is a shell builtin\necho is T/b/echo\ntool is T/a/tool\ntool is T/b/tool\n"},
+		{pathDirs + `f() { :; }; type -p tool f | sed "s|$T|T|"`, "T/a/tool\n"},
+		{pathDirs + `type -P echo | sed "s|$T|T|"`, "T/b/echo\n"},
+		{pathDirs + "type noexec; echo $?", "1\n"},
+	})
+}
+
+func TestScriptWithoutShebang(t *testing.T) {
+	// The shell that runs such a script is the test binary run as main.
+	script := `printf 'echo script $0 $1 $#\nf 2>/dev/null || echo no function\necho "[$x]"\nexit 3\n' > s; chmod +x s; f() { :; }; x=1; `
+	tests := []struct {
+		command string
+		want    string
+		status  int
+	}{
+		{script + "./s a b", "script ./s a 2\nno function\n[]\n", 3},
+		{script + `mkdir d; mv s d; cd d; ../d/s; PATH=$PWD:$PATH; s q 2>&1 | sed "s|$PWD|D|"`, "script ../d/s 0\nno function\n[]\nscript D/s q 1\nno function\n[]\n", 0},
+		{`printf '#!/bin/sh\necho sh $#\n' > t; chmod +x t; ./t x`, "sh 1\n", 0},
+		{`printf '\177ELF\000' > b; chmod +x b; ./b 2>/dev/null`, "", 126},
+	}
+	for _, test := range tests {
+		if got, status := runMain(t, "-c", test.command); got != test.want || status != test.status {
+			t.Errorf("%s: got %q, status %d, want %q, status %d", test.command, got, status, test.want, test.status)
+		}
+	}
+}
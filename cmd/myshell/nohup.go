@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// NohupExecutor implements `nohup command [arg ...]`, running an external
+// command with SIGHUP ignored so that it outlives the terminal. Output that
+// would go to the terminal is appended to nohup.out, in the current
+// directory or else in $HOME, with stderr following stdout, and input from
+// the terminal is replaced by /dev/null.
+func NohupExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("nohup command takes a command to run")
+	}
+	execPath, found := shellCtx.Runner.LookPath(shellCtx, args[0])
+	if !found {
+		shellCtx.Serr = fmt.Sprintf("nohup: failed to run command '%s': No such file or directory\n", args[0])
+		shellCtx.Status = 127
+		return nil
+	}
+
+	streams := shellCtx.Streams
+	ignoringInput := isTerminalFile(streams.Stdin)
+	if ignoringInput {
+		devNull, err := os.Open(os.DevNull)
+		if err == nil {
+			defer devNull.Close()
+			streams.Stdin = devNull
+		}
+	}
+	if isTerminalFile(streams.Stdout) {
+		output, name, err := openNohupOutput(shellCtx)
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("nohup: failed to open 'nohup.out': %s\n", describeOpenError(err))
+			shellCtx.Status = 127
+			return nil
+		}
+		defer output.Close()
+		message := " appending output to '" + name + "'"
+		if ignoringInput {
+			message = " ignoring input and" + message
+		}
+		fmt.Fprintf(streams.Stderr, "nohup:%s\n", message)
+		streams.Stdout = output
+		if isTerminalFile(streams.Stderr) {
+			streams.Stderr = output
+		}
+	} else if ignoringInput {
+		fmt.Fprintln(streams.Stderr, "nohup: ignoring input")
+	}
+
+	shellCtx.ignoreHangups = true
+	defer func() { shellCtx.ignoreHangups = false }()
+	status, err := shellCtx.RunExternalCommand(execPath, args[0], args[1:], shellCtx.Environ(), streams)
+	if err != nil {
+		shellCtx.Serr = fmt.Sprintf("nohup: failed to run command '%s': %s\n", args[0], describeOpenError(err))
+		status = 126
+	}
+	shellCtx.Status = status
+	return nil
+}
+
+func openNohupOutput(shellCtx *ShellCtx) (*os.File, string, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	output, err := os.OpenFile(shellCtx.ResolvePath("nohup.out"), flags, 0600)
+	if err == nil {
+		return output, "nohup.out", nil
+	}
+	home, found := shellCtx.GetVar("HOME")
+	if !found {
+		return nil, "", err
+	}
+	name := filepath.Join(home, "nohup.out")
+	output, homeErr := os.OpenFile(name, flags, 0600)
+	if homeErr != nil {
+		return nil, "", err
+	}
+	return output, name, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNohup(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`nohup sh -c 'kill -HUP $$; echo survived'; echo $?`, "survived\n0\n"},
+		{`sh -c 'kill -HUP $$; echo survived'; echo $?`, "129\n"},
+		{`nohup sh -c 'exit 4'; echo $?`, "4\n"},
+		{"nohup -- echo args", "args\n"},
+		{"nohup nosuch; echo $?", "127\n"},
+		{"touch plain; nohup ./plain; echo $?", "126\n"},
+		{"nohup; echo $?", "1\n"},
+		{"nohup echo kept; [ -e nohup.out ] || echo no file", "kept\nno file\n"},
+	})
+}
+
+func TestNohupOutputFile(t *testing.T) {
+	tty := openPty(t)
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	shellCtx.Streams = Streams{Stdin: tty, Stdout: tty, Stderr: stderr}
+
+	// Output meant for the terminal is appended to nohup.out, along with
+	// the errors when they too would go to the terminal.
+	shellCtx.RunLine("nohup echo first; nohup sh -c 'echo second; echo oops >&2' 2>&1")
+	output, err := os.ReadFile(filepath.Join(shellCtx.CurrentDir, "nohup.out"))
+	if want := "first\nsecond\noops\n"; err != nil || string(output) != want {
+		t.Errorf("nohup.out: %q, %v, want %q", output, err, want)
+	}
+	// The second message went to the terminal, with stderr.
+	messages, _ := os.ReadFile(stderr.Name())
+	if want := "nohup: ignoring input and appending output to 'nohup.out'\n"; string(messages) != want {
+		t.Errorf("messages: got %q", messages)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shoptNames lists the options shopt knows about. Options that aren't set
+// are simply missing from ShellCtx.Options.
+var shoptNames = map[string]bool{
+	"autocd":         true,
+	"cdspell":        true,
+	"dotglob":        true,
+	"fuzzycomplete":  true,
+	"globstar":       true,
+	"histappend":     true,
+	"histshare":      true,
+	"huponexit":      true,
+	"nocasecomplete": true,
+	"nocaseglob":     true,
+	"nullglob":       true,
+}
+
+// setOptions maps the single letter flags of set to the long names used
+// with set -o, 0 for those that only have a long name. Like shopt options,
+// they live in ShellCtx.Options.
+var setOptions = map[string]byte{
+	"errexit":    'e',
+	"errtrace":   'E',
+	"functrace":  'T',
+	"histexpand": 'H',
+	"monitor":    'm',
+	"notify":     'b',
+	"nounset":    'u',
+	"pipefail":   0,
+	"xtrace":     'x',
+}
+
+func setOptionByFlag(flag rune) (string, bool) {
+	for name, letter := range setOptions {
+		if letter != 0 && rune(letter) == flag {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// OptionFlags renders the single letter options that are on, as $- does.
+func (ctx *ShellCtx) OptionFlags() string {
+	flags := []byte{}
+	for name, letter := range setOptions {
+		if ctx.Options[name] && letter != 0 {
+			flags = append(flags, letter)
+		}
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	return string(flags)
+}
+
+// SetExecutor implements `set [-euxET] [-o name] [+euxET] [+o name] [--
+// arg ...]`.
+// Without arguments or with a lone -o it lists the options; arguments that
+// remain after the options replace the positional parameters.
+func SetExecutor(shellCtx *ShellCtx, args []string) error {
+	listOptions := len(args) == 0
+	for len(args) > 0 && len(args[0]) > 1 && (args[0][0] == '-' || args[0][0] == '+') {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			shellCtx.PositionalArgs = args
+			return nil
+		}
+		enable := option[0] == '-'
+		for _, flag := range option[1:] {
+			if flag == 'o' {
+				if len(args) == 0 || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[0], "+") {
+					listOptions = true
+					continue
+				}
+				name := args[0]
+				args = args[1:]
+				if _, found := setOptions[name]; !found {
+					shellCtx.Serr += fmt.Sprintf("set: %s: invalid option name\n", name)
+					shellCtx.Status = 1
+					return nil
+				}
+				shellCtx.setOption(name, enable)
+				continue
+			}
+			name, found := setOptionByFlag(flag)
+			if !found {
+				return fmt.Errorf("set command got invalid option %c%c", option[0], flag)
+			}
+			shellCtx.setOption(name, enable)
+		}
+	}
+
+	if listOptions {
+		names := make([]string, 0, len(setOptions))
+		for name := range setOptions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			state := "off"
+			if shellCtx.Options[name] {
+				state = "on"
+			}
+			shellCtx.Sout += fmt.Sprintf("%-15s\t%s\n", name, state)
+		}
+	}
+	if len(args) > 0 {
+		shellCtx.PositionalArgs = args
+	}
+	return nil
+}
+
+// ShiftExecutor implements `shift [n]`, dropping the first n positional
+// parameters.
+func ShiftExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("shift command takes at most 1 argument of type int")
+	}
+	count := 1
+	if len(args) == 1 {
+		var err error
+		if count, err = strconv.Atoi(args[0]); err != nil || count < 0 {
+			shellCtx.Serr = fmt.Sprintf("shift: %s: numeric argument required\n", args[0])
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+	if count > len(shellCtx.PositionalArgs) {
+		shellCtx.Serr = fmt.Sprintf("shift: %d: shift count out of range\n", count)
+		shellCtx.Status = 1
+		return nil
+	}
+	shellCtx.PositionalArgs = shellCtx.PositionalArgs[count:]
+	return nil
+}
+
+// GetoptsExecutor implements `getopts optstring name [arg ...]`, which puts
+// the next option in name each time it is called. A letter followed by : in
+// optstring takes an argument, left in OPTARG, and OPTIND is the index of
+// the next argument to look at. With a leading : errors are reported
+// through name and OPTARG instead of messages.
+func GetoptsExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("getopts command takes an option string and a variable name")
+	}
+	optstring, name, args := args[0], args[1], args[2:]
+	if !IsValidName(name) {
+		shellCtx.Serr = fmt.Sprintf("getopts: `%s': not a valid identifier\n", name)
+		shellCtx.Status = 1
+		return nil
+	}
+	if len(args) == 0 {
+		args = shellCtx.PositionalArgs
+	}
+	silent := strings.HasPrefix(optstring, ":")
+	quiet := silent
+	if opterr, _ := shellCtx.GetVar("OPTERR"); opterr == "0" {
+		quiet = true
+	}
+
+	value, _ := shellCtx.GetVar("OPTIND")
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 1 {
+		index = 1
+	}
+	if index != shellCtx.getoptsIndex {
+		shellCtx.getoptsIndex, shellCtx.getoptsOffset = index, 0
+	}
+	finish := func(option string) error {
+		shellCtx.SetVar("OPTIND", strconv.Itoa(shellCtx.getoptsIndex))
+		return shellCtx.SetVar(name, option)
+	}
+	fail := func(message string, option byte, silentResult string) error {
+		if silent {
+			shellCtx.SetVar("OPTARG", string(option))
+			return finish(silentResult)
+		}
+		if !quiet {
+			shellCtx.Serr = fmt.Sprintf("%s: %s -- %c\n", shellCtx.ShellName, message, option)
+		}
+		shellCtx.UnsetVar("OPTARG")
+		return finish("?")
+	}
+
+	if index > len(args) {
+		shellCtx.Status = 1
+		return finish("?")
+	}
+	arg := args[index-1]
+	if shellCtx.getoptsOffset == 0 {
+		if arg == "--" {
+			shellCtx.getoptsIndex++
+		}
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			shellCtx.Status = 1
+			return finish("?")
+		}
+		shellCtx.getoptsOffset = 1
+	}
+
+	option := arg[shellCtx.getoptsOffset]
+	shellCtx.getoptsOffset++
+	rest := arg[shellCtx.getoptsOffset:]
+	if rest == "" {
+		shellCtx.getoptsIndex++
+		shellCtx.getoptsOffset = 0
+	}
+	position := strings.IndexByte(optstring, option)
+	if option == ':' || position == -1 {
+		return fail("illegal option", option, "?")
+	}
+	if position+1 >= len(optstring) || optstring[position+1] != ':' {
+		shellCtx.UnsetVar("OPTARG")
+		return finish(string(option))
+	}
+
+	// The argument is the rest of this word or the next one.
+	switch {
+	case rest != "":
+		shellCtx.getoptsIndex++
+		shellCtx.getoptsOffset = 0
+	case shellCtx.getoptsIndex <= len(args):
+		rest = args[shellCtx.getoptsIndex-1]
+		shellCtx.getoptsIndex++
+	default:
+		return fail("option requires an argument", option, ":")
+	}
+	shellCtx.SetVar("OPTARG", rest)
+	return finish(string(option))
+}
+
+func (ctx *ShellCtx) setOption(name string, enable bool) {
+	if enable {
+		ctx.Options[name] = true
+	} else {
+		delete(ctx.Options, name)
+	}
+}
+
+// ShoptExecutor implements `shopt [-pqsuo] [optname ...]`. With -o it works
+// on the options of set -o instead.
+func ShoptExecutor(shellCtx *ShellCtx, args []string) error {
+	set, unset, print, quiet, setNames := false, false, false, false, false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 's':
+				set = true
+			case 'u':
+				unset = true
+			case 'p':
+				print = true
+			case 'q':
+				quiet = true
+			case 'o':
+				setNames = true
+			default:
+				return fmt.Errorf("shopt command got invalid option %s", option)
+			}
+		}
+	}
+	if set && unset {
+		return fmt.Errorf("shopt command cannot set and unset options at the same time")
+	}
+
+	known := func(name string) bool { return shoptNames[name] }
+	if setNames {
+		known = func(name string) bool {
+			_, found := setOptions[name]
+			return found
+		}
+	}
+	names := args
+	if len(names) == 0 {
+		all := shoptNames
+		if setNames {
+			all = map[string]bool{}
+			for name := range setOptions {
+				all[name] = true
+			}
+		}
+		for name := range all {
+			if (!set && !unset) || shellCtx.Options[name] == set {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if set || unset {
+			// With no names, -s and -u list the options in that state.
+			set, unset = false, false
+		}
+	}
+
+	for _, name := range names {
+		if !known(name) {
+			shellCtx.Serr += fmt.Sprintf("shopt: %s: invalid shell option name\n", name)
+			shellCtx.Status = 1
+			continue
+		}
+		switch {
+		case set || unset:
+			shellCtx.setOption(name, set)
+		case quiet:
+			if !shellCtx.Options[name] {
+				shellCtx.Status = 1
+			}
+		case print:
+			flag := "-u"
+			if shellCtx.Options[name] {
+				flag = "-s"
+			}
+			if setNames {
+				flag = "+o"
+				if shellCtx.Options[name] {
+					flag = "-o"
+				}
+				shellCtx.Sout += fmt.Sprintf("set %s %s\n", flag, name)
+				continue
+			}
+			shellCtx.Sout += fmt.Sprintf("shopt %s %s\n", flag, name)
+		default:
+			state := "off"
+			if shellCtx.Options[name] {
+				state = "on"
+			}
+			shellCtx.Sout += fmt.Sprintf("%-15s\t%s\n", name, state)
+			if !shellCtx.Options[name] && len(args) > 0 {
+				shellCtx.Status = 1
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestShift(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"set -- a b c d; shift; echo $# $@", "3 b c d\n"},
+		{"set -- a b c d; shift 2; echo $1", "c\n"},
+		{"set -- a b c d; shift 4; echo $? $#", "0 0\n"},
+		{"set -- a; shift 5; echo $? $@", "1 a\n"},
+		{"set -- a; shift x; echo $? $@", "1 a\n"},
+		{"set -- a; shift 0; echo $@", "a\n"},
+		{"f() { shift; echo $@; }; set -- outer; f 1 2 3; echo $@", "2 3\nouter\n"},
+		{"set -- a b c; while [ $# -gt 0 ]; do echo $1; shift; done", "a\nb\nc\n"},
+	})
+}
+
+func TestGetopts(t *testing.T) {
+	parse := `f() { local OPTIND=1; while getopts "ab:c" opt; do echo "$opt $OPTARG"; done; shift $((OPTIND-1)); echo "rest $*"; }; `
+	silent := `g() { while getopts ":ab:" opt; do echo "$opt $OPTARG"; done; }; `
+	checkScripts(t, []scriptTest{
+		{parse + "f -a -b val -c x y", "a \nb val\nc \nrest x y\n"},
+		{parse + "f -ac -bval z", "a \nc \nb val\nrest z\n"},
+		{parse + "f -- -a", "rest -a\n"},
+		{parse + "f a -a", "rest a -a\n"},
+		{parse + "f -b", "? \nrest \n"},
+		{parse + "f -z rest", "? \nrest rest\n"},
+		{silent + "g -x -b", "? x\n: b\n"},
+		{`getopts a opt -a -q; echo "$? $opt $OPTIND"`, "0 a 2\n"},
+		{`set -- -a; getopts a o; echo "$? $o $OPTIND"; getopts a o; echo "$? $o $OPTIND"`, "0 a 2\n1 ? 2\n"},
+	})
+}
+
+func TestSetOptions(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"( set -e; echo a; false; echo b ); echo $?", "a\n1\n"},
+		{"( set -e; false || echo ok; false && :; ! true; echo survived )", "ok\nsurvived\n"},
+		{"( set -e; f() { false; echo in f; }; f; echo after ); echo $?", "1\n"},
+		{"( set -e; x=$(false); echo after ); echo $?", "1\n"},
+		{"( set -e; set +e; false; echo after )", "after\n"},
+		{"x=$(exit 3); echo $?; x=$(exit 3) y=$(true); echo $?", "3\n0\n"},
+		{"false; x=$?; echo $? $x", "0 1\n"},
+		{`( set -u; echo "$nope"; echo after ); echo $?`, "1\n"},
+		{"( set -u; echo $(( nope + 1 )); echo after ); echo $?", "1\n"},
+		{"( set -u; for x in $nope; do :; done; echo after ); echo $?", "1\n"},
+		{`( set -u; f() { echo $nope; echo in f; }; f; echo after ); echo $?`, "1\n"},
+		{`set -u; echo "[$(echo $nope; echo in)]"; echo after`, "[]\nafter\n"},
+		{`set -u; set --; echo "$@" "$#" ok`, "0 ok\n"},
+		{`{ set -x; echo "a b" $((1+1)); x=1 y="p q"; } 2>&1`, "+ echo 'a b' 2\na b 2\n+ x=1 y='p q'\n"},
+		{`{ set -x; set +x; echo quiet; } 2>&1`, "+ set +x\nquiet\n"},
+		{"false | true; echo $?; set -o pipefail; false | true; echo $?; set +o pipefail; false | true; echo $?", "0\n1\n0\n"},
+		{"set -o errexit -o nounset; case $- in *e*u*) echo both;; esac", "both\n"},
+		{"set -e; set -o | grep -E '^(errexit|pipefail) '", "errexit        \ton\npipefail       \toff\n"},
+		{"set -o nosuch; echo $?", "1\n"},
+		{`set -- a "b c"; echo $# "$2"; set --; echo $#`, "2 b c\n0\n"},
+	})
+}
+
+func TestShopt(t *testing.T) {
+	files := "mkdir -p d/e; touch .hid vis A.txt d/e/f.txt d/g.txt; "
+	checkScripts(t, []scriptTest{
+		{"shopt nullglob; echo $?; shopt -s nullglob; shopt nullglob; echo $?", "nullglob       \toff\n1\nnullglob       \ton\n0\n"},
+		{"shopt -s nullglob dotglob; shopt -u dotglob; shopt -p nullglob dotglob", "shopt -s nullglob\nshopt -u dotglob\n"},
+		{"shopt -s; shopt -s autocd cdspell; shopt -s", "autocd         \ton\ncdspell        \ton\n"},
+		{"shopt -s autocd; shopt -pu | grep -c autocd", "0\n"},
+		{"shopt -q autocd; echo $?; shopt -s autocd; shopt -q autocd; echo $?; shopt -q autocd nullglob; echo $?", "1\n0\n1\n"},
+		{"shopt -s nosuch; echo $?", "1\n"},
+		{"echo nomatch*; shopt -s nullglob; echo [nomatch*]", "nomatch*\n\n"},
+		{files + "echo *; shopt -s dotglob; echo *", "A.txt d vis\n.hid A.txt d vis\n"},
+		{files + "echo **/*.txt; shopt -s globstar; echo **/*.txt", "d/g.txt\nA.txt d/e/f.txt d/g.txt\n"},
+		{files + "echo a*; shopt -s nocaseglob; echo a*", "a*\nA.txt\n"},
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// StatusBrokenPipe is the status of a command that was killed by SIGPIPE.
+const StatusBrokenPipe = 128 + int(syscall.SIGPIPE)
+
+// CatchBrokenPipes stops the Go runtime from killing the shell when its own
+// stdout or stderr is a pipe nobody reads anymore; such writes fail with
+// EPIPE instead. Caught signals go back to their default disposition in
+// child processes, so external commands still die of SIGPIPE as usual.
+func CatchBrokenPipes() {
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGPIPE)
+}
+
+func IsBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
+
+// OutputGuard serializes writes of background jobs to the terminal with the
+// prompt. When a job prints while the shell is waiting for input, its output
+// goes above a freshly drawn prompt instead of into the middle of the line
+// the user is typing.
+type OutputGuard struct {
+	mu     sync.Mutex
+	out    io.Writer
+	prompt string
+	// line, when set, is the input line being edited, which the guard can
+	// erase before printing and draw again after.
+	line EditedLine
+}
+
+// EditedLine is an input line drawn by the line editor. Erase returns what
+// clears it from the screen, leaving the cursor where it started, and
+// Redraw what draws it again there: prompt, buffer and cursor position.
+type EditedLine interface {
+	Erase() string
+	Redraw() string
+}
+
+func NewOutputGuard(out io.Writer) *OutputGuard {
+	return &OutputGuard{out: out}
+}
+
+func (guard *OutputGuard) ShowPrompt(prompt string, line EditedLine) {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	guard.prompt = prompt
+	guard.line = line
+	if line != nil {
+		io.WriteString(guard.out, line.Redraw())
+	} else {
+		io.WriteString(guard.out, prompt)
+	}
+}
+
+// Edit applies a change to the line being edited and draws it again,
+// without output of background jobs getting in between.
+func (guard *OutputGuard) Edit(change func()) {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	if guard.line == nil {
+		change()
+		return
+	}
+	drawing := guard.line.Erase()
+	change()
+	io.WriteString(guard.out, drawing+guard.line.Redraw())
+}
+
+func (guard *OutputGuard) LeavePrompt() {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	guard.prompt = ""
+	guard.line = nil
+}
+
+func (guard *OutputGuard) Write(data []byte) (int, error) {
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	if len(guard.prompt) == 0 {
+		return guard.out.Write(data)
+	}
+
+	buffer := bytes.Buffer{}
+	if guard.line != nil {
+		buffer.WriteString(guard.line.Erase())
+	} else {
+		buffer.WriteString("\n")
+	}
+	buffer.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		buffer.WriteString("\n")
+	}
+	if guard.line != nil {
+		buffer.WriteString(guard.line.Redraw())
+	} else {
+		buffer.WriteString(guard.prompt)
+	}
+	if _, err := guard.out.Write(buffer.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (guard *OutputGuard) Forward(reader *os.File) {
+	defer reader.Close()
+	buffer := make([]byte, 4096)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			guard.Write(buffer[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,784 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type TokenKind int
+
+const (
+	TokenWord TokenKind = iota
+	TokenPipe
+	TokenRedirect
+	TokenBackground
+	TokenSemicolon
+	TokenAnd
+	TokenOr
+	TokenNewline
+	TokenLParen
+	TokenRParen
+	TokenCaseBreak
+)
+
+// Token is a word or an operator. Start and End are its byte offsets in
+// the input, which is how commands recover their source text.
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Start int
+	End   int
+}
+
+type Redirect struct {
+	Fd     int
+	Op     string
+	Target string
+}
+
+// Command is an element of a pipeline: a simple command or a compound
+// command such as if.
+type Command interface {
+	commandSource() string
+}
+
+type SimpleCommand struct {
+	Words     []string
+	Redirects []Redirect
+}
+
+func (command *SimpleCommand) commandSource() string {
+	return strings.Join(command.Words, " ")
+}
+
+type Pipeline struct {
+	Commands []Command
+	Source   string
+	// Timed is set by the time keyword in front of the pipeline, and
+	// PosixTime by time -p.
+	Timed     bool
+	PosixTime bool
+	// Negated is set by ! in front of the pipeline, which inverts its
+	// status.
+	Negated bool
+}
+
+// AndOrList is a chain of pipelines joined by && and ||; Operators[i] sits
+// between Pipelines[i] and Pipelines[i+1].
+type AndOrList struct {
+	Pipelines []*Pipeline
+	Operators []string
+	Source    string
+}
+
+type ListItem struct {
+	AndOr      *AndOrList
+	Background bool
+}
+
+// CommandList is what a command line parses into: and-or lists separated by
+// ; or &, the latter running them in the background.
+type CommandList struct {
+	Items []*ListItem
+}
+
+// Tokenize splits a command line into words and operators. Words are kept
+// raw, with their quotes, so that quote removal and expansions can happen
+// later with full knowledge of what was quoted.
+func Tokenize(input string) ([]Token, error) {
+	tokens := []Token{}
+	word := strings.Builder{}
+	inWord := false
+	wordStart := 0
+
+	flushWord := func(end int) {
+		if inWord {
+			tokens = append(tokens, Token{Kind: TokenWord, Value: word.String(), Start: wordStart, End: end})
+			word.Reset()
+			inWord = false
+		}
+	}
+	addOperator := func(kind TokenKind, start int, end int) {
+		tokens = append(tokens, Token{Kind: kind, Value: input[start:end], Start: start, End: end})
+	}
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		if !inWord && !isOperatorByte(c) && c != ' ' && c != '\t' && c != '\n' {
+			wordStart = i
+		}
+		switch c {
+		case ' ', '\t':
+			flushWord(i)
+		case '\n':
+			flushWord(i)
+			tokens = append(tokens, Token{Kind: TokenNewline, Value: "newline", Start: i, End: i + 1})
+		case '#':
+			if inWord {
+				word.WriteByte(c)
+				continue
+			}
+			// A comment runs up to the end of the line; the newline itself
+			// still separates commands.
+			for i+1 < len(input) && input[i+1] != '\n' {
+				i++
+			}
+		case '\\':
+			if i+1 < len(input) && input[i+1] == '\n' {
+				// A backslash-newline joins two lines.
+				i++
+				continue
+			}
+			word.WriteByte(c)
+			if i+1 < len(input) {
+				i++
+				word.WriteByte(input[i])
+			}
+			inWord = true
+		case '\'':
+			end := strings.IndexByte(input[i+1:], '\'')
+			if end == -1 {
+				return nil, incomplete("unexpected EOF while looking for matching `''")
+			}
+			word.WriteString(input[i : i+end+2])
+			i += end + 1
+			inWord = true
+		case '"':
+			end := findClosingDoubleQuote(input, i+1)
+			if end == -1 {
+				return nil, incomplete("unexpected EOF while looking for matching `\"'")
+			}
+			word.WriteString(input[i : end+1])
+			i = end
+			inWord = true
+		case '$':
+			end := -1
+			switch {
+			case i+1 < len(input) && input[i+1] == '(':
+				end = findClosingParen(input, i+2)
+				if end == -1 {
+					return nil, incomplete("unexpected EOF while looking for matching `)'")
+				}
+			case i+1 < len(input) && input[i+1] == '{':
+				end = findClosingBrace(input, i+2)
+				if end == -1 {
+					return nil, incomplete("unexpected EOF while looking for matching `}'")
+				}
+			default:
+				end = i
+			}
+			word.WriteString(input[i : end+1])
+			i = end
+			inWord = true
+		case '`':
+			end := findClosingBackquote(input, i+1)
+			if end == -1 {
+				return nil, incomplete("unexpected EOF while looking for matching ``'")
+			}
+			word.WriteString(input[i : end+1])
+			i = end
+			inWord = true
+		case '|', '&':
+			flushWord(i)
+			kind := TokenPipe
+			if c == '&' {
+				kind = TokenBackground
+			}
+			if i+1 < len(input) && input[i+1] == c {
+				kind = TokenOr
+				if c == '&' {
+					kind = TokenAnd
+				}
+				addOperator(kind, i, i+2)
+				i++
+				continue
+			}
+			addOperator(kind, i, i+1)
+		case '(':
+			if inWord && strings.HasSuffix(word.String(), "=") {
+				if _, ok := ParseAssignmentWord(word.String()); ok {
+					// The list of an array assignment, arr=(a b c), stays
+					// part of the word.
+					end := findClosingParen(input, i+1)
+					if end == -1 {
+						return nil, incomplete("unexpected EOF while looking for matching `)'")
+					}
+					word.WriteString(input[i : end+1])
+					i = end
+					continue
+				}
+			}
+			if inWord || i+1 >= len(input) || input[i+1] != '(' {
+				flushWord(i)
+				addOperator(TokenLParen, i, i+1)
+				continue
+			}
+			// An arithmetic command, as in for ((...)), is kept as one word.
+			end := findClosingParen(input, i+1)
+			if end == -1 {
+				return nil, incomplete("unexpected EOF while looking for matching `))'")
+			}
+			word.WriteString(input[i : end+1])
+			i = end
+			inWord = true
+		case ')':
+			flushWord(i)
+			addOperator(TokenRParen, i, i+1)
+		case ';':
+			flushWord(i)
+			// ;; ends a case item, and so do ;& and ;;& which go on with
+			// the next one.
+			switch {
+			case strings.HasPrefix(input[i:], ";;&"):
+				addOperator(TokenCaseBreak, i, i+3)
+				i += 2
+			case strings.HasPrefix(input[i:], ";;"), strings.HasPrefix(input[i:], ";&"):
+				addOperator(TokenCaseBreak, i, i+2)
+				i++
+			default:
+				addOperator(TokenSemicolon, i, i+1)
+			}
+		case '>', '<':
+			start := i
+			op := string(c)
+			if c == '>' && i+1 < len(input) && input[i+1] == '>' {
+				op = ">>"
+				i++
+			} else if i+1 < len(input) && input[i+1] == '&' {
+				// >&n and <&n duplicate descriptor n, and n of - closes it.
+				op += "&"
+				i++
+			}
+			if inWord && isAllDigits(word.String()) {
+				op = word.String() + op
+				start = wordStart
+				word.Reset()
+				inWord = false
+			}
+			flushWord(start)
+			tokens = append(tokens, Token{Kind: TokenRedirect, Value: op, Start: start, End: i + 1})
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flushWord(len(input))
+	return tokens, nil
+}
+
+func isOperatorByte(c byte) bool {
+	return strings.IndexByte("|&;<>()", c) != -1
+}
+
+func findClosingDoubleQuote(input string, start int) int {
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		case '`':
+			if i = findClosingBackquote(input, i+1); i == -1 {
+				return -1
+			}
+		case '$':
+			if i+1 < len(input) && input[i+1] == '(' {
+				if i = findClosingParen(input, i+2); i == -1 {
+					return -1
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func findClosingBackquote(input string, start int) int {
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '`':
+			return i
+		}
+	}
+	return -1
+}
+
+// findClosingParen finds the ) ending a $( started before start, skipping
+// over quotes and nested parentheses.
+func findClosingParen(input string, start int) int {
+	depth := 1
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(input[i+1:], '\'')
+			if end == -1 {
+				return -1
+			}
+			i += end + 1
+		case '"':
+			if i = findClosingDoubleQuote(input, i+1); i == -1 {
+				return -1
+			}
+		case '`':
+			if i = findClosingBackquote(input, i+1); i == -1 {
+				return -1
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isAllDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCommandList parses a command line into its and-or lists.
+func ParseCommandList(input string, aliases map[string]string) (*CommandList, error) {
+	tokens, err := Tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err = ExpandAliases(tokens, aliases, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	parser := &parser{input: input, tokens: tokens}
+	return parser.parseCommandList()
+}
+
+// IncompleteError means the input ended in the middle of a command, like an
+// open quote or a trailing |. An interactive shell reads more lines instead
+// of reporting it.
+type IncompleteError struct {
+	Message string
+}
+
+func (err *IncompleteError) Error() string {
+	return err.Message
+}
+
+func incomplete(message string) error {
+	return &IncompleteError{Message: message}
+}
+
+func IsIncomplete(err error) bool {
+	var incompleteErr *IncompleteError
+	return errors.As(err, &incompleteErr)
+}
+
+type parser struct {
+	input  string
+	tokens []Token
+	pos    int
+}
+
+func (p *parser) peek() (Token, bool) {
+	if p.pos >= len(p.tokens) {
+		return Token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *parser) source(from int, to int) string {
+	return strings.TrimSpace(p.input[p.tokens[from].Start:p.tokens[to-1].End])
+}
+
+// skipNewlines moves past newlines where a command may continue on the next
+// line, and reports whether any input is left.
+func (p *parser) skipNewlines() bool {
+	for {
+		token, ok := p.peek()
+		if !ok {
+			return false
+		}
+		if token.Kind != TokenNewline {
+			return true
+		}
+		p.pos++
+	}
+}
+
+func unexpectedToken(token Token) error {
+	return fmt.Errorf("syntax error near unexpected token `%s'", token.Value)
+}
+
+func (p *parser) parseCommandList() (*CommandList, error) {
+	list, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := p.peek(); ok {
+		return nil, unexpectedToken(token)
+	}
+	return list, nil
+}
+
+// parseList parses and-or lists up to the end of the input or up to one of
+// the given reserved words, which is left for the caller. Other reserved
+// words that close a compound command are a syntax error here.
+func (p *parser) parseList(terminators ...string) (*CommandList, error) {
+	list := &CommandList{}
+	for {
+		if !p.skipNewlines() {
+			return list, nil
+		}
+		token, _ := p.peek()
+		if token.Kind == TokenRParen || token.Kind == TokenCaseBreak {
+			return list, nil
+		}
+		if token.Kind == TokenWord && closingWords[token.Value] {
+			if slices.Contains(terminators, token.Value) {
+				return list, nil
+			}
+			return nil, unexpectedToken(token)
+		}
+		if !startsCommand(token) {
+			return nil, unexpectedToken(token)
+		}
+		andOr, err := p.parseAndOr()
+		if err != nil {
+			return nil, err
+		}
+		item := &ListItem{AndOr: andOr}
+		list.Items = append(list.Items, item)
+
+		token, ok := p.peek()
+		if !ok {
+			return list, nil
+		}
+		switch token.Kind {
+		case TokenBackground:
+			item.Background = true
+			p.pos++
+		case TokenSemicolon, TokenNewline:
+			p.pos++
+		default:
+			return list, nil
+		}
+	}
+}
+
+// parseBody parses the list inside a compound command, which must not be
+// empty and must be followed by one of the terminators.
+func (p *parser) parseBody(terminators ...string) (*CommandList, error) {
+	list, err := p.parseList(terminators...)
+	if err != nil {
+		return nil, err
+	}
+	token, ok := p.peek()
+	if !ok {
+		return nil, incomplete("syntax error: unexpected end of file")
+	}
+	if len(list.Items) == 0 || token.Kind != TokenWord || !slices.Contains(terminators, token.Value) {
+		return nil, unexpectedToken(token)
+	}
+	return list, nil
+}
+
+// expect consumes the reserved word a compound command continues with.
+func (p *parser) expect(word string) error {
+	token, ok := p.peek()
+	if !ok {
+		return incomplete("syntax error: unexpected end of file")
+	}
+	if token.Kind != TokenWord || token.Value != word {
+		return unexpectedToken(token)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) parseAndOr() (*AndOrList, error) {
+	from := p.pos
+	andOr := &AndOrList{}
+	for {
+		pipeline, err := p.parsePipeline()
+		if err != nil {
+			return nil, err
+		}
+		andOr.Pipelines = append(andOr.Pipelines, pipeline)
+
+		token, ok := p.peek()
+		if !ok || (token.Kind != TokenAnd && token.Kind != TokenOr) {
+			andOr.Source = p.source(from, p.pos)
+			return andOr, nil
+		}
+		p.pos++
+		if !p.skipNewlines() {
+			return nil, incomplete(fmt.Sprintf("syntax error: unexpected end of input after `%s'", token.Value))
+		}
+		if next, _ := p.peek(); !startsCommand(next) {
+			return nil, unexpectedToken(next)
+		}
+		andOr.Operators = append(andOr.Operators, token.Value)
+	}
+}
+
+func (p *parser) parsePipeline() (*Pipeline, error) {
+	from := p.pos
+	pipeline := &Pipeline{}
+	for {
+		token, ok := p.peek()
+		if !ok || token.Kind != TokenWord {
+			break
+		}
+		if token.Value == "!" {
+			// As in bash, a second ! cancels the first.
+			p.pos++
+			pipeline.Negated = !pipeline.Negated
+		} else if token.Value == "time" && !pipeline.Timed {
+			p.pos++
+			pipeline.Timed = true
+			if token, ok := p.peek(); ok && token.Kind == TokenWord && token.Value == "-p" {
+				p.pos++
+				pipeline.PosixTime = true
+			}
+		} else {
+			break
+		}
+	}
+	if token, ok := p.peek(); pipeline.Negated && (!ok || token.Kind == TokenNewline || token.Kind == TokenSemicolon) {
+		// A lone ! negates the empty command, as in bash.
+		pipeline.Commands = []Command{&SimpleCommand{}}
+		pipeline.Source = p.source(from, p.pos)
+		return pipeline, nil
+	} else if pipeline.Negated && !startsCommand(token) {
+		return nil, unexpectedToken(token)
+	}
+	for {
+		command, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Commands = append(pipeline.Commands, command)
+
+		token, ok := p.peek()
+		if !ok || token.Kind != TokenPipe {
+			break
+		}
+		p.pos++
+		if !p.skipNewlines() {
+			return nil, incomplete("syntax error: unexpected end of input after `|'")
+		}
+	}
+	pipeline.Source = p.source(from, p.pos)
+	return pipeline, nil
+}
+
+func startsCommand(token Token) bool {
+	return token.Kind == TokenWord || token.Kind == TokenRedirect || token.Kind == TokenLParen
+}
+
+func (p *parser) parseCommand() (Command, error) {
+	token, _ := p.peek()
+	if token.Kind == TokenLParen {
+		return p.parseSubshell()
+	}
+	if token.Kind == TokenWord {
+		switch token.Value {
+		case "if":
+			return p.parseIf()
+		case "for":
+			return p.parseFor()
+		case "while", "until":
+			return p.parseWhile()
+		case "case":
+			return p.parseCase()
+		case "select":
+			return p.parseSelect()
+		case "{":
+			return p.parseBraceGroup()
+		case "[[":
+			return p.parseConditional()
+		case "function":
+			return p.parseFunction()
+		}
+		if _, ok := arithCommandBody(token.Value); ok {
+			return p.parseArithCommand()
+		}
+		if !reservedWords[token.Value] && p.isFunctionDefinition() {
+			return p.parseFunction()
+		}
+	}
+	return p.parseSimpleCommand()
+}
+
+func (p *parser) parseSimpleCommand() (*SimpleCommand, error) {
+	command := &SimpleCommand{}
+	for {
+		token, ok := p.peek()
+		if !ok || (token.Kind != TokenWord && token.Kind != TokenRedirect) {
+			break
+		}
+		if token.Kind == TokenRedirect {
+			if err := p.parseRedirect(&command.Redirects); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		command.Words = append(command.Words, token.Value)
+		p.pos++
+	}
+	if len(command.Words) == 0 && len(command.Redirects) == 0 {
+		token, ok := p.peek()
+		if !ok {
+			return nil, incomplete("syntax error: unexpected end of file")
+		}
+		return nil, unexpectedToken(token)
+	}
+	return command, nil
+}
+
+// parseRedirect consumes a redirection operator and its target.
+func (p *parser) parseRedirect(redirects *[]Redirect) error {
+	token, _ := p.peek()
+	p.pos++
+	target, ok := p.peek()
+	if !ok || target.Kind != TokenWord {
+		return unexpectedToken(token)
+	}
+	redirect, err := parseRedirect(token.Value, target.Value)
+	if err != nil {
+		return err
+	}
+	*redirects = append(*redirects, redirect)
+	p.pos++
+	return nil
+}
+
+// parseRedirects consumes the redirections following a compound command.
+func (p *parser) parseRedirects(redirects *[]Redirect) error {
+	for {
+		token, ok := p.peek()
+		if !ok || token.Kind != TokenRedirect {
+			return nil
+		}
+		if err := p.parseRedirect(redirects); err != nil {
+			return err
+		}
+	}
+}
+
+// ExpandAliases replaces the first word of every command with its alias, if
+// it has one. An alias whose value ends in a blank also gets the word after it
+// checked, and an alias is never expanded again inside its own expansion.
+// The patterns of a case, after in or ;; and up to the ), are left alone.
+func ExpandAliases(tokens []Token, aliases map[string]string, active map[string]bool) ([]Token, error) {
+	expanded := make([]Token, 0, len(tokens))
+	commandPosition := true
+	redirectTarget := false
+	caseWord, casePattern := false, false
+	for _, token := range tokens {
+		if caseWord && token.Kind == TokenWord && token.Value == "in" {
+			caseWord, casePattern = false, true
+		} else if casePattern {
+			expanded = append(expanded, token)
+			switch {
+			case token.Kind == TokenRParen:
+				casePattern, commandPosition = false, true
+			case token.Kind == TokenWord && token.Value == "esac":
+				casePattern, commandPosition = false, false
+			}
+			continue
+		}
+		switch token.Kind {
+		case TokenCaseBreak:
+			expanded = append(expanded, token)
+			casePattern = true
+			continue
+		case TokenPipe, TokenBackground, TokenSemicolon, TokenAnd, TokenOr, TokenNewline, TokenLParen, TokenRParen:
+			expanded = append(expanded, token)
+			commandPosition = true
+			continue
+		case TokenRedirect:
+			expanded = append(expanded, token)
+			redirectTarget = true
+			continue
+		}
+		if redirectTarget {
+			expanded = append(expanded, token)
+			redirectTarget = false
+			continue
+		}
+
+		if commandPosition {
+			if _, ok := ParseAssignmentWord(token.Value); ok {
+				expanded = append(expanded, token)
+				continue
+			}
+			if reservedWords[token.Value] {
+				// A command follows most reserved words, but not the
+				// name after for or the word after case.
+				expanded = append(expanded, token)
+				commandPosition = !namingWords[token.Value]
+				caseWord = token.Value == "case"
+				continue
+			}
+			value, found := aliases[token.Value]
+			if found && !active[token.Value] {
+				valueTokens, err := Tokenize(value)
+				if err != nil {
+					return nil, err
+				}
+				active[token.Value] = true
+				valueTokens, err = ExpandAliases(valueTokens, aliases, active)
+				delete(active, token.Value)
+				if err != nil {
+					return nil, err
+				}
+				// The expansion stands in for the alias in the source text.
+				for i := range valueTokens {
+					valueTokens[i].Start, valueTokens[i].End = token.Start, token.End
+				}
+				expanded = append(expanded, valueTokens...)
+				commandPosition = strings.HasSuffix(value, " ") || strings.HasSuffix(value, "\t") ||
+					(len(valueTokens) > 0 && valueTokens[len(valueTokens)-1].Kind != TokenWord &&
+						valueTokens[len(valueTokens)-1].Kind != TokenRedirect)
+				continue
+			}
+		}
+		expanded = append(expanded, token)
+		commandPosition = false
+	}
+	return expanded, nil
+}
+
+// maxRedirectFd is the highest descriptor a redirection can name.
+const maxRedirectFd = 255
+
+func parseRedirect(op string, target string) (Redirect, error) {
+	digits := strings.TrimRight(op, "<>&")
+	redirect := Redirect{Fd: 1, Op: op[len(digits):], Target: target}
+	if redirect.Op == "<" || redirect.Op == "<&" {
+		redirect.Fd = 0
+	}
+	if len(digits) > 0 {
+		fd, err := strconv.Atoi(digits)
+		if err != nil || fd > maxRedirectFd {
+			return Redirect{}, fmt.Errorf("%s: bad file descriptor", digits)
+		}
+		redirect.Fd = fd
+	}
+	return redirect, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandAliases(t *testing.T) {
+	aliases := map[string]string{"hi": "echo hello", "a": "echo pattern"}
+	tests := []struct{ line, want string }{
+		{"(hi)", "( echo hello )"},
+		{"(hi; hi) | hi", "( echo hello ; echo hello ) | echo hello"},
+		{"case a in a) hi;; b|a) hi ;; esac", "case a in a ) echo hello ;; b | a ) echo hello ;; esac"},
+		{"case a in\n(a) hi\nesac; hi", "case a in newline ( a ) echo hello newline esac ; echo hello"},
+		{"echo hi", "echo hi"},
+	}
+	for _, test := range tests {
+		tokens, err := Tokenize(test.line)
+		if err != nil {
+			t.Fatalf("Tokenize(%q): %v", test.line, err)
+		}
+		expanded, err := ExpandAliases(tokens, aliases, map[string]bool{})
+		if err != nil {
+			t.Fatalf("ExpandAliases(%q): %v", test.line, err)
+		}
+		values := []string{}
+		for _, token := range expanded {
+			values = append(values, token.Value)
+		}
+		if got := strings.Join(values, " "); got != test.want {
+			t.Errorf("ExpandAliases(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseNegatedPipeline(t *testing.T) {
+	tests := []struct {
+		line    string
+		negated bool
+		timed   bool
+		ok      bool
+	}{
+		{"! true", true, false, true},
+		{"! ! true", false, false, true},
+		{"! true | false", true, false, true},
+		{"time ! true", true, true, true},
+		{"! time true", true, true, true},
+		{"!", true, false, true},
+		{"echo !", false, false, true},
+		{"! && true", false, false, false},
+		{"! | true", false, false, false},
+	}
+	for _, test := range tests {
+		list, err := ParseCommandList(test.line, nil)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseCommandList(%q): %v", test.line, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		pipeline := list.Items[0].AndOr.Pipelines[0]
+		if pipeline.Negated != test.negated || pipeline.Timed != test.timed {
+			t.Errorf("ParseCommandList(%q): negated %v, timed %v", test.line, pipeline.Negated, pipeline.Timed)
+		}
+	}
+}
+
+func TestTokenizeComments(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{"echo a # b", "echo a"},
+		{"# whole line", ""},
+		{"echo a#b", "echo a#b"},
+		{`echo "#x" '#y' \#z`, `echo "#x" '#y' \#z`},
+		{"echo ${#} $#", "echo ${#} $#"},
+		{"echo a;# b", "echo a ;"},
+		{"echo a # it's unbalanced", "echo a"},
+		{"echo a # b\necho c", "echo a newline echo c"},
+	}
+	for _, test := range tests {
+		tokens, err := Tokenize(test.line)
+		if err != nil {
+			t.Errorf("Tokenize(%q): %v", test.line, err)
+			continue
+		}
+		values := []string{}
+		for _, token := range tokens {
+			values = append(values, token.Value)
+		}
+		if got := strings.Join(values, " "); got != test.want {
+			t.Errorf("Tokenize(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+	checkScripts(t, []scriptTest{
+		{"echo a # b\necho c #d", "a\nc\n"},
+		{`echo "#x" '#y' \#z a#b`, "#x #y #z a#b\n"},
+	})
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PrintfExecutor implements `printf [-v var] format [arg ...]`. The format
+// is reused until every argument has been consumed; conversions left
+// without an argument get an empty string or zero.
+func PrintfExecutor(shellCtx *ShellCtx, args []string) error {
+	variable := ""
+	if len(args) > 0 && args[0] == "-v" {
+		if len(args) < 2 {
+			return fmt.Errorf("printf command -v requires a variable name")
+		}
+		variable, args = args[1], args[2:]
+		if !IsValidName(variable) {
+			shellCtx.Serr = fmt.Sprintf("printf: `%s': not a valid identifier\n", variable)
+			shellCtx.Status = 2
+			return nil
+		}
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("printf command takes a format and optional arguments")
+	}
+
+	f := &formatter{format: args[0], args: args[1:]}
+	for {
+		consumed := f.next
+		if !f.pass() || f.next >= len(f.args) || f.next == consumed {
+			break
+		}
+	}
+	for _, message := range f.errors {
+		shellCtx.Serr += "printf: " + message + "\n"
+		shellCtx.Status = 1
+	}
+	if variable != "" {
+		if err := shellCtx.SetVar(variable, f.output.String()); err != nil {
+			shellCtx.Serr += fmt.Sprintf("printf: %s\n", err.Error())
+			shellCtx.Status = 1
+		}
+		return nil
+	}
+	shellCtx.Sout = f.output.String()
+	return nil
+}
+
+type formatter struct {
+	format string
+	args   []string
+	next   int
+	output strings.Builder
+	errors []string
+}
+
+func (f *formatter) arg() string {
+	if f.next >= len(f.args) {
+		return ""
+	}
+	f.next++
+	return f.args[f.next-1]
+}
+
+func (f *formatter) fail(message string) {
+	f.errors = append(f.errors, message)
+}
+
+// pass goes through the format once. It returns false when the output must
+// stop there, after \c or an invalid conversion.
+func (f *formatter) pass() bool {
+	format := f.format
+	for i := 0; i < len(format); i++ {
+		switch format[i] {
+		case '\\':
+			text, next, stop := decodeEscape(format, i, false)
+			f.output.WriteString(text)
+			if stop {
+				return false
+			}
+			i = next - 1
+		case '%':
+			next, ok := f.conversion(i + 1)
+			if !ok {
+				return false
+			}
+			i = next - 1
+		default:
+			f.output.WriteByte(format[i])
+		}
+	}
+	return true
+}
+
+// conversion formats one argument for the directive starting after the %
+// at start, and returns where the directive ends.
+func (f *formatter) conversion(start int) (int, bool) {
+	format := f.format
+	i := start
+	for i < len(format) && strings.IndexByte("-+ #0", format[i]) != -1 {
+		i++
+	}
+	spec := "%" + format[start:i]
+	number := func() {
+		if i < len(format) && format[i] == '*' {
+			spec += strconv.FormatInt(f.integer(f.arg()), 10)
+			i++
+			return
+		}
+		from := i
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			i++
+		}
+		spec += format[from:i]
+	}
+	number()
+	hasPrecision := i < len(format) && format[i] == '.'
+	if hasPrecision {
+		spec += "."
+		i++
+		number()
+	}
+	if i >= len(format) {
+		f.fail(fmt.Sprintf("`%s': missing format character", format[start-1:]))
+		return i, false
+	}
+
+	verb := format[i]
+	switch verb {
+	case '%':
+		f.output.WriteByte('%')
+	case 's':
+		fmt.Fprintf(&f.output, spec+"s", f.arg())
+	case 'q':
+		fmt.Fprintf(&f.output, spec+"s", ShellQuote(f.arg()))
+	case 'c':
+		arg := f.arg()
+		if _, size := utf8.DecodeRuneInString(arg); size > 0 {
+			arg = arg[:size]
+		}
+		fmt.Fprintf(&f.output, spec+"s", arg)
+	case 'b':
+		expanded, stop := expandEscapes(f.arg())
+		fmt.Fprintf(&f.output, spec+"s", expanded)
+		if stop {
+			return i + 1, false
+		}
+	case 'd', 'i':
+		fmt.Fprintf(&f.output, spec+"d", f.integer(f.arg()))
+	case 'u':
+		fmt.Fprintf(&f.output, spec+"d", uint64(f.integer(f.arg())))
+	case 'o', 'x', 'X':
+		// Negative numbers are shown in two's complement, as in C.
+		fmt.Fprintf(&f.output, spec+string(verb), uint64(f.integer(f.arg())))
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		if !hasPrecision && (verb == 'g' || verb == 'G') {
+			// Go would otherwise print the shortest exact representation.
+			spec += ".6"
+		}
+		fmt.Fprintf(&f.output, spec+string(verb), f.float(f.arg()))
+	default:
+		f.fail(fmt.Sprintf("`%c': invalid format character", verb))
+		return i + 1, false
+	}
+	return i + 1, true
+}
+
+// integer reads a numeric argument the way printf does: in C notation for
+// the base, or as the character code of what follows a leading quote.
+func (f *formatter) integer(arg string) int64 {
+	text := strings.TrimSpace(arg)
+	if text == "" {
+		return 0
+	}
+	if text[0] == '\'' || text[0] == '"' {
+		if len(text) == 1 {
+			return 0
+		}
+		r, _ := utf8.DecodeRuneInString(text[1:])
+		return int64(r)
+	}
+	value, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		if unsigned, uerr := strconv.ParseUint(text, 0, 64); uerr == nil {
+			return int64(unsigned)
+		}
+		f.fail(fmt.Sprintf("%s: invalid number", arg))
+		return 0
+	}
+	return value
+}
+
+func (f *formatter) float(arg string) float64 {
+	text := strings.TrimSpace(arg)
+	if text == "" {
+		return 0
+	}
+	if text[0] == '\'' || text[0] == '"' {
+		return float64(f.integer(text))
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		f.fail(fmt.Sprintf("%s: invalid number", arg))
+		return 0
+	}
+	return value
+}
+
+// decodeEscape decodes the backslash escape at s[i] and returns its text and
+// where it ends. In the argument of %b, octal escapes are written \0NNN and
+// \c, which stops all output, is recognized.
+func decodeEscape(s string, i int, inArg bool) (string, int, bool) {
+	if i+1 >= len(s) {
+		return "\\", i + 1, false
+	}
+	c := s[i+1]
+	switch c {
+	case 'a':
+		return "\a", i + 2, false
+	case 'b':
+		return "\b", i + 2, false
+	case 'e', 'E':
+		return "\x1b", i + 2, false
+	case 'f':
+		return "\f", i + 2, false
+	case 'n':
+		return "\n", i + 2, false
+	case 'r':
+		return "\r", i + 2, false
+	case 't':
+		return "\t", i + 2, false
+	case 'v':
+		return "\v", i + 2, false
+	case '\\', '\'', '"', '?':
+		return string(c), i + 2, false
+	case 'c':
+		if inArg {
+			return "", i + 2, true
+		}
+	case 'x', 'u', 'U':
+		digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		end := i + 2
+		for end < len(s) && end < i+2+digits && isHexDigit(s[end]) {
+			end++
+		}
+		if end == i+2 {
+			break
+		}
+		value, _ := strconv.ParseUint(s[i+2:end], 16, 32)
+		if c == 'x' {
+			return string([]byte{byte(value)}), end, false
+		}
+		return string(rune(value)), end, false
+	}
+	if c >= '0' && c <= '7' {
+		start := i + 1
+		if inArg && c == '0' {
+			start++
+		}
+		end := start
+		for end < len(s) && end < start+3 && s[end] >= '0' && s[end] <= '7' {
+			end++
+		}
+		value, _ := strconv.ParseUint("0"+s[start:end], 8, 16)
+		return string([]byte{byte(value)}), end, false
+	}
+	return s[i : i+2], i + 2, false
+}
+
+// expandEscapes decodes all escapes in the argument of %b. It also reports
+// whether a \c ended it.
+func expandEscapes(s string) (string, bool) {
+	expanded := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			expanded.WriteByte(s[i])
+			continue
+		}
+		text, next, stop := decodeEscape(s, i, true)
+		expanded.WriteString(text)
+		if stop {
+			return expanded.String(), true
+		}
+		i = next - 1
+	}
+	return expanded.String(), false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ShellQuote quotes a value so that the shell reads it back as one word,
+// with backslashes, or in $'...' when it holds control characters.
+func ShellQuote(value string) string {
+	if value == "" {
+		return "''"
+	}
+	if strings.IndexFunc(value, func(r rune) bool { return r < 0x20 || r == 0x7f }) != -1 {
+		quoted := strings.Builder{}
+		quoted.WriteString("$'")
+		for i := 0; i < len(value); i++ {
+			switch c := value[i]; c {
+			case '\n':
+				quoted.WriteString(`\n`)
+			case '\t':
+				quoted.WriteString(`\t`)
+			case '\r':
+				quoted.WriteString(`\r`)
+			case 0x1b:
+				quoted.WriteString(`\E`)
+			case '\\', '\'':
+				quoted.WriteByte('\\')
+				quoted.WriteByte(c)
+			default:
+				if c < 0x20 || c == 0x7f {
+					fmt.Fprintf(&quoted, "\\%03o", c)
+				} else {
+					quoted.WriteByte(c)
+				}
+			}
+		}
+		quoted.WriteByte('\'')
+		return quoted.String()
+	}
+	quoted := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(" !\"#$&'()*,;<>?[\\]^`{|}~", value[i]) != -1 {
+			quoted.WriteByte('\\')
+		}
+		quoted.WriteByte(value[i])
+	}
+	return quoted.String()
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestPrintf(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`printf '%s|' a b c`, "a|b|c|"},
+		{`printf '%s %s\n' 1 2 3`, "1 2\n3 \n"},
+		{`printf '%s\n'`, "\n"},
+		{`printf '%d %i %u %x %X %o\n' 42 3 4 255 255 8`, "42 3 4 ff FF 10\n"},
+		{`printf '%d\n' 0x10 "'A" -7`, "16\n65\n-7\n"},
+		{`printf '%5s|%-5s|%05d\n' ab cd 42`, "   ab|cd   |00042\n"},
+		{`printf '%.2f %e\n' 3.14159 1500`, "3.14 1.500000e+03\n"},
+		{`printf '%c%c\n' hello world`, "hw\n"},
+		{`printf '%q\n' "a b" "it's" ''`, "a\\ b\nit\\'s\n''\n"},
+		{`printf '%b\n' 'x\ty'`, "x\ty\n"},
+		{`printf 'a\tb\\n\n'`, "a\tb\\n\n"},
+		{`printf '\101\x42%%\n'`, "AB%\n"},
+		{`printf '%d\n' abc; echo $?`, "0\n1\n"},
+		{`printf -v out '%s-%s' x y; echo $out`, "x-y\n"},
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPrompt = "$ "
+
+// Prompt renders PS1, or the default prompt when it isn't set.
+func (ctx *ShellCtx) Prompt() string {
+	ps1, found := ctx.GetVar("PS1")
+	if !found {
+		return defaultPrompt
+	}
+	return ctx.ExpandPromptEscapes(ps1)
+}
+
+// ExpandPromptEscapes decodes the backslash escapes of bash prompts. On top
+// of bash's, \L is the shell nesting level taken from SHLVL, and \N expands
+// to one ">" per shell this one is nested in, so "\N\$ " reads ">>$ " two
+// levels deep and stays "$ " in a login shell.
+func (ctx *ShellCtx) ExpandPromptEscapes(prompt string) string {
+	rendered := strings.Builder{}
+	for i := 0; i < len(prompt); i++ {
+		if prompt[i] != '\\' || i+1 >= len(prompt) {
+			rendered.WriteByte(prompt[i])
+			continue
+		}
+		i++
+		switch prompt[i] {
+		case 'u':
+			user, _ := ctx.GetVar("USER")
+			rendered.WriteString(user)
+		case 'h', 'H':
+			hostname, _ := os.Hostname()
+			if prompt[i] == 'h' {
+				hostname, _, _ = strings.Cut(hostname, ".")
+			}
+			rendered.WriteString(hostname)
+		case 'w':
+			rendered.WriteString(ctx.AbbreviateDir(ctx.CurrentDir))
+		case 'W':
+			if dir := ctx.AbbreviateDir(ctx.CurrentDir); dir == "~" || dir == "/" {
+				rendered.WriteString(dir)
+			} else {
+				rendered.WriteString(filepath.Base(dir))
+			}
+		case '$':
+			if os.Geteuid() == 0 {
+				rendered.WriteByte('#')
+			} else {
+				rendered.WriteByte('$')
+			}
+		case 's':
+			rendered.WriteString(filepath.Base(ctx.ShellName))
+		case 'j':
+			rendered.WriteString(strconv.Itoa(len(ctx.Jobs.Snapshot())))
+		case 'L':
+			rendered.WriteString(strconv.Itoa(ctx.ShellLevel()))
+		case 'N':
+			rendered.WriteString(strings.Repeat(">", max(ctx.ShellLevel()-1, 0)))
+		case 't':
+			rendered.WriteString(time.Now().Format("15:04:05"))
+		case 'd':
+			rendered.WriteString(time.Now().Format("Mon Jan 02"))
+		case 'n':
+			rendered.WriteByte('\n')
+		case 'e':
+			rendered.WriteByte('\x1b')
+		case 'a':
+			rendered.WriteByte('\a')
+		case '[', ']':
+			// Only mark non-printing sequences for the line editor.
+		case '\\':
+			rendered.WriteByte('\\')
+		default:
+			rendered.WriteByte('\\')
+			rendered.WriteByte(prompt[i])
+		}
+	}
+	return rendered.String()
+}
+
+func (ctx *ShellCtx) ShellLevel() int {
+	value, _ := ctx.GetVar("SHLVL")
+	level, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || level < 0 {
+		return 0
+	}
+	return level
+}
+
+// EnterShellLevel bumps SHLVL for this shell, so that shells started from it
+// see how deeply they are nested.
+func (ctx *ShellCtx) EnterShellLevel() {
+	ctx.ExportVar("SHLVL", strconv.Itoa(ctx.ShellLevel()+1))
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestPromptEscapes(t *testing.T) {
+	tests := []struct {
+		shlvl, prompt, want string
+	}{
+		{"1", `\L`, "1"},
+		{"3", `\L`, "3"},
+		{"1", `\N$ `, "$ "},
+		{"3", `\N$ `, ">>$ "},
+		{"junk", `\L\N`, "0"},
+		{"1", `\j`, "0"},
+		{"1", `a\nb\\c\q`, "a\nb\\c\\q"},
+		{"1", `\[\e[1m\]x`, "\x1b[1mx"},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("SHLVL", test.shlvl)
+		if got := shellCtx.ExpandPromptEscapes(test.prompt); got != test.want {
+			t.Errorf("SHLVL=%s %q: got %q, want %q", test.shlvl, test.prompt, got, test.want)
+		}
+	}
+}
+
+func TestPromptJobCount(t *testing.T) {
+	shellCtx := NewShellCtx()
+	runShell(t, shellCtx, "sleep 5 & sleep 5 &")
+	defer runShell(t, shellCtx, "kill %1 %2; wait")
+	if got := shellCtx.ExpandPromptEscapes(`[\j]`); got != "[2]" {
+		t.Errorf(`\j with two jobs: got %q`, got)
+	}
+}
+
+func TestEnterShellLevel(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.SetVar("SHLVL", "2")
+	shellCtx.EnterShellLevel()
+	if output, _ := runShell(t, shellCtx, `sh -c 'echo $SHLVL'`); output != "3\n" {
+		t.Errorf("exported SHLVL: got %q", output)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// StatusReadTimeout is what read returns when -t runs out, as if it had
+// been interrupted by SIGALRM.
+const StatusReadTimeout = 128 + int(syscall.SIGALRM)
+
+type readOptions struct {
+	raw        bool
+	silent     bool
+	prompt     string
+	hasTimeout bool
+	timeout    time.Duration
+	// nchars is the number of characters to read, or -1 for a whole line.
+	nchars int
+}
+
+func (options *readOptions) set(flag byte, value string) string {
+	switch flag {
+	case 'p':
+		options.prompt = value
+	case 't':
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			return fmt.Sprintf("%s: invalid timeout specification", value)
+		}
+		options.hasTimeout = true
+		options.timeout = time.Duration(seconds * float64(time.Second))
+	case 'n':
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			return fmt.Sprintf("%s: invalid number", value)
+		}
+		options.nchars = count
+	}
+	return ""
+}
+
+// ReadExecutor implements `read [-rs] [-p prompt] [-t timeout] [-n nchars]
+// [name ...]`. The line is split on IFS into the names, the last one taking
+// whatever is left; without names it goes into REPLY as it is. Unless -r is
+// given, a backslash quotes the next character and joins lines.
+func ReadExecutor(shellCtx *ShellCtx, args []string) error {
+	options := readOptions{nchars: -1}
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+	flags:
+		for i := 1; i < len(option); i++ {
+			flag := option[i]
+			switch flag {
+			case 'r':
+				options.raw = true
+			case 's':
+				options.silent = true
+			case 'p', 't', 'n':
+				// The value is the rest of this argument or the next one.
+				value := option[i+1:]
+				if value == "" {
+					if len(args) == 0 {
+						return fmt.Errorf("read command -%c requires an argument", flag)
+					}
+					value, args = args[0], args[1:]
+				}
+				if message := options.set(flag, value); message != "" {
+					shellCtx.Serr = "read: " + message + "\n"
+					shellCtx.Status = 1
+					return nil
+				}
+				break flags
+			default:
+				return fmt.Errorf("read command got invalid option -%c", flag)
+			}
+		}
+	}
+	for _, name := range args {
+		if !IsValidName(name) {
+			shellCtx.Serr = fmt.Sprintf("read: `%s': not a valid identifier\n", name)
+			shellCtx.Status = 1
+			return nil
+		}
+	}
+
+	stdin := shellCtx.Streams.Stdin
+	fd := int(stdin.Fd())
+	if options.hasTimeout && options.timeout == 0 {
+		// read -t 0 only tells whether there is input waiting.
+		if !waitReadable(fd, time.Now()) {
+			shellCtx.Status = 1
+		}
+		return nil
+	}
+	if isTerminalFile(stdin) {
+		if options.prompt != "" {
+			fmt.Fprint(shellCtx.Streams.Stderr, options.prompt)
+		}
+		if options.silent || options.nchars >= 0 {
+			defer setReadMode(fd, options)()
+		}
+	}
+
+	line, escaped, status := readInput(stdin, options)
+	shellCtx.Status = status
+	if len(args) == 0 {
+		// REPLY gets the line as it is, so none of it separates fields.
+		args = []string{"REPLY"}
+		escaped = make([]bool, len(line))
+		for i := range escaped {
+			escaped[i] = true
+		}
+	}
+	ifs, found := shellCtx.GetVar("IFS")
+	if !found {
+		ifs = defaultIFS
+	}
+	for i, value := range splitReadFields(line, escaped, ifs, len(args)) {
+		if err := shellCtx.SetVar(args[i], value); err != nil {
+			shellCtx.Serr += fmt.Sprintf("read: %s\n", err.Error())
+			shellCtx.Status = 1
+		}
+	}
+	return nil
+}
+
+// setReadMode turns off echo for -s, and line buffering for -n so that read
+// gets the characters as soon as they are typed. It returns the function
+// that restores the terminal.
+func setReadMode(fd int, options readOptions) func() {
+	saved, err := getTermios(fd)
+	if err != nil {
+		return func() {}
+	}
+	mode := *saved
+	if options.silent {
+		mode.Lflag &^= syscall.ECHO
+	}
+	if options.nchars >= 0 {
+		mode.Lflag &^= syscall.ICANON
+		mode.Cc[syscall.VMIN] = 1
+		mode.Cc[syscall.VTIME] = 0
+	}
+	setTermios(fd, &mode)
+	return func() { setTermios(fd, saved) }
+}
+
+// readInput reads up to a newline, or nchars characters, one byte at a time
+// so the rest of the input stays with whoever reads next. Alongside the
+// bytes it returns which of them were quoted with a backslash.
+func readInput(file *os.File, options readOptions) ([]byte, []bool, int) {
+	line := []byte{}
+	escaped := []bool{}
+	deadline := time.Now().Add(options.timeout)
+	buffer := make([]byte, 1)
+	backslash := false
+	// -n counts characters, which may take several bytes.
+	char := []byte{}
+	chars := 0
+	for options.nchars < 0 || chars < options.nchars {
+		if options.hasTimeout && !waitReadable(int(file.Fd()), deadline) {
+			return line, escaped, StatusReadTimeout
+		}
+		n, err := file.Read(buffer)
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			// End of input, with or without a partial line.
+			return line, escaped, 1
+		}
+		c := buffer[0]
+		switch {
+		case backslash:
+			backslash = false
+			if c == '\n' {
+				// A backslash-newline joins the next line to this one.
+				continue
+			}
+			line = append(line, c)
+			escaped = append(escaped, true)
+		case c == '\\' && !options.raw:
+			backslash = true
+			continue
+		case c == '\n' && options.nchars < 0:
+			return line, escaped, 0
+		default:
+			line = append(line, c)
+			escaped = append(escaped, false)
+		}
+		char = append(char, c)
+		if utf8.FullRune(char) {
+			chars++
+			char = char[:0]
+		}
+	}
+	return line, escaped, 0
+}
+
+// waitReadable waits until input can be read from fd or the deadline has
+// passed.
+func waitReadable(fd int, deadline time.Time) bool {
+	for {
+		remaining := time.Until(deadline)
+		if remaining < 0 {
+			remaining = 0
+		}
+		timeout := syscall.NsecToTimeval(remaining.Nanoseconds())
+		set := &syscall.FdSet{}
+		set.Bits[fd/64] |= 1 << (uint(fd) % 64)
+		n, err := syscall.Select(fd+1, set, nil, nil, &timeout)
+		if err == syscall.EINTR {
+			continue
+		}
+		return err == nil && n > 0
+	}
+}
+
+// splitReadFields splits line into at most count fields on the unquoted IFS
+// characters. IFS whitespace around the fields is dropped; the last field
+// is the rest of the line, separators included.
+func splitReadFields(line []byte, escaped []bool, ifs string, count int) []string {
+	isSeparator := func(i int) bool {
+		return !escaped[i] && strings.IndexByte(ifs, line[i]) != -1
+	}
+	isWhitespace := func(i int) bool {
+		return isSeparator(i) && strings.IndexByte(defaultIFS, line[i]) != -1
+	}
+	fields := make([]string, count)
+	i := 0
+	for i < len(line) && isWhitespace(i) {
+		i++
+	}
+	for field := 0; field < count && i < len(line); field++ {
+		if field == count-1 {
+			end := len(line)
+			for end > i && isWhitespace(end-1) {
+				end--
+			}
+			fields[field] = string(line[i:end])
+			break
+		}
+		start := i
+		for i < len(line) && !isSeparator(i) {
+			i++
+		}
+		fields[field] = string(line[start:i])
+		for i < len(line) && isWhitespace(i) {
+			i++
+		}
+		if i < len(line) && isSeparator(i) {
+			i++
+			for i < len(line) && isWhitespace(i) {
+				i++
+			}
+		}
+	}
+	return fields
+}
+
+// MapfileExecutor implements `mapfile [-t] [-n count] [array]`, also known
+// as readarray: the lines of standard input become the elements of array,
+// or MAPFILE. -t strips the newlines and -n stops after count lines.
+func MapfileExecutor(shellCtx *ShellCtx, args []string) error {
+	trim := false
+	count := 0
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for i := 1; i < len(option); i++ {
+			switch option[i] {
+			case 't':
+				trim = true
+			case 'n':
+				value := option[i+1:]
+				if value == "" {
+					if len(args) == 0 {
+						return fmt.Errorf("mapfile command -n requires an argument")
+					}
+					value, args = args[0], args[1:]
+				}
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					shellCtx.Serr = fmt.Sprintf("mapfile: %s: invalid line count\n", value)
+					shellCtx.Status = 1
+					return nil
+				}
+				count = n
+				i = len(option)
+			default:
+				return fmt.Errorf("mapfile command got invalid option -%c", option[i])
+			}
+		}
+	}
+	name := "MAPFILE"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if !IsValidName(name) {
+		shellCtx.Serr = fmt.Sprintf("mapfile: `%s': not a valid identifier\n", name)
+		shellCtx.Status = 1
+		return nil
+	}
+
+	lines, err := readLines(shellCtx.Streams.Stdin, count)
+	if err != nil {
+		shellCtx.Serr = fmt.Sprintf("mapfile: %s\n", err.Error())
+		shellCtx.Status = 1
+		return nil
+	}
+	if trim {
+		for i, line := range lines {
+			lines[i] = strings.TrimSuffix(line, "\n")
+		}
+	}
+	if err := shellCtx.SetArray(name, lines); err != nil {
+		shellCtx.Serr = fmt.Sprintf("mapfile: %s\n", err.Error())
+		shellCtx.Status = 1
+	}
+	return nil
+}
+
+// readLines reads lines up to the end of input, or count of them if count
+// isn't zero. Reading everything goes through a buffer; a count is read a
+// byte at a time so that the lines after it are left for the next reader.
+func readLines(file *os.File, count int) ([]string, error) {
+	lines := []string{}
+	if count == 0 {
+		reader := bufio.NewReaderSize(file, 64*1024)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				lines = append(lines, line)
+			}
+			if err == io.EOF {
+				return lines, nil
+			}
+			if err != nil {
+				return lines, err
+			}
+		}
+	}
+	line := []byte{}
+	buffer := make([]byte, 1)
+	for len(lines) < count {
+		n, err := file.Read(buffer)
+		if n == 0 {
+			if err == nil {
+				continue
+			}
+			if len(line) > 0 {
+				lines = append(lines, string(line))
+			}
+			if err == io.EOF {
+				return lines, nil
+			}
+			return lines, err
+		}
+		line = append(line, buffer[0])
+		if buffer[0] == '\n' {
+			lines = append(lines, string(line))
+			line = line[:0]
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestRead(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{`echo 'a b c d' | { read x y; echo "$x|$y"; }`, "a|b c d\n"},
+		{`echo '  lead  trail  ' | { read x; echo "[$x]"; }`, "[lead  trail]\n"},
+		{`echo 'a  b' | { IFS= read x; echo "[$x]"; }`, "[a  b]\n"},
+		{`echo 'a:b:c' | { IFS=: read x y z; echo "$x $y $z"; }`, "a b c\n"},
+		{`echo 'a\ b\\c' | { read x; echo "$x"; }`, "a b\\c\n"},
+		{`echo 'a\ b\\c' | { read -r x; echo "$x"; }`, "a\\ b\\\\c\n"},
+		{`printf 'one\\\ntwo\n' | { read x; echo "$x"; }`, "onetwo\n"},
+		{`echo hello | { read -n 3 x; echo "$x"; }`, "hel\n"},
+		{`echo hello | { read; echo "$REPLY"; }`, "hello\n"},
+		{`printf 'a\nb\n' | { read x; read y; echo "$x$y"; }`, "ab\n"},
+		{`printf last | { read x; echo "$? $x"; }`, "1 last\n"},
+		{`{ read x; echo "$? [$x]"; } < /dev/null`, "1 []\n"},
+		// The prompt and the echo are for terminals only.
+		{`echo hi | { read -p 'prompt> ' x; echo "$x"; }`, "hi\n"},
+		{`echo pw | { read -s x; echo "$x"; }`, "pw\n"},
+		{`sleep 0.3 | { read -t 0.05 x; echo "$? [$x]"; }`, "142 []\n"},
+	})
+}
+
+func TestMapfile(t *testing.T) {
+	lines := `printf 'a\nb b\nc\n' > lines; `
+	checkScripts(t, []scriptTest{
+		{lines + `mapfile arr < lines; echo ${#arr[@]}; printf '[%s]' "${arr[@]}"`, "3\n[a\n][b b\n][c\n]"},
+		{lines + `mapfile -t arr < lines; printf '[%s]' "${arr[@]}"`, "[a][b b][c]"},
+		{lines + `mapfile -t -n 2 arr < lines; printf '[%s]' "${arr[@]}"`, "[a][b b]"},
+		{lines + `mapfile -t -n 0 arr < lines; echo ${#arr[@]}`, "3\n"},
+		{lines + `readarray -t r < lines; echo ${r[2]}`, "c\n"},
+		{`printf 'x\ny' | { mapfile -t; printf '[%s]' "${MAPFILE[@]}"; }`, "[x][y]"},
+		{`arr=(old old); mapfile -t arr < /dev/null; echo ${#arr[@]}`, "0\n"},
+		{`mapfile -t -n x arr < /dev/null; echo $?`, "1\n"},
+	})
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// RunExecutor implements `run [--cwd dir] [--env NAME=value]... [--] cmd args`,
+// executing a single command in another directory and/or with extra
+// environment variables while leaving the shell's own state untouched.
+func RunExecutor(shellCtx *ShellCtx, args []string) error {
+	dir := ""
+	overrides := []Assignment{}
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		option, value, hasValue := strings.Cut(args[0], "=")
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		if option != "--cwd" && option != "--env" {
+			return fmt.Errorf("run command got invalid option %s", option)
+		}
+		if !hasValue {
+			if len(args) == 0 {
+				return fmt.Errorf("run option %s requires an argument", option)
+			}
+			value = args[0]
+			args = args[1:]
+		}
+
+		if option == "--cwd" {
+			dir = value
+			continue
+		}
+		assignment, ok := ParseAssignment(value)
+		if !ok {
+			return fmt.Errorf("run option --env expects NAME=value, got %s", value)
+		}
+		overrides = append(overrides, assignment)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("run command requires a command to execute")
+	}
+
+	savedDir := shellCtx.CurrentDir
+	defer func() { shellCtx.CurrentDir = savedDir }()
+	if len(dir) > 0 {
+		path := shellCtx.ResolvePath(dir)
+		info, err := os.Stat(path)
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("run: %s: %s\n", dir, describeOpenError(err))
+			shellCtx.Status = 1
+			return nil
+		}
+		if !info.IsDir() {
+			shellCtx.Serr = fmt.Sprintf("run: %s: Not a directory\n", dir)
+			shellCtx.Status = 1
+			return nil
+		}
+		shellCtx.CurrentDir = path
+		overrides = append([]Assignment{{Name: "PWD", Value: path}}, overrides...)
+	}
+
+	shellCtx.Status = shellCtx.ExecuteArgs(args, overrides, nil, shellCtx.Streams)
+	return nil
+}
+
+// ExecExecutor implements `exec [command [arg ...]]`. With a command, the
+// shell process is replaced by it; in a subshell, which shares the process,
+// the command runs and then ends the subshell instead. Without one, the
+// redirections given to exec apply to the shell itself from then on.
+func ExecExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		if err := shellCtx.makePermanent(shellCtx.Streams); err != nil {
+			shellCtx.Serr = fmt.Sprintf("exec: %s\n", err.Error())
+			shellCtx.Status = 1
+		}
+		return nil
+	}
+	if shellCtx.subshell {
+		shellCtx.Status = shellCtx.ExecuteArgs(args, nil, nil, shellCtx.Streams)
+		shellCtx.exiting = true
+		return nil
+	}
+
+	execPath, found := shellCtx.Runner.LookPath(shellCtx, args[0])
+	if !found {
+		shellCtx.Serr = fmt.Sprintf("exec: %s: not found\n", args[0])
+		shellCtx.Status = 127
+		return nil
+	}
+	streams := shellCtx.Streams
+	files := append([]*os.File{streams.Stdin, streams.Stdout, streams.Stderr}, shellCtx.extraFiles(streams)...)
+	undo, err := moveFiles(files)
+	if err != nil {
+		shellCtx.Serr = fmt.Sprintf("exec: %s\n", err.Error())
+		shellCtx.Status = 1
+		return nil
+	}
+	workDir, _ := os.Getwd()
+	os.Chdir(shellCtx.CurrentDir)
+	shellCtx.Terminal.Restore()
+	err = syscall.Exec(execPath, args, shellCtx.Environ())
+	// The shell goes on, with its descriptors and directory as they were.
+	undo()
+	os.Chdir(workDir)
+	shellCtx.Serr = fmt.Sprintf("exec: %s: %s\n", args[0], describeOpenError(err))
+	shellCtx.Status = 126
+	return nil
+}
+
+// moveFiles puts each of files at the descriptor of its index, left open
+// across an exec, the standard streams that are nil being closed by it.
+// They are all copied out of the way first, so that none is overwritten
+// before it is moved, and so are the descriptors they replace, which undo
+// puts back as they were.
+func moveFiles(files []*os.File) (undo func(), err error) {
+	type replaced struct {
+		fd, saved int
+		cloexec   bool
+	}
+	copies := []int{}
+	moved := []replaced{}
+	undo = func() {
+		for i := len(moved) - 1; i >= 0; i-- {
+			if moved[i].saved < 0 {
+				syscall.Close(moved[i].fd)
+				continue
+			}
+			flags := 0
+			if moved[i].cloexec {
+				flags = syscall.O_CLOEXEC
+			}
+			syscall.Dup3(moved[i].saved, moved[i].fd, flags)
+			syscall.Close(moved[i].saved)
+		}
+		for _, duplicate := range copies {
+			syscall.Close(duplicate)
+		}
+	}
+	copyHigh := func(fd uintptr) (int, syscall.Errno) {
+		duplicate, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_DUPFD_CLOEXEC, uintptr(len(files)))
+		return int(duplicate), errno
+	}
+	sources := make([]int, len(files))
+	for fd, file := range files {
+		sources[fd] = -1
+		if file == nil {
+			continue
+		}
+		duplicate, errno := copyHigh(file.Fd())
+		if errno != 0 {
+			undo()
+			return nil, fmt.Errorf("%d: %s", fd, errno.Error())
+		}
+		sources[fd] = duplicate
+		copies = append(copies, duplicate)
+	}
+	for fd, file := range files {
+		if file == nil && fd > 2 {
+			continue
+		}
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+		if errno == syscall.EBADF {
+			moved = append(moved, replaced{fd: fd, saved: -1})
+		} else {
+			saved, errno := copyHigh(uintptr(fd))
+			if errno != 0 {
+				undo()
+				return nil, fmt.Errorf("%d: %s", fd, errno.Error())
+			}
+			moved = append(moved, replaced{fd: fd, saved: saved, cloexec: flags&syscall.FD_CLOEXEC != 0})
+		}
+		if file == nil {
+			// Closed by exec, so that the shell still has it if exec fails.
+			if errno != syscall.EBADF {
+				syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFD, syscall.FD_CLOEXEC)
+			}
+			continue
+		}
+		if err := syscall.Dup3(sources[fd], fd, 0); err != nil {
+			undo()
+			return nil, fmt.Errorf("%d: %s", fd, err.Error())
+		}
+	}
+	return undo, nil
+}
+
+// makePermanent makes streams those of the shell from then on. The standard
+// ones are moved onto the standard file descriptors of the process, which
+// is what the shell and the commands it starts use by default. Descriptors
+// above 2 go in the shell's table instead, Fds, as the process's own ones
+// from 3 on may be in use by the runtime. A subshell shares the process and
+// Fds with its shell, so it keeps copies of all of them to itself.
+func (ctx *ShellCtx) makePermanent(streams Streams) error {
+	standard := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	for fd, file := range []*os.File{streams.Stdin, streams.Stdout, streams.Stderr} {
+		if ctx.subshell {
+			if previous, found := ctx.permanent[fd]; (found && file == previous) || (!found && file == standard[fd]) {
+				continue
+			}
+			if err := ctx.keepPermanent(fd, file); err != nil {
+				return err
+			}
+			continue
+		}
+		if file == nil {
+			syscall.Close(fd)
+			continue
+		}
+		if int(file.Fd()) == fd {
+			continue
+		}
+		if err := syscall.Dup3(int(file.Fd()), fd, 0); err != nil {
+			return fmt.Errorf("%d: %s", fd, err.Error())
+		}
+	}
+	for fd, file := range streams.Extra {
+		if ctx.subshell {
+			if previous, found := ctx.permanent[fd]; found && file == previous {
+				continue
+			}
+			if err := ctx.keepPermanent(fd, file); err != nil {
+				return err
+			}
+			continue
+		}
+		if previous, found := ctx.Fds[fd]; found {
+			if file == previous {
+				continue
+			}
+			previous.Close()
+			delete(ctx.Fds, fd)
+		}
+		if file == nil {
+			continue
+		}
+		// The redirection closes its file once exec is done.
+		duplicate, err := syscall.Dup(int(file.Fd()))
+		if err != nil {
+			return fmt.Errorf("%d: %s", fd, err.Error())
+		}
+		syscall.CloseOnExec(duplicate)
+		if ctx.Fds == nil {
+			ctx.Fds = map[int]*os.File{}
+		}
+		ctx.Fds[fd] = os.NewFile(uintptr(duplicate), file.Name())
+	}
+	return nil
+}
+
+// keepPermanent keeps a copy of file as the subshell's descriptor fd, nil
+// for one it closed.
+func (ctx *ShellCtx) keepPermanent(fd int, file *os.File) error {
+	var duplicate *os.File
+	if file != nil {
+		// The redirection closes its file once exec is done.
+		copied, err := syscall.Dup(int(file.Fd()))
+		if err != nil {
+			return fmt.Errorf("%d: %s", fd, err.Error())
+		}
+		syscall.CloseOnExec(copied)
+		duplicate = os.NewFile(uintptr(copied), file.Name())
+	}
+	if previous := ctx.permanent[fd]; previous != nil {
+		previous.Close()
+	}
+	if ctx.permanent == nil {
+		ctx.permanent = map[int]*os.File{}
+	}
+	ctx.permanent[fd] = duplicate
+	return nil
+}
+
+// processState is what of the process a subshell may change while it
+// shares it with its shell: the file mode mask and the resource limits.
+// The directory is the shell's CurrentDir, and a subshell leaves signal
+// dispositions alone.
+type processState struct {
+	umask  int
+	limits map[int]syscall.Rlimit
+}
+
+func saveProcessState() processState {
+	state := processState{umask: currentUmask(), limits: map[int]syscall.Rlimit{}}
+	for _, limit := range resourceLimits {
+		var current syscall.Rlimit
+		if syscall.Getrlimit(limit.resource, &current) == nil {
+			state.limits[limit.resource] = current
+		}
+	}
+	return state
+}
+
+// restore puts back the mask and the limits that changed since state was
+// saved.
+func (state processState) restore() {
+	if currentUmask() != state.umask {
+		syscall.Umask(state.umask)
+	}
+	for resource, saved := range state.limits {
+		var current syscall.Rlimit
+		if syscall.Getrlimit(resource, &current) == nil && current != saved {
+			syscall.Setrlimit(resource, &saved)
+		}
+	}
+}
+
+// leaveSubshell gives the shell back what the subshell changed of the
+// process once it is done, and closes the files exec redirected for good
+// in it.
+func (ctx *ShellCtx) leaveSubshell() {
+	for _, file := range ctx.permanent {
+		if file != nil {
+			file.Close()
+		}
+	}
+	ctx.permanent = nil
+	ctx.processState.restore()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestExecRedirections(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		files map[string]string
+	}{
+		{
+			"descriptor above 2 for good",
+			[]string{"exec 3> log", "sh -c 'echo one >&3'", "echo two >&3", "exec 3>&-"},
+			map[string]string{"log": "one\ntwo\n"},
+		},
+		{
+			"descriptor above 2 for one command",
+			[]string{"sh -c 'echo temporary >&4' 4> other"},
+			map[string]string{"other": "temporary\n"},
+		},
+		{
+			"duplicated descriptor",
+			[]string{"{ echo out; sh -c 'echo err >&2'; } > both 2>&1"},
+			map[string]string{"both": "out\nerr\n"},
+		},
+		{
+			"duplicated in order",
+			[]string{"sh -c 'echo err >&2' 2>&1 > out 2> err"},
+			map[string]string{"out": "", "err": "err\n"},
+		},
+		{
+			"input duplicated",
+			[]string{"echo line > in", "exec 5< in", "read word <&5", "echo $word > out", "exec 5<&-"},
+			map[string]string{"out": "line\n"},
+		},
+		{
+			"subshell keeps its own",
+			[]string{"{ (exec > leak; echo in); echo out; } > main"},
+			map[string]string{"leak": "in\n", "main": "out\n"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shellCtx := NewShellCtx()
+			shellCtx.CurrentDir = t.TempDir()
+			for _, line := range test.lines {
+				if status := shellCtx.RunLine(line); status != 0 {
+					t.Fatalf("%s: status %d", line, status)
+				}
+			}
+			for name, want := range test.files {
+				if got, _ := os.ReadFile(filepath.Join(shellCtx.CurrentDir, name)); string(got) != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClosedDescriptors(t *testing.T) {
+	tests := []string{
+		"sh -c 'echo leaked >&4' 2> /dev/null",
+		"exec 3> log; exec 3>&-; echo leaked >&3",
+		"echo leaked >&7",
+		"echo leaked >&- 2> /dev/null",
+	}
+	for _, line := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.CurrentDir = t.TempDir()
+		if status := shellCtx.RunLine(line); status == 0 {
+			t.Errorf("%s: status 0", line)
+		}
+	}
+}
+
+// standardOut identifies the file at the process's descriptor 1.
+func standardOut(t *testing.T) syscall.Stat_t {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(1, &stat); err != nil {
+		t.Fatal(err)
+	}
+	return stat
+}
+
+func TestExecLeavesShellDescriptors(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(shellCtx.CurrentDir, "garbage"), []byte{0, 1, 2, 3}, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	before := standardOut(t)
+	lines := []string{
+		"(exec > leak)",
+		"exec ./garbage > out 2> /dev/null",
+	}
+	for _, line := range lines {
+		shellCtx.RunLine(line)
+		if after := standardOut(t); after.Ino != before.Ino || after.Dev != before.Dev {
+			t.Errorf("%s: moved the shell's standard output", line)
+		}
+	}
+}
+
+func TestParseRedirectFd(t *testing.T) {
+	tests := []struct {
+		op  string
+		fd  int
+		ok  bool
+		dir string
+	}{
+		{">", 1, true, ">"},
+		{"<", 0, true, "<"},
+		{"2>>", 2, true, ">>"},
+		{"3>", 3, true, ">"},
+		{"9<", 9, true, "<"},
+		{">&", 1, true, ">&"},
+		{"<&", 0, true, "<&"},
+		{"2>&", 2, true, ">&"},
+		{"256>", 0, false, ""},
+	}
+	for _, test := range tests {
+		redirect, err := parseRedirect(test.op, "file")
+		if (err == nil) != test.ok || test.ok && (redirect.Fd != test.fd || redirect.Op != test.dir) {
+			t.Errorf("parseRedirect(%q) = %+v, %v", test.op, redirect, err)
+		}
+	}
+}
+
+func TestRunBuiltin(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"mkdir sub; touch sub/f; run --cwd sub -- ls; ls", "f\nsub\n"},
+		{"mkdir sub; touch sub/f; run --cwd sub ls", "f\n"},
+		{"mkdir sub; run --cwd sub -- cd ..; ls", "sub\n"},
+		{`run --env A=1 --env B=2 -- sh -c 'echo $A$B'; echo "[$A]"`, "12\n[]\n"},
+		{"run --cwd missing -- true; echo $?", "1\n"},
+		{"run --env bad -- true; echo $?", "1\n"},
+		{"run -- missing; echo $?", "127\n"},
+	})
+}
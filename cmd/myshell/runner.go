@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// CommandSpec describes an external command the shell is about to spawn.
+type CommandSpec struct {
+	Path       string
+	Args       []string
+	Env        []string
+	Dir        string
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+	Background bool
+	// ExtraFiles are the descriptors from 3 on, as in exec.Cmd.
+	ExtraFiles []*os.File
+	// Group is the process group of the foreground job the command is part
+	// of, when job control is on.
+	Group *ProcessGroup
+}
+
+type Process interface {
+	Pid() int
+	Wait() (int, error)
+}
+
+// CommandRunner is the boundary between the shell and the operating system
+// for external commands. ExecRunner is the real implementation; tests can
+// swap in FakeRunner (or their own) on ShellCtx.Runner to observe spawned
+// argv and script results without running anything.
+type CommandRunner interface {
+	LookPath(ctx *ShellCtx, name string) (string, bool)
+	Start(spec *CommandSpec) (Process, error)
+}
+
+type ExecRunner struct{}
+
+func (ExecRunner) LookPath(ctx *ShellCtx, name string) (string, bool) {
+	return ctx.LookupExecutable(name)
+}
+
+func (ExecRunner) Start(spec *CommandSpec) (Process, error) {
+	cmd := exec.Command(spec.Path)
+	cmd.Args = spec.Args
+	cmd.Env = spec.Env
+	cmd.Dir = spec.Dir
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+	cmd.ExtraFiles = spec.ExtraFiles
+	if spec.Group != nil {
+		if err := spec.Group.start(cmd); err != nil {
+			return nil, err
+		}
+		return &execProcess{cmd: cmd, untraced: true}, nil
+	}
+	if spec.Background {
+		cmd.SysProcAttr = backgroundProcAttr()
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execProcess{cmd: cmd}, nil
+}
+
+type execProcess struct {
+	cmd *exec.Cmd
+	// untraced processes belong to a foreground job, and waiting on them
+	// also returns when they are stopped.
+	untraced bool
+}
+
+func (process *execProcess) Pid() int {
+	return process.cmd.Process.Pid
+}
+
+func (process *execProcess) Wait() (int, error) {
+	if process.untraced {
+		if status, stopped := waitUntraced(process.Pid()); stopped {
+			return status, errProcessStopped
+		}
+	}
+	err := process.cmd.Wait()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return 1, err
+		}
+	}
+	return ExitStatus(process.cmd.ProcessState), nil
+}
+
+func ExitStatus(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
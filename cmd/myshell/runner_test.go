@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type FakeResponse struct {
+	Stdout string
+	Stderr string
+	Status int
+	Err    error
+	// Filter, when set, makes the command read its input to the end and
+	// write what Filter returns for it after Stdout.
+	Filter func(input string) string
+}
+
+// FakeRunner pretends every command in Responses exists and answers it with
+// the scripted output and status. Every spawned argv is recorded in Calls.
+type FakeRunner struct {
+	mu        sync.Mutex
+	Responses map[string]FakeResponse
+	Calls     [][]string
+	nextPid   int
+}
+
+func (runner *FakeRunner) LookPath(_ *ShellCtx, name string) (string, bool) {
+	if _, found := runner.Responses[path.Base(name)]; !found {
+		return "", false
+	}
+	return path.Join("/fake/bin", path.Base(name)), true
+}
+
+func (runner *FakeRunner) Start(spec *CommandSpec) (Process, error) {
+	runner.mu.Lock()
+	runner.Calls = append(runner.Calls, append([]string{}, spec.Args...))
+	runner.nextPid++
+	pid := runner.nextPid
+	response := runner.Responses[path.Base(spec.Path)]
+	runner.mu.Unlock()
+
+	if response.Err != nil {
+		return nil, response.Err
+	}
+	io.WriteString(spec.Stdout, response.Stdout)
+	if response.Filter != nil {
+		input, _ := io.ReadAll(spec.Stdin)
+		io.WriteString(spec.Stdout, response.Filter(string(input)))
+	} else if spec.Stdin != nil {
+		go io.Copy(io.Discard, spec.Stdin)
+	}
+	io.WriteString(spec.Stderr, response.Stderr)
+	return &fakeProcess{pid: pid, status: response.Status}, nil
+}
+
+type fakeProcess struct {
+	pid    int
+	status int
+}
+
+func (process *fakeProcess) Pid() int {
+	return process.pid
+}
+
+func (process *fakeProcess) Wait() (int, error) {
+	return process.status, nil
+}
+
+// runFake runs line in a shell whose external commands are those of runner,
+// and returns what it printed and its status.
+func runFake(t *testing.T, runner *FakeRunner, line string) (string, int) {
+	t.Helper()
+	shellCtx := NewShellCtx()
+	shellCtx.Runner = runner
+	return runShell(t, shellCtx, line)
+}
+
+// runShell runs line in shellCtx and returns what it printed and its
+// status. Errors are thrown away.
+func runShell(t *testing.T, shellCtx *ShellCtx, line string) (string, int) {
+	t.Helper()
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	stderr, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	saved := shellCtx.Streams
+	shellCtx.Streams.Stdout, shellCtx.Streams.Stderr = stdout, stderr
+	defer func() { shellCtx.Streams = saved }()
+	status := shellCtx.RunLine(line)
+	output, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output), status
+}
+
+// scriptTest is a script and what it should print.
+type scriptTest struct{ script, want string }
+
+// checkScripts runs each script in a new shell, in a directory of its own,
+// and compares what it printed.
+func checkScripts(t *testing.T, tests []scriptTest) {
+	t.Helper()
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.CurrentDir = t.TempDir()
+		if got, _ := runShell(t, shellCtx, test.script); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.script, got, test.want)
+		}
+	}
+}
+
+func TestPipelineThroughFakeRunner(t *testing.T) {
+	runner := &FakeRunner{Responses: map[string]FakeResponse{
+		"gen":   {Stdout: "b\na\nc\n"},
+		"upper": {Filter: strings.ToUpper},
+		"lines": {Filter: func(input string) string { return strings.Repeat("#", strings.Count(input, "\n")) + "\n" }},
+		"fail":  {Status: 3},
+	}}
+
+	output, status := runFake(t, runner, "gen | upper")
+	if output != "B\nA\nC\n" || status != 0 {
+		t.Errorf("gen | upper: got %q, status %d", output, status)
+	}
+	output, _ = runFake(t, runner, "echo one two | upper x | lines")
+	if output != "#\n" {
+		t.Errorf("echo | upper | lines: got %q", output)
+	}
+	// The commands of a pipeline start concurrently, in no given order.
+	calls := []string{}
+	for _, call := range runner.Calls {
+		calls = append(calls, strings.Join(call, " "))
+	}
+	slices.Sort(calls)
+	want := []string{"gen", "lines", "upper", "upper x"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls: got %q, want %q", calls, want)
+	}
+}
+
+func TestPipelineStatusThroughFakeRunner(t *testing.T) {
+	runner := &FakeRunner{Responses: map[string]FakeResponse{
+		"ok":   {},
+		"fail": {Status: 3},
+	}}
+	tests := []struct {
+		line   string
+		status int
+	}{
+		{"fail | ok", 0},
+		{"ok | fail", 3},
+		{"! ok | fail", 0},
+		{"set -o pipefail; fail | ok", 3},
+		{"fail | ok; echo ${PIPESTATUS[*]}", 0},
+		{"missing | ok", 0},
+		{"ok | missing", 127},
+	}
+	for _, test := range tests {
+		if _, status := runFake(t, runner, test.line); status != test.status {
+			t.Errorf("%s: got status %d, want %d", test.line, status, test.status)
+		}
+	}
+	if output, _ := runFake(t, runner, "fail | ok | fail; echo ${PIPESTATUS[*]}"); output != "3 0 3\n" {
+		t.Errorf("PIPESTATUS: got %q", output)
+	}
+}
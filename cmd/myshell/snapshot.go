@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+)
+
+type EnvSnapshot struct {
+	Vars    map[string]*Variable
+	Aliases map[string]string
+	Options map[string]bool
+}
+
+func copyVariables(vars map[string]*Variable) map[string]*Variable {
+	copied := make(map[string]*Variable, len(vars))
+	for name, variable := range vars {
+		value := *variable
+		value.Elements = maps.Clone(variable.Elements)
+		value.Assoc = maps.Clone(variable.Assoc)
+		copied[name] = &value
+	}
+	return copied
+}
+
+func (ctx *ShellCtx) TakeEnvSnapshot() *EnvSnapshot {
+	return &EnvSnapshot{
+		Vars:    copyVariables(ctx.Vars),
+		Aliases: maps.Clone(ctx.Aliases),
+		Options: maps.Clone(ctx.Options),
+	}
+}
+
+// RestoreEnvSnapshot brings the shell back to a snapshot. Readonly variables
+// are left as they are now, since restoring must not be a way around them.
+func (ctx *ShellCtx) RestoreEnvSnapshot(snapshot *EnvSnapshot) {
+	vars := copyVariables(snapshot.Vars)
+	for name, variable := range ctx.Vars {
+		if variable.ReadOnly {
+			vars[name] = variable
+		}
+	}
+	ctx.Vars = vars
+	ctx.Aliases = maps.Clone(snapshot.Aliases)
+	ctx.Options = maps.Clone(snapshot.Options)
+	ctx.varChanged("PATH")
+}
+
+func EnvSaveExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) == 0 {
+		names := make([]string, 0, len(shellCtx.EnvSnapshots))
+		for name := range shellCtx.EnvSnapshots {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			shellCtx.Sout += name + "\n"
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("envsave command takes at most 1 argument of type string")
+	}
+	shellCtx.EnvSnapshots[args[0]] = shellCtx.TakeEnvSnapshot()
+	return nil
+}
+
+func EnvRestoreExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("envrestore command takes exactly 1 argument of type string")
+	}
+	snapshot, found := shellCtx.EnvSnapshots[args[0]]
+	if !found {
+		shellCtx.Serr = fmt.Sprintf("envrestore: %s: no such snapshot\n", args[0])
+		shellCtx.Status = 1
+		return nil
+	}
+	shellCtx.RestoreEnvSnapshot(snapshot)
+	return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestEnvSnapshots(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"a=1; envsave s; a=2; b=3; envrestore s; echo $a \"[$b]\"", "1 []\n"},
+		{"alias ll=ls; envsave s; unalias ll; alias k=x; envrestore s; alias", "alias ll='ls'\n"},
+		{"set -o pipefail; envsave s; set +o pipefail; envrestore s; false | true; echo $?", "1\n"},
+		{"arr=(x y); envsave s; arr=(z); envrestore s; echo ${arr[@]}", "x y\n"},
+		{"envsave b; envsave a; envsave", "a\nb\n"},
+		{"a=1; envsave s; a=2; envsave s; a=3; envrestore s; echo $a", "2\n"},
+		{"envrestore nope; echo $?", "1\n"},
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+func SourceExecutor(shellCtx *ShellCtx, args []string) error {
+	return sourceCommand(shellCtx, "source", args)
+}
+
+// DotExecutor is the POSIX spelling of source.
+func DotExecutor(shellCtx *ShellCtx, args []string) error {
+	return sourceCommand(shellCtx, ".", args)
+}
+
+// sourceCommand implements `source [-o] file [arg ...]`. Any arguments become
+// the positional parameters while the file runs.
+func sourceCommand(shellCtx *ShellCtx, command string, args []string) error {
+	once := false
+	if len(args) > 0 && args[0] == "-o" {
+		once = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%s command takes a file name argument", command)
+	}
+
+	name := args[0]
+	path, found := shellCtx.findSourceFile(name)
+	if !found {
+		shellCtx.Serr = fmt.Sprintf("%s: %s: No such file or directory\n", command, name)
+		shellCtx.Status = 1
+		return nil
+	}
+	if slices.Contains(shellCtx.SourceStack, path) {
+		chain := append(slices.Clone(shellCtx.SourceStack), path)
+		shellCtx.Serr = fmt.Sprintf("%s: %s: recursive source detected: %s\n", command, name, strings.Join(chain, " -> "))
+		shellCtx.Status = 1
+		return nil
+	}
+	if once && shellCtx.SourcedFiles[path] {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		shellCtx.Serr = fmt.Sprintf("%s: %s: %s\n", command, name, describeOpenError(err))
+		shellCtx.Status = 1
+		return nil
+	}
+
+	if len(args) > 1 {
+		savedArgs := shellCtx.PositionalArgs
+		shellCtx.PositionalArgs = args[1:]
+		defer func() { shellCtx.PositionalArgs = savedArgs }()
+	}
+	// A return in the file leaves the file, even when it is sourced from a
+	// function.
+	savedFunctionDepth := shellCtx.functionDepth
+	shellCtx.functionDepth = 0
+	shellCtx.sourceDepth++
+	shellCtx.Status = shellCtx.SourceFile(path, string(content))
+	shellCtx.functionDepth = savedFunctionDepth
+	shellCtx.sourceDepth--
+	return nil
+}
+
+// EvalExecutor implements `eval [arg ...]`: the arguments are joined with
+// spaces and run as a command line in the current shell.
+func EvalExecutor(shellCtx *ShellCtx, args []string) error {
+	line := strings.Join(args, " ")
+	if len(strings.TrimSpace(line)) == 0 {
+		return nil
+	}
+	shellCtx.Status = shellCtx.RunLine(line)
+	return nil
+}
+
+// findSourceFile locates the file to source. Like in bash, a name without a
+// slash is looked up in PATH first, where it doesn't need to be executable,
+// and then in the current directory.
+func (ctx *ShellCtx) findSourceFile(name string) (string, bool) {
+	if !strings.Contains(name, "/") {
+		for _, folder := range ctx.PathFolders {
+			candidate := filepath.Join(folder, name)
+			if info, err := os.Stat(candidate); err == nil && info.Mode().IsRegular() {
+				return candidate, true
+			}
+		}
+	}
+	path := ctx.ResolvePath(name)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return path, false
+	}
+	return path, true
+}
+
+// RunScript runs a script file given on the command line, with $0 set to its
+// name and its arguments as positional parameters, and returns the status to
+// exit with.
+func (ctx *ShellCtx) RunScript(name string, args []string) int {
+	path := ctx.ResolvePath(name)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "myshell: %s: %s\n", name, describeOpenError(err))
+		return 127
+	}
+	ctx.ShellName = name
+	ctx.PositionalArgs = args
+	return ctx.SourceFile(path, string(content))
+}
+
+// SourceFile runs the contents of a file in the current shell context while
+// keeping track of what is being sourced, so nested sources can detect loops.
+func (ctx *ShellCtx) SourceFile(path string, content string) int {
+	ctx.SourcedFiles[path] = true
+	ctx.SourceStack = append(ctx.SourceStack, path)
+	savedLineNo := ctx.LineNo
+	defer func() {
+		ctx.SourceStack = ctx.SourceStack[:len(ctx.SourceStack)-1]
+		ctx.LineNo = savedLineNo
+	}()
+
+	lines := strings.Split(content, "\n")
+	next := 0
+	readLine := func(bool) (string, error) {
+		if next == len(lines) {
+			return "", io.EOF
+		}
+		next++
+		return lines[next-1], nil
+	}
+
+	status := 0
+	for next < len(lines) {
+		lineNo := next + 1
+		command, _ := ctx.ReadCommand(readLine)
+		if len(strings.TrimSpace(command)) == 0 {
+			continue
+		}
+		ctx.LineNo = lineNo
+		status = ctx.RunLine(command)
+		if ctx.exiting {
+			break
+		}
+		if ctx.returning {
+			ctx.returning = false
+			break
+		}
+	}
+	return status
+}
+
+// ConfigDir is where the shell keeps its configuration (rc, themes,
+// plugins): $XDG_CONFIG_HOME/myshell, defaulting to ~/.config/myshell.
+func (ctx *ShellCtx) ConfigDir() string {
+	if configHome, found := ctx.GetVar("XDG_CONFIG_HOME"); found && filepath.IsAbs(configHome) {
+		return filepath.Join(configHome, "myshell")
+	}
+	homeDir, _ := ctx.GetVar("HOME")
+	return filepath.Join(homeDir, ".config", "myshell")
+}
+
+// RcFilePath picks the startup file: the XDG location if it exists, and the
+// legacy ~/.myshellrc otherwise.
+func (ctx *ShellCtx) RcFilePath() string {
+	path := filepath.Join(ctx.ConfigDir(), "rc")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	homeDir, _ := ctx.GetVar("HOME")
+	return filepath.Join(homeDir, ".myshellrc")
+}
+
+// LoadRcFile sources the startup file before the first prompt. An explicitly
+// requested rc file must exist, the default ones are optional.
+func (ctx *ShellCtx) LoadRcFile(rcFile string) {
+	path := rcFile
+	if len(path) == 0 {
+		path = ctx.RcFilePath()
+	} else {
+		path = ctx.ResolvePath(path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if len(rcFile) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", rcFile, describeOpenError(err))
+		}
+		return
+	}
+	ctx.SourceFile(path, string(content))
+}
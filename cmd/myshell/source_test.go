@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"echo 'echo $# $1' > a.sh; source ./a.sh x y", "2 x\n"},
+		{"echo 'x=set' > a.sh; . ./a.sh; echo $x", "set\n"},
+		{"echo 'echo in; return 3; echo after' > a.sh; source ./a.sh; echo $?", "in\n3\n"},
+		{"source ./missing.sh; echo $?", "1\n"},
+		{"echo 'echo a; source ./b.sh' > a.sh; echo 'echo b; source ./a.sh' > b.sh; source ./a.sh; echo $?", "a\nb\n1\n"},
+		{"echo 'n=$((n+1))' > a.sh; source -o ./a.sh; source -o ./a.sh; echo $n", "1\n"},
+		{"echo 'n=$((n+1))' > a.sh; source -o ./a.sh; source ./a.sh; echo $n", "2\n"},
+		{"echo 'n=$((n+1))' > a.sh; source ./a.sh; source -o ./a.sh; echo $n", "1\n"},
+	})
+}
+
+func TestRcFilePath(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      []string
+		configHome string
+		want       string
+	}{
+		{"legacy fallback", nil, "", "home/.myshellrc"},
+		{"default config dir", []string{"home/.config/myshell/rc"}, "", "home/.config/myshell/rc"},
+		{"XDG_CONFIG_HOME", []string{"xdg/myshell/rc", "home/.config/myshell/rc"}, "xdg", "xdg/myshell/rc"},
+		{"XDG_CONFIG_HOME without rc", []string{"home/.config/myshell/rc"}, "xdg", "home/.myshellrc"},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		for _, file := range test.files {
+			os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755)
+			os.WriteFile(filepath.Join(root, file), nil, 0644)
+		}
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("HOME", filepath.Join(root, "home"))
+		shellCtx.UnsetVar("XDG_CONFIG_HOME")
+		if len(test.configHome) > 0 {
+			shellCtx.SetVar("XDG_CONFIG_HOME", filepath.Join(root, test.configHome))
+		}
+		if got := shellCtx.RcFilePath(); got != filepath.Join(root, test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, got, filepath.Join(root, test.want))
+		}
+	}
+}
+
+func TestLoadRcFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "custom"), []byte("from=custom\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".myshellrc"), []byte("from=legacy\n"), 0644)
+	tests := []struct{ rcFile, want string }{
+		{"", "legacy"},
+		{filepath.Join(dir, "custom"), "custom"},
+		{filepath.Join(dir, "missing"), ""},
+	}
+	for _, test := range tests {
+		shellCtx := NewShellCtx()
+		shellCtx.SetVar("HOME", dir)
+		shellCtx.UnsetVar("XDG_CONFIG_HOME")
+		shellCtx.LoadRcFile(test.rcFile)
+		if got, _ := shellCtx.GetVar("from"); got != test.want {
+			t.Errorf("LoadRcFile(%q): got %q, want %q", test.rcFile, got, test.want)
+		}
+	}
+
+	options, err := ParseOptions([]string{"--rcfile", "path", "script"})
+	if err != nil || options.RcFile != "path" || len(options.Args) != 1 {
+		t.Errorf("ParseOptions --rcfile: %+v, %v", options, err)
+	}
+}
+
+func TestSourceKeepsState(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"printf 'alias hi=\"echo hello\"\\n' > a.sh; source ./a.sh; alias hi", "alias hi='echo hello'\n"},
+		{"printf 'f() { echo fn; }\\n' > a.sh; . ./a.sh; f", "fn\n"},
+		{`mkdir sub; T=$(pwd); echo 'cd sub' > a.sh; . ./a.sh; [ "$PWD" = "$T/sub" ] && echo moved`, "moved\n"},
+		{"echo 'x=1' > a.sh; source a.sh; echo $x", "1\n"},
+		{"mkdir bin; echo 'echo from path' > bin/lib.sh; PATH=$(pwd)/bin; source lib.sh", "from path\n"},
+		{"echo 'exit 4; echo no' > a.sh; (source ./a.sh; echo no); echo $?", "4\n"},
+		{"echo 'false' > a.sh; source ./a.sh; echo $?", "1\n"},
+		{`echo 'echo "$@"' > a.sh; set -- outer; source ./a.sh inner; echo $1`, "inner\nouter\n"},
+	})
+}
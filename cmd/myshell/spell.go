@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CorrectDirSpelling fixes minor typos in the components of an absolute path
+// that doesn't exist, like cdspell in bash: every component that can't be
+// found is replaced by a directory next to it that is one transposition,
+// one wrong, missing or extra character away.
+func CorrectDirSpelling(path string) (string, bool) {
+	corrected := "/"
+	for _, component := range strings.Split(filepath.Clean(path), "/") {
+		if len(component) == 0 {
+			continue
+		}
+		candidate := filepath.Join(corrected, component)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			corrected = candidate
+			continue
+		}
+		match, found := findSimilarDir(corrected, component)
+		if !found {
+			return "", false
+		}
+		corrected = filepath.Join(corrected, match)
+	}
+	return corrected, true
+}
+
+func findSimilarDir(parent string, name string) (string, bool) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if !isOneTypoAway(name, entry.Name()) {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(parent, entry.Name())); err == nil && info.IsDir() {
+			return entry.Name(), true
+		}
+	}
+	return "", false
+}
+
+func isOneTypoAway(typed string, name string) bool {
+	if len(typed) == len(name) {
+		diffs := []int{}
+		for i := 0; i < len(typed); i++ {
+			if typed[i] != name[i] {
+				diffs = append(diffs, i)
+			}
+		}
+		switch len(diffs) {
+		case 1:
+			return true
+		case 2:
+			i, j := diffs[0], diffs[1]
+			return j == i+1 && typed[i] == name[j] && typed[j] == name[i]
+		}
+		return false
+	}
+	if len(typed) > len(name) {
+		typed, name = name, typed
+	}
+	if len(name)-len(typed) != 1 {
+		return false
+	}
+	// One character was dropped from name: skipping it must give typed.
+	for i := 0; i < len(name); i++ {
+		if name[:i]+name[i+1:] == typed {
+			return true
+		}
+	}
+	return false
+}
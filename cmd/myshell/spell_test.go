@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsOneTypoAway(t *testing.T) {
+	tests := []struct {
+		typed, name string
+		want        bool
+	}{
+		{"scr", "src", true},
+		{"sec", "src", true},
+		{"sr", "src", true},
+		{"srcc", "src", true},
+		{"src", "src", false},
+		{"csr", "src", false},
+		{"s", "src", false},
+		{"abcd", "badc", false},
+		{"docs", "dosc", true},
+	}
+	for _, test := range tests {
+		if got := isOneTypoAway(test.typed, test.name); got != test.want {
+			t.Errorf("isOneTypoAway(%q, %q) = %v, want %v", test.typed, test.name, got, test.want)
+		}
+	}
+}
+
+func TestCorrectDirSpelling(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "src", "parser"), 0o755)
+	os.WriteFile(filepath.Join(root, "srd"), nil, 0o644)
+	tests := []struct {
+		path, want string
+		found      bool
+	}{
+		{"scr", "src", true},
+		{"scr/parsre", "src/parser", true},
+		{"src/parser", "src/parser", true},
+		{"sr/pasrer", "src/parser", true},
+		{"xyz", "", false},
+		{"scr/xyz", "", false},
+	}
+	for _, test := range tests {
+		got, found := CorrectDirSpelling(filepath.Join(root, test.path))
+		want := ""
+		if test.found {
+			want = filepath.Join(root, test.want)
+		}
+		if got != want || found != test.found {
+			t.Errorf("CorrectDirSpelling(%q) = %q, %v, want %q", test.path, got, found, want)
+		}
+	}
+
+	checkScripts(t, []scriptTest{
+		{`mkdir src; T=$(pwd); shopt -s cdspell; cd scr | sed "s|$T|T|"`, "T/src\n"},
+		{`mkdir src; T=$(pwd); shopt -s cdspell; cd scr > /dev/null; [ "$PWD" = "$T/src" ] && echo in`, "in\n"},
+		{"mkdir src; cd scr; echo $?", "1\n"},
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Terminal owns the termios state of the shell's controlling terminal. Every
+// transition between canonical and raw mode goes through it, so there is a
+// single place that knows how to put the terminal back the way we found it.
+type Terminal struct {
+	mu    sync.Mutex
+	fd    int
+	saved *syscall.Termios
+	raw   bool
+}
+
+func NewTerminal(fd int) *Terminal {
+	term := &Terminal{fd: fd}
+	if state, err := getTermios(fd); err == nil {
+		term.saved = state
+	}
+	return term
+}
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	state := &syscall.Termios{}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(state)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return state, nil
+}
+
+func setTermios(fd int, state *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(state)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func isTerminalFile(file *os.File) bool {
+	_, err := getTermios(int(file.Fd()))
+	return err == nil
+}
+
+func (term *Terminal) IsTerminal() bool {
+	return term.saved != nil
+}
+
+// OutputStops reports whether the tty has tostop set, in which case the
+// kernel stops background jobs that try to write to it.
+func (term *Terminal) OutputStops() bool {
+	state, err := getTermios(term.fd)
+	if err != nil {
+		return false
+	}
+	return state.Lflag&syscall.TOSTOP != 0
+}
+
+// Width is the number of columns of the terminal, 80 when it can't be told,
+// and Height the number of rows, 24 then.
+func (term *Terminal) Width() int {
+	cols, _ := term.windowSize()
+	return cols
+}
+
+func (term *Terminal) Height() int {
+	_, rows := term.windowSize()
+	return rows
+}
+
+func (term *Terminal) windowSize() (int, int) {
+	var size struct{ rows, cols, x, y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(term.fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.cols == 0 || size.rows == 0 {
+		return 80, 24
+	}
+	return int(size.cols), int(size.rows)
+}
+
+func (term *Terminal) IsRaw() bool {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return term.raw
+}
+
+func (term *Terminal) EnterRaw() error {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return term.enterRaw()
+}
+
+func (term *Terminal) enterRaw() error {
+	if term.saved == nil {
+		return nil
+	}
+	raw := *term.saved
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := setTermios(term.fd, &raw); err != nil {
+		return err
+	}
+	term.raw = true
+	return nil
+}
+
+// Restore puts the terminal back into the canonical state it had when the
+// shell started (or after the last successful foreground command).
+func (term *Terminal) Restore() error {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	return term.restore()
+}
+
+func (term *Terminal) restore() error {
+	if term.saved == nil {
+		return nil
+	}
+	term.raw = false
+	return setTermios(term.fd, term.saved)
+}
+
+// TakeForeground makes the shell's process group the foreground one of the
+// terminal again, after a job had it. Until then the shell is in the
+// background, where the kernel would stop it with SIGTTOU for doing so.
+func (term *Terminal) TakeForeground() {
+	if term.saved == nil {
+		return
+	}
+	if !signal.Ignored(syscall.SIGTTOU) {
+		signal.Ignore(syscall.SIGTTOU)
+		defer signal.Reset(syscall.SIGTTOU)
+	}
+	pgrp := int32(syscall.Getpgrp())
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(term.fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+}
+
+// Release hands the terminal over to a child process in canonical mode. The
+// returned function takes it back: a child that exited successfully may have
+// changed the settings on purpose (stty), so they become the new baseline,
+// otherwise the saved state is reinstated. Raw mode is re-entered if the
+// shell was using it before.
+func (term *Terminal) Release() func(success bool) {
+	term.mu.Lock()
+	wasRaw := term.raw
+	if wasRaw {
+		term.restore()
+	}
+	term.mu.Unlock()
+
+	return func(success bool) {
+		term.mu.Lock()
+		defer term.mu.Unlock()
+		if term.saved == nil {
+			return
+		}
+		if state, err := getTermios(term.fd); success && err == nil {
+			term.saved = state
+		} else {
+			term.restore()
+		}
+		if wasRaw {
+			term.enterRaw()
+		}
+	}
+}
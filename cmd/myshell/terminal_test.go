@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPty opens a new pseudo-terminal and returns its slave side, skipping
+// the test where there is none to be had.
+func openPty(t *testing.T) *os.File {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() { master.Close() })
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		t.Skip("no pty:", errno)
+	}
+	var number uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+		t.Skip("no pty:", errno)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pty:", err)
+	}
+	t.Cleanup(func() { slave.Close() })
+	return slave
+}
+
+func lflag(t *testing.T, file *os.File) uint32 {
+	t.Helper()
+	state, err := getTermios(int(file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.Lflag
+}
+
+func TestTerminalWithoutTty(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+
+	term := NewTerminal(int(reader.Fd()))
+	if term.IsTerminal() {
+		t.Error("a pipe is a terminal")
+	}
+	if err := term.EnterRaw(); err != nil || term.IsRaw() {
+		t.Errorf("EnterRaw on a pipe: %v, raw %v", err, term.IsRaw())
+	}
+	if err := term.Restore(); err != nil {
+		t.Errorf("Restore on a pipe: %v", err)
+	}
+	term.Release()(true)
+	if width, height := term.Width(), term.Height(); width != 80 || height != 24 {
+		t.Errorf("size of a pipe: %dx%d", width, height)
+	}
+}
+
+func TestTerminalRawAndRestore(t *testing.T) {
+	tty := openPty(t)
+	term := NewTerminal(int(tty.Fd()))
+	if !term.IsTerminal() {
+		t.Fatal("a pty is not a terminal")
+	}
+	canonical := lflag(t, tty)
+
+	if err := term.EnterRaw(); err != nil {
+		t.Fatal(err)
+	}
+	if !term.IsRaw() || lflag(t, tty)&(syscall.ICANON|syscall.ECHO|syscall.ISIG) != 0 {
+		t.Errorf("raw mode: raw %v, lflag %#o", term.IsRaw(), lflag(t, tty))
+	}
+	if err := term.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if term.IsRaw() || lflag(t, tty) != canonical {
+		t.Errorf("restored: raw %v, lflag %#o, want %#o", term.IsRaw(), lflag(t, tty), canonical)
+	}
+}
+
+func TestTerminalRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		success bool
+		keep    bool
+	}{
+		{"a successful child's settings are kept", true, true},
+		{"a failed child's settings are undone", false, false},
+	}
+	for _, test := range tests {
+		tty := openPty(t)
+		term := NewTerminal(int(tty.Fd()))
+		canonical := lflag(t, tty)
+		if err := term.EnterRaw(); err != nil {
+			t.Fatal(err)
+		}
+
+		resume := term.Release()
+		if term.IsRaw() || lflag(t, tty) != canonical {
+			t.Errorf("%s: released in raw mode", test.name)
+		}
+		// What the child does, like stty -echo.
+		state, _ := getTermios(int(tty.Fd()))
+		state.Lflag &^= syscall.ECHO
+		if err := setTermios(int(tty.Fd()), state); err != nil {
+			t.Fatal(err)
+		}
+		resume(test.success)
+		if !term.IsRaw() {
+			t.Errorf("%s: raw mode not re-entered", test.name)
+		}
+
+		term.Restore()
+		want := canonical
+		if test.keep {
+			want &^= syscall.ECHO
+		}
+		if got := lflag(t, tty); got != want {
+			t.Errorf("%s: lflag %#o, want %#o", test.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	readAccess  = 0x4
+	writeAccess = 0x2
+)
+
+func TestExecutor(shellCtx *ShellCtx, args []string) error {
+	return runTest(shellCtx, "test", args)
+}
+
+// BracketExecutor is `[ expression ]`, test with a closing bracket.
+func BracketExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) == 0 || args[len(args)-1] != "]" {
+		shellCtx.Serr = "[: missing `]'\n"
+		shellCtx.Status = 2
+		return nil
+	}
+	return runTest(shellCtx, "[", args[:len(args)-1])
+}
+
+// runTest sets the status to 0 when the expression holds, 1 when it
+// doesn't and 2 when it can't be evaluated.
+func runTest(shellCtx *ShellCtx, command string, args []string) error {
+	result, err := shellCtx.evalTest(args)
+	switch {
+	case err != nil:
+		shellCtx.Serr = fmt.Sprintf("%s: %s\n", command, err.Error())
+		shellCtx.Status = 2
+	case !result:
+		shellCtx.Status = 1
+	}
+	return nil
+}
+
+// evalTest follows POSIX in deciding by the number of arguments how to read
+// them, so that `[ -n ]` or `[ "$a" = ! ]` mean what they look like.
+// Longer expressions are parsed with ! binding tighter than -a, and -a
+// tighter than -o.
+func (ctx *ShellCtx) evalTest(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		return false, nil
+	case 1:
+		return args[0] != "", nil
+	case 2:
+		if args[0] == "!" {
+			return args[1] == "", nil
+		}
+		if isTestUnaryOp(args[0]) {
+			return ctx.testUnary(args[0], args[1])
+		}
+		return false, fmt.Errorf("%s: unary operator expected", args[0])
+	case 3:
+		if isTestBinaryOp(args[1]) {
+			return ctx.testBinary(args[0], args[1], args[2])
+		}
+		if args[0] == "!" {
+			result, err := ctx.evalTest(args[1:])
+			return !result, err
+		}
+		if args[0] == "(" && args[2] == ")" {
+			return args[1] != "", nil
+		}
+	case 4:
+		if args[0] == "!" {
+			result, err := ctx.evalTest(args[1:])
+			return !result, err
+		}
+		if args[0] == "(" && args[3] == ")" {
+			return ctx.evalTest(args[1:3])
+		}
+	}
+	parser := &testParser{ctx: ctx, args: args}
+	result := parser.or()
+	if parser.err == nil && parser.pos < len(args) {
+		parser.err = fmt.Errorf("%s: unexpected argument", args[parser.pos])
+	}
+	return result, parser.err
+}
+
+type testParser struct {
+	ctx  *ShellCtx
+	args []string
+	pos  int
+	err  error
+}
+
+func (p *testParser) peek() (string, bool) {
+	if p.pos >= len(p.args) {
+		return "", false
+	}
+	return p.args[p.pos], true
+}
+
+func (p *testParser) or() bool {
+	result := p.and()
+	for arg, ok := p.peek(); ok && arg == "-o" && p.err == nil; arg, ok = p.peek() {
+		p.pos++
+		// Both sides are always evaluated, as they have no side effects.
+		right := p.and()
+		result = result || right
+	}
+	return result
+}
+
+func (p *testParser) and() bool {
+	result := p.not()
+	for arg, ok := p.peek(); ok && arg == "-a" && p.err == nil; arg, ok = p.peek() {
+		p.pos++
+		right := p.not()
+		result = result && right
+	}
+	return result
+}
+
+func (p *testParser) not() bool {
+	if arg, ok := p.peek(); ok && arg == "!" {
+		p.pos++
+		return !p.not()
+	}
+	return p.primary()
+}
+
+func (p *testParser) primary() bool {
+	arg, ok := p.peek()
+	if !ok {
+		p.fail(fmt.Errorf("argument expected"))
+		return false
+	}
+	if arg == "(" {
+		p.pos++
+		result := p.or()
+		if closing, ok := p.peek(); !ok || closing != ")" {
+			p.fail(fmt.Errorf("`)' expected"))
+			return false
+		}
+		p.pos++
+		return result
+	}
+	if p.pos+2 < len(p.args) && isTestBinaryOp(p.args[p.pos+1]) {
+		left, op, right := arg, p.args[p.pos+1], p.args[p.pos+2]
+		p.pos += 3
+		result, err := p.ctx.testBinary(left, op, right)
+		p.fail(err)
+		return result
+	}
+	if isTestUnaryOp(arg) && p.pos+1 < len(p.args) {
+		operand := p.args[p.pos+1]
+		p.pos += 2
+		result, err := p.ctx.testUnary(arg, operand)
+		p.fail(err)
+		return result
+	}
+	p.pos++
+	return arg != ""
+}
+
+func (p *testParser) fail(err error) {
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+func isTestUnaryOp(op string) bool {
+	return len(op) == 2 && op[0] == '-' && strings.IndexByte("bcdefghknoprstuvwxzGLNOS", op[1]) != -1
+}
+
+func isTestBinaryOp(op string) bool {
+	switch op {
+	case "=", "==", "!=", "<", ">", "-eq", "-ne", "-lt", "-le", "-gt", "-ge", "-nt", "-ot", "-ef":
+		return true
+	}
+	return false
+}
+
+// testUnary evaluates a unary primary of test, which [[ ]] shares. Relative
+// paths are taken from the shell's current directory.
+func (ctx *ShellCtx) testUnary(op, operand string) (bool, error) {
+	switch op {
+	case "-z":
+		return operand == "", nil
+	case "-n":
+		return operand != "", nil
+	case "-v":
+		_, found, err := ctx.lookupSubscripted(operand)
+		return found, err
+	case "-o":
+		return ctx.Options[operand], nil
+	case "-t":
+		fd, err := strconv.Atoi(strings.TrimSpace(operand))
+		if err != nil {
+			return false, fmt.Errorf("%s: integer expression expected", operand)
+		}
+		_, err = getTermios(fd)
+		return err == nil, nil
+	}
+
+	if operand == "" {
+		return false, nil
+	}
+	path := ctx.ResolvePath(operand)
+	if op == "-L" || op == "-h" {
+		info, err := os.Lstat(path)
+		return err == nil && info.Mode()&os.ModeSymlink != 0, nil
+	}
+	switch op {
+	case "-r":
+		return syscall.Access(path, readAccess) == nil, nil
+	case "-w":
+		return syscall.Access(path, writeAccess) == nil, nil
+	case "-x":
+		return syscall.Access(path, searchAccess) == nil, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, nil
+	}
+	mode := info.Mode()
+	stat, _ := info.Sys().(*syscall.Stat_t)
+	switch op {
+	case "-e":
+		return true, nil
+	case "-f":
+		return mode.IsRegular(), nil
+	case "-d":
+		return mode.IsDir(), nil
+	case "-b":
+		return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0, nil
+	case "-c":
+		return mode&os.ModeCharDevice != 0, nil
+	case "-p":
+		return mode&os.ModeNamedPipe != 0, nil
+	case "-S":
+		return mode&os.ModeSocket != 0, nil
+	case "-s":
+		return info.Size() > 0, nil
+	case "-g":
+		return mode&os.ModeSetgid != 0, nil
+	case "-u":
+		return mode&os.ModeSetuid != 0, nil
+	case "-k":
+		return mode&os.ModeSticky != 0, nil
+	case "-O":
+		return stat != nil && int(stat.Uid) == os.Geteuid(), nil
+	case "-G":
+		return stat != nil && int(stat.Gid) == os.Getegid(), nil
+	case "-N":
+		return stat != nil && stat.Mtim.Nano() > stat.Atim.Nano(), nil
+	}
+	return false, fmt.Errorf("%s: unary operator expected", op)
+}
+
+// testBinary evaluates a binary primary of test, which [[ ]] shares for
+// everything except its pattern matching = and !=.
+func (ctx *ShellCtx) testBinary(left, op, right string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	case "<":
+		return left < right, nil
+	case ">":
+		return left > right, nil
+	case "-nt", "-ot", "-ef":
+		return ctx.compareFiles(left, op, right), nil
+	}
+
+	a, err := parseTestInteger(left)
+	if err != nil {
+		return false, err
+	}
+	b, err := parseTestInteger(right)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	case "-ge":
+		return a >= b, nil
+	}
+	return false, fmt.Errorf("%s: binary operator expected", op)
+}
+
+func parseTestInteger(value string) (int64, error) {
+	number, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: integer expression expected", value)
+	}
+	return number, nil
+}
+
+// compareFiles implements -nt and -ot, where an existing file is newer than
+// a missing one, and -ef, which tells whether both name the same file.
+func (ctx *ShellCtx) compareFiles(left, op, right string) bool {
+	leftInfo, leftErr := os.Stat(ctx.ResolvePath(left))
+	rightInfo, rightErr := os.Stat(ctx.ResolvePath(right))
+	switch op {
+	case "-nt":
+		return leftErr == nil && (rightErr != nil || leftInfo.ModTime().After(rightInfo.ModTime()))
+	case "-ot":
+		return rightErr == nil && (leftErr != nil || leftInfo.ModTime().Before(rightInfo.ModTime()))
+	}
+	return leftErr == nil && rightErr == nil && os.SameFile(leftInfo, rightInfo)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalTest(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	os.WriteFile(filepath.Join(shellCtx.CurrentDir, "file"), []byte("data"), 0o644)
+	os.WriteFile(filepath.Join(shellCtx.CurrentDir, "empty"), nil, 0o644)
+	os.WriteFile(filepath.Join(shellCtx.CurrentDir, "run"), nil, 0o755)
+	os.Mkdir(filepath.Join(shellCtx.CurrentDir, "dir"), 0o755)
+	os.Symlink("file", filepath.Join(shellCtx.CurrentDir, "link"))
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(shellCtx.CurrentDir, "empty"), old, old)
+	shellCtx.SetVar("set", "")
+	tests := []struct {
+		expr string
+		want bool
+		ok   bool
+	}{
+		{"", false, true},
+		{"x", true, true},
+		{"-n", true, true},
+		{"! x", false, true},
+		{"-f file", true, true},
+		{"-f dir", false, true},
+		{"-d dir", true, true},
+		{"-e missing", false, true},
+		{"-x run", true, true},
+		{"-x file", false, true},
+		{"-s file", true, true},
+		{"-s empty", false, true},
+		{"-L link", true, true},
+		{"-h file", false, true},
+		{"-z ''", true, true},
+		{"-v set", true, true},
+		{"-v unset", false, true},
+		{"-o pipefail", false, true},
+		{"a = a", true, true},
+		{"a == b", false, true},
+		{"a != b", true, true},
+		{"a < b", true, true},
+		{"10 -eq 10", true, true},
+		{"9 -lt 10", true, true},
+		{"-3 -ge -2", false, true},
+		{" 7 -gt 6", true, true},
+		{"x -eq 1", false, false},
+		{"file -nt empty", true, true},
+		{"empty -nt file", false, true},
+		{"file -ef link", true, true},
+		{"( x )", true, true},
+		{"! ( a = b )", true, true},
+		{"x -a ''", false, true},
+		{"'' -o x", true, true},
+		{"! '' -a x", true, true},
+		{"x -o x -a ''", true, true},
+		{"( a = b -o c = c ) -a d", true, true},
+		{"a = !", false, true},
+		{"-q x", false, false},
+		{"a b c d e", false, false},
+	}
+	for _, test := range tests {
+		args := strings.Fields(test.expr)
+		for i, arg := range args {
+			if arg == "''" {
+				args[i] = ""
+			}
+		}
+		got, err := shellCtx.evalTest(args)
+		if (err == nil) != test.ok || (err == nil && got != test.want) {
+			t.Errorf("test %s: got %v, %v", test.expr, got, err)
+		}
+	}
+}
+
+func TestTestBuiltins(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"test a = a; echo $?", "0\n"},
+		{"[ a = b ]; echo $?", "1\n"},
+		{"[ 1 -eq x ]; echo $?", "2\n"},
+		{"[ a = a; echo $?", "2\n"},
+		{`x=''; [ -n "$x" ]; echo $?`, "1\n"},
+		{`[ "$unset" = ! ]; echo $?`, "1\n"},
+		{"touch f; [ -f f ] && [ ! -d f ] && echo file", "file\n"},
+	})
+}
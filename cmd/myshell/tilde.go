@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"sort"
+	"strings"
+)
+
+// ExpandTilde resolves a tilde prefix, the part of a word between ~ and the
+// first slash: ~ is HOME, ~+ and ~- are PWD and OLDPWD, ~name is a named
+// directory or else the home directory of user name.
+func (ctx *ShellCtx) ExpandTilde(name string) (string, bool) {
+	switch name {
+	case "":
+		return ctx.GetVar("HOME")
+	case "+":
+		return ctx.GetVar("PWD")
+	case "-":
+		return ctx.GetVar("OLDPWD")
+	}
+	if dir, found := ctx.NamedDirs[name]; found {
+		return dir, true
+	}
+	account, err := user.Lookup(name)
+	if err != nil {
+		return "", false
+	}
+	return account.HomeDir, true
+}
+
+// AbbreviateDir shortens a directory for display: the longest named
+// directory or HOME containing it is replaced by its ~ form.
+func (ctx *ShellCtx) AbbreviateDir(dir string) string {
+	best, bestName := "", ""
+	consider := func(prefix, name string) {
+		if len(prefix) <= len(best) || prefix == "/" {
+			return
+		}
+		if dir == prefix || strings.HasPrefix(dir, prefix+"/") {
+			best, bestName = prefix, name
+		}
+	}
+	for name, namedDir := range ctx.NamedDirs {
+		consider(namedDir, name)
+	}
+	if home, found := ctx.GetVar("HOME"); found {
+		consider(strings.TrimSuffix(home, "/"), "")
+	}
+	if len(best) == 0 {
+		return dir
+	}
+	return "~" + bestName + dir[len(best):]
+}
+
+// tildePrefix expands the tilde prefix starting at word[start] == '~'. It
+// returns the directory and the length of the prefix, or false when the
+// prefix is quoted or doesn't name anything.
+func (ctx *ShellCtx) tildePrefix(word string, start int) (string, int, bool) {
+	end := start + 1
+	for end < len(word) && word[end] != '/' && word[end] != ':' {
+		end++
+	}
+	name := word[start+1 : end]
+	if strings.ContainsAny(name, "'\"\\$`") {
+		return "", 0, false
+	}
+	dir, found := ctx.ExpandTilde(name)
+	if !found {
+		return "", 0, false
+	}
+	return dir, end - start, true
+}
+
+func (ctx *ShellCtx) expandTildeWord(word string) string {
+	if !strings.HasPrefix(word, "~") {
+		return word
+	}
+	if dir, length, ok := ctx.tildePrefix(word, 0); ok {
+		return dir + word[length:]
+	}
+	return word
+}
+
+// hashNamedDirs implements `hash -d [name=dir ...]`, defining zsh-style
+// named directories that can be used as ~name.
+func hashNamedDirs(shellCtx *ShellCtx, args []string) error {
+	if len(args) == 0 {
+		names := make([]string, 0, len(shellCtx.NamedDirs))
+		for name := range shellCtx.NamedDirs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			shellCtx.Sout += fmt.Sprintf("%s=%s\n", name, shellCtx.NamedDirs[name])
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		name, dir, found := strings.Cut(arg, "=")
+		if !found {
+			if dir, found := shellCtx.NamedDirs[name]; found {
+				shellCtx.Sout += fmt.Sprintf("%s=%s\n", name, dir)
+			} else {
+				shellCtx.Serr += fmt.Sprintf("hash: %s: not found\n", name)
+				shellCtx.Status = 1
+			}
+			continue
+		}
+		if !IsValidName(name) {
+			shellCtx.Serr += fmt.Sprintf("hash: `%s': invalid directory name\n", name)
+			shellCtx.Status = 1
+			continue
+		}
+		shellCtx.NamedDirs[name] = shellCtx.ResolvePath(shellCtx.expandTildeWord(dir))
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestAbbreviateDir(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.SetVar("HOME", "/home/me")
+	shellCtx.NamedDirs["proj"] = "/home/me/work/proj"
+	shellCtx.NamedDirs["work"] = "/home/me/work"
+	shellCtx.NamedDirs["root"] = "/"
+	tests := []struct{ dir, want string }{
+		{"/home/me", "~"},
+		{"/home/me/src", "~/src"},
+		{"/home/me/work", "~work"},
+		{"/home/me/work/other", "~work/other"},
+		{"/home/me/work/proj/cmd", "~proj/cmd"},
+		{"/home/me/work/project", "~work/project"},
+		{"/home/meow", "/home/meow"},
+		{"/etc", "/etc"},
+	}
+	for _, test := range tests {
+		if got := shellCtx.AbbreviateDir(test.dir); got != test.want {
+			t.Errorf("AbbreviateDir(%q) = %q, want %q", test.dir, got, test.want)
+		}
+	}
+}
+
+func TestNamedDirectories(t *testing.T) {
+	const setup = `mkdir -p work/proj; HOME=$(pwd); T=$HOME; `
+	checkScripts(t, []scriptTest{
+		{setup + `hash -d proj=~/work/proj; cd ~proj; [ "$PWD" = "$T/work/proj" ] && echo in`, "in\n"},
+		{setup + `hash -d proj=work/proj; echo ~proj/x | sed "s|$T|T|"`, "T/work/proj/x\n"},
+		{setup + `hash -d b=/b a=/a; hash -d`, "a=/a\nb=/b\n"},
+		{setup + `hash -d a=/a; hash -d a`, "a=/a\n"},
+		{setup + `hash -d missing; echo $?`, "1\n"},
+		{setup + `hash -d 1x=/a; echo $?`, "1\n"},
+		{setup + `echo ~nobody-named-this "~proj"`, "~nobody-named-this ~proj\n"},
+		{setup + `hash -d proj=/p; echo "~proj" '~proj' \~proj`, "~proj ~proj ~proj\n"},
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultTimeFormat is how time reports when TIMEFORMAT isn't set, and
+// posixTimeFormat how time -p does.
+const (
+	defaultTimeFormat = "\nreal\t%3lR\nuser\t%3lU\nsys\t%3lS"
+	posixTimeFormat   = "real %2R\nuser %2U\nsys %2S"
+)
+
+// cpuTimes is the CPU time used by the shell and by the children it has
+// waited for.
+type cpuTimes struct {
+	user, sys time.Duration
+}
+
+func readCPUTimes() cpuTimes {
+	self, children := usageTimes(syscall.RUSAGE_SELF), usageTimes(syscall.RUSAGE_CHILDREN)
+	return cpuTimes{user: self.user + children.user, sys: self.sys + children.sys}
+}
+
+func usageTimes(who int) cpuTimes {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(who, &usage); err != nil {
+		return cpuTimes{}
+	}
+	return cpuTimes{user: time.Duration(usage.Utime.Nano()), sys: time.Duration(usage.Stime.Nano())}
+}
+
+// TimesExecutor implements `times`: the user and system time used by the
+// shell, then by the commands it ran.
+func TimesExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("times command takes no arguments")
+	}
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		times := usageTimes(who)
+		shellCtx.Sout += formatTimes("%3lU %3lS", 0, times.user, times.sys) + "\n"
+	}
+	return nil
+}
+
+// reportTime writes what a pipeline run with the time keyword took, from its
+// start and the CPU times read then, to the shell's stderr.
+func (ctx *ShellCtx) reportTime(pipeline *Pipeline, start time.Time, before cpuTimes) {
+	real := time.Since(start)
+	after := readCPUTimes()
+	format, found := ctx.GetVar("TIMEFORMAT")
+	if !found {
+		format = defaultTimeFormat
+	}
+	if pipeline.PosixTime {
+		format = posixTimeFormat
+	}
+	if format == "" {
+		return
+	}
+	report := formatTimes(format, real, after.user-before.user, after.sys-before.sys)
+	fmt.Fprintln(ctx.Streams.Stderr, report)
+}
+
+// strftimeLayouts are the Go layouts of the strftime conversions that have
+// one.
+var strftimeLayouts = map[byte]string{
+	'a': "Mon", 'A': "Monday", 'b': "Jan", 'h': "Jan", 'B': "January",
+	'c': "Mon Jan _2 15:04:05 2006", 'd': "02", 'D': "01/02/06", 'e': "_2",
+	'F': "2006-01-02", 'H': "15", 'I': "03", 'm': "01", 'M': "04", 'p': "PM",
+	'r': "03:04:05 PM", 'R': "15:04", 'S': "05", 'T': "15:04:05", 'x': "01/02/06",
+	'X': "15:04:05", 'y': "06", 'Y': "2006", 'z': "-0700", 'Z': "MST",
+}
+
+// strftime formats t the way the C function does, for HISTTIMEFORMAT.
+// Unknown conversions are kept as they are.
+func strftime(format string, t time.Time) string {
+	formatted := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			formatted.WriteByte(format[i])
+			continue
+		}
+		i++
+		if layout, found := strftimeLayouts[format[i]]; found {
+			formatted.WriteString(t.Format(layout))
+			continue
+		}
+		switch format[i] {
+		case 'j':
+			fmt.Fprintf(&formatted, "%03d", t.YearDay())
+		case 'k':
+			fmt.Fprintf(&formatted, "%2d", t.Hour())
+		case 'l':
+			fmt.Fprintf(&formatted, "%2d", (t.Hour()+11)%12+1)
+		case 's':
+			fmt.Fprintf(&formatted, "%d", t.Unix())
+		case 'u':
+			fmt.Fprintf(&formatted, "%d", (int(t.Weekday())+6)%7+1)
+		case 'w':
+			fmt.Fprintf(&formatted, "%d", t.Weekday())
+		case 'n':
+			formatted.WriteByte('\n')
+		case 't':
+			formatted.WriteByte('\t')
+		case '%':
+			formatted.WriteByte('%')
+		default:
+			formatted.WriteString(format[i-1 : i+1])
+		}
+	}
+	return formatted.String()
+}
+
+// formatTimes expands the escapes of TIMEFORMAT: %R, %U and %S for the real,
+// user and system times, each optionally preceded by the number of decimals
+// and by l for the minutes and seconds form, and %P for the CPU percentage.
+func formatTimes(format string, real, user, sys time.Duration) string {
+	report := strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 >= len(format) {
+			report.WriteByte(format[i])
+			continue
+		}
+		start := i
+		i++
+		precision := 3
+		if format[i] >= '0' && format[i] <= '9' {
+			precision = min(int(format[i]-'0'), 3)
+			i++
+		}
+		long := i < len(format) && format[i] == 'l'
+		if long {
+			i++
+		}
+		if i >= len(format) {
+			report.WriteString(format[start:])
+			break
+		}
+		var value time.Duration
+		switch format[i] {
+		case '%':
+			report.WriteByte('%')
+			continue
+		case 'P':
+			percent := 0.0
+			if real > 0 {
+				percent = float64(user+sys) / float64(real) * 100
+			}
+			fmt.Fprintf(&report, "%.2f", percent)
+			continue
+		case 'R':
+			value = real
+		case 'U':
+			value = user
+		case 'S':
+			value = sys
+		default:
+			report.WriteString(format[start : i+1])
+			continue
+		}
+		seconds := value.Seconds()
+		if long {
+			minutes := int(seconds / 60)
+			fmt.Fprintf(&report, "%dm%.*fs", minutes, precision, seconds-float64(minutes*60))
+		} else {
+			fmt.Fprintf(&report, "%.*f", precision, seconds)
+		}
+	}
+	return report.String()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestFormatTimes(t *testing.T) {
+	real, user, sys := 75250*time.Millisecond, 1500*time.Millisecond, 250*time.Millisecond
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{defaultTimeFormat, "\nreal\t1m15.250s\nuser\t0m1.500s\nsys\t0m0.250s"},
+		{posixTimeFormat, "real 75.25\nuser 1.50\nsys 0.25"},
+		{"%R", "75.250"},
+		{"%0R|%1U|%9S", "75|1.5|0.250"},
+		{"%lU", "0m1.500s"},
+		{"%P", "2.33"},
+		{"100%%", "100%"},
+		{"%x %3", "%x %3"},
+		{"trailing %", "trailing %"},
+	}
+	for _, test := range tests {
+		if got := formatTimes(test.format, real, user, sys); got != test.want {
+			t.Errorf("formatTimes(%q) = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestTimeKeyword(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"TIMEFORMAT='took'; { time echo in; } 2>&1", "in\ntook\n"},
+		{"TIMEFORMAT='took'; { time true | false; } 2>&1; echo $?", "took\n1\n"},
+		{"TIMEFORMAT='took'; { time ! false; } 2>&1; echo $?", "took\n0\n"},
+		{"TIMEFORMAT=''; { time true; } 2>&1", ""},
+		{"{ time -p true; } 2>&1 | cut -d' ' -f1", "real\nuser\nsys\n"},
+		{"TIMEFORMAT='%0R'; { time sleep 0.2; } 2>&1", "0\n"},
+	})
+}
+
+func TestTimes(t *testing.T) {
+	tests := []struct {
+		line string
+		want *regexp.Regexp
+	}{
+		{"times", regexp.MustCompile(`^(\d+m\d+\.\d{3}s \d+m\d+\.\d{3}s\n){2}$`)},
+		{"times x; echo $?", regexp.MustCompile(`^1\n$`)},
+	}
+	for _, test := range tests {
+		if got, _ := runShell(t, NewShellCtx(), test.line); !test.want.MatchString(got) {
+			t.Errorf("%s: got %q, want a match of %s", test.line, got, test.want)
+		}
+	}
+}
+
+func TestStrftime(t *testing.T) {
+	at := time.Date(2024, time.March, 5, 14, 7, 9, 0, time.UTC)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%F %T", "2024-03-05 14:07:09"},
+		{"%d/%m/%y %H:%M", "05/03/24 14:07"},
+		{"%a %A %b %B", "Tue Tuesday Mar March"},
+		{"%e|%k|%l|%I %p", " 5|14| 2|02 PM"},
+		{"%j %u %w", "065 2 2"},
+		{"%s", "1709647629"},
+		{"%D %R", "03/05/24 14:07"},
+		{"a%nb%tc 100%%", "a\nb\tc 100%"},
+		{"%q %", "%q %"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := strftime(test.format, at); got != test.want {
+			t.Errorf("strftime(%q) = %q, want %q", test.format, got, test.want)
+		}
+	}
+}
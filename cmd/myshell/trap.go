@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var signalNumbers = map[string]syscall.Signal{
+	"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT, "KILL": syscall.SIGKILL,
+	"ILL": syscall.SIGILL, "TRAP": syscall.SIGTRAP, "ABRT": syscall.SIGABRT,
+	"BUS": syscall.SIGBUS, "FPE": syscall.SIGFPE, "USR1": syscall.SIGUSR1,
+	"SEGV": syscall.SIGSEGV, "USR2": syscall.SIGUSR2, "PIPE": syscall.SIGPIPE,
+	"ALRM": syscall.SIGALRM, "TERM": syscall.SIGTERM, "CHLD": syscall.SIGCHLD,
+	"CONT": syscall.SIGCONT, "STOP": syscall.SIGSTOP, "TSTP": syscall.SIGTSTP, "TTIN": syscall.SIGTTIN,
+	"TTOU": syscall.SIGTTOU, "URG": syscall.SIGURG, "XCPU": syscall.SIGXCPU,
+	"XFSZ": syscall.SIGXFSZ, "VTALRM": syscall.SIGVTALRM, "PROF": syscall.SIGPROF,
+	"WINCH": syscall.SIGWINCH, "IO": syscall.SIGIO, "SYS": syscall.SIGSYS,
+}
+
+// pseudoSignals are trap conditions that aren't signals: EXIT runs when the
+// shell exits, ERR after a command fails and DEBUG before every simple
+// command.
+var pseudoSignals = map[string]bool{"EXIT": true, "ERR": true, "DEBUG": true}
+
+// traceOptions are the set options under which the ERR and DEBUG traps
+// also apply inside functions and subshells.
+var traceOptions = map[string]string{"ERR": "errtrace", "DEBUG": "functrace"}
+
+// fatalSignals end the shell when they aren't trapped. The shell watches
+// them to restore the terminal first.
+var fatalSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// interactiveSignals are the ones an interactive shell ignores when they
+// aren't trapped, so that neither the keys sending them nor a read from
+// the terminal in the background stop or end it. They are caught rather
+// than ignored, as the commands the shell starts would inherit that.
+var interactiveSignals = []os.Signal{syscall.SIGQUIT, syscall.SIGTSTP, syscall.SIGTTIN}
+
+// ParseTrapCondition turns a signal name, with or without SIG and in any
+// case, a signal number or one of EXIT, ERR and DEBUG into the name traps
+// are kept under.
+func ParseTrapCondition(spec string) (string, bool) {
+	if number, err := strconv.Atoi(spec); err == nil {
+		if number == 0 {
+			return "EXIT", true
+		}
+		return signalName(syscall.Signal(number))
+	}
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	if _, found := signalNumbers[name]; found || pseudoSignals[name] {
+		return name, true
+	}
+	return "", false
+}
+
+func signalName(sig syscall.Signal) (string, bool) {
+	for name, number := range signalNumbers {
+		if number == sig {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func displayCondition(name string) string {
+	if pseudoSignals[name] {
+		return name
+	}
+	return "SIG" + name
+}
+
+// TrapTable holds the commands set with trap. Signal handlers only run
+// between commands: the signal is queued by the goroutine receiving it and
+// the shell picks it up at the next safe point.
+type TrapTable struct {
+	mu       sync.Mutex
+	handlers map[string]string
+	signals  chan os.Signal
+	pending  chan string
+	// queued is signalled whenever a trapped signal has been queued.
+	queued chan struct{}
+	// shell is, in a subshell's table, the one of the shell process that
+	// receives the signals.
+	shell *TrapTable
+	// ignored are the interactiveSignals once the shell is interactive,
+	// and interrupt is where SIGINT then goes.
+	ignored   map[os.Signal]bool
+	interrupt *atomic.Int32
+}
+
+// interactive reports whether the interactive shell ignores sig, and where
+// it puts SIGINT.
+func (traps *TrapTable) interactive(sig os.Signal) (bool, *atomic.Int32) {
+	traps.mu.Lock()
+	defer traps.mu.Unlock()
+	return traps.ignored[sig], traps.interrupt
+}
+
+func NewTrapTable() *TrapTable {
+	return &TrapTable{
+		handlers: map[string]string{},
+		signals:  make(chan os.Signal, 8),
+		pending:  make(chan string, 16),
+		queued:   make(chan struct{}, 1),
+	}
+}
+
+func (traps *TrapTable) Handler(name string) (string, bool) {
+	traps.mu.Lock()
+	defer traps.mu.Unlock()
+	handler, found := traps.handlers[name]
+	return handler, found
+}
+
+// Set installs a handler; an empty one ignores the condition.
+func (traps *TrapTable) Set(name, handler string) {
+	traps.mu.Lock()
+	traps.handlers[name] = handler
+	traps.mu.Unlock()
+	if sig, found := signalNumbers[name]; found && traps.signals != nil {
+		if handler == "" {
+			signal.Ignore(sig)
+		} else {
+			signal.Notify(traps.signals, sig)
+		}
+	}
+}
+
+// Reset gives a condition its default behavior back.
+func (traps *TrapTable) Reset(name string) {
+	traps.mu.Lock()
+	delete(traps.handlers, name)
+	traps.mu.Unlock()
+	sig, found := signalNumbers[name]
+	if !found || traps.signals == nil {
+		return
+	}
+	if ignored, _ := traps.interactive(sig); isFatalSignal(sig) || ignored {
+		// Keep receiving it, in case it was ignored, so that the terminal
+		// is still restored before the shell dies, or so that it goes on
+		// being ignored.
+		signal.Notify(traps.signals, sig)
+	} else {
+		signal.Reset(sig)
+	}
+}
+
+// forSubshell is the table a copy of the shell starts with. Signal traps
+// are reset there, except for ignored signals, and the ERR and DEBUG traps
+// are only inherited with errtrace and functrace. A subshell doesn't
+// receive signals of its own, so its table has no channels and leaves the
+// signal dispositions of the process alone.
+func (traps *TrapTable) forSubshell(options map[string]bool) *TrapTable {
+	traps.mu.Lock()
+	defer traps.mu.Unlock()
+	sub := &TrapTable{handlers: map[string]string{}, shell: traps}
+	if traps.shell != nil {
+		sub.shell = traps.shell
+	}
+	for name, handler := range traps.handlers {
+		switch {
+		case traceOptions[name] != "" && options[traceOptions[name]]:
+			sub.handlers[name] = handler
+		case !pseudoSignals[name] && handler == "":
+			sub.handlers[name] = handler
+		}
+	}
+	return sub
+}
+
+func (traps *TrapTable) names() []string {
+	traps.mu.Lock()
+	defer traps.mu.Unlock()
+	names := make([]string, 0, len(traps.handlers))
+	for name := range traps.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isFatalSignal(sig os.Signal) bool {
+	for _, fatal := range fatalSignals {
+		if fatal == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// startIgnoringHangups calls start with SIGHUP ignored by the process, so
+// that the commands it starts inherit that, then gives the shell its own
+// handling back.
+func (traps *TrapTable) startIgnoringHangups(start func()) {
+	shell := traps
+	if traps.shell != nil {
+		shell = traps.shell
+	}
+	if shell.signals == nil || signal.Ignored(syscall.SIGHUP) {
+		start()
+		return
+	}
+	signal.Ignore(syscall.SIGHUP)
+	defer signal.Notify(shell.signals, syscall.SIGHUP)
+	start()
+}
+
+// HandleSignals starts receiving signals for the shell. A trapped signal is
+// queued for its handler. A fatal one that isn't trapped restores the
+// terminal first, so that the shell doesn't leave it in raw mode, and is
+// then re-raised with the default disposition so the exit status still
+// reflects it. On SIGHUP an interactive shell passes the hangup on to its
+// jobs, runs the EXIT trap and saves the history before going.
+func (ctx *ShellCtx) HandleSignals() {
+	traps := ctx.Traps
+	signal.Notify(traps.signals, fatalSignals...)
+	go func() {
+		for sig := range traps.signals {
+			name, _ := signalName(sig.(syscall.Signal))
+			if handler, found := traps.Handler(name); found {
+				if handler != "" {
+					select {
+					case traps.pending <- name:
+					default:
+					}
+					select {
+					case traps.queued <- struct{}{}:
+					default:
+					}
+				}
+				continue
+			}
+			ignored, interrupt := traps.interactive(sig)
+			if ignored {
+				continue
+			}
+			if sig == syscall.SIGINT && interrupt != nil {
+				interrupt.Store(int32(syscall.SIGINT))
+				continue
+			}
+			if isFatalSignal(sig) {
+				if sig == syscall.SIGHUP && ctx.Interactive {
+					ctx.HangupJobs()
+					ctx.RunExitTrap()
+					ctx.SaveHistory()
+				}
+				ctx.Terminal.Restore()
+				signal.Reset(sig)
+				syscall.Kill(syscall.Getpid(), sig.(syscall.Signal))
+			}
+		}
+	}()
+}
+
+// SignalShell sends sig to the shell's own process. The signal arrives
+// asynchronously, so when it is trapped this waits until it is queued, and
+// its handler runs right after the command that sent it.
+func (traps *TrapTable) SignalShell(sig syscall.Signal) error {
+	if traps.shell != nil {
+		traps = traps.shell
+	}
+	name, _ := signalName(sig)
+	if handler, found := traps.Handler(name); !found || handler == "" || traps.queued == nil {
+		return syscall.Kill(syscall.Getpid(), sig)
+	}
+	select {
+	case <-traps.queued:
+	default:
+	}
+	if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+		return err
+	}
+	select {
+	case <-traps.queued:
+	case <-time.After(time.Second):
+	}
+	return nil
+}
+
+// HandleInteractiveSignals makes the interactive shell ignore the
+// interactiveSignals that aren't trapped, and SIGINT interrupt the
+// commands it runs itself instead of ending it. The commands it starts are
+// in their own process group, which the terminal sends the signals to.
+func (ctx *ShellCtx) HandleInteractiveSignals() {
+	traps := ctx.Traps
+	ctx.interrupt = &atomic.Int32{}
+	traps.mu.Lock()
+	traps.ignored = map[os.Signal]bool{}
+	for _, sig := range interactiveSignals {
+		traps.ignored[sig] = true
+	}
+	traps.interrupt = ctx.interrupt
+	traps.mu.Unlock()
+	signal.Notify(traps.signals, interactiveSignals...)
+}
+
+// RunPendingTraps runs the handlers of the signals received since the last
+// call.
+func (ctx *ShellCtx) RunPendingTraps() {
+	if ctx.Traps.pending == nil || ctx.inTrap {
+		return
+	}
+	for {
+		select {
+		case name := <-ctx.Traps.pending:
+			if handler, found := ctx.Traps.Handler(name); found && handler != "" {
+				ctx.runTrap(handler)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// runTrap runs a handler without letting it change $?.
+func (ctx *ShellCtx) runTrap(handler string) {
+	lastPipeline, lastStatus := ctx.LastPipeline, ctx.LastStatus
+	ctx.inTrap = true
+	defer func() { ctx.inTrap = false }()
+	ctx.RunLine(handler)
+	if lastPipeline != nil {
+		ctx.SetPipelineResult(lastPipeline)
+	}
+	ctx.LastStatus = lastStatus
+}
+
+// RunExitTrap runs the EXIT trap, once, when the shell or a subshell ends.
+func (ctx *ShellCtx) RunExitTrap() {
+	handler, found := ctx.Traps.Handler("EXIT")
+	if !found {
+		return
+	}
+	ctx.Traps.Reset("EXIT")
+	if handler != "" {
+		lastStatus := ctx.LastStatus
+		ctx.exiting = false
+		ctx.runTrap(handler)
+		ctx.LastStatus = lastStatus
+	}
+}
+
+// runConditionTrap runs the ERR or DEBUG trap. Inside functions they only
+// apply with errtrace and functrace respectively, and never inside another
+// trap handler.
+func (ctx *ShellCtx) runConditionTrap(name string) {
+	if ctx.inTrap {
+		return
+	}
+	if ctx.functionDepth > 0 && !ctx.Options[traceOptions[name]] {
+		return
+	}
+	if handler, found := ctx.Traps.Handler(name); found && handler != "" {
+		ctx.runTrap(handler)
+	}
+}
+
+// TrapExecutor implements `trap [-lp] [[handler] condition ...]`. A handler
+// of - resets the conditions and an empty one ignores them; with a single
+// argument that names a condition, it is reset as well.
+func TrapExecutor(shellCtx *ShellCtx, args []string) error {
+	print := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		switch option {
+		case "-l":
+			shellCtx.Sout = listSignals()
+			return nil
+		case "-p":
+			print = true
+		default:
+			return fmt.Errorf("trap command got invalid option %s", option)
+		}
+	}
+	if print || len(args) == 0 {
+		names := shellCtx.Traps.names()
+		if len(args) > 0 {
+			names = names[:0]
+			for _, spec := range args {
+				if name, ok := ParseTrapCondition(spec); ok {
+					names = append(names, name)
+				}
+			}
+		}
+		for _, name := range names {
+			if handler, found := shellCtx.Traps.Handler(name); found {
+				shellCtx.Sout += fmt.Sprintf("trap -- %s %s\n", SingleQuote(handler), displayCondition(name))
+			}
+		}
+		return nil
+	}
+
+	handler, specs := args[0], args[1:]
+	reset := handler == "-"
+	if _, isCondition := ParseTrapCondition(handler); len(specs) == 0 && isCondition {
+		reset, specs = true, args
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("trap command takes a handler and at least one condition")
+	}
+	for _, spec := range specs {
+		name, ok := ParseTrapCondition(spec)
+		if !ok {
+			shellCtx.Serr += fmt.Sprintf("trap: %s: invalid signal specification\n", spec)
+			shellCtx.Status = 1
+			continue
+		}
+		if reset {
+			shellCtx.Traps.Reset(name)
+		} else {
+			shellCtx.Traps.Set(name, handler)
+		}
+	}
+	return nil
+}
+
+func listSignals() string {
+	numbers := []int{}
+	for _, sig := range signalNumbers {
+		numbers = append(numbers, int(sig))
+	}
+	sort.Ints(numbers)
+	listing := strings.Builder{}
+	for _, number := range numbers {
+		name, _ := signalName(syscall.Signal(number))
+		fmt.Fprintf(&listing, "%2d) SIG%s\n", number, name)
+	}
+	return listing.String()
+}
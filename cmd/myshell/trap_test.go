@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInteractiveSignals(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	shellCtx.HandleSignals()
+	shellCtx.HandleInteractiveSignals()
+	t.Cleanup(func() {
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTSTP, syscall.SIGTTIN)
+	})
+
+	for _, sig := range []syscall.Signal{syscall.SIGQUIT, syscall.SIGTSTP, syscall.SIGTTIN} {
+		syscall.Kill(syscall.Getpid(), sig)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if shellCtx.interrupted() {
+		t.Fatalf("an ignored signal interrupted the shell")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		shellCtx.RunLine("while :; do :; done")
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("SIGINT didn't interrupt the loop")
+	}
+	if !shellCtx.interrupted() {
+		t.Errorf("the interruption isn't reported")
+	}
+}
+
+func TestErrtraceAndFunctrace(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"trap 'echo err' ERR; f() { false; }; f", "err\n"},
+		{"set -E; trap 'echo err' ERR; f() { false; }; f", "err\nerr\n"},
+		{"set -o errtrace; trap 'echo err' ERR; (false)", "err\nerr\n"},
+		{"trap 'echo err' ERR; (false)", "err\n"},
+		{"set -E; trap 'echo err' ERR; echo $(false; echo out)", "err out\n"},
+		{"trap 'echo err' ERR; echo $(false; echo out)", "out\n"},
+		{"set -E; set +E; trap 'echo err' ERR; f() { false; }; f", "err\n"},
+		{"trap 'echo dbg' DEBUG; f() { echo in; }; f", "dbg\nin\n"},
+		{"set -T; trap 'echo dbg' DEBUG; f() { echo in; }; f", "dbg\ndbg\nin\n"},
+		{"set -o functrace; trap 'echo dbg' DEBUG; (echo sub)", "dbg\nsub\n"},
+		{"set -T; trap 'echo dbg' DEBUG; echo $(echo sub)", "dbg\ndbg sub\n"},
+	})
+}
+
+func TestTrapBuiltin(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"trap 'echo bye' EXIT; trap -p EXIT", "trap -- 'echo bye' EXIT\n"},
+		{"trap 'echo bye' EXIT; trap - EXIT; trap -p EXIT", ""},
+		{"trap 'echo int' INT; trap -p SIGINT", "trap -- 'echo int' SIGINT\n"},
+		{"trap 'echo x' 2; trap -p INT", "trap -- 'echo x' SIGINT\n"},
+		{"(trap 'echo sub' EXIT; echo in); echo out", "in\nsub\nout\n"},
+		{"(trap 'echo $?' EXIT; (exit 3))", "3\n"},
+		{"trap 'echo err' ERR; false; true", "err\n"},
+		{"trap 'echo dbg' DEBUG; echo a; trap - DEBUG; echo b", "dbg\na\ndbg\nb\n"},
+		{"trap 'echo x' NOPE; echo $?", "1\n"},
+		{"trap -l | head -2", " 1) SIGHUP\n 2) SIGINT\n"},
+		{"trap 'false' ERR; false; echo $?", "1\n"},
+	})
+	got, status := runMain(t, "-c", "trap 'echo bye $?' EXIT; echo hi; exit 4")
+	if got != "hi\nbye 4\n" || status != 4 {
+		t.Errorf("EXIT trap: got %q, status %d", got, status)
+	}
+}
+
+func TestTrappedSignals(t *testing.T) {
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	shellCtx.HandleSignals()
+	t.Cleanup(func() {
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	})
+	tests := []struct{ line, want string }{
+		{"trap 'echo usr1' USR1; kill -USR1 $$; echo after", "usr1\nafter\n"},
+		{"trap 'echo last' USR2; kill -USR2 $$", "last\n"},
+		{"trap 'echo $?' USR1; false; kill -USR1 $$; echo $?", "0\n0\n"},
+		{"trap '' USR1; kill -USR1 $$; echo ignored", "ignored\n"},
+		{"trap 'echo term' TERM; kill $$; echo alive", "term\nalive\n"},
+		{"trap 'echo sub' USR2; (kill -USR2 $$); echo after", "sub\nafter\n"},
+	}
+	for _, test := range tests {
+		if got, _ := runShell(t, shellCtx, test.line); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+// Linux resource numbers that the syscall package doesn't name.
+const (
+	rlimitNproc   = 6
+	rlimitMemlock = 8
+)
+
+const rlimInfinity = ^uint64(0)
+
+type resourceLimit struct {
+	flag     byte
+	resource int
+	name     string
+	unit     string
+	// scale is how many bytes, or whatever the kernel counts, one unit is.
+	scale uint64
+}
+
+// resourceLimits are in the order ulimit -a lists them.
+var resourceLimits = []resourceLimit{
+	{'c', syscall.RLIMIT_CORE, "core file size", "blocks", 512},
+	{'d', syscall.RLIMIT_DATA, "data seg size", "kbytes", 1024},
+	{'f', syscall.RLIMIT_FSIZE, "file size", "blocks", 512},
+	{'l', rlimitMemlock, "max locked memory", "kbytes", 1024},
+	{'n', syscall.RLIMIT_NOFILE, "open files", "", 1},
+	{'s', syscall.RLIMIT_STACK, "stack size", "kbytes", 1024},
+	{'t', syscall.RLIMIT_CPU, "cpu time", "seconds", 1},
+	{'u', rlimitNproc, "max user processes", "", 1},
+	{'v', syscall.RLIMIT_AS, "virtual memory", "kbytes", 1024},
+}
+
+func findResourceLimit(flag byte) (resourceLimit, bool) {
+	for _, limit := range resourceLimits {
+		if limit.flag == flag {
+			return limit, true
+		}
+	}
+	return resourceLimit{}, false
+}
+
+func formatLimit(value, scale uint64) string {
+	if value == rlimInfinity {
+		return "unlimited"
+	}
+	return strconv.FormatUint(value/scale, 10)
+}
+
+// UlimitExecutor implements `ulimit [-SHa] [-cdflnstuv] [limit]`. The limits
+// belong to the shell process, and every command started after they change
+// inherits them, though a subshell gives them back to its shell when it is
+// done. Without -S or -H a new limit sets both the soft and the
+// hard one, and the soft one is displayed. The limit may also be unlimited,
+// or soft or hard for the current value of either; -f is the default.
+func UlimitExecutor(shellCtx *ShellCtx, args []string) error {
+	soft, hard, all := false, false, false
+	selected := []resourceLimit{}
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for i := 1; i < len(option); i++ {
+			switch flag := option[i]; flag {
+			case 'S':
+				soft = true
+			case 'H':
+				hard = true
+			case 'a':
+				all = true
+			default:
+				limit, found := findResourceLimit(flag)
+				if !found {
+					return fmt.Errorf("ulimit command got invalid option -%c", flag)
+				}
+				selected = append(selected, limit)
+			}
+		}
+	}
+	if all {
+		selected = resourceLimits
+	}
+	if len(selected) == 0 {
+		selected = []resourceLimit{resourceLimits[2]}
+	}
+	if len(args) > 1 || (len(args) == 1 && (all || len(selected) > 1)) {
+		return fmt.Errorf("ulimit command takes one limit for a single resource")
+	}
+
+	if len(args) == 0 {
+		for _, limit := range selected {
+			current, err := shellCtx.getLimit(limit.resource)
+			if err != nil {
+				shellCtx.Serr += fmt.Sprintf("ulimit: %s: cannot get limit: %s\n", limit.name, err.Error())
+				shellCtx.Status = 1
+				continue
+			}
+			value := current.Cur
+			if hard && !soft {
+				value = current.Max
+			}
+			if len(selected) == 1 {
+				shellCtx.Sout += formatLimit(value, limit.scale) + "\n"
+				continue
+			}
+			unit := fmt.Sprintf("(-%c)", limit.flag)
+			if limit.unit != "" {
+				unit = fmt.Sprintf("(%s, -%c)", limit.unit, limit.flag)
+			}
+			shellCtx.Sout += fmt.Sprintf("%-28s%13s %s\n", limit.name, unit, formatLimit(value, limit.scale))
+		}
+		return nil
+	}
+
+	limit := selected[0]
+	current, err := shellCtx.getLimit(limit.resource)
+	if err != nil {
+		shellCtx.Serr = fmt.Sprintf("ulimit: %s: cannot get limit: %s\n", limit.name, err.Error())
+		shellCtx.Status = 1
+		return nil
+	}
+	var value uint64
+	switch args[0] {
+	case "unlimited":
+		value = rlimInfinity
+	case "soft":
+		value = current.Cur
+	case "hard":
+		value = current.Max
+	default:
+		number, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("ulimit: %s: invalid number\n", args[0])
+			shellCtx.Status = 1
+			return nil
+		}
+		value = number * limit.scale
+	}
+	if !soft && !hard {
+		soft, hard = true, true
+	}
+	if soft {
+		current.Cur = value
+	}
+	if hard {
+		current.Max = value
+	}
+	if err := shellCtx.setLimit(limit.resource, current); err != nil {
+		shellCtx.Serr = fmt.Sprintf("ulimit: %s: cannot modify limit: %s\n", limit.name, describeOpenError(err))
+		shellCtx.Status = 1
+	}
+	return nil
+}
+
+// getLimit reads the limits of resource, with the hard one a subshell
+// lowered.
+func (ctx *ShellCtx) getLimit(resource int) (syscall.Rlimit, error) {
+	var current syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &current); err != nil {
+		return current, err
+	}
+	if hard, found := ctx.hardLimits[resource]; found {
+		current.Max = hard
+	}
+	return current, nil
+}
+
+// setLimit changes the limits of resource. A subshell only lowers the soft
+// limit of the process, keeping to itself a lower hard one, which the shell
+// couldn't raise back once the subshell is done.
+func (ctx *ShellCtx) setLimit(resource int, limits syscall.Rlimit) error {
+	if !ctx.subshell {
+		return syscall.Setrlimit(resource, &limits)
+	}
+	var process syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &process); err != nil {
+		return err
+	}
+	if hard, found := ctx.hardLimits[resource]; found && limits.Max > hard {
+		return syscall.EPERM
+	}
+	if limits.Cur > limits.Max {
+		return syscall.EINVAL
+	}
+	if limits.Max >= process.Max {
+		delete(ctx.hardLimits, resource)
+		return syscall.Setrlimit(resource, &limits)
+	}
+	if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: limits.Cur, Max: process.Max}); err != nil {
+		return err
+	}
+	if ctx.hardLimits == nil {
+		ctx.hardLimits = map[int]uint64{}
+	}
+	ctx.hardLimits[resource] = limits.Max
+	return nil
+}
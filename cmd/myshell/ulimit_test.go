@@ -0,0 +1,47 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestFormatLimit(t *testing.T) {
+	tests := []struct {
+		value, scale uint64
+		want         string
+	}{
+		{rlimInfinity, 512, "unlimited"},
+		{0, 512, "0"},
+		{8388608, 1024, "8192"},
+		{1024, 1, "1024"},
+	}
+	for _, test := range tests {
+		if got := formatLimit(test.value, test.scale); got != test.want {
+			t.Errorf("formatLimit(%d, %d) = %q, want %q", test.value, test.scale, got, test.want)
+		}
+	}
+}
+
+func TestUlimit(t *testing.T) {
+	var saved syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Cur < 100 {
+		t.Skip("open file limit too low:", saved.Cur)
+	}
+	t.Cleanup(func() { syscall.Setrlimit(syscall.RLIMIT_NOFILE, &saved) })
+	// Each script sets limits in a subshell, which restores them. Only root
+	// could raise a hard limit back, so those are left alone.
+	checkScripts(t, []scriptTest{
+		{`[ "$(ulimit -n)" = "$(ulimit -Sn)" ] && echo soft`, "soft\n"},
+		{`( ulimit -Sn 90; ulimit -n; sh -c 'ulimit -n' )`, "90\n90\n"},
+		{"( ulimit -Sn 80; ulimit -Sn 70; ulimit -Sn )", "70\n"},
+		{"( ulimit -Sn 50; ulimit -Sn hard; [ $(ulimit -Sn) = $(ulimit -Hn) ] && echo raised )", "raised\n"},
+		{"( ulimit -Sc 0; ulimit -c; ulimit -Sf unlimited; ulimit -f )", "0\nunlimited\n"},
+		{"( ulimit -Sc 0; ulimit -Sn 64; ulimit -n -c )", "open files                           (-n) 64\ncore file size               (blocks, -c) 0\n"},
+		{"( ulimit -Sc 0; ulimit -a | grep core )", "core file size               (blocks, -c) 0\n"},
+		{"ulimit -n abc; echo $?", "1\n"},
+		{"ulimit -q; echo $?", "1\n"},
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// UmaskExecutor implements `umask [-pS] [mode]`. The mask belongs to the
+// process, so it applies to the files redirections create and is inherited
+// by the commands the shell starts; a subshell gives it back to its shell
+// when it is done. A mode is either octal or symbolic, as
+// in chmod: u=rwx,g=rx,o= or g-w.
+func UmaskExecutor(shellCtx *ShellCtx, args []string) error {
+	symbolic, reusable := false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 'S':
+				symbolic = true
+			case 'p':
+				reusable = true
+			default:
+				return fmt.Errorf("umask command got invalid option -%c", flag)
+			}
+		}
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("umask command takes at most 1 mode")
+	}
+
+	mask := currentUmask()
+	if len(args) == 0 {
+		output := fmt.Sprintf("%04o", mask)
+		if symbolic {
+			output = formatSymbolicMode(^mask & 0777)
+		}
+		switch {
+		case reusable && symbolic:
+			output = "umask -S " + output
+		case reusable:
+			output = "umask " + output
+		}
+		shellCtx.Sout = output + "\n"
+		return nil
+	}
+
+	mode := args[0]
+	if mode[0] >= '0' && mode[0] <= '9' {
+		value, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || value > 0777 {
+			shellCtx.Serr = fmt.Sprintf("umask: %s: octal number out of range\n", mode)
+			shellCtx.Status = 1
+			return nil
+		}
+		mask = int(value)
+	} else {
+		allowed, err := applySymbolicMode(^mask&0777, mode)
+		if err != nil {
+			shellCtx.Serr = fmt.Sprintf("umask: %s\n", err.Error())
+			shellCtx.Status = 1
+			return nil
+		}
+		mask = ^allowed & 0777
+	}
+	syscall.Umask(mask)
+	if symbolic {
+		shellCtx.Sout = formatSymbolicMode(^mask&0777) + "\n"
+	}
+	return nil
+}
+
+// currentUmask reads the mask, which can only be done by setting it.
+func currentUmask() int {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return mask
+}
+
+// permissionShifts is where the bits of each class of users are.
+var permissionShifts = map[byte]uint{'u': 6, 'g': 3, 'o': 0}
+
+func formatSymbolicMode(mode int) string {
+	classes := []string{}
+	for _, who := range "ugo" {
+		bits := mode >> permissionShifts[byte(who)] & 7
+		perms := ""
+		for i, letter := range "rwx" {
+			if bits&(4>>i) != 0 {
+				perms += string(letter)
+			}
+		}
+		classes = append(classes, string(who)+"="+perms)
+	}
+	return strings.Join(classes, ",")
+}
+
+// applySymbolicMode applies clauses like u+w,go-rx to the permissions mode
+// grants.
+func applySymbolicMode(mode int, spec string) (int, error) {
+	for _, clause := range strings.Split(spec, ",") {
+		i := 0
+		who := ""
+		for i < len(clause) && strings.IndexByte("ugoa", clause[i]) != -1 {
+			who += string(clause[i])
+			i++
+		}
+		if who == "" || strings.Contains(who, "a") {
+			who = "ugo"
+		}
+		if i >= len(clause) {
+			return 0, fmt.Errorf("%s: invalid symbolic mode", spec)
+		}
+		if strings.IndexByte("+-=", clause[i]) == -1 {
+			return 0, fmt.Errorf("`%c': invalid symbolic mode operator", clause[i])
+		}
+		op := clause[i]
+		bits := 0
+		for _, c := range clause[i+1:] {
+			switch c {
+			case 'r':
+				bits |= 4
+			case 'w':
+				bits |= 2
+			case 'x':
+				bits |= 1
+			default:
+				return 0, fmt.Errorf("`%c': invalid symbolic mode character", c)
+			}
+		}
+		for j := 0; j < len(who); j++ {
+			shift := permissionShifts[who[j]]
+			switch op {
+			case '+':
+				mode |= bits << shift
+			case '-':
+				mode &^= bits << shift
+			case '=':
+				mode = mode&^(7<<shift) | bits<<shift
+			}
+		}
+	}
+	return mode, nil
+}
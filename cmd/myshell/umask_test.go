@@ -0,0 +1,46 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestApplySymbolicMode(t *testing.T) {
+	tests := []struct {
+		mode int
+		spec string
+		want int
+		ok   bool
+	}{
+		{0755, "u=rwx,g=rx,o=", 0750, true},
+		{0750, "g-x", 0740, true},
+		{0740, "o+w", 0742, true},
+		{0755, "a=r", 0444, true},
+		{0755, "=rw", 0666, true},
+		{0700, "go+rx", 0755, true},
+		{0644, "u+", 0644, true},
+		{0755, "u", 0, false},
+		{0755, "u*x", 0, false},
+		{0755, "u=z", 0, false},
+	}
+	for _, test := range tests {
+		got, err := applySymbolicMode(test.mode, test.spec)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("applySymbolicMode(%#o, %q) = %#o, %v, want %#o", test.mode, test.spec, got, err, test.want)
+		}
+	}
+}
+
+func TestUmask(t *testing.T) {
+	saved := syscall.Umask(022)
+	t.Cleanup(func() { syscall.Umask(saved) })
+	// Each script sets the mask in a subshell, which restores it.
+	checkScripts(t, []scriptTest{
+		{"umask; umask -S; umask -p; umask -pS", "0022\nu=rwx,g=rx,o=rx\numask 0022\numask -S u=rwx,g=rx,o=rx\n"},
+		{"( umask 077; umask; umask -S ); umask", "0077\nu=rwx,g=,o=\n0022\n"},
+		{"( umask u=rwx,g=rx,o=; umask; umask g-x; umask; umask o+w; umask )", "0027\n0037\n0035\n"},
+		{"( umask -S u=rw,go=r )", "u=rw,g=r,o=r\n"},
+		{"( umask 888; echo $?; umask u=z; echo $?; umask )", "1\n1\n0022\n"},
+		{"( umask 077; echo > f; ls -l f | cut -c1-10; sh -c umask )", "-rw-------\n0077\n"},
+	})
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type BuiltinFlag struct {
+	Name string
+	Help string
+}
+
+// BuiltinUsage describes how a builtin is invoked. Both the help text and the
+// completion of a builtin's options are generated from it, so a flag only has
+// to be documented once to show up in both.
+type BuiltinUsage struct {
+	Synopsis string
+	Summary  string
+	Flags    []BuiltinFlag
+}
+
+var builtinUsages = map[string]BuiltinUsage{
+	"exit": {Synopsis: "exit [n]", Summary: "Exit the shell with status n, or the last status."},
+	"history": {Synopsis: "history [-c] [-d offset] [n] or history -a|-r|-w [file]", Summary: "List the command history, the last n entries when n is given and with their times when HISTTIMEFORMAT is set, or manage the history file, $HISTFILE by default.",
+		Flags: []BuiltinFlag{
+			{"-c", "clear the history"},
+			{"-d", "delete the entry at offset, counting back from the end when negative, or the entries of a range like 3-5"},
+			{"-a", "append the entries of this session not yet saved to the file"},
+			{"-r", "read the file and add its entries to the history"},
+			{"-w", "write the whole history to the file"},
+		}},
+	"fc": {Synopsis: "fc [-e editor] [first [last]] or fc -l [-nr] [first [last]]", Summary: "Edit commands from the history in $FCEDIT, $VISUAL or $EDITOR and run the result, or list them.",
+		Flags: []BuiltinFlag{
+			{"-e", "edit with editor instead, or run the commands again unedited when it is -"},
+			{"-l", "list the commands, the last 16 by default, instead of editing them"},
+			{"-n", "list without the history numbers"},
+			{"-r", "reverse the order of the commands"},
+		}},
+	"complete": {Synopsis: "complete [-pr] [-cdf] [-A action] [-o option] [-W wordlist] [-G glob] [-F function] [-C command] [name ...]", Summary: "Set how Tab completes the arguments of the named commands, or print or remove those settings.",
+		Flags: []BuiltinFlag{
+			{"-p", "print the completion specs in a form that can be reused as input"},
+			{"-r", "remove the completion specs of the names, or all of them"},
+			{"-c", "complete command names, like -A command"},
+			{"-d", "complete directory names, like -A directory"},
+			{"-f", "complete file names, like -A file"},
+			{"-A", "complete the names of a kind: command, directory or file"},
+			{"-o", "default or dirnames to fall back to file or directory names when nothing matches, nospace to add no space after a completion"},
+			{"-W", "complete the words of wordlist, expanded when completing"},
+			{"-G", "complete the files matching glob"},
+			{"-F", "run function with the command name, the word and the previous word, and complete what it puts in COMPREPLY"},
+			{"-C", "run command the same way and complete the lines it prints"},
+		}},
+	"echo": {Synopsis: "echo [arg ...]", Summary: "Write arguments to standard output."},
+	"type": {Synopsis: "type [-afptP] name ...", Summary: "Display how each command name would be interpreted.",
+		Flags: []BuiltinFlag{
+			{"-a", "display every match: aliases, keywords, functions, builtins and files along PATH"},
+			{"-f", "ignore shell functions"},
+			{"-t", "print one of alias, keyword, function, builtin or file"},
+			{"-p", "print the path of the file that would be run, if any"},
+			{"-P", "search PATH for a file even if the name is also something else"},
+		}},
+	"pwd": {Synopsis: "pwd [-L | -P]", Summary: "Print the current working directory.",
+		Flags: []BuiltinFlag{
+			{"-L", "print the logical directory, which may contain symlinks"},
+			{"-P", "print the physical directory, with symlinks resolved"},
+		}},
+	"cd": {Synopsis: "cd [-L | -P] [dir]", Summary: "Change the current directory, searching CDPATH for relative names.",
+		Flags: []BuiltinFlag{
+			{"-L", "follow symlinks logically, .. drops the previous path component"},
+			{"-P", "resolve symlinks, using the physical directory structure"},
+			{"--", "end of options"},
+		}},
+	"env": {Synopsis: "env [-i] [-u name] [name=value ...] [command [arg ...]]",
+		Summary: "Run a command in a modified environment, or print the environment.",
+		Flags: []BuiltinFlag{
+			{"-i", "start with an empty environment"},
+			{"-u", "remove name from the environment"},
+			{"--", "end of options"},
+		}},
+	"source": {Synopsis: "source [-o] file [arg ...]", Summary: "Execute commands from a file in the current shell.",
+		Flags: []BuiltinFlag{{"-o", "skip the file if it has already been sourced"}}},
+	".": {Synopsis: ". [-o] file [arg ...]", Summary: "Execute commands from a file in the current shell.",
+		Flags: []BuiltinFlag{{"-o", "skip the file if it has already been sourced"}}},
+	"readonly": {Synopsis: "readonly [-p] [name[=value] ...]", Summary: "Mark variables as unchangeable.",
+		Flags: []BuiltinFlag{{"-p", "list all readonly variables"}}},
+	"unset": {Synopsis: "unset [-v | -f] [name ...]", Summary: "Remove shell variables or functions.",
+		Flags: []BuiltinFlag{
+			{"-v", "treat each name as a variable"},
+			{"-f", "treat each name as a function"},
+		}},
+	"eval":       {Synopsis: "eval [arg ...]", Summary: "Join the arguments into a command line and run it in the current shell."},
+	"exec":       {Synopsis: "exec [command [arg ...]]", Summary: "Replace the shell with command, or make the redirections of exec permanent."},
+	"envsave":    {Synopsis: "envsave [name]", Summary: "Save variables, aliases and options as a named snapshot, or list snapshots."},
+	"envrestore": {Synopsis: "envrestore name", Summary: "Restore variables, aliases and options from a snapshot."},
+	"alias":      {Synopsis: "alias [name[=value] ...]", Summary: "Define or display aliases."},
+	"unalias": {Synopsis: "unalias [-a] name [name ...]", Summary: "Remove alias definitions.",
+		Flags: []BuiltinFlag{{"-a", "remove all aliases"}}},
+	"run": {Synopsis: "run [--cwd dir] [--env name=value ...] [--] command [arg ...]",
+		Summary: "Run a command in another directory or with extra environment variables.",
+		Flags: []BuiltinFlag{
+			{"--cwd", "run the command in dir"},
+			{"--env", "add name=value to the command's environment"},
+			{"--", "end of options"},
+		}},
+	"jobs": {Synopsis: "jobs", Summary: "List background jobs."},
+	"kill": {Synopsis: "kill [-s sigspec | -n signum | -sigspec] pid | %job ... or kill -l [status]", Summary: "Send a signal, TERM by default, to processes or jobs, or list the signal names.",
+		Flags: []BuiltinFlag{
+			{"-s", "send the signal named by sigspec"},
+			{"-n", "send the signal numbered signum"},
+			{"-l", "list the signal names, or the names of the signals ending the given statuses"},
+		}},
+	"nohup": {Synopsis: "nohup command [arg ...]", Summary: "Run a command immune to hangups, appending its output to nohup.out when it would go to a terminal."},
+	"disown": {Synopsis: "disown [-ahr] [%job ...]", Summary: "Remove jobs, the current one by default, from the job table.",
+		Flags: []BuiltinFlag{
+			{"-a", "remove every job"},
+			{"-r", "remove only running jobs"},
+			{"-h", "keep the jobs but don't send them SIGHUP when the shell exits"},
+		}},
+	"wait": {Synopsis: "wait [pid | %job ...]", Summary: "Wait for the given background jobs, or all of them, to finish and return the status of the last one."},
+	"dirs": {Synopsis: "dirs [-c] [-l] [-p] [-v] [+N | -N]", Summary: "Display the directory stack.",
+		Flags: []BuiltinFlag{
+			{"-c", "clear the directory stack"},
+			{"-l", "do not abbreviate the home directory as ~"},
+			{"-p", "print one entry per line"},
+			{"-v", "print one entry per line, prefixed with its position"},
+		}},
+	"pushd": {Synopsis: "pushd [-n] [dir | +N | -N]", Summary: "Add a directory to the directory stack, or rotate the stack.",
+		Flags: []BuiltinFlag{{"-n", "do not change directory, only manipulate the stack"}}},
+	"shopt": {Synopsis: "shopt [-pqsuo] [optname ...]", Summary: "Set and unset shell options: autocd, cdspell, dotglob, fuzzycomplete, globstar, histappend, histshare, huponexit, nocasecomplete, nocaseglob and nullglob.",
+		Flags: []BuiltinFlag{
+			{"-s", "enable each optname"},
+			{"-u", "disable each optname"},
+			{"-p", "print options in a form that can be reused as input"},
+			{"-q", "suppress output, the status tells whether optname is set"},
+			{"-o", "restrict the optnames to those of set -o"},
+		}},
+	"hash": {Synopsis: "hash [-lrt] [-p path] [name ...] | hash -d [name=dir ...]", Summary: "Remember or display where commands were found along PATH, or define named directories usable as ~name.",
+		Flags: []BuiltinFlag{
+			{"-r", "forget every remembered location"},
+			{"-p", "remember path as the location of each name"},
+			{"-t", "print the remembered location of each name"},
+			{"-l", "list the table in a form that can be reused as input"},
+			{"-d", "operate on named directories"},
+		}},
+	"set": {Synopsis: "set [-bemuxEHT] [-o name] [--] [arg ...]", Summary: "Set shell options and positional parameters, or list the options.",
+		Flags: []BuiltinFlag{
+			{"-b", "report finished background jobs right away rather than before the next prompt (notify)"},
+			{"-e", "exit as soon as a command fails (errexit)"},
+			{"-m", "run foreground pipelines in process groups of their own, given the terminal, so Ctrl-C and Ctrl-Z reach them and not the shell (monitor)"},
+			{"-u", "treat expanding an unset variable as an error (nounset)"},
+			{"-x", "print each command and its expanded arguments before running it (xtrace)"},
+			{"-E", "ERR traps are inherited by functions, command substitutions and subshells (errtrace)"},
+			{"-H", "expand history references like !! and !$ in lines typed at the prompt (histexpand)"},
+			{"-T", "DEBUG and RETURN traps are inherited the same way (functrace)"},
+			{"-o", "set the option given by its long name; pipefail makes a pipeline fail when any of its commands does"},
+			{"--", "assign the remaining arguments to the positional parameters"},
+		}},
+	"shift":  {Synopsis: "shift [n]", Summary: "Drop the first n positional parameters, one by default."},
+	"break":  {Synopsis: "break [n]", Summary: "Exit from the n innermost enclosing loops, one by default."},
+	"return": {Synopsis: "return [n]", Summary: "Return from a function or sourced file with status n, or the last status."},
+	"local":  {Synopsis: "local [-aAix] [name[=value] ...]", Summary: "Create variables visible only in the current function and the functions it calls."},
+	"trap": {Synopsis: "trap [-lp] [[handler] condition ...]", Summary: "Run a command when the shell gets a signal, exits (EXIT), a command fails (ERR) or before each command (DEBUG).",
+		Flags: []BuiltinFlag{
+			{"-l", "list the signal names and numbers"},
+			{"-p", "print the traps in a form that can be reused as input"},
+		}},
+	"test": {Synopsis: "test [expression]", Summary: "Evaluate a conditional expression on files, strings and integers.",
+		Flags: []BuiltinFlag{
+			{"-e", "true if the file exists"},
+			{"-f", "true if the file is a regular file"},
+			{"-d", "true if the file is a directory"},
+			{"-x", "true if the file is executable or searchable"},
+			{"-n", "true if the string is not empty"},
+			{"-z", "true if the string is empty"},
+		}},
+	"[": {Synopsis: "[ expression ]", Summary: "Evaluate a conditional expression, like test."},
+	"declare": {Synopsis: "declare [-aAifFgprx] [name[=value] ...]", Summary: "Set variable values and attributes, or display them.",
+		Flags: []BuiltinFlag{
+			{"-a", "make the names indexed arrays"},
+			{"-A", "make the names associative arrays"},
+			{"-i", "evaluate values assigned to the names arithmetically"},
+			{"-r", "make the names readonly"},
+			{"-x", "export the names"},
+			{"-g", "create global variables even inside a function"},
+			{"-p", "display the attributes and value of each name"},
+			{"-f", "display function definitions"},
+			{"-F", "display function names only"},
+		}},
+	"typeset": {Synopsis: "typeset [-aAifFgprx] [name[=value] ...]", Summary: "Set variable values and attributes, like declare."},
+	"let":     {Synopsis: "let expression ...", Summary: "Evaluate arithmetic expressions; fail if the last one is zero."},
+	"getopts": {Synopsis: "getopts optstring name [arg ...]", Summary: "Parse the next option from the positional parameters, or the args, into name, OPTARG and OPTIND."},
+	"true":    {Synopsis: "true", Summary: "Return a successful result."},
+	"false":   {Synopsis: "false", Summary: "Return an unsuccessful result."},
+	":":       {Synopsis: ": [arg ...]", Summary: "Do nothing beyond expanding the arguments, and succeed."},
+	"printf": {Synopsis: "printf [-v var] format [arg ...]", Summary: "Format and print arguments under the control of format, reusing it for the remaining arguments.",
+		Flags: []BuiltinFlag{{"-v", "assign the output to the variable var instead of printing it"}}},
+	"read": {Synopsis: "read [-rs] [-p prompt] [-t timeout] [-n nchars] [name ...]", Summary: "Read a line from standard input and split it into the named variables, or REPLY.",
+		Flags: []BuiltinFlag{
+			{"-r", "do not treat backslashes as escape characters"},
+			{"-s", "do not echo input coming from a terminal"},
+			{"-p", "show prompt before reading, if the input is a terminal"},
+			{"-t", "give up after timeout seconds"},
+			{"-n", "return after reading nchars characters instead of a whole line"},
+		}},
+	"umask": {Synopsis: "umask [-pS] [mode]", Summary: "Display or set the file mode creation mask, in octal or in symbolic form like u=rwx,g=rx,o=.",
+		Flags: []BuiltinFlag{
+			{"-S", "display the mask symbolically, as the permissions it leaves"},
+			{"-p", "display the mask in a form that can be reused as input"},
+		}},
+	"ulimit": {Synopsis: "ulimit [-SHa] [-cdflnstuv] [limit]", Summary: "Display or set the resource limits of the shell and the commands it starts.",
+		Flags: []BuiltinFlag{
+			{"-S", "use the soft limit"},
+			{"-H", "use the hard limit"},
+			{"-a", "display all the limits"},
+			{"-c", "the maximum size of core files"},
+			{"-d", "the maximum size of a process's data segment"},
+			{"-f", "the maximum size of files written (the default)"},
+			{"-l", "the maximum size of locked memory"},
+			{"-n", "the maximum number of open file descriptors"},
+			{"-s", "the maximum stack size"},
+			{"-t", "the maximum amount of cpu time in seconds"},
+			{"-u", "the maximum number of user processes"},
+			{"-v", "the maximum amount of virtual memory"},
+		}},
+	"help": {Synopsis: "help [-ds] [pattern ...]", Summary: "Display information about builtin commands, those whose names match the patterns or all of them.",
+		Flags: []BuiltinFlag{
+			{"-d", "output a short description of each topic"},
+			{"-s", "output only the synopsis of each topic"},
+		}},
+	"which": {Synopsis: "which [-a] name ...", Summary: "Show what the shell runs for each name, following its alias, function, builtin and PATH lookup.",
+		Flags: []BuiltinFlag{{"-a", "show every match, not only the one that is run"}}},
+	"times": {Synopsis: "times", Summary: "Display the user and system times used by the shell, then by its children."},
+	"mapfile": {Synopsis: "mapfile [-t] [-n count] [array]", Summary: "Read lines from standard input into the elements of an indexed array, MAPFILE by default.",
+		Flags: []BuiltinFlag{
+			{"-t", "remove the trailing newline from each line"},
+			{"-n", "read at most count lines; 0 means all of them"},
+		}},
+	"readarray": {Synopsis: "readarray [-t] [-n count] [array]", Summary: "Read lines into an indexed array, like mapfile."},
+	"continue":  {Synopsis: "continue [n]", Summary: "Resume the next iteration of the n-th enclosing loop, the innermost by default."},
+	"popd": {Synopsis: "popd [-n] [+N | -N]", Summary: "Remove an entry from the directory stack.",
+		Flags: []BuiltinFlag{{"-n", "do not change directory, only manipulate the stack"}}},
+}
+
+// Help formats the usage the way help text is shown to the user: the
+// synopsis, the summary and one line per option.
+func (usage BuiltinUsage) Help() string {
+	help := strings.Builder{}
+	help.WriteString(usage.Synopsis + "\n    " + usage.Summary + "\n")
+	if len(usage.Flags) > 0 {
+		help.WriteString("\n    Options:\n")
+		for _, flag := range usage.Flags {
+			help.WriteString(fmt.Sprintf("      %-8s%s\n", flag.Name, flag.Help))
+		}
+	}
+	return help.String()
+}
+
+// HelpExecutor implements `help [-ds] [pattern ...]`. Without patterns it
+// lists the synopsis of every builtin; otherwise it shows the help of the
+// builtins whose names match, -d limiting it to the summary and -s to the
+// synopsis.
+func HelpExecutor(shellCtx *ShellCtx, args []string) error {
+	short, description := false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 's':
+				short = true
+			case 'd':
+				description = true
+			default:
+				return fmt.Errorf("help command got invalid option -%c", flag)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(builtinUsages))
+	for name := range builtinUsages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		shellCtx.Sout = "These shell commands are defined internally. Type `help name' to find out more about `name'.\n\n"
+		for _, name := range names {
+			shellCtx.Sout += " " + builtinUsages[name].Synopsis + "\n"
+		}
+		return nil
+	}
+
+	// As in bash, patterns that match nothing only fail when none match.
+	matched := false
+	for _, pattern := range args {
+		for _, name := range names {
+			if name != pattern && !MatchPattern(pattern, name) {
+				continue
+			}
+			matched = true
+			usage := builtinUsages[name]
+			switch {
+			case short:
+				shellCtx.Sout += name + ": " + usage.Synopsis + "\n"
+			case description:
+				shellCtx.Sout += name + " - " + usage.Summary + "\n"
+			default:
+				shellCtx.Sout += name + ": " + usage.Help()
+			}
+		}
+	}
+	if !matched {
+		shellCtx.Serr = fmt.Sprintf("help: no help topics match `%s'.\n", args[len(args)-1])
+		shellCtx.Status = 1
+	}
+	return nil
+}
+
+// CompleteBuiltinFlags returns the options of a builtin that start with
+// prefix, sorted.
+func CompleteBuiltinFlags(name string, prefix string) []string {
+	usage, found := builtinUsages[name]
+	if !found {
+		return nil
+	}
+	matches := []string{}
+	for _, flag := range usage.Flags {
+		if strings.HasPrefix(flag.Name, prefix) {
+			matches = append(matches, flag.Name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
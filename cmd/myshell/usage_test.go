@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCompleteBuiltinFlags(t *testing.T) {
+	tests := []struct {
+		typed string
+		want  []string
+	}{
+		{"history -", []string{"-a", "-c", "-d", "-r", "-w"}},
+		{"fc -", []string{"-e", "-l", "-n", "-r"}},
+		{"history -c", []string{"-c"}},
+		{"history -x", []string{}},
+		{"echo hi | history -", []string{"-a", "-c", "-d", "-r", "-w"}},
+	}
+	shellCtx := NewShellCtx()
+	shellCtx.CurrentDir = t.TempDir()
+	for _, test := range tests {
+		if got, _ := shellCtx.Complete(parseCompletionWord(test.typed)); !slices.Equal(got, test.want) {
+			t.Errorf("completing %q: got %q, want %q", test.typed, got, test.want)
+		}
+	}
+}
+
+// Every builtin has the metadata that help and completion are built from.
+func TestBuiltinsHaveUsage(t *testing.T) {
+	for name := range NewShellCtx().Builtins {
+		usage, found := builtinUsages[name]
+		if !found || len(usage.Synopsis) == 0 {
+			t.Errorf("%s has no usage", name)
+		}
+		for _, flag := range usage.Flags {
+			if !strings.HasPrefix(flag.Name, "-") || len(flag.Help) == 0 {
+				t.Errorf("%s: bad flag %+v", name, flag)
+			}
+		}
+	}
+}
+
+func TestHelp(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"help -s cd", "cd: cd [-L | -P] [dir]\n"},
+		{"help -d cd", "cd - Change the current directory, searching CDPATH for relative names.\n"},
+		{"help cd | head -2", "cd: cd [-L | -P] [dir]\n    Change the current directory, searching CDPATH for relative names.\n"},
+		{"help cd | grep -c -- -P", "2\n"},
+		{"help -s 'c?'", "cd: cd [-L | -P] [dir]\n"},
+		{"help -s true false", "true: true\nfalse: false\n"},
+		{"help | grep -c '^ cd '", "1\n"},
+		{"help nosuch; echo $?", "1\n"},
+		{"help nosuch1 nosuch2 2>&1; echo $?", "help: no help topics match `nosuch2'.\n1\n"},
+		{"help -s nosuch cd; echo $?", "cd: cd [-L | -P] [dir]\n0\n"},
+		{"help -x; echo $?", "1\n"},
+	})
+}
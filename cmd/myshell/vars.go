@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Variable struct {
+	Value    string
+	Exported bool
+	ReadOnly bool
+	// Integer is set by declare -i: values assigned to the variable are
+	// evaluated as arithmetic expressions.
+	Integer bool
+	// Elements makes the variable an indexed array. Arrays may be sparse;
+	// element 0 is what the name alone refers to, and Value is unused.
+	Elements map[int]string
+	// Assoc makes it an associative array, keyed by strings; "0" stands in
+	// for the name alone.
+	Assoc map[string]string
+}
+
+type Assignment struct {
+	Name  string
+	Value string
+	// Subscript is the index of arr[sub]=value when Indexed is set, and
+	// Append is set for +=.
+	Subscript string
+	Indexed   bool
+	Append    bool
+	// Compound holds the expanded elements of arr=(...).
+	Compound []ArrayElement
+}
+
+func NewVariables(environ []string) map[string]*Variable {
+	vars := make(map[string]*Variable, len(environ))
+	for _, entry := range environ {
+		name, value, found := strings.Cut(entry, "=")
+		if !found || !IsValidName(name) {
+			continue
+		}
+		vars[name] = &Variable{Value: value, Exported: true}
+	}
+	return vars
+}
+
+func IsValidName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			continue
+		}
+		if i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func ParseAssignment(word string) (Assignment, bool) {
+	name, value, found := strings.Cut(word, "=")
+	if !found || !IsValidName(name) {
+		return Assignment{}, false
+	}
+	return Assignment{Name: name, Value: value}, true
+}
+
+// ParseAssignmentWord parses an assignment as the shell's own syntax has it,
+// which besides NAME=value allows NAME[subscript]=value, += to append and a
+// compound value (...), left raw until it is expanded.
+func ParseAssignmentWord(word string) (Assignment, bool) {
+	end := 0
+	for end < len(word) && isNameByte(word[end]) {
+		end++
+	}
+	assignment := Assignment{Name: word[:end]}
+	if !IsValidName(assignment.Name) {
+		return Assignment{}, false
+	}
+	rest := word[end:]
+	if strings.HasPrefix(rest, "[") {
+		closing := strings.IndexByte(rest, ']')
+		if closing == -1 {
+			return Assignment{}, false
+		}
+		assignment.Subscript, assignment.Indexed = rest[1:closing], true
+		rest = rest[closing+1:]
+	}
+	if strings.HasPrefix(rest, "+=") {
+		assignment.Append = true
+		rest = rest[1:]
+	}
+	if !strings.HasPrefix(rest, "=") {
+		return Assignment{}, false
+	}
+	assignment.Value = rest[1:]
+	return assignment, true
+}
+
+// SplitAssignments separates the leading NAME=value words of a command from
+// the command itself. Everything after the first non-assignment is left as is.
+func SplitAssignments(words []string) ([]Assignment, []string) {
+	return splitAssignments(words, ParseAssignment)
+}
+
+// SplitAssignmentWords is SplitAssignments for the words of a command line,
+// where assignments can target array elements.
+func SplitAssignmentWords(words []string) ([]Assignment, []string) {
+	return splitAssignments(words, ParseAssignmentWord)
+}
+
+func splitAssignments(words []string, parse func(string) (Assignment, bool)) ([]Assignment, []string) {
+	assignments := []Assignment{}
+	for i, word := range words {
+		assignment, ok := parse(word)
+		if !ok {
+			return assignments, words[i:]
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, []string{}
+}
+
+func (ctx *ShellCtx) GetVar(name string) (string, bool) {
+	if ctx.DynamicVars[name] {
+		return ctx.dynamicValue(name), true
+	}
+	variable, found := ctx.Vars[name]
+	if !found {
+		return "", false
+	}
+	if variable.Elements != nil {
+		value, found := variable.Elements[0]
+		return value, found
+	}
+	if variable.Assoc != nil {
+		value, found := variable.Assoc["0"]
+		return value, found
+	}
+	return variable.Value, true
+}
+
+func (ctx *ShellCtx) SetVar(name, value string) error {
+	variable, found := ctx.Vars[name]
+	if !found {
+		ctx.Vars[name] = &Variable{Value: value}
+		ctx.varChanged(name)
+		return nil
+	}
+	if variable.ReadOnly {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	value, err := ctx.convertValue(variable, value)
+	if err != nil {
+		return err
+	}
+	if variable.Elements != nil {
+		variable.Elements[0] = value
+	} else if variable.Assoc != nil {
+		variable.Assoc["0"] = value
+	} else {
+		variable.Value = value
+	}
+	ctx.varChanged(name)
+	return nil
+}
+
+// convertValue applies the attributes of a variable to a value about to be
+// assigned to it.
+func (ctx *ShellCtx) convertValue(variable *Variable, value string) (string, error) {
+	if !variable.Integer {
+		return value, nil
+	}
+	number, err := ctx.evalArithmetic(value, 0)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(number, 10), nil
+}
+
+func (ctx *ShellCtx) ExportVar(name, value string) error {
+	if err := ctx.SetVar(name, value); err != nil {
+		return err
+	}
+	ctx.Vars[name].Exported = true
+	return nil
+}
+
+func (ctx *ShellCtx) UnsetVar(name string) error {
+	variable, found := ctx.Vars[name]
+	if found && variable.ReadOnly {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+	// Like in bash, an unset dynamic variable loses its special meaning.
+	delete(ctx.DynamicVars, name)
+	if !found {
+		return nil
+	}
+	delete(ctx.Vars, name)
+	ctx.varChanged(name)
+	return nil
+}
+
+// varChanged keeps state derived from variables in sync with them.
+func (ctx *ShellCtx) varChanged(name string) {
+	switch name {
+	case "PATH":
+		path, _ := ctx.GetVar("PATH")
+		ctx.PathFolders = SplitPath(path)
+		ctx.PathCache = map[string]HashEntry{}
+	case "MYSHELL_COLORS":
+		value, _ := ctx.GetVar(name)
+		if depth, err := ParseColorDepth(value); err == nil {
+			ctx.ColorDepth = depth
+		}
+	case "RANDOM", "SECONDS":
+		if !ctx.DynamicVars[name] {
+			return
+		}
+		variable, found := ctx.Vars[name]
+		if !found {
+			return
+		}
+		value, _ := strconv.ParseInt(strings.TrimSpace(variable.Value), 10, 64)
+		if name == "RANDOM" {
+			ctx.Random.Seed(value)
+		} else {
+			ctx.StartTime = time.Now().Add(-time.Duration(value) * time.Second)
+		}
+	}
+}
+
+func NewDynamicVars() map[string]bool {
+	return map[string]bool{"RANDOM": true, "SECONDS": true, "LINENO": true}
+}
+
+// dynamicValue computes RANDOM, SECONDS and LINENO at the moment they are
+// expanded rather than storing them.
+func (ctx *ShellCtx) dynamicValue(name string) string {
+	switch name {
+	case "RANDOM":
+		return strconv.Itoa(ctx.Random.Intn(32768))
+	case "SECONDS":
+		return strconv.Itoa(int(time.Since(ctx.StartTime).Seconds()))
+	case "LINENO":
+		return strconv.Itoa(ctx.LineNo)
+	}
+	return ""
+}
+
+// CheckAssignable reports the first assignment that targets a readonly
+// variable, so a command can be refused before anything runs.
+func (ctx *ShellCtx) CheckAssignable(assignments []Assignment) error {
+	for _, assignment := range assignments {
+		if variable, found := ctx.Vars[assignment.Name]; found && variable.ReadOnly {
+			return fmt.Errorf("%s: readonly variable", assignment.Name)
+		}
+	}
+	return nil
+}
+
+// Environ returns the exported variables in os/exec format, with overrides
+// applied on top of them without touching the shell's own variables.
+func (ctx *ShellCtx) Environ(overrides ...Assignment) []string {
+	env := map[string]string{}
+	for name, variable := range ctx.Vars {
+		// Arrays can't be passed in the environment.
+		if variable.Exported && variable.Elements == nil && variable.Assoc == nil {
+			env[name] = variable.Value
+		}
+	}
+	for _, assignment := range overrides {
+		env[assignment.Name] = assignment.Value
+	}
+
+	environ := make([]string, 0, len(env))
+	for name, value := range env {
+		environ = append(environ, name+"="+value)
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+// WithTempVars runs fn with the given assignments applied to the shell
+// variables, restoring the previous state afterwards.
+func (ctx *ShellCtx) WithTempVars(assignments []Assignment, fn func()) {
+	saved := make(map[string]*Variable, len(assignments))
+	for _, assignment := range assignments {
+		if _, seen := saved[assignment.Name]; seen {
+			continue
+		}
+		if variable, found := ctx.Vars[assignment.Name]; found {
+			copied := *variable
+			saved[assignment.Name] = &copied
+		} else {
+			saved[assignment.Name] = nil
+		}
+	}
+	for _, assignment := range assignments {
+		ctx.Vars[assignment.Name] = &Variable{Value: assignment.Value, Exported: true}
+		ctx.varChanged(assignment.Name)
+	}
+
+	defer func() {
+		for name, variable := range saved {
+			if variable == nil {
+				delete(ctx.Vars, name)
+			} else {
+				ctx.Vars[name] = variable
+			}
+			ctx.varChanged(name)
+		}
+	}()
+	fn()
+}
+
+// QuoteValue renders a value in double quotes so that it reads back as the
+// same string.
+func QuoteValue(value string) string {
+	quoted := strings.Builder{}
+	quoted.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte("$`\"\\", value[i]) != -1 {
+			quoted.WriteByte('\\')
+		}
+		quoted.WriteByte(value[i])
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+func ReadonlyExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		names := make([]string, 0)
+		for name, variable := range shellCtx.Vars {
+			if variable.ReadOnly {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			shellCtx.Sout += fmt.Sprintf("readonly %s=%s\n", name, formatValue(shellCtx.Vars[name]))
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		if err := shellCtx.declare(arg, declareOptions{set: "r"}, false); err != nil {
+			shellCtx.Serr += fmt.Sprintf("readonly: %s\n", err.Error())
+			shellCtx.Status = 1
+		}
+	}
+	return nil
+}
+
+func UnsetExecutor(shellCtx *ShellCtx, args []string) error {
+	if len(args) > 0 && args[0] == "-f" {
+		for _, name := range args[1:] {
+			delete(shellCtx.Functions, name)
+		}
+		return nil
+	}
+	if len(args) > 0 && args[0] == "-v" {
+		args = args[1:]
+	}
+	for _, name := range args {
+		unset := shellCtx.UnsetVar
+		if array, subscript, ok := splitSubscript(name); ok {
+			name = array
+			unset = func(name string) error { return shellCtx.UnsetElement(name, subscript) }
+		}
+		if err := unset(name); err != nil {
+			shellCtx.Serr += fmt.Sprintf("unset: %s\n", err.Error())
+			shellCtx.Status = 1
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestReadonly(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"readonly a=1; a=2; echo $a $?", "1 1\n"},
+		{"readonly a=1; readonly -p | grep ' a='", "readonly a=\"1\"\n"},
+		{"a=1; readonly a; a=2; echo $a", "1\n"},
+		{"readonly b; b=3; echo \"[$b]\"", "[]\n"},
+		{"readonly a=1; unset a; echo $? $a", "1 1\n"},
+		{"readonly c=1; c=2 true; echo $?", "1\n"},
+		{"readonly a=1; f() { local a=5; }; f; echo $? $a", "1 1\n"},
+		{"readonly 1x=2; echo $?", "1\n"},
+		{"readonly a=1; (a=2); echo $?", "1\n"},
+	})
+}
+
+func TestDynamicVariables(t *testing.T) {
+	checkScripts(t, []scriptTest{
+		{"[ $RANDOM -ge 0 ] && [ $RANDOM -le 32767 ] && echo range", "range\n"},
+		{"RANDOM=5; a=$RANDOM; RANDOM=5; [ $a = $RANDOM ] && echo seeded", "seeded\n"},
+		{"[ $RANDOM$RANDOM$RANDOM != $RANDOM$RANDOM$RANDOM ] && echo differs", "differs\n"},
+		{"SECONDS=100; echo $SECONDS", "100\n"},
+		{"[ $SECONDS -lt 5 ] && echo started", "started\n"},
+		{`printf 'echo $LINENO\n\necho $LINENO\nif true\nthen echo x\nfi; echo $LINENO\n' > a.sh; source ./a.sh`, "1\n3\nx\n4\n"},
+		{`printf 'echo $LINENO\n' > a.sh; printf '\nsource ./a.sh; echo $LINENO\n' > b.sh; source ./b.sh`, "1\n2\n"},
+	})
+}